@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/pkg/config"
+)
+
+// newPolicy 加载命令策略 (IPMI_POLICY_FILE)，判定记录到审计日志
+func newPolicy(cfg *config.Config, st *stores) (*service.Policy, error) {
+	pc, err := service.LoadPolicyConfig(cfg.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	p, err := service.NewPolicy(pc, st.approvals)
+	if err != nil {
+		return nil, err
+	}
+	p.OnDecision = func(task domain.ExecTask, d domain.PolicyDecision) {
+		if err := st.audit.RecordPolicy(task, d); err != nil {
+			fmt.Fprintln(os.Stderr, "ipmictl: audit:", err)
+		}
+	}
+	return p, nil
+}
+
+// policyExit 输出策略拦截的原因与后续操作提示；非策略错误返回 -1
+func policyExit(err error) int {
+	var pe *service.PolicyError
+	if !errors.As(err, &pe) {
+		return -1
+	}
+	fmt.Fprintln(os.Stderr, "ipmictl:", err)
+	switch {
+	case pe.Decision.ConfirmToken != "":
+		fmt.Fprintf(os.Stderr, "re-run with -confirm %s to proceed\n", pe.Decision.ConfirmToken)
+	case pe.Decision.ApprovalID != "" && errors.Is(err, service.ErrApprovalRequired):
+		fmt.Fprintf(os.Stderr, "ask another user to run 'ipmictl approval approve %s', then re-run with -approval %s\n", pe.Decision.ApprovalID, pe.Decision.ApprovalID)
+	}
+	return exitPolicy
+}
+
+// runApproval 查看与处理命令审批单 (审批人为当前系统用户，不能审批自己的请求)
+func runApproval(cfg *config.Config, st *stores, sub string, args []string) int {
+	policy, err := newPolicy(cfg, st)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+	switch sub {
+	case "list", "ls":
+		return approvalList(policy, args)
+	case "approve", "reject":
+		if len(args) != 1 {
+			fmt.Fprint(os.Stderr, usage)
+			return exitUsage
+		}
+		a, err := policy.Decide(args[0], service.LocalUser().Name, sub == "approve")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipmictl:", err)
+			return exitFailed
+		}
+		fmt.Printf("%s %s (%s: %s)\n", a.ID, a.Status, a.Requester, a.Command)
+		return recordAudit(st, domain.AuditApproval, a.ID, nil, map[string]any{"status": a.Status, "requester": a.Requester, "command": a.Command})
+	default:
+		fmt.Fprintf(os.Stderr, "ipmictl: unknown approval command %q\n%s", sub, usage)
+		return exitUsage
+	}
+}
+
+func approvalList(policy *service.Policy, args []string) int {
+	fs := flag.NewFlagSet("approval list", flag.ContinueOnError)
+	status := fs.String("status", "pending", "pending|approved|rejected|used|expired (empty: all)")
+	asJSON := fs.Bool("json", false, "output JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	list, err := policy.Approvals(domain.ApprovalStatus(*status), 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, list)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tREQUESTER\tTARGETS\tCOMMAND\tREASONS")
+	for _, a := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", a.ID, a.Status, a.Requester, len(a.MachineIDs), a.Command, strings.Join(a.Reasons, "; "))
+	}
+	_ = tw.Flush()
+	return exitOK
+}
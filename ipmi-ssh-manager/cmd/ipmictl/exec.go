@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/logging"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/ssh"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/pkg/config"
+)
+
+// jsonResult -json 模式下每台机器输出一行 (JSON Lines)
+type jsonResult struct {
+	JobID     string `json:"job_id"`
+	MachineID int64  `json:"machine_id"`
+	IPMIIP    string `json:"ipmi_ip"`
+	Command   string `json:"command"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	ExitCode  int    `json:"exit_code"`
+	Error     string `json:"error,omitempty"`
+	Passed    bool   `json:"passed"`
+	AssertMsg string `json:"assert_msg,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+func runExec(cfg *config.Config, st *stores, args []string) int {
+	fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+	ids := fs.String("ids", "", "comma separated machine IDs")
+	selector := fs.String("selector", "", "label selector, e.g. 'rack=A12,role!=db'")
+	parallel := fs.Int("parallel", 0, "max parallel hosts (0: IPMI_MAX_PARALLEL)")
+	timeout := fs.Int("timeout", 30, "per host timeout in seconds")
+	authMode := fs.String("auth", "key", "key|password (password read from IPMI_SSH_PASSWORD)")
+	keyFile := fs.String("key-file", "", "fallback private key for machines without their own key")
+	assertExit := fs.String("assert-exit", "", "allowed exit codes, e.g. '0,1' (default 0)")
+	confirm := fs.String("confirm", "", "confirmation token required by the command policy")
+	approval := fs.String("approval", "", "approved request ID required by the command policy")
+	asJSON := fs.Bool("json", false, "emit one JSON object per host (JSON Lines)")
+	render := fs.Bool("render", false, "render {{.ipmi_ip}} / {{.hostname}} style templates per machine")
+	maxOutput := fs.Int("max-output-kb", 0, "keep at most N KiB of stdout/stderr per host (0: IPMI_MAX_OUTPUT_KB)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	command := strings.Join(fs.Args(), " ")
+	if strings.TrimSpace(command) == "" {
+		fmt.Fprintln(os.Stderr, "ipmictl: exec needs a COMMAND")
+		return exitUsage
+	}
+	local := service.LocalUser()
+	task := domain.ExecTask{Command: command, Timeout: *timeout, Selector: *selector, Parallel: *parallel, AuthMode: *authMode, Stream: !*asJSON, User: local.Name, Role: local.Role, Confirm: *confirm, ApprovalID: *approval, MaxOutput: *maxOutput << 10, Render: *render}
+	for _, f := range strings.Split(*ids, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ipmictl: invalid id %q\n", f)
+			return exitUsage
+		}
+		task.MachineIDs = append(task.MachineIDs, id)
+	}
+	if *authMode == "password" {
+		task.Password = os.Getenv("IPMI_SSH_PASSWORD")
+		if task.Password == "" {
+			fmt.Fprintln(os.Stderr, "ipmictl: -auth password requires IPMI_SSH_PASSWORD")
+			return exitUsage
+		}
+	}
+	if *assertExit != "" {
+		a := &domain.Assertions{}
+		for _, f := range strings.Split(*assertExit, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ipmictl: invalid exit code %q\n", f)
+				return exitUsage
+			}
+			a.ExitCodes = append(a.ExitCodes, code)
+		}
+		task.Assertions = a
+	}
+	if _, err := domain.ParseSelector(task.Selector); err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+
+	task.JobID = service.NewJobID()
+	if err := st.audit.RecordExec(task); err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl: audit:", err)
+		return exitUsage
+	}
+
+	hWriter := service.NewHistoryWriter(st.history, cfg.HistoryFlushInterval, cfg.HistoryBatchSize)
+	defer hWriter.Close()
+	hWriter.SetBlockTimeout(time.Duration(cfg.HistoryBlockMs) * time.Millisecond)
+	if _, err := hWriter.SetJournal(cfg.HistoryJournalPath()); err != nil {
+		slog.Warn("history journal unavailable", logging.Err(err))
+	}
+	execSvc := service.NewExecService(st.machines, hWriter, ssh.NewExecutor(cfg.MaxParallel), cfg.MaxParallel)
+	policy, err := newPolicy(cfg, st)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+	execSvc.SetPolicy(policy)
+	execSvc.SetOutputLimit(cfg.MaxOutputKB << 10)
+	if *keyFile != "" {
+		b, err := os.ReadFile(*keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipmictl:", err)
+			return exitUsage
+		}
+		key := string(b)
+		execSvc.SetGlobalKeyProvider(func() string { return key })
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var (
+		mu     sync.Mutex
+		failed int
+		total  int
+		out    = newPrefixWriter(os.Stdout, os.Stderr)
+	)
+	var onChunk service.ChunkFunc
+	if !*asJSON {
+		onChunk = func(m domain.Machine, chunk []byte, isErr bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			out.write(int64(m.ID), m.IPMIIP, chunk, isErr)
+		}
+	}
+	err = execSvc.StreamExecChunks(ctx, task, onChunk, func(r domain.ExecResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		total++
+		if !r.Passed {
+			failed++
+		}
+		if *asJSON {
+			_ = json.NewEncoder(os.Stdout).Encode(jsonResult{JobID: r.JobID, MachineID: r.MachineID, IPMIIP: r.IPMIIP, Command: r.Command, Stdout: r.Stdout, Stderr: r.Stderr, ExitCode: r.ExitCode, Error: errToString(r.Err), Passed: r.Passed, AssertMsg: r.AssertMsg, Truncated: r.Truncated})
+			return
+		}
+		host := r.IPMIIP
+		if host == "" {
+			host = "#" + strconv.FormatInt(r.MachineID, 10)
+		}
+		if !out.streamed(r.MachineID) { // 执行器不支持流式时一次性输出
+			out.write(r.MachineID, host, []byte(r.Stdout), false)
+			out.write(r.MachineID, host, []byte(r.Stderr), true)
+		}
+		out.flush(r.MachineID, host)
+		if !r.Passed {
+			fmt.Fprintf(os.Stderr, "[%s] FAILED: %s\n", host, r.AssertMsg)
+		}
+	})
+	if code := policyExit(err); code >= 0 {
+		return code
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+	if !*asJSON {
+		fmt.Fprintf(os.Stderr, "%d hosts, %d ok, %d failed\n", total, total-failed, failed)
+	}
+	if failed > 0 || ctx.Err() != nil {
+		return exitFailed
+	}
+	return exitOK
+}
+
+func errToString(e error) string {
+	if e == nil {
+		return ""
+	}
+	return e.Error()
+}
+
+// prefixWriter 按行输出并加 "[host] " 前缀，未结束的行缓存到下一片段或 flush
+type prefixWriter struct {
+	stdout, stderr io.Writer
+	partial        map[int64]*[2]bytes.Buffer // [0]=stdout [1]=stderr 的未完成行
+	seen           map[int64]bool
+}
+
+func newPrefixWriter(stdout, stderr io.Writer) *prefixWriter {
+	return &prefixWriter{stdout: stdout, stderr: stderr, partial: map[int64]*[2]bytes.Buffer{}, seen: map[int64]bool{}}
+}
+
+func (p *prefixWriter) streamed(id int64) bool { return p.seen[id] }
+
+func (p *prefixWriter) write(id int64, host string, chunk []byte, isErr bool) {
+	if len(chunk) == 0 {
+		return
+	}
+	p.seen[id] = true
+	bufs, ok := p.partial[id]
+	if !ok {
+		bufs = &[2]bytes.Buffer{}
+		p.partial[id] = bufs
+	}
+	idx, w := 0, p.stdout
+	if isErr {
+		idx, w = 1, p.stderr
+	}
+	buf := &bufs[idx]
+	buf.Write(chunk)
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil { // 不完整的行放回缓存
+			buf.Reset()
+			buf.WriteString(line)
+			return
+		}
+		fmt.Fprintf(w, "[%s] %s", host, line)
+	}
+}
+
+func (p *prefixWriter) flush(id int64, host string) {
+	bufs, ok := p.partial[id]
+	if !ok {
+		return
+	}
+	if bufs[0].Len() > 0 {
+		fmt.Fprintf(p.stdout, "[%s] %s\n", host, bufs[0].String())
+	}
+	if bufs[1].Len() > 0 {
+		fmt.Fprintf(p.stderr, "[%s] %s\n", host, bufs[1].String())
+	}
+	delete(p.partial, id)
+}
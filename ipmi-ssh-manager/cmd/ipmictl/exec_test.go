@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	type chunk struct {
+		id    int64
+		host  string
+		data  string
+		isErr bool
+	}
+	cases := []struct {
+		name       string
+		chunks     []chunk
+		flush      []int64
+		stdout     string
+		stderr     string
+		streamedID int64
+	}{
+		{
+			name:   "whole lines",
+			chunks: []chunk{{1, "10.0.0.1", "a\nb\n", false}},
+			stdout: "[10.0.0.1] a\n[10.0.0.1] b\n",
+		},
+		{
+			name:   "line split across chunks",
+			chunks: []chunk{{1, "h1", "hel", false}, {1, "h1", "lo\nwor", false}, {1, "h1", "ld\n", false}},
+			stdout: "[h1] hello\n[h1] world\n",
+		},
+		{
+			name:   "partial line flushed with newline",
+			chunks: []chunk{{1, "h1", "done", false}, {1, "h1", "warn", true}},
+			flush:  []int64{1},
+			stdout: "[h1] done\n",
+			stderr: "[h1] warn\n",
+		},
+		{
+			name:   "hosts do not mix partial lines",
+			chunks: []chunk{{1, "h1", "one-", false}, {2, "h2", "two-", false}, {2, "h2", "b\n", false}, {1, "h1", "a\n", false}},
+			stdout: "[h2] two-b\n[h1] one-a\n",
+		},
+		{
+			name:   "stdout and stderr buffered separately",
+			chunks: []chunk{{1, "h1", "out", false}, {1, "h1", "err\n", true}, {1, "h1", "\n", false}},
+			stdout: "[h1] out\n",
+			stderr: "[h1] err\n",
+		},
+		{
+			name:   "empty chunk is not streamed",
+			chunks: []chunk{{1, "h1", "", false}},
+			flush:  []int64{1},
+		},
+		{
+			name:       "flush without buffered output",
+			chunks:     []chunk{{3, "h3", "x\n", false}},
+			flush:      []int64{3, 3, 4},
+			stdout:     "[h3] x\n",
+			streamedID: 3,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			p := newPrefixWriter(&stdout, &stderr)
+			hosts := map[int64]string{}
+			for _, ch := range c.chunks {
+				hosts[ch.id] = ch.host
+				p.write(ch.id, ch.host, []byte(ch.data), ch.isErr)
+			}
+			for _, id := range c.flush {
+				p.flush(id, hosts[id])
+			}
+			if stdout.String() != c.stdout || stderr.String() != c.stderr {
+				t.Fatalf("stdout %q stderr %q, want %q %q", stdout.String(), stderr.String(), c.stdout, c.stderr)
+			}
+			if c.streamedID != 0 && !p.streamed(c.streamedID) {
+				t.Fatalf("id %d not marked streamed", c.streamedID)
+			}
+		})
+	}
+	if p := newPrefixWriter(&bytes.Buffer{}, &bytes.Buffer{}); p.streamed(1) {
+		t.Fatal("fresh writer reports streamed")
+	}
+	p := newPrefixWriter(&bytes.Buffer{}, &bytes.Buffer{})
+	p.write(1, "h1", nil, false)
+	if p.streamed(1) {
+		t.Fatal("empty chunk marked host as streamed")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/pkg/importexport"
+)
+
+// multiFlag 可重复出现的字符串参数 (-label a=b -label c=d)
+type multiFlag []string
+
+func (f *multiFlag) String() string     { return strings.Join(*f, ",") }
+func (f *multiFlag) Set(v string) error { *f = append(*f, v); return nil }
+
+func runMachine(st *stores, sub string, args []string) int {
+	switch sub {
+	case "list", "ls":
+		return machineList(st, args)
+	case "add":
+		return machineAdd(st, args)
+	case "import":
+		return machineImport(st, args)
+	case "export":
+		return machineExport(st, args)
+	case "rm", "delete":
+		return machineRemove(st, args)
+	case "trash":
+		return machineTrash(st, args)
+	case "restore":
+		return machineRestore(st, args)
+	case "purge":
+		return machinePurge(st, args)
+	case "history", "revisions":
+		return machineHistory(st, args)
+	default:
+		fmt.Fprintf(os.Stderr, "ipmictl: unknown machine command %q\n%s", sub, usage)
+		return exitUsage
+	}
+}
+
+func machineList(st *stores, args []string) int {
+	fs := flag.NewFlagSet("machine list", flag.ContinueOnError)
+	query := fs.String("q", "", "structured query, e.g. 'ssh_user=root ipmi_ip:10.0.0.0/24 label:rack=A12'")
+	asJSON := fs.Bool("json", false, "output JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	q, err := domain.ParseMachineQuery(*query)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+	page, err := st.machines.Query(q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, page.Items)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tIPMI_IP\tSSH_IP\tSSH_USER\tLABELS\tGROUPS\tREMARK")
+	for _, m := range page.Items {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", m.ID, m.IPMIIP, m.SSHIP, m.SSHUser, formatLabels(m.Labels), strings.Join(m.Groups, ","), m.Remark)
+	}
+	_ = tw.Flush()
+	return exitOK
+}
+
+func machineAdd(st *stores, args []string) int {
+	fs := flag.NewFlagSet("machine add", flag.ContinueOnError)
+	var (
+		m       domain.Machine
+		keyFile string
+		labels  multiFlag
+		attrs   multiFlag
+		groups  multiFlag
+	)
+	fs.StringVar(&m.IPMIIP, "ipmi", "", "IPMI IP (required, unique)")
+	fs.StringVar(&m.SSHIP, "ssh-ip", "", "SSH IP (default: same as -ipmi)")
+	fs.StringVar(&m.SSHUser, "user", "root", "SSH user")
+	fs.StringVar(&m.ZBXID, "zbx-id", "", "Zabbix ID")
+	fs.StringVar(&m.Remark, "remark", "", "remark")
+	fs.StringVar(&keyFile, "key-file", "", "private key file")
+	fs.IntVar(&m.Version, "version", 0, "expected current version (0 = overwrite unconditionally)")
+	fs.Var(&labels, "label", "label key=value (repeatable)")
+	fs.Var(&attrs, "attr", "attribute key=value for command templates (repeatable)")
+	fs.Var(&groups, "group", "group name (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if strings.TrimSpace(m.IPMIIP) == "" {
+		fmt.Fprintln(os.Stderr, "ipmictl: -ipmi is required")
+		return exitUsage
+	}
+	if m.SSHIP == "" {
+		m.SSHIP = m.IPMIIP
+	}
+	if keyFile != "" {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipmictl:", err)
+			return exitUsage
+		}
+		m.SSHKey = string(b)
+	}
+	if len(labels) > 0 {
+		m.Labels = map[string]string{}
+		for _, kv := range labels {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				fmt.Fprintf(os.Stderr, "ipmictl: invalid label %q (want key=value)\n", kv)
+				return exitUsage
+			}
+			m.Labels[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	if len(attrs) > 0 {
+		m.Attrs = map[string]string{}
+		for _, kv := range attrs {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				fmt.Fprintf(os.Stderr, "ipmictl: invalid attr %q (want key=value)\n", kv)
+				return exitUsage
+			}
+			m.Attrs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	if len(groups) > 0 {
+		m.Groups = groups
+	}
+	var before any
+	if old, err := st.machines.GetByIPMI(m.IPMIIP); err == nil {
+		before = service.AuditMachine(old)
+	}
+	if err := st.machines.Save(&m); err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if code := recordAudit(st, domain.AuditMachineUpsert, m.IPMIIP, before, service.AuditMachine(m)); code != exitOK {
+		return code
+	}
+	fmt.Printf("saved machine %d (%s)\n", m.ID, m.IPMIIP)
+	return exitOK
+}
+
+func machineImport(st *stores, args []string) int {
+	fs := flag.NewFlagSet("machine import", flag.ContinueOnError)
+	format := fs.String("format", "json", "json|csv")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "ipmictl: machine import needs a FILE (or - for stdin)")
+		return exitUsage
+	}
+	var (
+		data []byte
+		err  error
+	)
+	if fs.Arg(0) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+	var ms []domain.Machine
+	if *format == "csv" {
+		ms, err = importexport.ParseMachinesCSV(data)
+	} else {
+		ms, err = importexport.ParseMachinesJSON(data)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+	err = st.machines.BulkUpsert(ms)
+	var bce *repository.BulkConflictError
+	if err != nil && !errors.As(err, &bce) {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	skipped := map[string]bool{}
+	if bce != nil {
+		for _, c := range bce.Conflicts {
+			skipped[c.IPMIIP] = true
+		}
+	}
+	ipmis := make([]string, 0, len(ms))
+	for _, m := range ms {
+		if !skipped[m.IPMIIP] {
+			ipmis = append(ipmis, m.IPMIIP)
+		}
+	}
+	after := map[string]any{"count": len(ipmis), "ipmi_ips": ipmis}
+	if bce != nil {
+		after["conflicts"] = bce.Conflicts
+	}
+	if code := recordAudit(st, domain.AuditMachineImport, *format, nil, after); code != exitOK {
+		return code
+	}
+	fmt.Printf("imported %d machines\n", len(ipmis))
+	if bce != nil {
+		for _, c := range bce.Conflicts {
+			fmt.Fprintf(os.Stderr, "ipmictl: skipped %s: version %d, current %d\n", c.IPMIIP, c.Expected, c.Current)
+		}
+		return exitFailed
+	}
+	return exitOK
+}
+
+func machineExport(st *stores, args []string) int {
+	fs := flag.NewFlagSet("machine export", flag.ContinueOnError)
+	format := fs.String("format", "json", "json|csv")
+	redact := fs.Bool("redact", false, "omit ssh keys")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	list, err := st.machines.ListAll()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if *redact {
+		for i := range list {
+			list[i].SSHKey = ""
+		}
+	}
+	if *format == "csv" {
+		fmt.Print(importexport.RenderMachinesCSV(list))
+		return exitOK
+	}
+	out, err := importexport.SerializeMachinesJSON(list)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	fmt.Println(out)
+	return exitOK
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + labels[k]
+	}
+	return strings.Join(parts, ",")
+}
+
+func writeJSON(w io.Writer, v any) int {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	return exitOK
+}
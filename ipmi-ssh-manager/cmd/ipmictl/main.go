@@ -0,0 +1,196 @@
+// ipmictl: 无界面命令行前端，复用 repository / service 包，适合脚本与 cron。
+//
+// 用法:
+//
+//	ipmictl machine list   [-q query] [-json]
+//	ipmictl machine add    -ipmi IP [-ssh-ip IP] [-user root] [-key-file F] [-remark R] [-label k=v]... [-group g]...
+//	ipmictl machine import [-format json|csv] FILE|-
+//	ipmictl machine export [-format json|csv] [-redact]
+//	ipmictl machine rm|restore|purge IPMI    (删除到回收站、从回收站恢复、彻底删除)
+//	ipmictl machine trash [-json]
+//	ipmictl machine history [-json] [-diff FROM,TO] IPMI
+//	ipmictl exec [-ids 1,2] [-selector sel] [-parallel N] [-timeout S] [-json] COMMAND...
+//	ipmictl user list|add|set|rm|token ...   (server 模式的用户与访问令牌)
+//	ipmictl audit list|export|verify ...     (审计日志)
+//	ipmictl approval list|approve|reject ... (命令审批)
+//	ipmictl migrate status|up [-shared] ...  (数据库迁移；-shared 为 IPMI_DB_DSN 共享库)
+//	ipmictl backup create|list|restore|check (数据库备份、恢复与完整性检查)
+//
+// 数据目录与并发等沿用环境变量 (IPMI_DATA_DIR / IPMI_MAX_PARALLEL ...)。
+// 退出码: 0 全部成功；1 任一机器失败；2 参数或环境错误；3 命令策略拦截 (需确认 / 审批或被拒绝)。
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/logging"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/pkg/config"
+)
+
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+	exitPolicy = 3
+)
+
+const usage = `usage:
+  ipmictl machine list   [-q query] [-json]
+  ipmictl machine add    -ipmi IP [-ssh-ip IP] [-user root] [-key-file F] [-remark R] [-label k=v]... [-group g]...
+  ipmictl machine import [-format json|csv] FILE|-
+  ipmictl machine export [-format json|csv] [-redact]
+  ipmictl machine rm      IPMI
+  ipmictl machine trash   [-json]
+  ipmictl machine restore IPMI
+  ipmictl machine purge   IPMI
+  ipmictl machine history [-json] [-diff FROM,TO] IPMI
+  ipmictl exec [-ids 1,2] [-selector sel] [-parallel N] [-timeout S] [-auth key|password] [-key-file F] [-assert-exit 0,1] [-confirm TOKEN] [-approval ID] [-max-output-kb N] [-json] COMMAND...
+  ipmictl user list  [-json]
+  ipmictl user add   -name N [-role viewer|operator|admin] [-group g]...
+  ipmictl user set   -name N [-role R] [-group g]... [-all-groups] [-disabled true|false]
+  ipmictl user rm    NAME
+  ipmictl user token NAME
+  ipmictl audit list   [-actor A] [-action A] [-target T] [-limit N] [-json]
+  ipmictl audit export [-format jsonl|csv]
+  ipmictl audit verify
+  ipmictl approval list [-status pending|approved|rejected|used|expired] [-json]
+  ipmictl approval approve|reject ID
+  ipmictl migrate status [-json] [-shared]
+  ipmictl migrate up     [-dry-run] [-shared]
+  ipmictl backup create
+  ipmictl backup list    [-json]
+  ipmictl backup restore FILE
+  ipmictl backup check
+`
+
+// stores 本地 SQLite 仓库 (ipmictl 仅支持本地数据库，不走远程 API)；
+// 配置了 IPMI_DB_DSN 时机器与历史使用共享库
+type stores struct {
+	db        *sql.DB
+	shared    *sql.DB // 共享库，未配置时为 nil
+	machines  *repository.MachineRepo
+	history   *repository.HistoryRepo
+	users     *repository.UserRepo
+	audit     *service.Auditor
+	approvals *repository.ApprovalRepo
+}
+
+func openStores(cfg *config.Config) (*stores, error) {
+	if cfg.RemoteAPIBase != "" {
+		return nil, fmt.Errorf("ipmictl does not support remote mode (IPMI_REMOTE_API_BASE is set)")
+	}
+	db, err := sql.Open("sqlite", cfg.DBPath())
+	if err != nil {
+		return nil, err
+	}
+	// 执行未应用的迁移；HistoryRepo.EnsureSchema 另外创建全文索引
+	h := repository.NewHistoryRepo(db)
+	if err := h.EnsureSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	a := repository.NewAuditRepo(db)
+	st := &stores{db: db, machines: repository.NewMachineRepo(db), history: h, users: repository.NewUserRepo(db), audit: service.NewAuditor(a), approvals: repository.NewApprovalRepo(db)}
+	if cfg.DatabaseDSN != "" {
+		shared, d, err := repository.OpenDSN(cfg.DatabaseDSN)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		st.shared, st.machines, st.history = shared, repository.NewMachineRepoDialect(shared, d), repository.NewHistoryRepoDialect(shared, d)
+		if err := st.history.EnsureSchema(); err != nil {
+			st.close()
+			return nil, err
+		}
+	}
+	st.machines = st.machines.WithActor(service.LocalUser().Name) // 修订记录的操作者与审计一致
+	return st, nil
+}
+
+// close 关闭本地库与共享库
+func (s *stores) close() {
+	if s.shared != nil {
+		s.shared.Close()
+	}
+	s.db.Close()
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	cfg := config.Load()
+	// 日志只写文件 (与桌面版 / server 共用)，不干扰命令输出
+	if lf, err := logging.OpenRotating(cfg.LogPath(), int64(cfg.LogMaxSizeMB)<<20, cfg.LogMaxFiles); err == nil {
+		defer lf.Close()
+		slog.SetDefault(logging.New(logging.Options{Level: logging.ParseLevel(cfg.LogLevel), File: lf}).With("component", "ipmictl"))
+	} else {
+		slog.SetDefault(logging.Discard())
+	}
+	if args[0] == "migrate" {
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return exitUsage
+		}
+		return runMigrate(cfg, args[1], args[2:])
+	}
+	st, err := openStores(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+	defer st.close()
+
+	switch args[0] {
+	case "machine", "machines":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return exitUsage
+		}
+		return runMachine(st, args[1], args[2:])
+	case "exec":
+		return runExec(cfg, st, args[1:])
+	case "user", "users":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return exitUsage
+		}
+		return runUser(st, args[1], args[2:])
+	case "audit":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return exitUsage
+		}
+		return runAudit(st, args[1], args[2:])
+	case "approval", "approvals":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return exitUsage
+		}
+		return runApproval(cfg, st, args[1], args[2:])
+	case "backup", "backups":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return exitUsage
+		}
+		return runBackup(cfg, st, args[1], args[2:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "ipmictl: unknown command %q\n%s", args[0], usage)
+		return exitUsage
+	}
+}
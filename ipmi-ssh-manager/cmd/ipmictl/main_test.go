@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain config.Load 只读取一次环境变量：先指向临时数据目录，避免写入仓库中的 data/
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ipmictl-test-")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("IPMI_DATA_DIR", dir)
+	for _, k := range []string{"IPMI_DB_DSN", "IPMI_REMOTE_API_BASE", "IPMI_POLICY_FILE", "IPMI_SSH_PASSWORD"} {
+		os.Unsetenv(k)
+	}
+	os.Setenv("IPMI_USER", "ci")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// runCaptured 执行 run 并返回退出码与 stderr 内容
+func runCaptured(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	errPath := filepath.Join(t.TempDir(), "stderr")
+	stderr, err := os.Create(errPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldOut, oldErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout, stderr
+	code := run(args)
+	os.Stdout, os.Stderr = oldOut, oldErr
+	stdout.Close()
+	stderr.Close()
+	b, _ := os.ReadFile(errPath)
+	return code, string(b)
+}
+
+func TestRun_ExitCodes(t *testing.T) {
+	if code, msg := runCaptured(t, "machine", "add", "-ipmi", "10.0.0.1", "-ssh-ip", "127.0.0.1:1"); code != exitOK {
+		t.Fatalf("machine add: %d %s", code, msg)
+	}
+	keyless := filepath.Join(t.TempDir(), "missing.key")
+	cases := []struct {
+		name string
+		args []string
+		want int
+		msg  string // stderr 需包含的内容
+	}{
+		{"no args", nil, exitUsage, "usage:"},
+		{"help", []string{"help"}, exitOK, ""},
+		{"unknown command", []string{"frobnicate"}, exitUsage, `unknown command "frobnicate"`},
+		{"machine without sub", []string{"machine"}, exitUsage, "usage:"},
+		{"unknown machine sub", []string{"machine", "frob"}, exitUsage, "unknown machine command"},
+		{"machine add without ipmi", []string{"machine", "add"}, exitUsage, "-ipmi is required"},
+		{"machine list bad query", []string{"machine", "list", "-q", "limit:-1"}, exitUsage, ""},
+		{"exec unknown flag", []string{"exec", "-nope", "uptime"}, exitUsage, "-nope"},
+		{"exec without command", []string{"exec", "-ids", "1"}, exitUsage, "needs a COMMAND"},
+		{"exec blank command", []string{"exec", "-ids", "1", " "}, exitUsage, "needs a COMMAND"},
+		{"exec bad id", []string{"exec", "-ids", "1,x", "uptime"}, exitUsage, `invalid id "x"`},
+		{"exec bad timeout", []string{"exec", "-timeout", "soon", "uptime"}, exitUsage, "-timeout"},
+		{"exec bad assert", []string{"exec", "-ids", "1", "-assert-exit", "0,ok", "uptime"}, exitUsage, `invalid exit code "ok"`},
+		{"exec password without env", []string{"exec", "-ids", "1", "-auth", "password", "uptime"}, exitUsage, "IPMI_SSH_PASSWORD"},
+		{"exec bad selector", []string{"exec", "-selector", "rack==", "uptime"}, exitUsage, ""},
+		{"exec missing key file", []string{"exec", "-ids", "1", "-key-file", keyless, "uptime"}, exitUsage, ""},
+		{"exec host fails", []string{"exec", "-ids", "1", "-timeout", "2", "uptime"}, exitFailed, "FAILED"},
+		{"exec host fails json", []string{"exec", "-ids", "1", "-timeout", "2", "-json", "uptime"}, exitFailed, ""},
+		{"policy deny", []string{"exec", "-ids", "1", "rm -rf /"}, exitPolicy, ""},
+		{"policy confirm", []string{"exec", "-ids", "1", "reboot"}, exitPolicy, "re-run with -confirm"},
+		{"policy bad confirm", []string{"exec", "-ids", "1", "-confirm", "bogus", "reboot"}, exitPolicy, "re-run with -confirm"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, msg := runCaptured(t, c.args...)
+			if code != c.want {
+				t.Fatalf("exit %d, want %d; stderr:\n%s", code, c.want, msg)
+			}
+			if !strings.Contains(msg, c.msg) {
+				t.Fatalf("stderr %q missing %q", msg, c.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/pkg/config"
+)
+
+// runMigrate 查看 / 执行数据库迁移；不经 openStores，避免查看状态时自动升级
+func runMigrate(cfg *config.Config, sub string, args []string) int {
+	if cfg.RemoteAPIBase != "" {
+		fmt.Fprintln(os.Stderr, "ipmictl: ipmictl does not support remote mode (IPMI_REMOTE_API_BASE is set)")
+		return exitUsage
+	}
+	db, err := sql.Open("sqlite", cfg.DBPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+	defer db.Close()
+	switch sub {
+	case "status":
+		return migrateStatus(cfg, db, args)
+	case "up":
+		return migrateUp(cfg, db, args)
+	default:
+		fmt.Fprintf(os.Stderr, "ipmictl: unknown migrate command %q\n%s", sub, usage)
+		return exitUsage
+	}
+}
+
+// migrateTarget -shared 时打开 IPMI_DB_DSN 指向的共享库，否则为本地 SQLite；返回的 done 关闭打开的共享库
+func migrateTarget(cfg *config.Config, local *sql.DB, shared bool) (db *sql.DB, d repository.Dialect, done func(), err error) {
+	if !shared {
+		return local, repository.DialectSQLite, func() {}, nil
+	}
+	if cfg.DatabaseDSN == "" {
+		return nil, "", nil, errors.New("-shared needs IPMI_DB_DSN")
+	}
+	db, d, err = repository.OpenDSN(cfg.DatabaseDSN)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return db, d, func() { db.Close() }, nil
+}
+
+// migrateStatus 列出各版本状态；存在未应用、已修改或未知版本时退出码为 1
+func migrateStatus(cfg *config.Config, local *sql.DB, args []string) int {
+	fs := flag.NewFlagSet("migrate status", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "output JSON")
+	shared := fs.Bool("shared", false, "show the shared database (IPMI_DB_DSN) instead of the local SQLite file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	db, d, done, err := migrateTarget(cfg, local, *shared)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+	defer done()
+	st, err := repository.MigrationStateDialect(db, d)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	code := exitOK
+	for _, s := range st {
+		if !s.Applied || s.Modified || s.Unknown {
+			code = exitFailed
+		}
+	}
+	if *asJSON {
+		if c := writeJSON(os.Stdout, st); c != exitOK {
+			return c
+		}
+		return code
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED_AT")
+	for _, s := range st {
+		state, at := "pending", ""
+		switch {
+		case s.Unknown:
+			state = "unknown"
+		case s.Modified:
+			state = "modified"
+		case s.Applied:
+			state = "applied"
+		}
+		if !s.AppliedAt.IsZero() {
+			at = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, at)
+	}
+	_ = tw.Flush()
+	return code
+}
+
+// migrateUp 应用未执行的迁移；-dry-run 只列出将要执行的版本
+func migrateUp(cfg *config.Config, local *sql.DB, args []string) int {
+	fs := flag.NewFlagSet("migrate up", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "list pending migrations without applying them")
+	shared := fs.Bool("shared", false, "migrate the shared database (IPMI_DB_DSN) instead of the local SQLite file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	db, d, closeDB, err := migrateTarget(cfg, local, *shared)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitUsage
+	}
+	defer closeDB()
+	if *dryRun {
+		pending, err := repository.PendingMigrationsDialect(db, d)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipmictl:", err)
+			return exitFailed
+		}
+		for _, m := range pending {
+			fmt.Printf("would apply %04d_%s\n", m.Version, m.Name)
+		}
+		fmt.Printf("%d pending\n", len(pending))
+		return exitOK
+	}
+	done, err := repository.MigrateDialect(db, d)
+	for _, m := range done {
+		fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if err := repository.NewHistoryRepoDialect(db, d).EnsureSchema(); err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	fmt.Printf("%d applied, schema up to date\n", len(done))
+	return exitOK
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+// machineRemove 删除机器 (移入回收站，可用 machine restore 恢复)
+func machineRemove(st *stores, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	old, err := st.machines.GetByIPMI(args[0])
+	if errors.Is(err, sql.ErrNoRows) {
+		fmt.Fprintf(os.Stderr, "ipmictl: machine %s not found\n", args[0])
+		return exitFailed
+	}
+	if err == nil {
+		err = st.machines.DeleteByIPMI(args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if code := recordAudit(st, domain.AuditMachineDelete, old.IPMIIP, service.AuditMachine(old), nil); code != exitOK {
+		return code
+	}
+	fmt.Printf("moved machine %s to the trash\n", old.IPMIIP)
+	return exitOK
+}
+
+func machineTrash(st *stores, args []string) int {
+	fs := flag.NewFlagSet("machine trash", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "output JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	list, err := st.machines.ListDeleted()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, list)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tIPMI_IP\tSSH_IP\tGROUPS\tDELETED_AT\tREMARK")
+	for _, m := range list {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", m.ID, m.IPMIIP, m.SSHIP, strings.Join(m.Groups, ","), m.DeletedAt.Local().Format(time.DateTime), m.Remark)
+	}
+	_ = tw.Flush()
+	return exitOK
+}
+
+func machineRestore(st *stores, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	m, err := st.machines.Restore(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if code := recordAudit(st, domain.AuditMachineRestore, m.IPMIIP, nil, service.AuditMachine(m)); code != exitOK {
+		return code
+	}
+	fmt.Printf("restored machine %d (%s)\n", m.ID, m.IPMIIP)
+	return exitOK
+}
+
+func machinePurge(st *stores, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	if err := st.machines.Purge(args[0]); err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if code := recordAudit(st, domain.AuditMachinePurge, args[0], nil, nil); code != exitOK {
+		return code
+	}
+	fmt.Printf("purged machine %s (revisions are kept)\n", args[0])
+	return exitOK
+}
+
+// machineHistory 列出机器的修订记录；-diff FROM,TO 输出两个修订 (修订 ID) 的差异
+func machineHistory(st *stores, args []string) int {
+	fs := flag.NewFlagSet("machine history", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "output JSON")
+	diff := fs.String("diff", "", "revision IDs FROM,TO to compare")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *diff != "" {
+		return revisionDiff(st, *diff, *asJSON)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "ipmictl: machine history needs an IPMI IP")
+		return exitUsage
+	}
+	list, err := st.machines.ListRevisions(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, list)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tREV\tACTION\tACTOR\tTIME\tSSH_IP\tGROUPS")
+	for _, r := range list {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Revision, r.Action, r.Actor, r.CreatedAt.Local().Format(time.DateTime), r.Snapshot.SSHIP, strings.Join(r.Snapshot.Groups, ","))
+	}
+	_ = tw.Flush()
+	return exitOK
+}
+
+func revisionDiff(st *stores, ids string, asJSON bool) int {
+	a, b, ok := strings.Cut(ids, ",")
+	from, err1 := strconv.ParseInt(strings.TrimSpace(a), 10, 64)
+	to, err2 := strconv.ParseInt(strings.TrimSpace(b), 10, 64)
+	if !ok || err1 != nil || err2 != nil {
+		fmt.Fprintf(os.Stderr, "ipmictl: invalid -diff %q (want FROM,TO revision IDs)\n", ids)
+		return exitUsage
+	}
+	var (
+		d   domain.MachineRevisionDiff
+		err error
+	)
+	if d.From, err = st.machines.GetRevision(from); err == nil {
+		d.To, err = st.machines.GetRevision(to)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if d.From.IPMIIP != d.To.IPMIIP {
+		fmt.Fprintf(os.Stderr, "ipmictl: revisions %d and %d belong to different machines\n", from, to)
+		return exitUsage
+	}
+	d.Changes = domain.DiffSnapshots(d.From.Snapshot, d.To.Snapshot)
+	if asJSON {
+		return writeJSON(os.Stdout, d)
+	}
+	fmt.Printf("%s: revision %d (%s) -> %d (%s)\n", d.From.IPMIIP, d.From.Revision, d.From.Action, d.To.Revision, d.To.Action)
+	for _, c := range d.Changes {
+		fmt.Printf("  %s: %q -> %q\n", c.Field, c.Old, c.New)
+	}
+	return exitOK
+}
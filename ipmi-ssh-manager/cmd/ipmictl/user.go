@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+// runUser 管理 server 模式的用户与访问令牌 (直接操作本地数据库，视为管理员)
+func runUser(st *stores, sub string, args []string) int {
+	users := service.NewUserService(st.users)
+	switch sub {
+	case "list", "ls":
+		return userList(users, args)
+	case "add":
+		return userAdd(st, users, args)
+	case "set":
+		return userSet(st, users, args)
+	case "rm", "delete":
+		return userDelete(st, users, args)
+	case "token":
+		return userToken(st, users, args)
+	default:
+		fmt.Fprintf(os.Stderr, "ipmictl: unknown user command %q\n%s", sub, usage)
+		return exitUsage
+	}
+}
+
+func userList(users *service.UserService, args []string) int {
+	fs := flag.NewFlagSet("user list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "output JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	list, err := users.List()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, list)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tROLE\tGROUPS\tDISABLED")
+	for _, u := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\n", u.Name, u.Role, strings.Join(u.Groups, ","), u.Disabled)
+	}
+	_ = tw.Flush()
+	return exitOK
+}
+
+func userAdd(st *stores, users *service.UserService, args []string) int {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	var groups multiFlag
+	name := fs.String("name", "", "user name (required)")
+	role := fs.String("role", string(domain.RoleViewer), "viewer|operator|admin")
+	fs.Var(&groups, "group", "limit to machine group (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	u, token, err := users.Create(domain.User{Name: *name, Role: domain.Role(*role), Groups: groups})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if code := recordAudit(st, domain.AuditUserCreate, u.Name, nil, u); code != exitOK {
+		return code
+	}
+	fmt.Println(token)
+	return exitOK
+}
+
+func userSet(st *stores, users *service.UserService, args []string) int {
+	fs := flag.NewFlagSet("user set", flag.ContinueOnError)
+	var groups multiFlag
+	name := fs.String("name", "", "user name (required)")
+	role := fs.String("role", "", "viewer|operator|admin (default: unchanged)")
+	fs.Var(&groups, "group", "replace group scope (repeatable)")
+	allGroups := fs.Bool("all-groups", false, "remove the group scope")
+	disabled := fs.String("disabled", "", "true|false (default: unchanged)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	u, err := users.Get(*name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipmictl: user %q: %v\n", *name, err)
+		return exitFailed
+	}
+	old := u
+	if *role != "" {
+		u.Role = domain.Role(*role)
+	}
+	if *allGroups {
+		u.Groups = nil
+	} else if len(groups) > 0 {
+		u.Groups = groups
+	}
+	switch *disabled {
+	case "":
+	case "true", "false":
+		u.Disabled = *disabled == "true"
+	default:
+		fmt.Fprintf(os.Stderr, "ipmictl: invalid -disabled %q\n", *disabled)
+		return exitUsage
+	}
+	if err := users.Update(u); err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	return recordAudit(st, domain.AuditUserUpdate, u.Name, old, u)
+}
+
+func userDelete(st *stores, users *service.UserService, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	old, err := users.Get(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipmictl: user %q: %v\n", args[0], err)
+		return exitFailed
+	}
+	if err := users.Delete(args[0]); err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	return recordAudit(st, domain.AuditUserDelete, old.Name, old, nil)
+}
+
+// userToken 重新生成访问令牌并打印
+func userToken(st *stores, users *service.UserService, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	token, err := users.ResetToken(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl:", err)
+		return exitFailed
+	}
+	if code := recordAudit(st, domain.AuditUserToken, args[0], nil, service.RedactedSecret("token")); code != exitOK {
+		return code
+	}
+	fmt.Println(token)
+	return exitOK
+}
+
+// recordAudit 以本地用户身份写入审计；失败时返回 exitFailed
+func recordAudit(st *stores, action, target string, before, after any) int {
+	if err := st.audit.Record(service.LocalUser().Name, action, target, before, after); err != nil {
+		fmt.Fprintln(os.Stderr, "ipmictl: audit:", err)
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,16 @@
+package domain
+
+// Assertions 任务级的期望输出规则；全部满足才判定为通过。
+// 未配置任何规则时沿用默认判定: ExitCode == 0 且无执行错误。
+type Assertions struct {
+	ExitCodes      []int             `json:"exit_codes,omitempty"`       // 允许的退出码集合 (空表示仅 0)
+	StdoutMatch    []string          `json:"stdout_match,omitempty"`     // stdout 必须匹配的正则
+	StdoutNotMatch []string          `json:"stdout_not_match,omitempty"` // stdout 不得匹配的正则
+	MaxDurationMs  int64             `json:"max_duration_ms,omitempty"`  // 最长耗时 (<=0 不限)
+	JSONEquals     map[string]string `json:"json_equals,omitempty"`      // stdout 解析为 JSON 后，路径 (如 "status.health" / "items[0].name") 的值须等于给定字符串
+}
+
+// Empty 是否未配置任何规则
+func (a *Assertions) Empty() bool {
+	return a == nil || (len(a.ExitCodes) == 0 && len(a.StdoutMatch) == 0 && len(a.StdoutNotMatch) == 0 && a.MaxDurationMs <= 0 && len(a.JSONEquals) == 0)
+}
@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// 审计动作
+const (
+	AuditMachineUpsert  = "machine.upsert"
+	AuditMachineDelete  = "machine.delete"
+	AuditMachineImport  = "machine.import"
+	AuditMachineRestore = "machine.restore"
+	AuditMachinePurge   = "machine.purge"
+	AuditGroupDelete    = "group.delete"
+	AuditGlobalKeySet   = "global_key.set"
+	AuditExec           = "exec"
+	AuditExecPolicy     = "exec.policy"
+	AuditApproval       = "exec.approval"
+	AuditUserCreate     = "user.create"
+	AuditUserUpdate     = "user.update"
+	AuditUserDelete     = "user.delete"
+	AuditUserToken      = "user.token_reset"
+	AuditDBBackup       = "db.backup"
+	AuditDBRestore      = "db.restore"
+)
+
+// AuditEntry 审计日志条目 (只追加)。Before / After 为变更前后的 JSON (敏感字段已脱敏)；
+// Hash = sha256(PrevHash + 本条内容)，任一条被修改、删除或插入都会使之后的链校验失败。
+type AuditEntry struct {
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target,omitempty"` // 对象标识，如 IPMI IP / 分组名 / 任务 ID
+	Before   string    `json:"before,omitempty"`
+	After    string    `json:"after,omitempty"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+// AuditTimeFormat 审计时间的存储与哈希格式 (UTC，定宽以便按字符串比较)
+const AuditTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// ComputeHash 按 PrevHash 与内容计算本条哈希 (不含 ID 与 Hash 本身)
+func (e AuditEntry) ComputeHash() string {
+	b, _ := json.Marshal([]string{e.PrevHash, e.Time.UTC().Format(AuditTimeFormat), e.Actor, e.Action, e.Target, e.Before, e.After})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditQuery 审计查询条件，空值表示不限；结果按 ID 倒序
+type AuditQuery struct {
+	Actor  string    `json:"actor,omitempty"`
+	Action string    `json:"action,omitempty"` // 精确匹配，或以 "." 结尾按前缀匹配 (如 "machine.")
+	Target string    `json:"target,omitempty"` // 子串匹配
+	Since  time.Time `json:"since,omitempty"`
+	Until  time.Time `json:"until,omitempty"`
+	Offset int       `json:"offset,omitempty"`
+	Limit  int       `json:"limit,omitempty"` // <=0 默认 200
+}
+
+// AuditVerifyResult 链校验结果；OK=false 时 BrokenID 为第一条校验失败的条目
+type AuditVerifyResult struct {
+	OK       bool   `json:"ok"`
+	Checked  int    `json:"checked"`
+	BrokenID int64  `json:"broken_id,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	HeadHash string `json:"head_hash"` // 最后一条的哈希，可另行保存用于发现尾部截断
+}
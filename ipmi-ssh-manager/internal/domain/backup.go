@@ -0,0 +1,24 @@
+package domain
+
+import "time"
+
+// BackupInfo 数据库备份文件；SchemaVersion 等字段在校验 (Inspect) 后填充
+type BackupInfo struct {
+	Name          string    `json:"name"`
+	Path          string    `json:"path,omitempty"`
+	Size          int64     `json:"size"`
+	CreatedAt     time.Time `json:"created_at"`
+	SchemaVersion int       `json:"schema_version,omitempty"` // 已应用的最高迁移版本
+	Machines      int       `json:"machines,omitempty"`
+	History       int       `json:"history,omitempty"`
+}
+
+// IntegrityReport 数据库完整性检查结果；Problems 为 PRAGMA integrity_check 报告的问题及迁移状态异常
+type IntegrityReport struct {
+	OK            bool      `json:"ok"`
+	Problems      []string  `json:"problems,omitempty"`
+	SchemaVersion int       `json:"schema_version"`
+	Pending       int       `json:"pending"` // 未应用的迁移数
+	CheckedAt     time.Time `json:"checked_at"`
+	DurationMs    int64     `json:"duration_ms"`
+}
@@ -1,23 +1,51 @@
 package domain
 
+import "time"
+
 type ExecTask struct {
-	Command    string
-	Timeout    int     // 秒
-	MachineIDs []int64 // 目标机器ID列表
-	Parallel   int     // 每任务并发(>0 覆盖全局)
-	AuthMode   string  // "key"(默认) | "password"
-	Password   string  // 当 AuthMode=="password" 时使用 (一次性，不落盘)
-	Stream     bool    // 是否实时流式输出
+	Command    string      // Render 为 true 时支持 {{.ipmi_ip}} / {{.hostname}} 等按机器渲染的模板
+	Timeout    int         // 秒
+	MachineIDs []int64     // 目标机器ID列表
+	Selector   string      // 标签选择器 (如 "rack=A12,role!=db")，执行时解析并与 MachineIDs 合并
+	Parallel   int         // 每任务并发(>0 覆盖全局)
+	AuthMode   string      // "key"(默认) | "password"
+	Password   string      // 当 AuthMode=="password" 时使用 (一次性，不落盘)
+	Stream     bool        // 是否实时流式输出
+	JobID      string      // 所属任务 ID (为空时执行前自动生成)，写入每条历史
+	Assertions *Assertions // 期望输出规则 (nil 使用默认判定)
+	User       string      // 发起执行的用户，写入任务与每条历史
+	Groups     []string    // 非空时仅允许这些分组内的机器 (发起者的分组范围)
+	Role       Role        // 发起者角色 (按角色的命令白名单)
+	Confirm    string      // 策略要求确认时的确认令牌 (PolicyDecision.ConfirmToken)
+	ApprovalID string      // 策略要求审批时已批准的审批单 ID
+	MaxOutput  int         // 每台机器 stdout / stderr 各自保留的字节上限 (<=0 使用全局默认)，超出只保留开头与结尾
+	Render     bool        // 按机器渲染 Command 模板；默认原样下发 (如 docker inspect -f '{{.State.Status}}')
 }
 
 type ExecResult struct {
+	JobID         string
 	MachineID     int64
 	IPMIIP        string
 	SSHIP         string
 	SSHUser       string
+	Command       string // 针对该机器渲染后的实际命令
 	Stdout        string
 	Stderr        string
 	ExitCode      int
 	Err           error
-	UsedGlobalKey bool // 当使用全局私钥回退时为 true
+	UsedGlobalKey bool     // 当使用全局私钥回退时为 true
+	Passed        bool     // 断言判定结果 (无断言时为 ExitCode == 0 且 Err == nil)
+	AssertMsg     string   // 未通过的原因，多条以 "; " 分隔
+	User          string   // 发起执行的用户
+	Truncated     bool     // 输出超过上限，Stdout / Stderr 只保留开头与结尾
+	OutputBytes   int64    // 原始 stdout + stderr 字节数
+	Groups        []string // 机器所属分组 (按分组范围推送事件)
+}
+
+// JobInfo 运行中的任务
+type JobInfo struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
 }
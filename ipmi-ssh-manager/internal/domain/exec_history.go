@@ -4,15 +4,78 @@ import "time"
 
 // ExecHistory 记录单次命令在某台机器的执行结果
 type ExecHistory struct {
-	ID         int64     `json:"id"`
-	MachineID  int64     `json:"machine_id"`
-	IPMIIP     string    `json:"ipmi_ip"`
-	Command    string    `json:"command"`
-	Stdout     string    `json:"stdout"`
-	Stderr     string    `json:"stderr"`
-	ExitCode   int       `json:"exit_code"`
-	ErrorText  string    `json:"error,omitempty"`
-	StartedAt  time.Time `json:"started_at"`
-	FinishedAt time.Time `json:"finished_at"`
-	DurationMs int64     `json:"duration_ms"`
+	ID          int64     `json:"id"`
+	JobID       string    `json:"job_id,omitempty"`
+	MachineID   int64     `json:"machine_id"`
+	IPMIIP      string    `json:"ipmi_ip"`
+	Command     string    `json:"command"`
+	Stdout      string    `json:"stdout"`
+	Stderr      string    `json:"stderr"`
+	ExitCode    int       `json:"exit_code"`
+	ErrorText   string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	DurationMs  int64     `json:"duration_ms"`
+	Passed      bool      `json:"passed"`
+	AssertMsg   string    `json:"assert_msg,omitempty"`
+	User        string    `json:"user,omitempty"`         // 发起执行的用户
+	Truncated   bool      `json:"truncated,omitempty"`    // 执行时输出超过上限，只保留了开头与结尾
+	OutputBytes int64     `json:"output_bytes,omitempty"` // 原始 stdout + stderr 字节数
+	Preview     bool      `json:"preview,omitempty"`      // 列表中的 Stdout / Stderr 只是预览，完整输出需按 ID 读取
+}
+
+// HistoryOutput 单条历史的完整输出 (按需加载)
+type HistoryOutput struct {
+	ID          int64  `json:"id"`
+	MachineID   int64  `json:"machine_id"`
+	Stdout      string `json:"stdout"`
+	Stderr      string `json:"stderr"`
+	Truncated   bool   `json:"truncated"`
+	OutputBytes int64  `json:"output_bytes"`
+}
+
+// HistorySearch 历史全文检索条件，空值表示不限。
+// Query 使用 FTS5 语法：短语 "link down"、前缀 err*、布尔 AND / OR / NOT、列限定 stderr:timeout
+type HistorySearch struct {
+	Query      string    `json:"query,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	Passed     *bool     `json:"passed,omitempty"`
+	JobID      string    `json:"job_id,omitempty"`
+	Selector   string    `json:"selector,omitempty"` // 机器标签选择器，如 "rack=A12"
+	MachineIDs []int64   `json:"machine_ids,omitempty"`
+	Since      time.Time `json:"since,omitempty"` // 按 started_at
+	Until      time.Time `json:"until,omitempty"`
+	Sort       string    `json:"sort,omitempty"` // rank (默认，有 Query 时按相关度) | newest
+	Offset     int       `json:"offset,omitempty"`
+	Limit      int       `json:"limit,omitempty"` // <=0 默认 50，最大 500
+}
+
+// HistoryHit 检索命中的一条历史；Snippet 为已做 HTML 转义、命中词以 <mark> 包裹的片段
+type HistoryHit struct {
+	ExecHistory
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// HistoryStatsQuery 历史统计范围与分组，空值表示不限
+type HistoryStatsQuery struct {
+	Since      time.Time `json:"since,omitempty"`    // 为空时默认最近 7 天
+	Until      time.Time `json:"until,omitempty"`    // 为空时为当前时间
+	Command    string    `json:"command,omitempty"`  // 精确匹配
+	Selector   string    `json:"selector,omitempty"` // 机器标签选择器
+	MachineIDs []int64   `json:"machine_ids,omitempty"`
+	Bucket     string    `json:"bucket,omitempty"`   // 趋势时间粒度 hour | day，空时按跨度自动选择
+	GroupBy    string    `json:"group_by,omitempty"` // 趋势分组：空 (整体) | machine | command
+	Top        int       `json:"top,omitempty"`      // 排行榜 / 分组序列条数，<=0 默认 10
+}
+
+// HistorySample 统计用的单条执行 (不含输出)
+type HistorySample struct {
+	MachineID  int64
+	IPMIIP     string
+	Command    string
+	StartedAt  time.Time
+	DurationMs int64
+	Passed     bool
+	ExitCode   int
+	ErrorText  string
 }
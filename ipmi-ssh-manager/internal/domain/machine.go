@@ -5,12 +5,24 @@ import "time"
 // Machine 统一的机器领域模型
 // 注意: remark / created_at 在部分早期表结构可能不存在；请保证迁移后包含
 type Machine struct {
-	ID        int       `json:"id"`
-	IPMIIP    string    `json:"ipmi_ip"`          // IPMI管理IP
-	SSHIP     string    `json:"ssh_ip"`           // SSH连接IP
-	SSHUser   string    `json:"ssh_user"`         // SSH用户名 (默认 root)
-	ZBXID     string    `json:"zbx_id,omitempty"` // Zabbix/监控ID
-	SSHKey    string    `json:"-"`                // 私钥（不序列化）
-	Remark    string    `json:"remark,omitempty"`
-	CreatedAt time.Time `json:"created_at,omitempty"`
+	ID        int               `json:"id"`
+	IPMIIP    string            `json:"ipmi_ip"`          // IPMI管理IP
+	SSHIP     string            `json:"ssh_ip"`           // SSH连接IP
+	SSHUser   string            `json:"ssh_user"`         // SSH用户名 (默认 root)
+	ZBXID     string            `json:"zbx_id,omitempty"` // Zabbix/监控ID
+	SSHKey    string            `json:"-"`                // 私钥（不序列化）
+	Remark    string            `json:"remark,omitempty"`
+	Attrs     map[string]string `json:"attrs,omitempty"`  // 自定义属性，可在命令模板中引用；保存时 nil 表示保留原值
+	Labels    map[string]string `json:"labels,omitempty"` // 标签 (用于选择器定位，如 rack=A12)
+	Groups    []string          `json:"groups,omitempty"` // 所属分组名称
+	CreatedAt time.Time         `json:"created_at,omitempty"`
+	Version   int               `json:"version,omitempty"`   // 乐观锁版本；保存时非 0 表示只在库中版本一致时更新
+	DeletedAt time.Time         `json:"deleted_at,omitzero"` // 移入回收站的时间 (仅回收站列表填充)
+}
+
+// Group 命名的机器分组 (与机器多对多)
+type Group struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	MemberCount int    `json:"member_count"`
 }
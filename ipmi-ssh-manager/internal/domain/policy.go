@@ -0,0 +1,48 @@
+package domain
+
+import "time"
+
+// PolicyVerdict 命令策略的判定
+type PolicyVerdict string
+
+const (
+	VerdictAllow   PolicyVerdict = "allow"   // 直接执行
+	VerdictConfirm PolicyVerdict = "confirm" // 需携带确认令牌重新提交
+	VerdictApprove PolicyVerdict = "approve" // 需另一名用户审批
+	VerdictDeny    PolicyVerdict = "deny"    // 拒绝执行
+)
+
+// PolicyDecision 一次下发的策略判定结果
+type PolicyDecision struct {
+	Verdict      PolicyVerdict `json:"verdict"`
+	Reasons      []string      `json:"reasons,omitempty"` // 命中的规则 / 阈值
+	Targets      int           `json:"targets"`
+	Granted      bool          `json:"granted"`                 // 是否放行 (allow，或已提供有效确认令牌 / 审批)
+	ConfirmToken string        `json:"confirm_token,omitempty"` // Verdict=confirm 且未放行时返回，原样放入 ExecTask.Confirm 重新提交
+	ApprovalID   string        `json:"approval_id,omitempty"`   // Verdict=approve 时的审批单
+}
+
+// ApprovalStatus 审批单状态
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+	ApprovalUsed     ApprovalStatus = "used" // 已用于一次执行 (单次有效)
+	ApprovalExpired  ApprovalStatus = "expired"
+)
+
+// Approval 需要第二人审批的下发请求；审批只对同一发起者、同一命令与同一组目标机器有效
+type Approval struct {
+	ID         string         `json:"id"`
+	Requester  string         `json:"requester"`
+	Command    string         `json:"command"`
+	MachineIDs []int64        `json:"machine_ids"` // 解析后的目标 (含选择器匹配结果)
+	Reasons    []string       `json:"reasons,omitempty"`
+	Status     ApprovalStatus `json:"status"`
+	Approver   string         `json:"approver,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	DecidedAt  time.Time      `json:"decided_at,omitempty"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+}
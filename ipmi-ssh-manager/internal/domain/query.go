@@ -0,0 +1,324 @@
+package domain
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilterOp 字段过滤运算符
+type FilterOp string
+
+const (
+	FilterContains FilterOp = ":"    // 子串匹配 (不区分大小写)
+	FilterEq       FilterOp = "="    // 精确匹配
+	FilterNotEq    FilterOp = "!="   // 不等
+	FilterGt       FilterOp = ">"    // 大于 (id 数值比较，其余按字符串)
+	FilterLt       FilterOp = "<"    // 小于
+	FilterCIDR     FilterOp = "cidr" // IP 属于网段 (仅 ipmi_ip / ssh_ip)
+)
+
+// MachineQueryFields 可查询/排序的机器字段 (ssh_key 为敏感字段不开放)
+var MachineQueryFields = []string{"id", "ipmi_ip", "ssh_ip", "ssh_user", "zbx_id", "remark", "created_at"}
+
+// FieldFilter 单个字段条件
+type FieldFilter struct {
+	Field string   `json:"field"`
+	Op    FilterOp `json:"op"`
+	Value string   `json:"value"`
+}
+
+// MachineQuery 结构化机器查询，全部条件为 AND。
+type MachineQuery struct {
+	Filters  []FieldFilter `json:"filters,omitempty"`
+	Text     []string      `json:"text,omitempty"`     // remark 全文关键词，全部命中
+	Selector Selector      `json:"selector,omitempty"` // 标签 / 分组谓词
+	SortBy   string        `json:"sort_by,omitempty"`  // 默认 id
+	Desc     bool          `json:"desc,omitempty"`
+	Offset   int           `json:"offset,omitempty"`
+	Limit    int           `json:"limit,omitempty"` // <=0 不分页
+}
+
+// MachinePage 分页查询结果，Total 为分页前的匹配总数
+type MachinePage struct {
+	Items  []Machine `json:"items"`
+	Total  int       `json:"total"`
+	Offset int       `json:"offset"`
+	Limit  int       `json:"limit"`
+}
+
+// ParseMachineQuery 解析查询语句，空白分隔、双引号包裹含空格的值:
+//
+//	ssh_user=root ipmi_ip:10.0.0.0/24 remark:"rack move" label:rack=A12 group:prod sort:-created_at limit:50
+//
+// 字段条件支持 `:` (包含) `=` `!=` `>` `<`；ipmi_ip / ssh_ip 的值为网段时按 CIDR 匹配；
+// 无字段前缀的词作为 remark 全文关键词；label:/group: 转为选择器条件；sort:/offset:/limit: 控制排序分页。
+func ParseMachineQuery(s string) (MachineQuery, error) {
+	var q MachineQuery
+	for _, tok := range splitQuery(s) {
+		key, op, val, ok := splitTerm(tok)
+		if !ok {
+			q.Text = append(q.Text, tok)
+			continue
+		}
+		switch key {
+		case "sort":
+			if op != FilterContains {
+				return q, fmt.Errorf("invalid sort term %q", tok)
+			}
+			q.Desc = strings.HasPrefix(val, "-")
+			q.SortBy = strings.TrimPrefix(val, "-")
+			if !isQueryField(q.SortBy) {
+				return q, fmt.Errorf("unknown sort field %q", q.SortBy)
+			}
+		case "offset", "limit":
+			n, err := strconv.Atoi(val)
+			if op != FilterContains || err != nil || n < 0 {
+				return q, fmt.Errorf("invalid %s term %q", key, tok)
+			}
+			if key == "offset" {
+				q.Offset = n
+			} else {
+				q.Limit = n
+			}
+		case "label", "group":
+			term := val
+			if key == "group" {
+				term = SelectorGroupKey + "=" + val
+			}
+			sel, err := ParseSelector(term)
+			if err != nil || op != FilterContains {
+				return q, fmt.Errorf("invalid %s term %q", key, tok)
+			}
+			q.Selector = append(q.Selector, sel...)
+		default:
+			if !isQueryField(key) {
+				return q, fmt.Errorf("unknown field %q", key)
+			}
+			if (key == "ipmi_ip" || key == "ssh_ip") && (op == FilterContains || op == FilterEq) && strings.Contains(val, "/") {
+				if _, err := netip.ParsePrefix(val); err != nil {
+					return q, fmt.Errorf("invalid cidr %q: %w", val, err)
+				}
+				op = FilterCIDR
+			}
+			if key == "id" && op != FilterContains {
+				if _, err := strconv.Atoi(val); err != nil {
+					return q, fmt.Errorf("invalid id %q", val)
+				}
+			}
+			q.Filters = append(q.Filters, FieldFilter{Field: key, Op: op, Value: val})
+		}
+	}
+	return q, nil
+}
+
+// NeedsPostFilter 是否包含无法下推到 SQL 的条件 (CIDR / 标签)，需在内存中二次过滤
+func (q MachineQuery) NeedsPostFilter() bool {
+	if !q.Selector.Empty() {
+		return true
+	}
+	for _, f := range q.Filters {
+		if f.Op == FilterCIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPost 仅判断 CIDR 与标签条件
+func (q MachineQuery) MatchPost(m Machine) bool {
+	for _, f := range q.Filters {
+		if f.Op == FilterCIDR && !matchFilter(f, m) {
+			return false
+		}
+	}
+	return q.Selector.Matches(m)
+}
+
+// Matches 判断机器是否满足全部条件 (供无 SQL 的仓库实现在内存中过滤)
+func (q MachineQuery) Matches(m Machine) bool {
+	for _, f := range q.Filters {
+		if !matchFilter(f, m) {
+			return false
+		}
+	}
+	remark := strings.ToLower(m.Remark)
+	for _, t := range q.Text {
+		if !strings.Contains(remark, strings.ToLower(t)) {
+			return false
+		}
+	}
+	return q.Selector.Matches(m)
+}
+
+// Apply 在内存中执行完整查询：过滤、排序、分页
+func (q MachineQuery) Apply(list []Machine) MachinePage {
+	var matched []Machine
+	for _, m := range list {
+		if q.Matches(m) {
+			matched = append(matched, m)
+		}
+	}
+	SortMachines(matched, q.SortBy, q.Desc)
+	return Paginate(matched, q.Offset, q.Limit)
+}
+
+// SortMachines 按字段排序 (稳定)；未知字段按 id
+func SortMachines(list []Machine, field string, desc bool) {
+	less := func(a, b Machine) bool {
+		switch field {
+		case "", "id":
+			return a.ID < b.ID
+		case "created_at":
+			return a.CreatedAt.Before(b.CreatedAt)
+		default:
+			return MachineField(a, field) < MachineField(b, field)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if desc {
+			return less(list[j], list[i])
+		}
+		return less(list[i], list[j])
+	})
+}
+
+// Paginate 截取分页 (limit<=0 返回全部)
+func Paginate(list []Machine, offset, limit int) MachinePage {
+	page := MachinePage{Total: len(list), Offset: offset, Limit: limit}
+	if offset > len(list) {
+		offset = len(list)
+	}
+	end := len(list)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	page.Items = list[offset:end]
+	if page.Items == nil {
+		page.Items = []Machine{}
+	}
+	return page
+}
+
+// MachineField 返回机器字段的字符串值
+func MachineField(m Machine, field string) string {
+	switch field {
+	case "id":
+		return strconv.Itoa(m.ID)
+	case "ipmi_ip":
+		return m.IPMIIP
+	case "ssh_ip":
+		return m.SSHIP
+	case "ssh_user":
+		return m.SSHUser
+	case "zbx_id":
+		return m.ZBXID
+	case "remark":
+		return m.Remark
+	case "created_at":
+		if m.CreatedAt.IsZero() {
+			return ""
+		}
+		return m.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+func matchFilter(f FieldFilter, m Machine) bool {
+	v := MachineField(m, f.Field)
+	switch f.Op {
+	case FilterContains:
+		return strings.Contains(strings.ToLower(v), strings.ToLower(f.Value))
+	case FilterEq:
+		return v == f.Value
+	case FilterNotEq:
+		return v != f.Value
+	case FilterGt, FilterLt:
+		var cmp int
+		if f.Field == "id" {
+			n, _ := strconv.Atoi(f.Value)
+			cmp = m.ID - n
+		} else {
+			cmp = strings.Compare(v, f.Value)
+		}
+		if f.Op == FilterGt {
+			return cmp > 0
+		}
+		return cmp < 0
+	case FilterCIDR:
+		prefix, err := netip.ParsePrefix(f.Value)
+		if err != nil {
+			return false
+		}
+		addr, err := netip.ParseAddr(stripPort(v))
+		return err == nil && prefix.Contains(addr)
+	}
+	return false
+}
+
+// stripPort 去掉 "host:port" 中的端口 (ssh_ip 可能带端口)
+func stripPort(s string) string {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().String()
+	}
+	return s
+}
+
+func isQueryField(f string) bool {
+	for _, x := range MachineQueryFields {
+		if x == f {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTerm 拆分 key<op>value；运算符前须为合法标识符，否则视为普通关键词
+func splitTerm(tok string) (string, FilterOp, string, bool) {
+	for i, c := range tok {
+		if c == '_' || (c >= 'a' && c <= 'z') {
+			continue
+		}
+		if i == 0 {
+			return "", "", "", false
+		}
+		key, rest := tok[:i], tok[i:]
+		for _, op := range []FilterOp{FilterNotEq, FilterContains, FilterEq, FilterGt, FilterLt} {
+			if strings.HasPrefix(rest, string(op)) {
+				return key, op, strings.TrimPrefix(rest, string(op)), true
+			}
+		}
+		return "", "", "", false
+	}
+	return "", "", "", false
+}
+
+// splitQuery 按空白切分，双引号内空白保留
+func splitQuery(s string) []string {
+	var (
+		out   []string
+		cur   strings.Builder
+		quote bool
+	)
+	flush := func() {
+		if cur.Len() > 0 {
+			out = append(out, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quote = !quote
+		case !quote && (c == ' ' || c == '\t' || c == '\n'):
+			flush()
+		default:
+			cur.WriteRune(c)
+		}
+	}
+	flush()
+	return out
+}
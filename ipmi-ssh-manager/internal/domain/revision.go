@@ -0,0 +1,113 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 机器修订动作
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete" // 移入回收站
+	RevisionRestore = "restore"
+)
+
+// MachineSnapshot 某一修订时机器的完整状态；SSHKey 只保存指纹 (sha256:前 8 字节)，可判断是否变更而不泄露私钥
+type MachineSnapshot struct {
+	IPMIIP  string            `json:"ipmi_ip"`
+	SSHIP   string            `json:"ssh_ip"`
+	SSHUser string            `json:"ssh_user"`
+	ZBXID   string            `json:"zbx_id,omitempty"`
+	Remark  string            `json:"remark,omitempty"`
+	SSHKey  string            `json:"ssh_key,omitempty"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Groups  []string          `json:"groups,omitempty"`
+}
+
+// MachineRevision 机器的一次变更 (只追加)；Revision 按机器从 1 递增
+type MachineRevision struct {
+	ID        int64           `json:"id"`
+	MachineID int64           `json:"machine_id"`
+	IPMIIP    string          `json:"ipmi_ip"`
+	Revision  int             `json:"revision"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor,omitempty"`
+	Snapshot  MachineSnapshot `json:"snapshot"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// FieldChange 两个修订间的一处差异；Field 为 ssh_ip、attrs.<key>、labels.<key>、groups 等
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// MachineRevisionDiff 两个修订的差异
+type MachineRevisionDiff struct {
+	From    MachineRevision `json:"from"`
+	To      MachineRevision `json:"to"`
+	Changes []FieldChange   `json:"changes"`
+}
+
+// KeyFingerprint SSH Key 的指纹 (空 Key 返回空串)
+func KeyFingerprint(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// SnapshotOf 机器的脱敏快照 (分组排序，便于比较)
+func SnapshotOf(m Machine) MachineSnapshot {
+	s := MachineSnapshot{IPMIIP: m.IPMIIP, SSHIP: m.SSHIP, SSHUser: m.SSHUser, ZBXID: m.ZBXID, Remark: m.Remark, SSHKey: KeyFingerprint(m.SSHKey)}
+	if len(m.Attrs) > 0 {
+		s.Attrs = maps.Clone(m.Attrs)
+	}
+	if len(m.Labels) > 0 {
+		s.Labels = maps.Clone(m.Labels)
+	}
+	if len(m.Groups) > 0 {
+		s.Groups = slices.Sorted(slices.Values(m.Groups))
+	}
+	return s
+}
+
+// DiffSnapshots 从 a 到 b 的字段变化 (按字段顺序；map 键按字典序)
+func DiffSnapshots(a, b MachineSnapshot) []FieldChange {
+	var out []FieldChange
+	add := func(field, o, n string) {
+		if o != n {
+			out = append(out, FieldChange{Field: field, Old: o, New: n})
+		}
+	}
+	add("ipmi_ip", a.IPMIIP, b.IPMIIP)
+	add("ssh_ip", a.SSHIP, b.SSHIP)
+	add("ssh_user", a.SSHUser, b.SSHUser)
+	add("zbx_id", a.ZBXID, b.ZBXID)
+	add("remark", a.Remark, b.Remark)
+	add("ssh_key", a.SSHKey, b.SSHKey)
+	diffMap := func(prefix string, x, y map[string]string) {
+		keys := slices.Sorted(maps.Keys(x))
+		for k := range y {
+			if _, ok := x[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			add(prefix+k, x[k], y[k])
+		}
+	}
+	diffMap("attrs.", a.Attrs, b.Attrs)
+	diffMap("labels.", a.Labels, b.Labels)
+	add("groups", strings.Join(a.Groups, ","), strings.Join(b.Groups, ","))
+	return out
+}
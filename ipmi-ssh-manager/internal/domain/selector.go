@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SelectorOp 选择器条件运算符
+type SelectorOp int
+
+const (
+	OpEq        SelectorOp = iota // key=value
+	OpNotEq                       // key!=value (标签缺失也视为不等)
+	OpExists                      // key
+	OpNotExists                   // !key
+)
+
+// SelectorGroupKey 保留键：group=name 匹配分组成员
+const SelectorGroupKey = "group"
+
+// Requirement 单个选择条件
+type Requirement struct {
+	Key   string
+	Op    SelectorOp
+	Value string
+}
+
+// Selector 由逗号分隔的条件组成，全部满足才算匹配 (AND)。
+// 语法示例: "rack=A12,role!=db,gpu,!retired,group=web"
+type Selector []Requirement
+
+// ParseSelector 解析选择器字符串；空串返回空选择器 (匹配全部)。
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var req Requirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			req = Requirement{Key: strings.TrimSpace(kv[0]), Op: OpNotEq, Value: strings.TrimSpace(kv[1])}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			req = Requirement{Key: strings.TrimSpace(kv[0]), Op: OpEq, Value: strings.TrimSpace(kv[1])}
+		case strings.HasPrefix(part, "!"):
+			req = Requirement{Key: strings.TrimSpace(part[1:]), Op: OpNotExists}
+		default:
+			req = Requirement{Key: part, Op: OpExists}
+		}
+		if req.Key == "" || strings.ContainsAny(req.Key, " !=") {
+			return nil, fmt.Errorf("invalid selector term %q", part)
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// Empty 是否无任何条件
+func (sel Selector) Empty() bool { return len(sel) == 0 }
+
+// Matches 判断机器是否满足全部条件
+func (sel Selector) Matches(m Machine) bool {
+	for _, req := range sel {
+		if !req.matches(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (req Requirement) matches(m Machine) bool {
+	if req.Key == SelectorGroupKey {
+		in := false
+		for _, g := range m.Groups {
+			if g == req.Value {
+				in = true
+				break
+			}
+		}
+		switch req.Op {
+		case OpEq:
+			return in
+		case OpNotEq:
+			return !in
+		case OpExists:
+			return len(m.Groups) > 0
+		default:
+			return len(m.Groups) == 0
+		}
+	}
+	v, ok := m.Labels[req.Key]
+	switch req.Op {
+	case OpEq:
+		return ok && v == req.Value
+	case OpNotEq:
+		return !ok || v != req.Value
+	case OpExists:
+		return ok
+	default:
+		return !ok
+	}
+}
@@ -0,0 +1,91 @@
+package domain
+
+import "time"
+
+// Role 用户角色
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // 只读：机器 / 历史 / 任务
+	RoleOperator Role = "operator" // 执行命令、维护机器
+	RoleAdmin    Role = "admin"    // 全部权限 (含敏感信息、电源控制与用户管理)
+)
+
+// Permission 操作权限
+type Permission string
+
+const (
+	PermView         Permission = "view"          // 查看机器 / 历史 / 任务
+	PermExec         Permission = "exec"          // 执行命令
+	PermEditMachines Permission = "edit_machines" // 新增 / 修改 / 删除 / 导入机器与分组
+	PermViewSecrets  Permission = "view_secrets"  // 未脱敏导出、设置全局私钥
+	PermPower        Permission = "power"         // 电源控制 (reboot / ipmitool power 等)
+	PermManageUsers  Permission = "manage_users"  // 用户与角色管理
+	PermViewAudit    Permission = "view_audit"    // 查询 / 导出 / 校验审计日志
+	PermManageData   Permission = "manage_data"   // 数据库备份 / 恢复与完整性检查
+)
+
+// RolePermissions 各角色拥有的权限
+var RolePermissions = map[Role][]Permission{
+	RoleViewer:   {PermView},
+	RoleOperator: {PermView, PermExec, PermEditMachines},
+	RoleAdmin:    {PermView, PermExec, PermEditMachines, PermViewSecrets, PermPower, PermManageUsers, PermViewAudit, PermManageData},
+}
+
+// Valid 是否为已定义的角色
+func (r Role) Valid() bool {
+	_, ok := RolePermissions[r]
+	return ok
+}
+
+// User 操作者；Groups 非空时只能查看和操作这些分组内的机器
+type User struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	Groups    []string  `json:"groups,omitempty"`
+	Disabled  bool      `json:"disabled,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// Can 是否拥有权限 (已禁用的用户没有任何权限)
+func (u User) Can(p Permission) bool {
+	if u.Disabled {
+		return false
+	}
+	for _, have := range RolePermissions[u.Role] {
+		if have == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Scoped 是否受分组范围限制
+func (u User) Scoped() bool { return len(u.Groups) > 0 }
+
+// InScope 机器是否在用户范围内 (不受限，或属于任一允许的分组)
+func (u User) InScope(m Machine) bool {
+	if !u.Scoped() {
+		return true
+	}
+	for _, g := range m.Groups {
+		if u.HasGroup(g) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasGroup 分组是否在用户范围内
+func (u User) HasGroup(name string) bool {
+	if !u.Scoped() {
+		return true
+	}
+	for _, g := range u.Groups {
+		if g == name {
+			return true
+		}
+	}
+	return false
+}
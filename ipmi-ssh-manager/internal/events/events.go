@@ -0,0 +1,90 @@
+// Package events 后端向前端推送的事件：类型化事件结构 + 事件总线接口。
+// 适配器：Wails (桌面窗口)、内存 (测试)、WebSocket/SSE (httpapi.Hub，server 模式)。
+package events
+
+import (
+	"context"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// 事件名 (前端 EventsOn 订阅)
+const (
+	NameExecResult  = "exec_result"
+	NameExecChunk   = "exec_chunk"
+	NameExecJobDone = "exec_job_done"
+)
+
+// Event 可推送的事件，数据本身按 JSON 编码
+type Event interface {
+	EventName() string
+}
+
+// Audience 有可见范围的事件：server 模式只推送给发起者 (owner)，
+// 或有查看权限且机器分组 (groups) 在其范围内的用户；不实现的事件推送给全部连接
+type Audience interface {
+	Audience() (owner string, groups []string)
+}
+
+// Bus 事件总线
+type Bus interface {
+	Publish(ev Event)
+}
+
+// ExecResult 单台机器执行完成；JobID 为空表示非任务的临时流执行
+type ExecResult struct {
+	JobID         string   `json:"job_id,omitempty"`
+	MachineID     int64    `json:"machine_id"`
+	IPMIIP        string   `json:"ipmi_ip"`
+	SSHIP         string   `json:"ssh_ip,omitempty"`
+	SSHUser       string   `json:"ssh_user,omitempty"`
+	Command       string   `json:"command"`
+	Stdout        string   `json:"stdout"`
+	Stderr        string   `json:"stderr"`
+	ExitCode      int      `json:"exit_code"`
+	Error         string   `json:"error"`
+	Progress      float64  `json:"progress"` // 0~1
+	UsedGlobalKey bool     `json:"used_global_key"`
+	Passed        bool     `json:"passed"`
+	AssertMsg     string   `json:"assert_msg,omitempty"`
+	User          string   `json:"user,omitempty"`         // 发起执行的用户
+	Truncated     bool     `json:"truncated,omitempty"`    // 输出超过上限，只保留开头与结尾
+	OutputBytes   int64    `json:"output_bytes,omitempty"` // 原始 stdout + stderr 字节数
+	Groups        []string `json:"-"`                      // 机器所属分组 (仅用于推送范围)
+}
+
+func (ExecResult) EventName() string { return NameExecResult }
+
+func (e ExecResult) Audience() (string, []string) { return e.User, e.Groups }
+
+// ExecChunk 实时输出片段
+type ExecChunk struct {
+	JobID     string   `json:"job_id,omitempty"`
+	MachineID int64    `json:"machine_id"`
+	IPMIIP    string   `json:"ipmi_ip"`
+	Chunk     string   `json:"chunk"`
+	IsErr     bool     `json:"is_err"`
+	User      string   `json:"user,omitempty"`
+	Groups    []string `json:"-"`
+}
+
+func (ExecChunk) EventName() string { return NameExecChunk }
+
+func (e ExecChunk) Audience() (string, []string) { return e.User, e.Groups }
+
+// JobDone 任务结束 (全部机器完成或被取消)
+type JobDone struct {
+	JobID string `json:"job_id"`
+	User  string `json:"user,omitempty"`
+}
+
+func (JobDone) EventName() string { return NameExecJobDone }
+
+func (e JobDone) Audience() (string, []string) { return e.User, nil }
+
+// WailsBus 通过 Wails runtime 推送 (需 OnStartup 注入的 context)
+type WailsBus struct{ ctx context.Context }
+
+func NewWailsBus(ctx context.Context) *WailsBus { return &WailsBus{ctx: ctx} }
+
+func (w *WailsBus) Publish(ev Event) { runtime.EventsEmit(w.ctx, ev.EventName(), ev) }
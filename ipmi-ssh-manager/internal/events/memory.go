@@ -0,0 +1,52 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryBus 记录全部事件的内存总线，供测试断言事件顺序
+type MemoryBus struct {
+	mu      sync.Mutex
+	events  []Event
+	changed chan struct{} // 每次 Publish 关闭并替换，用于唤醒等待者
+}
+
+func NewMemoryBus() *MemoryBus { return &MemoryBus{changed: make(chan struct{})} }
+
+func (b *MemoryBus) Publish(ev Event) {
+	b.mu.Lock()
+	b.events = append(b.events, ev)
+	close(b.changed)
+	b.changed = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// Events 返回已发布事件的副本 (按发布顺序)
+func (b *MemoryBus) Events() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Event(nil), b.events...)
+}
+
+// WaitFor 等待出现名为 name 的事件，超时返回 false
+func (b *MemoryBus) WaitFor(name string, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		b.mu.Lock()
+		for _, ev := range b.events {
+			if ev.EventName() == name {
+				b.mu.Unlock()
+				return true
+			}
+		}
+		changed := b.changed
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
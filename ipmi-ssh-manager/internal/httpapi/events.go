@@ -0,0 +1,190 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/events"
+)
+
+// message 推送给浏览器的消息 (与 Wails EventsOn 的事件名 / 数据一致)
+type message struct {
+	Name string       `json:"name"`
+	Data events.Event `json:"data"`
+}
+
+// subscriberBuffer 每个订阅者的缓冲；写满说明客户端过慢，断开由其重连
+const subscriberBuffer = 1024
+
+// Hub 事件总线的 WebSocket 适配器：推送给所有 WebSocket 与 SSE 连接；
+// 启用认证时按连接的用户过滤 (见 canSee)
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan []byte]subscriber
+}
+
+// subscriber 连接的认证用户；authed 为 false 表示未启用认证，接收全部事件
+type subscriber struct {
+	user   domain.User
+	authed bool
+}
+
+var _ events.Bus = (*Hub)(nil)
+
+func NewHub() *Hub { return &Hub{subs: make(map[chan []byte]subscriber)} }
+
+// canSee 用户是否可以收到事件：需查看权限；有范围的事件 (events.Audience) 只推送给
+// 发起者或机器在其分组范围内的用户，与 REST 接口的可见性一致
+func canSee(u domain.User, ev events.Event) bool {
+	if !u.Can(domain.PermView) {
+		return false
+	}
+	a, ok := ev.(events.Audience)
+	if !ok {
+		return true
+	}
+	owner, groups := a.Audience()
+	return (owner != "" && owner == u.Name) || u.InScope(domain.Machine{Groups: groups})
+}
+
+// Publish 推送事件给可见的订阅者
+func (h *Hub) Publish(ev events.Event) {
+	msg, err := json.Marshal(message{Name: ev.EventName(), Data: ev})
+	if err != nil {
+		log.Printf("httpapi: encode event %s: %v", ev.EventName(), err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch, sub := range h.subs {
+		if sub.authed && !canSee(sub.user, ev) {
+			continue
+		}
+		select {
+		case ch <- msg:
+		default: // 慢客户端：断开
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe 以请求中认证的用户订阅 (requireAuth 放入 context)
+func (h *Hub) subscribe(r *http.Request) chan []byte {
+	ch := make(chan []byte, subscriberBuffer)
+	u, authed := r.Context().Value(userKey{}).(domain.User)
+	h.mu.Lock()
+	h.subs[ch] = subscriber{user: u, authed: authed}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *Hub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Subscribers 当前订阅者数量
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+// wsPingInterval 心跳间隔，保持经过代理的空闲连接
+const wsPingInterval = 30 * time.Second
+
+// ServeWS WebSocket 推送，每条消息为 {"name": 事件名, "data": 事件数据}
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	ch := h.subscribe(r) // 先订阅，握手完成后客户端不会错过事件
+	defer h.unsubscribe(ch)
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade 已写回错误响应
+	}
+	defer conn.Close()
+
+	// 读循环仅用于感知客户端断开
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer"))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// ServeSSE Server-Sent Events 推送 (WebSocket 不可用时的备选)，event 字段为事件名
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	ch := h.subscribe(r)
+	defer h.unsubscribe(ch)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var ev struct {
+				Name string          `json:"name"`
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(msg, &ev); err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Name, ev.Data)
+			flusher.Flush()
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
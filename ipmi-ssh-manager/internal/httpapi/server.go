@@ -0,0 +1,277 @@
+// Package httpapi 以 HTTP 服务方式运行：静态托管 webui，按 Backend 方法映射 JSON 接口，
+// 并通过 WebSocket / SSE 推送 exec_result / exec_chunk / exec_job_done 事件，
+// 使同一前端可在浏览器中使用。启用 Auth 后 /api/ 下的接口需携带访问令牌。
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+// apiMethods 对外暴露的方法 (白名单)。backend 的其余导出方法不可调用：
+// 装配用的 Set* (SetLogger / SetBackupService 等)、ForUser (身份由令牌决定)、生命周期钩子与 GetGlobalSSHKey (私钥不可经网络读取)。
+// 新增接口须在此登记
+var apiMethods = map[string]bool{
+	// 用户与审计
+	"CurrentUser": true, "ListUsers": true, "CreateUser": true, "UpdateUser": true, "DeleteUser": true, "ResetUserToken": true,
+	"QueryAudit": true, "ExportAudit": true, "VerifyAudit": true,
+	// 机器、分组与回收站
+	"MachinesLookup": true, "ListMachines": true, "UpsertMachine": true, "DeleteMachine": true,
+	"SelectMachines": true, "QueryMachines": true, "ListGroups": true, "DeleteGroup": true,
+	"ImportMachines": true, "ExportMachines": true, "SetGlobalSSHKey": true, "HasGlobalSSHKey": true,
+	"MachineRevisions": true, "DiffMachineRevisions": true, "ListTrash": true, "RestoreMachine": true, "PurgeMachine": true,
+	// 执行与策略
+	"Execute": true, "ExecuteStream": true, "ExecuteStreamEvents": true, "ExecuteStreamChunks": true,
+	"StartJob": true, "StartJobWithSelector": true, "StartJobRequest": true, "PreviewCommand": true,
+	"AnalyzeJob": true, "CancelJob": true, "ListJobs": true,
+	"CheckPolicy": true, "ListApprovals": true, "ApproveExec": true, "RejectExec": true,
+	// 历史与报告
+	"RecentHistory": true, "RecentHistoryFiltered": true, "HistoryWriterStats": true, "HistoryOutput": true,
+	"SearchHistory": true, "HistoryAnalytics": true, "HistoryTrend": true, "ExportReport": true,
+	// 运维
+	"ListBackups": true, "CreateBackup": true, "RestoreBackup": true, "CheckIntegrity": true, "TailLogs": true,
+}
+
+// maxBodyBytes 单次请求体上限 (导入机器时数据较大)
+const maxBodyBytes = 32 << 20
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Server HTTP 服务：/api/call/<Method> 调用绑定对象方法，/api/events 推送事件，其余路径为静态资源
+type Server struct {
+	target  reflect.Value
+	methods map[string]reflect.Method
+	hub     *Hub
+	assets  fs.FS
+	auth    *Auth
+}
+
+// Auth 访问令牌认证
+type Auth struct {
+	Authenticate func(token string) (domain.User, error) // 令牌无效时返回 service.ErrUnauthenticated
+	Bind         func(domain.User) any                   // 以该用户身份调用的 backend (须与 NewServer 的 backend 同类型)
+}
+
+// SetAuth 启用认证：/api/ 下的接口需携带 Authorization: Bearer <token>；
+// 浏览器 WebSocket / EventSource 无法设置请求头，可改用 ?token= 参数
+func (s *Server) SetAuth(a *Auth) { s.auth = a }
+
+// NewServer 以 backend (通常为 *wailsapi.Backend) 在 apiMethods 中登记的导出方法构建接口；assets 为前端静态资源
+func NewServer(backend any, hub *Hub, assets fs.FS) *Server {
+	v := reflect.ValueOf(backend)
+	t := v.Type()
+	methods := make(map[string]reflect.Method)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if !apiMethods[m.Name] || !callable(m.Type) {
+			continue
+		}
+		methods[m.Name] = m
+	}
+	return &Server{target: v, methods: methods, hub: hub, assets: assets}
+}
+
+// callable 参数须可 JSON 解码，返回值最多两个且第二个为 error
+func callable(t reflect.Type) bool {
+	for i := 1; i < t.NumIn(); i++ { // 0 为接收者
+		switch t.In(i).Kind() {
+		case reflect.Interface, reflect.Func, reflect.Chan:
+			return false
+		}
+	}
+	switch t.NumOut() {
+	case 0, 1:
+		return true
+	case 2:
+		return t.Out(1) == errorType
+	}
+	return false
+}
+
+// Methods 返回已暴露的方法名 (有序)
+func (s *Server) Methods() []string {
+	names := make([]string, 0, len(s.methods))
+	for n := range s.methods {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Handler 返回完整路由
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/methods", s.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Methods())
+	}))
+	mux.HandleFunc("POST /api/call/{method}", s.requireAuth(s.handleCall))
+	mux.HandleFunc("GET /api/events", s.requireAuth(s.hub.ServeWS))
+	mux.HandleFunc("GET /api/events/sse", s.requireAuth(s.hub.ServeSSE))
+	if s.assets != nil {
+		mux.Handle("GET /", http.FileServerFS(s.assets))
+	}
+	return mux
+}
+
+// handleCall 请求体为位置参数数组 (与 Wails 绑定调用一致)，缺省参数取零值；
+// 成功返回方法结果的 JSON，失败返回 {"error": "..."}
+func (s *Server) handleCall(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.methods[r.PathValue("method")]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown method %q", r.PathValue("method")))
+		return
+	}
+	args, err := decodeArgs(m.Type, http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	target := s.target
+	if u, ok := r.Context().Value(userKey{}).(domain.User); ok && s.auth.Bind != nil {
+		if target = reflect.ValueOf(s.auth.Bind(u)); target.Type() != s.target.Type() {
+			writeError(w, http.StatusInternalServerError, errors.New("auth: Bind returned a different backend type"))
+			return
+		}
+	}
+	out := m.Func.Call(append([]reflect.Value{target}, args...))
+	var result any
+	switch len(out) {
+	case 1:
+		if m.Type.Out(0) == errorType {
+			err, _ = out[0].Interface().(error)
+		} else {
+			result = out[0].Interface()
+		}
+	case 2:
+		result = out[0].Interface()
+		err, _ = out[1].Interface().(error)
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+type userKey struct{}
+
+// requireAuth 校验访问令牌并把用户放入请求 context；未启用认证时直接放行
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil {
+			next(w, r)
+			return
+		}
+		u, err := s.auth.Authenticate(requestToken(r))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	}
+}
+
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return r.URL.Query().Get("token")
+}
+
+// errorStatus 检索语法错误 400，认证失败 401，权限不足或策略拒绝 403，版本冲突 409，需确认 / 审批 428，其余 500
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrInvalidSearchQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, service.ErrPolicyDenied):
+		return http.StatusForbidden
+	case errors.Is(err, repository.ErrVersionConflict):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrConfirmRequired), errors.Is(err, service.ErrApprovalRequired):
+		return http.StatusPreconditionRequired
+	}
+	return http.StatusInternalServerError
+}
+
+// ListenAndServe 监听 addr 直到 ctx 结束，随后优雅关闭 (最多等待 5 秒)
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutCtx)
+}
+
+func decodeArgs(t reflect.Type, body io.Reader) ([]reflect.Value, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("body must be a JSON array of arguments: %w", err)
+	}
+	n := t.NumIn() - 1
+	if len(raw) > n {
+		return nil, fmt.Errorf("too many arguments: got %d, want %d", len(raw), n)
+	}
+	args := make([]reflect.Value, n)
+	for i := 0; i < n; i++ {
+		p := reflect.New(t.In(i + 1))
+		if i < len(raw) && !isNull(raw[i]) {
+			if err := json.Unmarshal(raw[i], p.Interface()); err != nil {
+				return nil, fmt.Errorf("argument %d: %w", i, err)
+			}
+		}
+		args[i] = p.Elem()
+	}
+	return args, nil
+}
+
+func isNull(b json.RawMessage) bool { return strings.TrimSpace(string(b)) == "null" }
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError 输出 {"error": ...}；策略拦截时附带 "policy" (判定、确认令牌或审批单 ID)，
+// 版本冲突时附带 "conflicts" (各机器的期望与当前版本)
+func writeError(w http.ResponseWriter, status int, err error) {
+	var (
+		pe  *service.PolicyError
+		ce  *repository.ConflictError
+		bce *repository.BulkConflictError
+	)
+	switch {
+	case errors.As(err, &pe):
+		writeJSON(w, status, map[string]any{"error": err.Error(), "policy": pe.Decision})
+		return
+	case errors.As(err, &ce):
+		writeJSON(w, status, map[string]any{"error": err.Error(), "conflicts": []repository.ConflictError{*ce}})
+		return
+	case errors.As(err, &bce):
+		writeJSON(w, status, map[string]any{"error": err.Error(), "conflicts": bce.Conflicts, "applied": bce.Applied})
+		return
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
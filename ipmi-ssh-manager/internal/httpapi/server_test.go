@@ -0,0 +1,391 @@
+package httpapi
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	_ "modernc.org/sqlite"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+	sshmock "github.com/QingMing-Bot/ipmi-ssh-manager/internal/ssh"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/wailsapi"
+)
+
+// newTestBackend 内存库 + MockExecutor；已启用用户管理
+func newTestBackend(t *testing.T) (*wailsapi.Backend, *service.UserService, *sshmock.MockExecutor) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	mRepo := repository.NewMachineRepo(db)
+	if err := mRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	hRepo := repository.NewHistoryRepo(db)
+	if err := hRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	hWriter := service.NewHistoryWriter(hRepo, 1, 10)
+	t.Cleanup(hWriter.Close)
+	uRepo := repository.NewUserRepo(db)
+	if err := uRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	mock := sshmock.NewMockExecutor()
+	backend := wailsapi.NewBackend(db, mRepo, hRepo, service.NewExecService(mRepo, hWriter, mock, 4))
+	users := service.NewUserService(uRepo)
+	backend.SetUserService(users)
+	return backend, users, mock
+}
+
+// newTestServer 未启用认证的完整服务
+func newTestServer(t *testing.T) (*httptest.Server, *sshmock.MockExecutor) {
+	backend, _, mock := newTestBackend(t)
+	hub := NewHub()
+	backend.SetEventBus(hub)
+	srv := httptest.NewServer(NewServer(backend, hub, nil).Handler())
+	t.Cleanup(srv.Close)
+	return srv, mock
+}
+
+func callAPI(t *testing.T, srv *httptest.Server, method string, args ...any) (int, []byte) {
+	t.Helper()
+	return callAPIAs(t, srv, "", method, args...)
+}
+
+// callAPIAs 携带访问令牌调用 (token 为空时不带 Authorization)
+func callAPIAs(t *testing.T, srv *httptest.Server, token, method string, args ...any) (int, []byte) {
+	t.Helper()
+	body, _ := json.Marshal(args)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/call/"+method, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	_, _ = buf.ReadFrom(resp.Body)
+	return resp.StatusCode, buf.Bytes()
+}
+
+func TestServer_Call(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	if code, body := callAPI(t, srv, "UpsertMachine", domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root"}); code != http.StatusOK {
+		t.Fatalf("UpsertMachine: %d %s", code, body)
+	}
+	code, body := callAPI(t, srv, "ListMachines")
+	if code != http.StatusOK {
+		t.Fatalf("ListMachines: %d %s", code, body)
+	}
+	var ms []domain.Machine
+	if err := json.Unmarshal(body, &ms); err != nil || len(ms) != 1 || ms[0].IPMIIP != "10.0.0.1" {
+		t.Fatalf("unexpected machines %s (%v)", body, err)
+	}
+	// 携带过期版本的保存 -> 409 + {"conflicts"}
+	stale := ms[0]
+	stale.Remark = "first"
+	if code, body := callAPI(t, srv, "UpsertMachine", stale); code != http.StatusOK {
+		t.Fatalf("UpsertMachine with version: %d %s", code, body)
+	}
+	stale.Remark = "second"
+	if code, body := callAPI(t, srv, "UpsertMachine", stale); code != http.StatusConflict || !strings.Contains(string(body), `"current":2`) {
+		t.Fatalf("stale UpsertMachine: %d %s", code, body)
+	}
+	// 缺省参数取零值: QueryMachines(query) 省略 offset/limit
+	if code, body := callAPI(t, srv, "QueryMachines", "ipmi_ip=10.0.0.1"); code != http.StatusOK || !strings.Contains(string(body), `"total":1`) {
+		t.Fatalf("QueryMachines: %d %s", code, body)
+	}
+	// 方法返回的错误 -> 500 + {"error"}
+	if code, body := callAPI(t, srv, "SetGlobalSSHKey", "  "); code != http.StatusInternalServerError || !strings.Contains(string(body), "empty key") {
+		t.Fatalf("SetGlobalSSHKey: %d %s", code, body)
+	}
+	if code, _ := callAPI(t, srv, "GetGlobalSSHKey"); code != http.StatusNotFound {
+		t.Fatalf("GetGlobalSSHKey must not be exposed, got %d", code)
+	}
+	if code, _ := callAPI(t, srv, "DeleteGroup", "a", "b"); code != http.StatusBadRequest {
+		t.Fatalf("too many args: got %d", code)
+	}
+	if code, _ := callAPI(t, srv, "ListMachines", "x"); code != http.StatusBadRequest {
+		t.Fatalf("extra arg: got %d", code)
+	}
+}
+
+func TestServer_MethodsAllowList(t *testing.T) {
+	backend, _, _ := newTestBackend(t)
+	methods := NewServer(backend, NewHub(), nil).Methods()
+	if len(methods) != len(apiMethods) { // 登记的方法须存在于 Backend 且可调用
+		for name := range apiMethods {
+			if !slices.Contains(methods, name) {
+				t.Errorf("allow-listed method %s is not exposed", name)
+			}
+		}
+	}
+	for _, name := range methods {
+		// 装配用的 Set* 不可经网络调用；SetGlobalSSHKey 为受 view_secrets 保护的接口
+		if strings.HasPrefix(name, "Set") && name != "SetGlobalSSHKey" {
+			t.Errorf("setter %s is exposed", name)
+		}
+	}
+	srv, _ := newTestServer(t)
+	for _, name := range []string{"SetLogger", "SetBackupService", "ForUser", "GetGlobalSSHKey"} {
+		if code, _ := callAPI(t, srv, name); code != http.StatusNotFound {
+			t.Errorf("%s must not be exposed, got %d", name, code)
+		}
+	}
+}
+
+func TestServer_WebSocketEvents(t *testing.T) {
+	srv, mock := newTestServer(t)
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up\n"})
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		if code, body := callAPI(t, srv, "UpsertMachine", domain.Machine{IPMIIP: ip, SSHIP: ip, SSHUser: "root", SSHKey: "k"}); code != http.StatusOK {
+			t.Fatalf("UpsertMachine: %d %s", code, body)
+		}
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	code, body := callAPI(t, srv, "StartJob", "job-ws", "uptime", []int64{1, 2}, 5, 2, "key", "", false)
+	if code != http.StatusOK || string(bytes.TrimSpace(body)) != `"job-ws"` {
+		t.Fatalf("StartJob: %d %s", code, body)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	results := 0
+	for {
+		var ev struct {
+			Name string         `json:"name"`
+			Data map[string]any `json:"data"`
+		}
+		if err := conn.ReadJSON(&ev); err != nil {
+			t.Fatalf("read event: %v (results so far %d)", err, results)
+		}
+		if ev.Data["job_id"] != "job-ws" {
+			t.Fatalf("unexpected job id in %+v", ev)
+		}
+		switch ev.Name {
+		case "exec_result":
+			results++
+			if ev.Data["stdout"] != "up\n" {
+				t.Fatalf("unexpected result %+v", ev.Data)
+			}
+		case "exec_job_done":
+			if results != 2 {
+				t.Fatalf("job done after %d results, want 2", results)
+			}
+			return
+		}
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+	backend, users, mock := newTestBackend(t)
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up\n"})
+	hub := NewHub()
+	backend.SetEventBus(hub)
+	s := NewServer(backend, hub, nil)
+	s.SetAuth(&Auth{Authenticate: users.Authenticate, Bind: func(u domain.User) any { return backend.ForUser(u) }})
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+
+	adminToken, err := users.Bootstrap("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := callAPI(t, srv, "ListMachines"); code != http.StatusUnauthorized {
+		t.Fatalf("no token: got %d", code)
+	}
+	if code, _ := callAPIAs(t, srv, "bogus", "ListMachines"); code != http.StatusUnauthorized {
+		t.Fatalf("bad token: got %d", code)
+	}
+	if _, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/events", nil); err == nil {
+		t.Fatal("websocket without token accepted")
+	}
+	code, body := callAPIAs(t, srv, adminToken, "CreateUser", "vic", "viewer")
+	if code != http.StatusOK {
+		t.Fatalf("CreateUser: %d %s", code, body)
+	}
+	var viewerToken string
+	_ = json.Unmarshal(body, &viewerToken)
+	if code, body := callAPIAs(t, srv, adminToken, "UpsertMachine", domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root", SSHKey: "k"}); code != http.StatusOK {
+		t.Fatalf("UpsertMachine: %d %s", code, body)
+	}
+
+	if code, body := callAPIAs(t, srv, viewerToken, "CurrentUser"); code != http.StatusOK || !strings.Contains(string(body), `"name":"vic"`) {
+		t.Fatalf("CurrentUser: %d %s", code, body)
+	}
+	if code, _ := callAPIAs(t, srv, viewerToken, "ListMachines"); code != http.StatusOK {
+		t.Fatalf("viewer list: %d", code)
+	}
+	for _, c := range []struct {
+		method string
+		args   []any
+	}{
+		{"Execute", []any{"uptime", []int64{1}, 5, 1, "key", ""}},
+		{"DeleteMachine", []any{"10.0.0.1"}},
+		{"ListUsers", nil},
+	} {
+		if code, body := callAPIAs(t, srv, viewerToken, c.method, c.args...); code != http.StatusForbidden {
+			t.Fatalf("viewer %s: %d %s", c.method, code, body)
+		}
+	}
+	if code, _ := callAPIAs(t, srv, viewerToken, "ForUser", domain.User{Name: "x", Role: domain.RoleAdmin}); code != http.StatusNotFound {
+		t.Fatalf("ForUser must not be exposed, got %d", code)
+	}
+	for _, name := range []string{"SetBackupService", "SetLogger"} { // 为 nil 会关闭备份 / 日志
+		if code, _ := callAPIAs(t, srv, viewerToken, name); code != http.StatusNotFound {
+			t.Fatalf("viewer %s: got %d", name, code)
+		}
+	}
+
+	code, body = callAPIAs(t, srv, adminToken, "Execute", "uptime", []int64{1}, 5, 1, "key", "")
+	if code != http.StatusOK {
+		t.Fatalf("admin Execute: %d %s", code, body)
+	}
+	var rs []domain.ExecResult
+	if err := json.Unmarshal(body, &rs); err != nil || len(rs) != 1 || rs[0].User != "admin" {
+		t.Fatalf("admin Execute result %s (%v)", body, err)
+	}
+}
+
+func TestServer_EventsScopedByGroup(t *testing.T) {
+	backend, users, mock := newTestBackend(t)
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up\n"})
+	hub := NewHub()
+	backend.SetEventBus(hub)
+	s := NewServer(backend, hub, nil)
+	s.SetAuth(&Auth{Authenticate: users.Authenticate, Bind: func(u domain.User) any { return backend.ForUser(u) }})
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+
+	adminToken, err := users.Bootstrap("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{"admin": adminToken}
+	for _, u := range []struct{ name, group string }{{"opa", "a"}, {"opb", "b"}} {
+		code, body := callAPIAs(t, srv, adminToken, "CreateUser", u.name, "operator", []string{u.group})
+		if code != http.StatusOK {
+			t.Fatalf("CreateUser %s: %d %s", u.name, code, body)
+		}
+		var tok string
+		_ = json.Unmarshal(body, &tok)
+		tokens[u.name] = tok
+	}
+	for _, m := range []domain.Machine{
+		{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root", SSHKey: "k", Groups: []string{"a"}},
+		{IPMIIP: "10.0.0.2", SSHIP: "10.0.0.2", SSHUser: "root", SSHKey: "k", Groups: []string{"b"}},
+	} {
+		if code, body := callAPIAs(t, srv, adminToken, "UpsertMachine", m); code != http.StatusOK {
+			t.Fatalf("UpsertMachine: %d %s", code, body)
+		}
+	}
+	conns := map[string]*websocket.Conn{}
+	for name, tok := range tokens {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/events?token="+tok, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns[name] = conn
+	}
+	// readUntilDone 读取连接上的事件直到 jobID 结束，返回 "事件名 job_id ipmi_ip" 列表
+	readUntilDone := func(name, jobID string) []string {
+		conn := conns[name]
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var seen []string
+		for {
+			var ev struct {
+				Name string         `json:"name"`
+				Data map[string]any `json:"data"`
+			}
+			if err := conn.ReadJSON(&ev); err != nil {
+				t.Fatalf("%s read event: %v (seen %v)", name, err, seen)
+			}
+			seen = append(seen, fmt.Sprint(ev.Name, " ", ev.Data["job_id"], " ", ev.Data["ipmi_ip"]))
+			if ev.Name == "exec_job_done" && ev.Data["job_id"] == jobID {
+				return seen
+			}
+		}
+	}
+	start := func(name, jobID string, ids ...int64) {
+		if code, body := callAPIAs(t, srv, tokens[name], "StartJob", jobID, "uptime", ids, 5, 2, "key", "", false); code != http.StatusOK {
+			t.Fatalf("%s StartJob: %d %s", name, code, body)
+		}
+	}
+
+	start("admin", "job-all", 1, 2)
+	if seen := readUntilDone("admin", "job-all"); len(seen) != 3 {
+		t.Fatalf("admin should see both hosts: %v", seen)
+	}
+	// 各自的任务作为哨兵：此前的事件已按顺序送达
+	start("opa", "job-a", 1)
+	got := strings.Join(readUntilDone("opa", "job-a"), "|")
+	if want := "exec_result job-all 10.0.0.1|exec_result job-a 10.0.0.1|exec_job_done job-a <nil>"; got != want {
+		t.Fatalf("opa events:\n got %s\nwant %s", got, want)
+	}
+	start("opb", "job-b", 2)
+	got = strings.Join(readUntilDone("opb", "job-b"), "|")
+	if want := "exec_result job-all 10.0.0.2|exec_result job-b 10.0.0.2|exec_job_done job-b <nil>"; got != want {
+		t.Fatalf("opb events:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestServer_PolicyConfirm(t *testing.T) {
+	backend, _, mock := newTestBackend(t)
+	mock.Set("reboot", sshmock.MockResult{})
+	policy, err := service.NewPolicy(service.PolicyConfig{Rules: []service.PolicyRule{{Name: "power", Pattern: `\breboot\b`, Action: "warn"}, {Name: "wipe", Pattern: `\bwipefs\b`, Action: "deny"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.SetPolicy(policy)
+	hub := NewHub()
+	backend.SetEventBus(hub)
+	srv := httptest.NewServer(NewServer(backend, hub, nil).Handler())
+	t.Cleanup(srv.Close)
+
+	m := domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root"}
+	if code, body := callAPI(t, srv, "UpsertMachine", m); code != http.StatusOK {
+		t.Fatalf("UpsertMachine: %d %s", code, body)
+	}
+	req := map[string]any{"command": "reboot", "selector": "", "machine_ids": []int64{1}}
+	code, body := callAPI(t, srv, "StartJobRequest", req)
+	var resp struct {
+		Error  string                `json:"error"`
+		Policy domain.PolicyDecision `json:"policy"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || code != http.StatusPreconditionRequired || resp.Policy.ConfirmToken == "" {
+		t.Fatalf("unconfirmed reboot: %d %s (%v)", code, body, err)
+	}
+	req["confirm"] = resp.Policy.ConfirmToken
+	if code, body := callAPI(t, srv, "StartJobRequest", req); code != http.StatusOK {
+		t.Fatalf("confirmed reboot: %d %s", code, body)
+	}
+	req = map[string]any{"command": "wipefs -a /dev/sdb", "machine_ids": []int64{1}}
+	if code, body := callAPI(t, srv, "CheckPolicy", req); code != http.StatusOK || !strings.Contains(string(body), `"verdict":"deny"`) {
+		t.Fatalf("CheckPolicy: %d %s", code, body)
+	}
+	if code, body := callAPI(t, srv, "StartJobRequest", req); code != http.StatusForbidden {
+		t.Fatalf("denied command: %d %s", code, body)
+	}
+}
@@ -0,0 +1,126 @@
+// Package logging 基于 log/slog 的结构化日志：JSON 写入按大小轮转的文件，可选同时输出到控制台；
+// 执行链路通过 context 携带 job_id / machine_id / ipmi_ip 等关联字段。
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// 统一的属性名，便于按字段检索日志
+const (
+	KeyJobID     = "job_id"
+	KeyMachineID = "machine_id"
+	KeyIPMIIP    = "ipmi_ip"
+	KeyUser      = "user"
+	KeyError     = "error"
+)
+
+// Options 日志配置
+type Options struct {
+	Level   slog.Level
+	File    io.Writer // JSON 输出 (通常为 *RotatingFile)，为空不写文件
+	Console io.Writer // 文本输出 (如 os.Stderr)，为空不输出；TUI 模式应为空
+}
+
+// New 创建 logger；File 与 Console 都为空时丢弃所有日志
+func New(opts Options) *slog.Logger {
+	ho := &slog.HandlerOptions{Level: opts.Level}
+	var hs []slog.Handler
+	if opts.File != nil {
+		hs = append(hs, slog.NewJSONHandler(opts.File, ho))
+	}
+	if opts.Console != nil {
+		hs = append(hs, slog.NewTextHandler(opts.Console, ho))
+	}
+	switch len(hs) {
+	case 0:
+		return Discard()
+	case 1:
+		return slog.New(hs[0])
+	}
+	return slog.New(teeHandler(hs))
+}
+
+// teeHandler 将记录分发到多个 handler
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+// Discard 丢弃全部输出的 logger (测试或未配置时使用)
+func Discard() *slog.Logger { return slog.New(slog.DiscardHandler) }
+
+// ParseLevel 解析 debug|info|warn|error，无法识别时为 info
+func ParseLevel(s string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// Err 错误属性 (nil 时值为空串)
+func Err(err error) slog.Attr {
+	if err == nil {
+		return slog.String(KeyError, "")
+	}
+	return slog.String(KeyError, err.Error())
+}
+
+type ctxKey struct{}
+
+// NewContext 返回携带 l 的 context，下游 (如 ssh 执行器) 用 FromContext 取出以保留关联字段
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext 取出 context 中的 logger，没有时返回 fallback (fallback 为空时返回 slog.Default())
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+			return l
+		}
+	}
+	if fallback != nil {
+		return fallback
+	}
+	return slog.Default()
+}
@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile_TailAcrossFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "app.log")
+	rf, err := OpenRotating(path, 400, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(Options{File: rf})
+	for i := range 20 {
+		jl := l.With(KeyJobID, fmt.Sprintf("job%d", i%2), KeyIPMIIP, "10.0.0.1")
+		if i%5 == 0 {
+			jl.Warn("host failed", "seq", i)
+		} else {
+			jl.Info("host done", "seq", i)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("x")); err == nil {
+		t.Fatal("write after close should fail")
+	}
+
+	// 只保留当前文件 + 2 个旧文件，且每个不超过上限
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		st, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Size() > 400 {
+			t.Fatalf("%s is %d bytes", name, st.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected at most 2 backups, stat .3: %v", err)
+	}
+
+	all, err := Tail(path, TailQuery{Lines: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) == 0 || len(all) >= 20 {
+		t.Fatalf("retained %d entries, want some but not all", len(all))
+	}
+	if seq := all[len(all)-1]["seq"]; seq != float64(19) {
+		t.Fatalf("last entry seq = %v", seq)
+	}
+	for i := 1; i < len(all); i++ { // 正序且跨文件连续
+		if all[i]["seq"].(float64) != all[i-1]["seq"].(float64)+1 {
+			t.Fatalf("entries out of order at %d: %v", i, all)
+		}
+	}
+
+	last, err := Tail(path, TailQuery{Lines: 3, JobID: "job1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 3 || last[0]["seq"] != float64(15) || last[2]["seq"] != float64(19) {
+		t.Fatalf("job filter = %v", last)
+	}
+	warns, err := Tail(path, TailQuery{Level: "warn", Contains: "host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range warns {
+		if e["level"] != "WARN" {
+			t.Fatalf("level filter let through %v", e)
+		}
+	}
+	if len(warns) == 0 || warns[len(warns)-1]["seq"] != float64(15) {
+		t.Fatalf("warn entries = %v", warns)
+	}
+	if _, err := Tail(path, TailQuery{Level: "loud"}); err == nil {
+		t.Fatal("invalid level should fail")
+	}
+	if es, err := Tail(filepath.Join(t.TempDir(), "none.log"), TailQuery{}); err != nil || len(es) != 0 {
+		t.Fatalf("missing file: %v %v", es, err)
+	}
+}
+
+func TestContextLogger(t *testing.T) {
+	var file, console bytes.Buffer
+	base := New(Options{File: &file, Console: &console, Level: ParseLevel("debug")})
+	ctx := NewContext(context.Background(), base.With(KeyJobID, "j1", KeyMachineID, 7))
+	FromContext(ctx, nil).Debug("dial", Err(fmt.Errorf("refused")))
+	if got := file.String(); !strings.Contains(got, `"job_id":"j1"`) || !strings.Contains(got, `"machine_id":7`) || !strings.Contains(got, `"error":"refused"`) {
+		t.Fatalf("json output = %s", got)
+	}
+	if got := console.String(); !strings.Contains(got, "job_id=j1") {
+		t.Fatalf("console output = %s", got)
+	}
+	fallback := Discard()
+	if FromContext(context.Background(), fallback) != fallback {
+		t.Fatal("FromContext should return fallback")
+	}
+	if ParseLevel("nonsense") != ParseLevel("info") {
+		t.Fatal("unknown level should default to info")
+	}
+}
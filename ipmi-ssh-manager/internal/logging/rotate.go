@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile 按大小轮转的日志文件：超过 MaxBytes 时 app.log → app.log.1 → app.log.2 ...，
+// 最多保留 MaxFiles 个旧文件
+type RotatingFile struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotating 打开 (必要时创建目录与文件) 日志文件；maxBytes<=0 不轮转，maxFiles<=0 时保留 1 个
+func OpenRotating(path string, maxBytes int64, maxFiles int) (*RotatingFile, error) {
+	if maxFiles <= 0 {
+		maxFiles = 1
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	r := &RotatingFile{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Path 当前日志文件路径
+func (r *RotatingFile) Path() string { return r.path }
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, st.Size()
+	return nil
+}
+
+// Write 写入一条记录；slog handler 每条记录调用一次，轮转不会拆开同一条记录
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	_ = os.Remove(backupName(r.path, r.maxFiles))
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(backupName(r.path, i), backupName(r.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(r.path, backupName(r.path, 1)); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// Close 关闭文件；之后的写入返回 os.ErrClosed
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+func backupName(path string, i int) string { return fmt.Sprintf("%s.%d", path, i) }
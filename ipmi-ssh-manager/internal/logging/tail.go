@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// TailQuery 读取最近日志的条件
+type TailQuery struct {
+	Lines    int    `json:"lines"`    // 返回条数上限 (<=0 为 200，最大 5000)
+	Level    string `json:"level"`    // 最低级别 debug|info|warn|error，空为全部
+	JobID    string `json:"job_id"`   // 只看该任务
+	Contains string `json:"contains"` // 原始行包含的子串 (区分大小写)
+}
+
+// Entry 一条日志 (JSON 解析结果；非 JSON 行放在 msg)
+type Entry map[string]any
+
+// maxTailLines Tail 单次返回上限
+const maxTailLines = 5000
+
+// Tail 从最新的日志往前读取，按时间正序返回最近 q.Lines 条匹配记录；会继续读已轮转的旧文件
+func Tail(path string, q TailQuery) ([]Entry, error) {
+	if q.Lines <= 0 {
+		q.Lines = 200
+	}
+	q.Lines = min(q.Lines, maxTailLines)
+	var minLevel *slog.Level
+	if q.Level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(q.Level)); err != nil {
+			return nil, err
+		}
+		minLevel = &l
+	}
+	var out []Entry
+	for i := 0; len(out) < q.Lines; i++ {
+		name := path
+		if i > 0 {
+			name = backupName(path, i)
+		}
+		err := readLinesBackward(name, func(line []byte) bool {
+			if e, ok := matchLine(line, q, minLevel); ok {
+				out = append(out, e)
+			}
+			return len(out) < q.Lines
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			if i == 0 { // 尚未写过日志
+				continue
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.Reverse(out)
+	return out, nil
+}
+
+func matchLine(line []byte, q TailQuery, minLevel *slog.Level) (Entry, bool) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil, false
+	}
+	if q.Contains != "" && !bytes.Contains(line, []byte(q.Contains)) {
+		return nil, false
+	}
+	var e Entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		e = Entry{slog.MessageKey: string(line)}
+	}
+	if q.JobID != "" {
+		if id, _ := e[KeyJobID].(string); id != q.JobID {
+			return nil, false
+		}
+	}
+	if minLevel != nil {
+		s, _ := e[slog.LevelKey].(string)
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil || l < *minLevel {
+			return nil, false
+		}
+	}
+	return e, true
+}
+
+// readLinesBackward 从文件末尾起逐行 (去掉换行) 回调，fn 返回 false 时停止
+func readLinesBackward(name string, fn func([]byte) bool) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	const chunk = 64 << 10
+	var (
+		pos  = st.Size()
+		rest []byte // 尚未遇到行首的尾部片段
+	)
+	for pos > 0 {
+		n := min(int64(chunk), pos)
+		pos -= n
+		buf := make([]byte, n, n+int64(len(rest)))
+		if _, err := f.ReadAt(buf, pos); err != nil && err != io.EOF {
+			return err
+		}
+		buf = append(buf, rest...)
+		for {
+			i := bytes.LastIndexByte(buf, '\n')
+			if i < 0 {
+				break
+			}
+			if line := buf[i+1:]; len(line) > 0 && !fn(line) {
+				return nil
+			}
+			buf = buf[:i]
+		}
+		rest = buf
+	}
+	if len(rest) > 0 {
+		fn(rest)
+	}
+	return nil
+}
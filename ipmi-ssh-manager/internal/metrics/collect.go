@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/ssh"
+)
+
+// Sources 需要采集的组件；为 nil 的项跳过
+type Sources struct {
+	Exec    *service.ExecService
+	History *service.HistoryWriter
+	SSH     *ssh.Executor
+	// SQLite 为 true 时挂载 repository 全局查询耗时回调
+	SQLite bool
+}
+
+// Register 在 reg 上注册各组件指标并挂载观察回调；应在开始执行任务前调用
+func Register(reg *Registry, src Sources) {
+	if src.Exec != nil {
+		commands := reg.Counter("ipmi_exec_commands_total", "Commands executed per host, by result (ok|failed).", "result")
+		failures := reg.Counter("ipmi_exec_failures_total", "Failed per-host executions by error class.", "class")
+		duration := reg.Histogram("ipmi_exec_host_duration_seconds", "Per-host command duration in seconds.", nil, "host")
+		src.Exec.SetExecObserver(func(r domain.ExecResult, d time.Duration) {
+			class := service.ErrorClass(r)
+			if class == "ok" {
+				commands.Inc("ok")
+			} else {
+				commands.Inc("failed")
+				failures.Inc(class)
+			}
+			if r.IPMIIP != "" {
+				duration.Observe(d.Seconds(), r.IPMIIP)
+			}
+		})
+		exec := src.Exec
+		reg.GaugeFunc("ipmi_exec_active_jobs", "Batch executions currently running.", func() float64 { return float64(exec.ActiveRuns()) })
+	}
+	if src.SSH != nil {
+		e := src.SSH
+		reg.GaugeFunc("ipmi_ssh_pool_connections", "SSH connections currently held in the pool.", func() float64 { return float64(e.PoolStats().Open) })
+		reg.CounterFunc("ipmi_ssh_pool_dials_total", "SSH connections dialed.", func() float64 { return float64(e.PoolStats().Dials) })
+		reg.CounterFunc("ipmi_ssh_pool_dial_errors_total", "SSH dials that failed (network or auth).", func() float64 { return float64(e.PoolStats().DialErrors) })
+		reg.CounterFunc("ipmi_ssh_pool_evictions_total", "Pooled SSH connections dropped after a failed health check.", func() float64 { return float64(e.PoolStats().Evictions) })
+	}
+	if src.History != nil {
+		w := src.History
+		reg.GaugeFunc("ipmi_history_queue_depth", "History records waiting to be written.", func() float64 { return float64(w.Stats().QueueDepth) })
+		reg.GaugeFunc("ipmi_history_queue_capacity", "History writer queue capacity.", func() float64 { return float64(w.Stats().QueueCapacity) })
+		reg.CounterFunc("ipmi_history_written_total", "History records written.", func() float64 { return float64(w.Stats().Written) })
+		reg.CounterFunc("ipmi_history_write_errors_total", "History records lost because they failed to insert and could not be spilled.", func() float64 { return float64(w.Stats().Failed) })
+		reg.CounterFunc("ipmi_history_dropped_total", "History records lost because the queue was full and could not be spilled.", func() float64 { return float64(w.Stats().Dropped) })
+		reg.CounterFunc("ipmi_history_spilled_total", "History records spilled to the on-disk journal.", func() float64 { return float64(w.Stats().Spilled) })
+		reg.CounterFunc("ipmi_history_replayed_total", "History records replayed from the on-disk journal.", func() float64 { return float64(w.Stats().Replayed) })
+		reg.CounterFunc("ipmi_history_busy_retries_total", "History batch inserts retried because the database was busy.", func() float64 { return float64(w.Stats().Retries) })
+		reg.GaugeFunc("ipmi_history_journal_pending", "History records in the on-disk journal waiting to be replayed.", func() float64 { return float64(w.Stats().JournalPending) })
+	}
+	if src.SQLite {
+		latency := reg.Histogram("ipmi_sqlite_query_duration_seconds", "SQLite repository call latency in seconds.",
+			[]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}, "op")
+		repository.SetQueryObserver(func(op string, d time.Duration) { latency.Observe(d.Seconds(), op) })
+	}
+}
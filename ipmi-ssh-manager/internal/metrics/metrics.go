@@ -0,0 +1,298 @@
+// Package metrics 轻量的 Prometheus 指标注册表：计数器 / 仪表 / 直方图 (可带标签)，
+// 以 Prometheus 文本格式 (0.0.4) 输出，不依赖 client_golang。
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets 耗时类直方图的默认桶 (秒)
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry 指标集合；按注册顺序输出
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry { return &Registry{names: map[string]bool{}} }
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo 以 Prometheus 文本格式输出全部指标
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	list := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range list {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler /metrics 处理器
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// vec 按标签值分组的序列
+type vec[T any] struct {
+	name, help, typ string
+	labels          []string
+	mu              sync.Mutex
+	series          map[string]*T
+	values          map[string][]string
+	newT            func() *T
+}
+
+func newVec[T any](name, help, typ string, labels []string, newT func() *T) *vec[T] {
+	return &vec[T]{name: name, help: help, typ: typ, labels: labels, series: map[string]*T{}, values: map[string][]string{}, newT: newT}
+}
+
+func (v *vec[T]) get(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = v.newT()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each 按标签值排序遍历 (调用方持有 v.mu)
+func (v *vec[T]) each(fn func(labels string, s *T)) {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fn(labelString(v.labels, v.values[k]), v.series[k])
+	}
+}
+
+func (v *vec[T]) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.typ)
+}
+
+// Counter 只增计数器
+type Counter struct{ v *vec[float64] }
+
+// Counter 注册计数器 (名称建议以 _total 结尾)
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{v: newVec(name, help, "counter", labels, func() *float64 { return new(float64) })}
+	r.register(name, c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Add 增加 delta (负值忽略)
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	p := c.v.get(labelValues)
+	c.v.mu.Lock()
+	*p += delta
+	c.v.mu.Unlock()
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	c.v.header(w)
+	c.v.each(func(labels string, s *float64) { writeSample(w, c.v.name, labels, *s) })
+}
+
+// Gauge 可增可减的仪表
+type Gauge struct{ v *vec[float64] }
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{v: newVec(name, help, "gauge", labels, func() *float64 { return new(float64) })}
+	r.register(name, g)
+	return g
+}
+
+func (g *Gauge) Set(val float64, labelValues ...string) {
+	p := g.v.get(labelValues)
+	g.v.mu.Lock()
+	*p = val
+	g.v.mu.Unlock()
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	p := g.v.get(labelValues)
+	g.v.mu.Lock()
+	*p += delta
+	g.v.mu.Unlock()
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.v.mu.Lock()
+	defer g.v.mu.Unlock()
+	g.v.header(w)
+	g.v.each(func(labels string, s *float64) { writeSample(w, g.v.name, labels, *s) })
+}
+
+// funcMetric 采集时调用函数取值 (无标签)
+type funcMetric struct {
+	name, help, typ string
+	fn              func() float64
+}
+
+// GaugeFunc 采集时读取当前值 (如队列长度、连接数)
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{name: name, help: help, typ: "gauge", fn: fn})
+}
+
+// CounterFunc 采集时读取组件内部维护的累计值
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{name: name, help: help, typ: "counter", fn: fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+	writeSample(w, f.name, "", f.fn())
+}
+
+// Histogram 累积桶直方图
+type Histogram struct {
+	v       *vec[histSeries]
+	buckets []float64
+}
+
+type histSeries struct {
+	counts []uint64 // 与 buckets 对应 (非累积)，末尾为 +Inf
+	sum    float64
+	count  uint64
+}
+
+// Histogram 注册直方图；buckets 为空时使用 DefaultBuckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &Histogram{buckets: b}
+	h.v = newVec(name, help, "histogram", labels, func() *histSeries { return &histSeries{counts: make([]uint64, len(b)+1)} })
+	r.register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(val float64, labelValues ...string) {
+	s := h.v.get(labelValues)
+	i := sort.SearchFloat64s(h.buckets, val) // 第一个 >= val 的桶 (le 语义)
+	h.v.mu.Lock()
+	s.counts[i]++
+	s.sum += val
+	s.count++
+	h.v.mu.Unlock()
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	h.v.header(w)
+	h.v.each(func(labels string, s *histSeries) {
+		var cum uint64
+		for i, ub := range h.buckets {
+			cum += s.counts[i]
+			writeSample(w, h.v.name+"_bucket", joinLabels(labels, `le="`+formatFloat(ub)+`"`), float64(cum))
+		}
+		cum += s.counts[len(h.buckets)]
+		writeSample(w, h.v.name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(cum))
+		writeSample(w, h.v.name+"_sum", labels, s.sum)
+		writeSample(w, h.v.name+"_count", labels, float64(s.count))
+	})
+}
+
+func writeSample(w *bufio.Writer, name, labels string, val float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(val))
+	w.WriteByte('\n')
+}
+
+func labelString(names, values []string) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n + `="` + escapeLabel(values[i]) + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/ssh"
+
+	_ "modernc.org/sqlite"
+)
+
+func render(t *testing.T, reg *Registry) string {
+	t.Helper()
+	var sb strings.Builder
+	if _, err := reg.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestRegistry_TextFormat(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("x_total", "Things.\nDone.", "kind")
+	c.Inc(`a"b`)
+	c.Add(2, "z")
+	c.Add(-1, "z") // 忽略
+	h := reg.Histogram("lat_seconds", "Latency.", []float64{1, 0.1}, "op")
+	h.Observe(0.05, "q")
+	h.Observe(0.1, "q") // le 含等于
+	h.Observe(3, "q")
+	reg.GaugeFunc("depth", "Queue depth.", func() float64 { return 7 })
+
+	want := strings.Join([]string{
+		`# HELP x_total Things.\nDone.`,
+		`# TYPE x_total counter`,
+		`x_total{kind="a\"b"} 1`,
+		`x_total{kind="z"} 2`,
+		`# HELP lat_seconds Latency.`,
+		`# TYPE lat_seconds histogram`,
+		`lat_seconds_bucket{op="q",le="0.1"} 2`,
+		`lat_seconds_bucket{op="q",le="1"} 2`,
+		`lat_seconds_bucket{op="q",le="+Inf"} 3`,
+		`lat_seconds_sum{op="q"} 3.15`,
+		`lat_seconds_count{op="q"} 3`,
+		`# HELP depth Queue depth.`,
+		`# TYPE depth gauge`,
+		`depth 7`,
+		``,
+	}, "\n")
+	if got := render(t, reg); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate metric should panic")
+		}
+	}()
+	reg.Gauge("depth", "again")
+}
+
+func TestRegister_ExecAndHistory(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	mRepo := repository.NewMachineRepo(db)
+	hRepo := repository.NewHistoryRepo(db)
+	if err := mRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	m1 := domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "192.168.0.1", SSHUser: "root", SSHKey: "k"}
+	m2 := domain.Machine{IPMIIP: "10.0.0.2", SSHIP: "192.168.0.2", SSHUser: "root", SSHKey: "k"}
+	for _, m := range []*domain.Machine{&m1, &m2} {
+		if err := mRepo.Save(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mock := ssh.NewMockExecutor()
+	mock.Set("uptime", ssh.MockResult{Stdout: "up"})
+	hw := service.NewHistoryWriter(hRepo, 1, 10)
+	svc := service.NewExecService(mRepo, hw, mock, 0)
+
+	reg := NewRegistry()
+	Register(reg, Sources{Exec: svc, History: hw, SQLite: true})
+	t.Cleanup(func() { repository.SetQueryObserver(nil) })
+
+	// 第二个 ID 不存在 → not_found
+	if _, err := svc.BatchExec(domain.ExecTask{Command: "uptime", MachineIDs: []int64{int64(m1.ID), 999}}); err != nil {
+		t.Fatal(err)
+	}
+	// 等待异步写入完成 (flush 间隔 1s)
+	for deadline := time.Now().Add(3 * time.Second); hw.Stats().Written < 1 && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+	}
+	hw.Close()
+
+	out := render(t, reg)
+	for _, want := range []string{
+		`ipmi_exec_commands_total{result="ok"} 1`,
+		`ipmi_exec_commands_total{result="failed"} 1`,
+		`ipmi_exec_failures_total{class="not_found"} 1`,
+		`ipmi_exec_host_duration_seconds_count{host="10.0.0.1"} 1`,
+		`ipmi_exec_active_jobs 0`,
+		`ipmi_history_queue_depth 0`,
+		`ipmi_history_written_total 1`,
+		`ipmi_history_dropped_total 0`,
+		`ipmi_sqlite_query_duration_seconds_count{op="history.insert_batch"} 1`,
+		`ipmi_sqlite_query_duration_seconds_count{op="machine.get_by_ids"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `host=""`) {
+		t.Errorf("unknown machine should not get a duration series:\n%s", out)
+	}
+}
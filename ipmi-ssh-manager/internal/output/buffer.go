@@ -0,0 +1,129 @@
+package output
+
+import (
+	"fmt"
+	"sync"
+	"unicode/utf8"
+)
+
+// Buffer 有上限的输出缓冲 (io.Writer)：超过 limit 时只保留开头与结尾各约一半，中间丢弃，
+// 内存占用不超过 limit。limit<=0 时不限制
+type Buffer struct {
+	mu    sync.Mutex
+	limit int
+	head  []byte // 开头 limit-limit/2 字节
+	tail  []byte // 结尾 limit/2 字节的环形缓冲，写满后 pos 处为最旧的字节
+	pos   int
+	total int64
+}
+
+func NewBuffer(limit int) *Buffer { return &Buffer{limit: limit} }
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	b.total += int64(n)
+	if b.limit <= 0 {
+		b.head = append(b.head, p...)
+		return n, nil
+	}
+	if room := b.limit - b.limit/2 - len(b.head); room > 0 {
+		k := min(room, len(p))
+		b.head = append(b.head, p[:k]...)
+		p = p[k:]
+	}
+	b.writeTail(p)
+	return n, nil
+}
+
+func (b *Buffer) writeTail(p []byte) {
+	n := b.limit / 2
+	if n == 0 || len(p) == 0 {
+		return
+	}
+	if len(p) >= n {
+		b.tail = append(b.tail[:0], p[len(p)-n:]...)
+		b.pos = 0
+		return
+	}
+	if len(b.tail) < n { // 未写满：追加
+		k := min(n-len(b.tail), len(p))
+		b.tail = append(b.tail, p[:k]...)
+		p = p[k:]
+	}
+	for len(p) > 0 { // 已写满：覆盖最旧的字节
+		k := copy(b.tail[b.pos:], p)
+		p = p[k:]
+		b.pos = (b.pos + k) % n
+	}
+}
+
+// Total 写入的总字节数 (含被丢弃的部分)
+func (b *Buffer) Total() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
+}
+
+// Truncated 是否有内容被丢弃
+func (b *Buffer) Truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.truncated()
+}
+
+func (b *Buffer) truncated() bool { return b.limit > 0 && b.total > int64(b.limit) }
+
+// String 返回保留的内容；被截断时在开头与结尾之间插入 "...[truncated N bytes]..." 标记，
+// 并去掉截断处不完整的 UTF-8 字符
+func (b *Buffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tail := make([]byte, 0, len(b.tail))
+	tail = append(append(tail, b.tail[b.pos:]...), b.tail[:b.pos]...)
+	if !b.truncated() {
+		return string(b.head) + string(tail)
+	}
+	omitted := b.total - int64(len(b.head)) - int64(len(tail))
+	return string(trimRuneEnd(b.head)) + fmt.Sprintf("\n...[truncated %d bytes]...\n", omitted) + string(trimRuneStart(tail))
+}
+
+// Truncate 按 limit 截断 s (规则同 Buffer)，返回结果及是否截断
+func Truncate(s string, limit int) (string, bool) {
+	if limit <= 0 || len(s) <= limit {
+		return s, false
+	}
+	b := NewBuffer(limit)
+	_, _ = b.Write([]byte(s))
+	return b.String(), true
+}
+
+// trimRuneEnd 去掉末尾不完整的 UTF-8 字符
+func trimRuneEnd(p []byte) []byte {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return p[:i]
+			}
+			break
+		}
+	}
+	return p
+}
+
+// trimRuneStart 去掉开头残缺的 UTF-8 字符的后续字节
+func trimRuneStart(p []byte) []byte {
+	for i := 0; i < utf8.UTFMax && len(p) > 0 && !utf8.RuneStart(p[0]); i++ {
+		p = p[1:]
+	}
+	return p
+}
+
+// Head 返回 s 的前至多 n 字节 (不拆开 UTF-8 字符)
+func Head(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return string(trimRuneEnd([]byte(s[:n])))
+}
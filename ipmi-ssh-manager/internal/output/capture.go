@@ -0,0 +1,67 @@
+package output
+
+import "context"
+
+// Capture 单台机器一次执行的输出捕获上限与结果。调用方经 WithCapture 放入 context：
+// 支持的执行器 (ssh.Executor) 读取输出时即按 Limit 截断并调用 Finish，避免整段输出驻留内存；
+// 其它执行器 (Mock / 远程) 返回后由调用方 Fit 截断。nil 表示不限制
+type Capture struct {
+	Limit       int   // 每路 (stdout / stderr) 保留字节上限，<=0 不限
+	StdoutBytes int64 // 原始 stdout 字节数
+	StderrBytes int64 // 原始 stderr 字节数
+	Truncated   bool  // 任一路被截断
+	done        bool
+}
+
+type captureKey struct{}
+
+// WithCapture 把 c 放入 context
+func WithCapture(ctx context.Context, c *Capture) context.Context {
+	return context.WithValue(ctx, captureKey{}, c)
+}
+
+// FromContext 取出 Capture，没有时返回 nil
+func FromContext(ctx context.Context) *Capture {
+	c, _ := ctx.Value(captureKey{}).(*Capture)
+	return c
+}
+
+// NewBuffer 按 Limit 创建缓冲 (c 为 nil 时不限制)
+func (c *Capture) NewBuffer() *Buffer {
+	if c == nil {
+		return NewBuffer(0)
+	}
+	return NewBuffer(c.Limit)
+}
+
+// Finish 执行器读取完毕后记录原始字节数与截断情况
+func (c *Capture) Finish(stdout, stderr *Buffer) {
+	if c == nil {
+		return
+	}
+	c.StdoutBytes, c.StderrBytes = stdout.Total(), stderr.Total()
+	c.Truncated = stdout.Truncated() || stderr.Truncated()
+	c.done = true
+}
+
+// Fit 执行器未调用 Finish 时按 Limit 截断输出并记录；已处理时原样返回
+func (c *Capture) Fit(stdout, stderr string) (string, string) {
+	if c == nil || c.done {
+		return stdout, stderr
+	}
+	c.StdoutBytes, c.StderrBytes = int64(len(stdout)), int64(len(stderr))
+	var t1, t2 bool
+	stdout, t1 = Truncate(stdout, c.Limit)
+	stderr, t2 = Truncate(stderr, c.Limit)
+	c.Truncated = t1 || t2
+	c.done = true
+	return stdout, stderr
+}
+
+// Bytes 原始输出总字节数 (stdout + stderr)
+func (c *Capture) Bytes() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.StdoutBytes + c.StderrBytes
+}
@@ -0,0 +1,40 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// EncodingGzip 历史输出 blob 的压缩格式 (exec_output.encoding)
+const EncodingGzip = "gzip"
+
+// Compress 以 gzip 压缩
+func Compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(p); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress 按 encoding 解压；空 encoding 表示未压缩
+func Decompress(encoding string, p []byte) ([]byte, error) {
+	switch encoding {
+	case "":
+		return p, nil
+	case EncodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(p))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	}
+	return nil, fmt.Errorf("unsupported output encoding %q", encoding)
+}
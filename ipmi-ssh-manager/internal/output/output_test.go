@@ -0,0 +1,84 @@
+package output
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBuffer_HeadTail(t *testing.T) {
+	b := NewBuffer(10)
+	for _, s := range []string{"0123", "4567", "89ab", "cdef", "g"} {
+		b.Write([]byte(s))
+	}
+	if !b.Truncated() || b.Total() != 17 {
+		t.Fatalf("truncated=%v total=%d", b.Truncated(), b.Total())
+	}
+	if got, want := b.String(), "01234\n...[truncated 7 bytes]...\ncdefg"; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+
+	small := NewBuffer(10)
+	small.Write([]byte("012345"))
+	small.Write([]byte("6789"))
+	if small.Truncated() || small.String() != "0123456789" {
+		t.Fatalf("within limit: %q", small.String())
+	}
+	unlimited := NewBuffer(0)
+	unlimited.Write([]byte(strings.Repeat("x", 1000)))
+	if unlimited.Truncated() || len(unlimited.String()) != 1000 {
+		t.Fatal("limit 0 should keep everything")
+	}
+}
+
+func TestTruncate_UTF8(t *testing.T) {
+	s := strings.Repeat("日志", 10) // 每个字 3 字节
+	got, truncated := Truncate(s, 16)
+	if !truncated {
+		t.Fatal("expected truncation")
+	}
+	if !strings.HasPrefix(got, "日志") || !strings.HasSuffix(got, "日志") || !strings.Contains(got, "[truncated") {
+		t.Fatalf("got %q", got)
+	}
+	for _, r := range got {
+		if r == '�' {
+			t.Fatalf("broken rune in %q", got)
+		}
+	}
+	if out, tr := Truncate("short", 16); tr || out != "short" {
+		t.Fatalf("short input changed: %q", out)
+	}
+}
+
+func TestCapture_FitAndCompress(t *testing.T) {
+	ctx := WithCapture(context.Background(), &Capture{Limit: 8})
+	c := FromContext(ctx)
+	stdout, stderr := c.Fit(strings.Repeat("a", 20), "err")
+	if !c.Truncated || c.StdoutBytes != 20 || c.Bytes() != 23 || stderr != "err" || stdout != "aaaa\n...[truncated 12 bytes]...\naaaa" {
+		t.Fatalf("capture %+v stdout %q", c, stdout)
+	}
+	// 执行器已经 Finish 时 Fit 不再处理
+	c2 := &Capture{Limit: 4}
+	so, se := c2.NewBuffer(), c2.NewBuffer()
+	so.Write([]byte("123456"))
+	c2.Finish(so, se)
+	if out, _ := c2.Fit(so.String(), ""); out != so.String() || !c2.Truncated || c2.StdoutBytes != 6 {
+		t.Fatalf("after Finish: %q %+v", out, c2)
+	}
+	if FromContext(context.Background()) != nil {
+		t.Fatal("empty context should have no capture")
+	}
+
+	raw := []byte(strings.Repeat("line of output\n", 200))
+	z, err := Compress(raw)
+	if err != nil || len(z) >= len(raw) {
+		t.Fatalf("compress: %d bytes, %v", len(z), err)
+	}
+	back, err := Decompress(EncodingGzip, z)
+	if err != nil || string(back) != string(raw) {
+		t.Fatalf("decompress: %v", err)
+	}
+	if _, err := Decompress("lz4", z); err == nil {
+		t.Fatal("unknown encoding should fail")
+	}
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// ApprovalRepo 命令审批单表 (server / 桌面 / ipmictl 共用同一数据库，审批可跨进程完成)
+type ApprovalRepo struct{ db *sql.DB }
+
+func NewApprovalRepo(db *sql.DB) *ApprovalRepo { return &ApprovalRepo{db: db} }
+
+// EnsureSchema 执行未应用的迁移 (见 Migrate，幂等)
+func (r *ApprovalRepo) EnsureSchema() error {
+	_, err := Migrate(r.db)
+	return err
+}
+
+const approvalColumns = `id,requester,command,machine_ids_json,COALESCE(reasons_json,''),status,COALESCE(approver,''),created_at,decided_at,expires_at`
+
+// Create 新增审批单
+func (r *ApprovalRepo) Create(a domain.Approval) error {
+	ids, _ := json.Marshal(a.MachineIDs)
+	reasons := ""
+	if len(a.Reasons) > 0 {
+		b, _ := json.Marshal(a.Reasons)
+		reasons = string(b)
+	}
+	_, err := r.db.Exec(`INSERT INTO exec_approvals(id,requester,command,machine_ids_json,reasons_json,status,created_at,expires_at) VALUES (?,?,?,?,?,?,?,?)`,
+		a.ID, a.Requester, a.Command, string(ids), reasons, string(a.Status), a.CreatedAt, a.ExpiresAt)
+	return err
+}
+
+// Get 不存在时返回 sql.ErrNoRows
+func (r *ApprovalRepo) Get(id string) (domain.Approval, error) {
+	return scanApproval(r.db.QueryRow(`SELECT `+approvalColumns+` FROM exec_approvals WHERE id=?`, id))
+}
+
+// Transition 仅当当前状态为 from 时更新为 to (并发下保证单次生效)；未命中时返回 sql.ErrNoRows
+func (r *ApprovalRepo) Transition(id string, from, to domain.ApprovalStatus, approver string, at time.Time) error {
+	q := `UPDATE exec_approvals SET status=?, decided_at=? WHERE id=? AND status=?`
+	args := []any{string(to), at, id, string(from)}
+	if approver != "" {
+		q = `UPDATE exec_approvals SET status=?, decided_at=?, approver=? WHERE id=? AND status=?`
+		args = []any{string(to), at, approver, id, string(from)}
+	}
+	res, err := r.db.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// List 按创建时间倒序；status 为空表示全部
+func (r *ApprovalRepo) List(status domain.ApprovalStatus, limit int) ([]domain.Approval, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := r.db.Query(`SELECT `+approvalColumns+` FROM exec_approvals WHERE (?='' OR status=?) ORDER BY created_at DESC LIMIT ?`, string(status), string(status), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []domain.Approval
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+func scanApproval(row rowScanner) (domain.Approval, error) {
+	var (
+		a       domain.Approval
+		ids     string
+		reasons string
+		status  string
+		decided sql.NullTime
+	)
+	if err := row.Scan(&a.ID, &a.Requester, &a.Command, &ids, &reasons, &status, &a.Approver, &a.CreatedAt, &decided, &a.ExpiresAt); err != nil {
+		return domain.Approval{}, err
+	}
+	a.Status = domain.ApprovalStatus(status)
+	_ = json.Unmarshal([]byte(ids), &a.MachineIDs)
+	if reasons != "" {
+		_ = json.Unmarshal([]byte(reasons), &a.Reasons)
+	}
+	if decided.Valid {
+		a.DecidedAt = decided.Time
+	}
+	return a, nil
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// AuditRepo 审计日志表：只追加，触发器拒绝 UPDATE / DELETE；哈希链在追加时计算
+type AuditRepo struct {
+	db *sql.DB
+	mu sync.Mutex // 串行化追加，保证读取链尾与插入之间不被打断
+}
+
+func NewAuditRepo(db *sql.DB) *AuditRepo { return &AuditRepo{db: db} }
+
+// EnsureSchema 执行未应用的迁移 (见 Migrate，幂等)；只追加触发器在 0006_audit_log 中创建
+func (r *AuditRepo) EnsureSchema() error {
+	_, err := Migrate(r.db)
+	return err
+}
+
+// Append 接在链尾追加一条，填充 ID / Time / PrevHash / Hash
+func (r *AuditRepo) Append(e *domain.AuditEntry) error {
+	defer observeQuery("audit.append", time.Now())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var prev string
+	if err := tx.QueryRow(`SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&prev); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	e.PrevHash = prev
+	e.Hash = e.ComputeHash()
+	res, err := tx.Exec(`INSERT INTO audit_log(ts,actor,action,target,before_json,after_json,prev_hash,hash) VALUES (?,?,?,?,?,?,?,?)`,
+		e.Time.Format(domain.AuditTimeFormat), e.Actor, e.Action, e.Target, e.Before, e.After, e.PrevHash, e.Hash)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	e.ID, _ = res.LastInsertId()
+	return nil
+}
+
+const auditColumns = `id,ts,actor,action,COALESCE(target,''),COALESCE(before_json,''),COALESCE(after_json,''),prev_hash,hash`
+
+// Query 按条件查询 (ID 倒序)
+func (r *AuditRepo) Query(q domain.AuditQuery) ([]domain.AuditEntry, error) {
+	defer observeQuery("audit.query", time.Now())
+	where := ""
+	args := []any{}
+	if q.Actor != "" {
+		where += " AND actor = ?"
+		args = append(args, q.Actor)
+	}
+	if strings.HasSuffix(q.Action, ".") {
+		where += " AND action LIKE ?"
+		args = append(args, q.Action+"%")
+	} else if q.Action != "" {
+		where += " AND action = ?"
+		args = append(args, q.Action)
+	}
+	if q.Target != "" {
+		where += " AND target LIKE ?"
+		args = append(args, "%"+q.Target+"%")
+	}
+	if !q.Since.IsZero() {
+		where += " AND ts >= ?"
+		args = append(args, q.Since.UTC().Format(domain.AuditTimeFormat))
+	}
+	if !q.Until.IsZero() {
+		where += " AND ts < ?"
+		args = append(args, q.Until.UTC().Format(domain.AuditTimeFormat))
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 200
+	}
+	args = append(args, limit, max(0, q.Offset))
+	rows, err := r.db.Query(`SELECT `+auditColumns+` FROM audit_log WHERE 1=1`+where+` ORDER BY id DESC LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []domain.AuditEntry
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// Walk 按 ID 升序遍历全部条目 (校验哈希链)；fn 返回错误时停止
+func (r *AuditRepo) Walk(fn func(domain.AuditEntry) error) error {
+	rows, err := r.db.Query(`SELECT ` + auditColumns + ` FROM audit_log ORDER BY id ASC`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func scanAudit(row rowScanner) (domain.AuditEntry, error) {
+	var (
+		e  domain.AuditEntry
+		ts string
+	)
+	if err := row.Scan(&e.ID, &ts, &e.Actor, &e.Action, &e.Target, &e.Before, &e.After, &e.PrevHash, &e.Hash); err != nil {
+		return domain.AuditEntry{}, err
+	}
+	e.Time, _ = time.Parse(domain.AuditTimeFormat, ts)
+	return e, nil
+}
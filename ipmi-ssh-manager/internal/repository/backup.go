@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// sqliteHeader SQLite 数据库文件的前 16 字节
+const sqliteHeader = "SQLite format 3\x00"
+
+// ErrInvalidBackup 文件不是可用的本程序数据库 (非 SQLite、已损坏或缺少必需的表)
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// BackupTo 以 VACUUM INTO 在线生成一致的数据库快照 (不阻塞写入)；dest 已存在时报错。
+// 备份按原样复制各列，SSH Key 保持加密存储的形式
+func BackupTo(db *sql.DB, dest string) error {
+	defer observeQuery("schema.backup", time.Now())
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("backup %s already exists", dest)
+	}
+	tmp := dest + ".tmp"
+	_ = os.Remove(tmp)
+	if _, err := db.Exec(`VACUUM INTO ?`, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("backup: %w", err)
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// InspectBackup 校验备份文件：SQLite 文件头、PRAGMA integrity_check、迁移版本不高于当前程序且含机器与历史表
+func InspectBackup(path string) (domain.BackupInfo, error) {
+	info := domain.BackupInfo{Name: filepath.Base(path), Path: path}
+	st, err := os.Stat(path)
+	if err != nil {
+		return info, err
+	}
+	info.Size, info.CreatedAt = st.Size(), st.ModTime()
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	head := make([]byte, len(sqliteHeader))
+	_, err = io.ReadFull(f, head)
+	f.Close()
+	if err != nil || !bytes.Equal(head, []byte(sqliteHeader)) {
+		return info, fmt.Errorf("%w: %s is not a SQLite database", ErrInvalidBackup, info.Name)
+	}
+	db, err := sql.Open("sqlite", readOnlyURI(path))
+	if err != nil {
+		return info, err
+	}
+	defer db.Close()
+	problems, err := integrityProblems(db)
+	if err != nil {
+		return info, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if len(problems) > 0 {
+		return info, fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, problems[0])
+	}
+	migs, err := MigrationState(db)
+	if err != nil {
+		return info, err
+	}
+	for _, m := range migs {
+		if m.Unknown {
+			return info, fmt.Errorf("%w: backup version %d (%s) is not known", ErrSchemaTooNew, m.Version, m.Name)
+		}
+		if m.Applied {
+			info.SchemaVersion = m.Version
+		}
+	}
+	if info.SchemaVersion == 0 {
+		return info, fmt.Errorf("%w: %s has no schema_migrations (not created by this program)", ErrInvalidBackup, info.Name)
+	}
+	if err := db.QueryRow(`SELECT (SELECT count(*) FROM machines), (SELECT count(*) FROM exec_history)`).Scan(&info.Machines, &info.History); err != nil {
+		return info, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	return info, nil
+}
+
+// RestoreFrom 用 SQLite 在线备份接口把 src 的全部页复制到当前库 (其它连接随后读到恢复后的数据)，
+// 随后执行迁移补齐旧备份缺少的版本。调用方应先用 InspectBackup 校验
+func RestoreFrom(db *sql.DB, src string) error {
+	defer observeQuery("schema.restore", time.Now())
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	err = conn.Raw(func(dc any) error {
+		r, ok := dc.(interface {
+			NewRestore(string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return errors.New("restore: sqlite driver does not support the backup API")
+		}
+		bk, err := r.NewRestore(readOnlyURI(src))
+		if err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+		for retry := 0; ; {
+			more, err := bk.Step(-1)
+			if err != nil && IsBusy(err) && retry < 50 {
+				retry++
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			if err != nil {
+				_ = bk.Finish()
+				return fmt.Errorf("restore: %w", err)
+			}
+			if !more {
+				break
+			}
+		}
+		return bk.Finish()
+	})
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	_, err = Migrate(db)
+	return err
+}
+
+// IntegrityCheck 对当前库执行 PRAGMA integrity_check 并检查迁移状态
+func IntegrityCheck(db *sql.DB) (domain.IntegrityReport, error) {
+	defer observeQuery("schema.integrity_check", time.Now())
+	start := time.Now()
+	rep := domain.IntegrityReport{CheckedAt: start}
+	problems, err := integrityProblems(db)
+	if err != nil {
+		return rep, err
+	}
+	migs, err := MigrationState(db)
+	if err != nil {
+		return rep, err
+	}
+	for _, m := range migs {
+		switch {
+		case m.Unknown:
+			problems = append(problems, fmt.Sprintf("migration %04d_%s is newer than this build", m.Version, m.Name))
+		case m.Modified:
+			problems = append(problems, fmt.Sprintf("migration %04d_%s differs from the embedded script", m.Version, m.Name))
+		case !m.Applied:
+			rep.Pending++
+			continue
+		}
+		rep.SchemaVersion = m.Version
+	}
+	if rep.Pending > 0 {
+		problems = append(problems, fmt.Sprintf("%d migrations pending", rep.Pending))
+	}
+	rep.Problems, rep.OK = problems, len(problems) == 0
+	rep.DurationMs = time.Since(start).Milliseconds()
+	return rep, nil
+}
+
+// readOnlyURI 只读打开文件的 SQLite URI (转义 URI 中有特殊含义的字符)
+func readOnlyURI(path string) string {
+	return "file:" + strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(filepath.ToSlash(path)) + "?mode=ro"
+}
+
+// integrityProblems PRAGMA integrity_check 的输出 (结果为 ok 时返回空)
+func integrityProblems(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		if s != "ok" {
+			out = append(out, s)
+		}
+	}
+	return out, rows.Err()
+}
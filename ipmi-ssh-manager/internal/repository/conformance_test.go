@@ -0,0 +1,406 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// 机器与历史仓库的一致性测试：同一组用例在 SQLite 内存库上运行，
+// 并在设置了 IPMI_TEST_POSTGRES_DSN / IPMI_TEST_MYSQL_DSN 时在对应的库上运行 (未设置时跳过)。
+// 共享库用例会删除并重建机器与历史相关的表，DSN 只能指向专用的测试库
+
+func TestConformance_SQLite(t *testing.T) {
+	runConformance(t, openMemRaw(t), DialectSQLite)
+}
+
+func TestConformance_Postgres(t *testing.T) { runSharedConformance(t, "IPMI_TEST_POSTGRES_DSN") }
+
+func TestConformance_MySQL(t *testing.T) { runSharedConformance(t, "IPMI_TEST_MYSQL_DSN") }
+
+func runSharedConformance(t *testing.T, env string) {
+	dsn := os.Getenv(env)
+	if dsn == "" {
+		t.Skipf("%s not set", env)
+	}
+	db, d, err := OpenDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, table := range []string{"exec_output", "exec_history", "machine_group_members", "machine_groups", "machine_labels", "machine_revisions", "machines", "schema_migrations"} {
+		if _, err := db.Exec(`DROP TABLE IF EXISTS ` + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runConformance(t, db, d)
+}
+
+func runConformance(t *testing.T, db *sql.DB, d Dialect) {
+	machines, history := NewMachineRepoDialect(db, d), NewHistoryRepoDialect(db, d)
+	if err := machines.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.EnsureSchema(); err != nil { // 幂等
+		t.Fatal(err)
+	}
+	if pending, err := PendingMigrationsDialect(db, d); err != nil || len(pending) != 0 {
+		t.Fatalf("pending migrations %v %v", pending, err)
+	}
+	t.Run("machines", func(t *testing.T) { conformMachines(t, machines) })
+	t.Run("versions", func(t *testing.T) { conformVersions(t, machines) })
+	t.Run("history", func(t *testing.T) { conformHistory(t, history, d) })
+}
+
+func conformMachines(t *testing.T, r *MachineRepo) {
+	a := domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "192.168.0.1", SSHUser: "root", SSHKey: "KEY-A", Remark: "Rack A web",
+		Labels: map[string]string{"rack": "A12", "role": "web"}, Groups: []string{"web", "prod"}, Attrs: map[string]string{"sn": "X1"}}
+	b := domain.Machine{IPMIIP: "10.0.0.2", SSHUser: "admin", Remark: "db node", Labels: map[string]string{"rack": "A12", "role": "db"}, Groups: []string{"prod"}}
+	c := domain.Machine{IPMIIP: "10.0.1.3", SSHUser: "root", Remark: "spare"}
+	for _, m := range []*domain.Machine{&a, &b, &c} {
+		if err := r.Save(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.ID == 0 || a.ID == b.ID || b.ID == c.ID {
+		t.Fatalf("ids %d %d %d", a.ID, b.ID, c.ID)
+	}
+	// 更新保持 ID；Attrs / Labels / Groups 为 nil 时保留原值
+	upd := domain.Machine{IPMIIP: a.IPMIIP, SSHIP: a.SSHIP, SSHUser: "root", SSHKey: "KEY-A", Remark: "Rack A web (new)"}
+	if err := r.Save(&upd); err != nil || upd.ID != a.ID {
+		t.Fatalf("update id %d, want %d (%v)", upd.ID, a.ID, err)
+	}
+	got, err := r.GetByIPMI(a.IPMIIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SSHKey != "KEY-A" || got.Remark != "Rack A web (new)" || got.Labels["role"] != "web" || !slices.Equal(got.Groups, []string{"prod", "web"}) || got.Attrs["sn"] != "X1" {
+		t.Fatalf("get %+v", got)
+	}
+	if _, err := r.GetByIPMI("10.9.9.9"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("missing machine: %v", err)
+	}
+	if list, err := r.GetByIDs([]int64{int64(a.ID), int64(c.ID)}); err != nil || len(list) != 2 {
+		t.Fatalf("get by ids %d %v", len(list), err)
+	}
+	if list, err := r.SearchByIPMI("10.0.0"); err != nil || len(list) != 2 || list[0].IPMIIP != b.IPMIIP {
+		t.Fatalf("search %+v %v", list, err)
+	}
+	if list, err := r.SelectMachines("rack=A12,role!=db"); err != nil || len(list) != 1 || list[0].ID != a.ID {
+		t.Fatalf("select %+v %v", list, err)
+	}
+
+	page, err := r.Query(domain.MachineQuery{Filters: []domain.FieldFilter{{Field: "ssh_user", Op: domain.FilterEq, Value: "root"}}, Text: []string{"RACK a"}})
+	if err != nil || page.Total != 1 || page.Items[0].ID != a.ID {
+		t.Fatalf("query filter/text %+v %v", page, err)
+	}
+	page, err = r.Query(domain.MachineQuery{SortBy: "ipmi_ip", Desc: true, Offset: 1, Limit: 2})
+	if err != nil || page.Total != 3 || len(page.Items) != 2 || page.Items[0].IPMIIP != b.IPMIIP || page.Items[1].IPMIIP != a.IPMIIP {
+		t.Fatalf("query page %+v %v", page, err)
+	}
+	if page, err = r.Query(domain.MachineQuery{Offset: 2}); err != nil || len(page.Items) != 1 || page.Items[0].ID != c.ID {
+		t.Fatalf("query offset only %+v %v", page, err)
+	}
+	if page, err = r.Query(domain.MachineQuery{Filters: []domain.FieldFilter{{Field: "id", Op: domain.FilterGt, Value: "0"}, {Field: "ipmi_ip", Op: domain.FilterContains, Value: "0.1."}}}); err != nil || page.Total != 1 {
+		t.Fatalf("query contains %+v %v", page, err)
+	}
+	// 用户输入中的 % _ \ 按字面匹配
+	for _, pat := range []string{"db_node", "%", `\`} {
+		if page, err = r.Query(domain.MachineQuery{Text: []string{pat}}); err != nil || page.Total != 0 {
+			t.Fatalf("query wildcard %q: %+v %v", pat, page, err)
+		}
+		if page, err = r.Query(domain.MachineQuery{Filters: []domain.FieldFilter{{Field: "remark", Op: domain.FilterContains, Value: pat}}}); err != nil || page.Total != 0 {
+			t.Fatalf("filter wildcard %q: %+v %v", pat, page, err)
+		}
+	}
+	if list, err := r.SearchByIPMI("10_0"); err != nil || len(list) != 0 {
+		t.Fatalf("search wildcard %+v %v", list, err)
+	}
+
+	if err := r.BulkUpsert([]domain.Machine{{IPMIIP: c.IPMIIP, SSHUser: "root", Remark: "spare 2"}, {IPMIIP: "10.0.2.4", SSHUser: "root", Groups: []string{"web"}}}); err != nil {
+		t.Fatal(err)
+	}
+	all, err := r.ListAll()
+	if err != nil || len(all) != 4 || all[2].ID != c.ID || all[2].Remark != "spare 2" || all[3].IPMIIP != "10.0.2.4" {
+		t.Fatalf("list after bulk upsert %+v %v", all, err)
+	}
+	groups, err := r.ListGroups()
+	if err != nil || len(groups) != 2 || groups[0].Name != "prod" || groups[0].MemberCount != 2 || groups[1].MemberCount != 2 {
+		t.Fatalf("groups %+v %v", groups, err)
+	}
+	if err := r.DeleteGroup("web"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.GetByIPMI(a.IPMIIP); !slices.Equal(got.Groups, []string{"prod"}) {
+		t.Fatalf("groups after delete %v", got.Groups)
+	}
+	if err := r.DeleteByIPMI(b.IPMIIP); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteByIPMI(b.IPMIIP); err != nil { // 不存在时不报错
+		t.Fatal(err)
+	}
+	if all, _ := r.ListAll(); len(all) != 3 {
+		t.Fatalf("%d machines after delete", len(all))
+	}
+	if groups, _ := r.ListGroups(); len(groups) != 1 || groups[0].MemberCount != 1 {
+		t.Fatalf("groups after machine delete %+v", groups)
+	}
+	conformRevisions(t, r, a, b)
+}
+
+// conformRevisions 修订记录、回收站与恢复 (接 conformMachines 的状态：b 已删除)
+func conformRevisions(t *testing.T, r *MachineRepo, a, b domain.Machine) {
+	actions := func(ip string) []string {
+		revs, err := r.ListRevisions(ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, rev := range revs {
+			out = append(out, rev.Action)
+		}
+		return out
+	}
+	// 删除分组也是机器的变更
+	if got := actions(a.IPMIIP); !slices.Equal(got, []string{"update", "update", "create"}) {
+		t.Fatalf("revisions of a %v", got)
+	}
+	cur, _ := r.GetByIPMI(a.IPMIIP)
+	cur.SSHIP = "192.168.0.9"
+	if err := r.ForActor("alice").Save(&cur); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Save(&cur); err != nil { // 内容未变不记录
+		t.Fatal(err)
+	}
+	revs, err := r.ListRevisions(a.IPMIIP)
+	if err != nil || len(revs) != 4 || revs[0].Actor != "alice" || revs[0].Revision != 4 || revs[0].Snapshot.SSHIP != "192.168.0.9" {
+		t.Fatalf("revisions after update %+v %v", revs, err)
+	}
+	if revs[3].Snapshot.SSHKey == "" || strings.Contains(revs[3].Snapshot.SSHKey, "KEY-A") {
+		t.Fatalf("snapshot key must be a fingerprint, got %q", revs[3].Snapshot.SSHKey)
+	}
+	first, err := r.GetRevision(revs[3].ID)
+	if err != nil || first.Snapshot.Remark != "Rack A web" || !slices.Equal(first.Snapshot.Groups, []string{"prod", "web"}) {
+		t.Fatalf("first revision %+v %v", first, err)
+	}
+	changes := domain.DiffSnapshots(first.Snapshot, revs[0].Snapshot)
+	var fields []string
+	for _, c := range changes {
+		fields = append(fields, c.Field)
+	}
+	if !slices.Equal(fields, []string{"ssh_ip", "remark", "groups"}) {
+		t.Fatalf("diff %+v", changes)
+	}
+
+	trash, err := r.ListDeleted()
+	if err != nil || len(trash) != 1 || trash[0].IPMIIP != b.IPMIIP || trash[0].DeletedAt.IsZero() || !slices.Equal(trash[0].Groups, []string{"prod"}) {
+		t.Fatalf("trash %+v %v", trash, err)
+	}
+	if _, err := r.GetByIPMI(b.IPMIIP); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("deleted machine is still visible: %v", err)
+	}
+	restored, err := r.Restore(b.IPMIIP)
+	if err != nil || restored.ID != b.ID || restored.Labels["role"] != "db" {
+		t.Fatalf("restore %+v %v", restored, err)
+	}
+	// created_at 在回收站与普通读取之间一致且可解析
+	if trash[0].CreatedAt.IsZero() || !restored.CreatedAt.Equal(trash[0].CreatedAt) {
+		t.Fatalf("created_at round trip: trash %v restored %v", trash[0].CreatedAt, restored.CreatedAt)
+	}
+	all, err := r.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range all {
+		if m.CreatedAt.IsZero() {
+			t.Fatalf("created_at of %s not parsed", m.IPMIIP)
+		}
+	}
+	if groups, _ := r.ListGroups(); len(groups) != 1 || groups[0].MemberCount != 2 {
+		t.Fatalf("groups after restore %+v", groups)
+	}
+	if _, err := r.Restore(b.IPMIIP); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("restore of a live machine: %v", err)
+	}
+	if err := r.Purge(b.IPMIIP); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("purge of a live machine: %v", err)
+	}
+	if err := r.DeleteByIPMI(b.IPMIIP); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Purge(b.IPMIIP); err != nil {
+		t.Fatal(err)
+	}
+	if trash, _ := r.ListDeleted(); len(trash) != 0 {
+		t.Fatalf("trash after purge %+v", trash)
+	}
+	if got := actions(b.IPMIIP); !slices.Equal(got, []string{"delete", "restore", "delete", "create"}) {
+		t.Fatalf("revisions of b %v", got)
+	}
+	// 彻底删除后可重新登记
+	again := domain.Machine{IPMIIP: b.IPMIIP, SSHUser: "root"}
+	if err := r.Save(&again); err != nil || again.ID == b.ID {
+		t.Fatalf("re-create %d %v", again.ID, err)
+	}
+}
+
+// conformVersions 乐观锁：携带过期 version 的保存被拒绝，批量写入逐条报告冲突
+func conformVersions(t *testing.T, r *MachineRepo) {
+	m := domain.Machine{IPMIIP: "10.0.3.1", SSHUser: "root", Groups: []string{"ops"}}
+	if err := r.Save(&m); err != nil || m.Version != 1 {
+		t.Fatalf("create version %d %v", m.Version, err)
+	}
+	alice, bob := m, m
+	alice.Remark = "alice"
+	if err := r.Save(&alice); err != nil || alice.Version != 2 {
+		t.Fatalf("update version %d %v", alice.Version, err)
+	}
+	bob.Remark = "bob"
+	err := r.Save(&bob)
+	var ce *ConflictError
+	if !errors.Is(err, ErrVersionConflict) || !errors.As(err, &ce) || ce.Expected != 1 || ce.Current != 2 {
+		t.Fatalf("stale save: %v", err)
+	}
+	if got, _ := r.GetByIPMI(m.IPMIIP); got.Remark != "alice" || got.Version != 2 {
+		t.Fatalf("stale save overwrote %+v", got)
+	}
+	force := domain.Machine{IPMIIP: m.IPMIIP, SSHUser: "root", Remark: "forced"} // version 0 不检查
+	if err := r.Save(&force); err != nil || force.Version != 3 {
+		t.Fatalf("unconditional save %d %v", force.Version, err)
+	}
+	// 分组变化与软删除同样递增版本
+	if err := r.DeleteGroup("ops"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.GetByIPMI(m.IPMIIP); got.Version != 4 {
+		t.Fatalf("version after group delete %d", got.Version)
+	}
+
+	batch := []domain.Machine{
+		{IPMIIP: m.IPMIIP, SSHUser: "root", Remark: "stale", Version: 3},
+		{IPMIIP: "10.0.3.2", SSHUser: "root"},
+		{IPMIIP: "10.0.3.3", SSHUser: "root", Version: 5}, // 不存在的机器
+	}
+	err = r.BulkUpsert(batch)
+	var be *BulkConflictError
+	if !errors.Is(err, ErrVersionConflict) || !errors.As(err, &be) || be.Applied != 1 || len(be.Conflicts) != 2 ||
+		be.Conflicts[0].IPMIIP != m.IPMIIP || be.Conflicts[0].Current != 4 || be.Conflicts[1].Current != 0 {
+		t.Fatalf("bulk conflicts: %v", err)
+	}
+	if got, err := r.GetByIPMI("10.0.3.2"); err != nil || got.Version != 1 {
+		t.Fatalf("non-conflicting row not written: %+v %v", got, err)
+	}
+	if _, err := r.GetByIPMI("10.0.3.3"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("conflicting row written: %v", err)
+	}
+}
+
+func conformHistory(t *testing.T, r *HistoryRepo, d Dialect) {
+	base := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	h1 := domain.ExecHistory{MachineID: 1, IPMIIP: "10.0.0.1", Command: "uptime", Stdout: "up 3 days", JobID: "job-1", User: "alice",
+		StartedAt: base, FinishedAt: base.Add(time.Second), DurationMs: 1000, Passed: true}
+	if err := r.Insert(&h1); err != nil || h1.ID == 0 {
+		t.Fatalf("insert id %d %v", h1.ID, err)
+	}
+	big := strings.Repeat("kernel: link down\n", 1000)
+	batch := []domain.ExecHistory{
+		{MachineID: 2, IPMIIP: "10.0.0.2", Command: "dmesg", Stdout: big, ExitCode: 1, JobID: "job-1", StartedAt: base, FinishedAt: base, Truncated: true},
+		{MachineID: 1, IPMIIP: "10.0.0.1", Command: "Uptime -p", ErrorText: "timeout", JobID: "job-2", StartedAt: base.Add(time.Hour), FinishedAt: base.Add(time.Hour)},
+	}
+	if err := r.InsertBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].ID <= h1.ID || batch[1].ID <= batch[0].ID {
+		t.Fatalf("batch ids %d %d after %d", batch[0].ID, batch[1].ID, h1.ID)
+	}
+
+	recent, err := r.ListRecent(10)
+	if err != nil || len(recent) != 3 || recent[0].ID != batch[1].ID {
+		t.Fatalf("recent %+v %v", recent, err)
+	}
+	if !recent[2].StartedAt.Equal(base) || recent[2].User != "alice" || !recent[2].Passed || recent[2].OutputBytes != int64(len(h1.Stdout)) {
+		t.Fatalf("round trip %+v", recent[2])
+	}
+	if list, err := r.ListFiltered(10, "10.0.0.1", "uptime"); err != nil || len(list) != 2 {
+		t.Fatalf("filtered %d %v", len(list), err)
+	}
+	for _, pat := range []string{"10_0_0_1", "%", `\`} {
+		if list, err := r.ListFiltered(10, pat, ""); err != nil || len(list) != 0 {
+			t.Fatalf("filtered wildcard %q: %d %v", pat, len(list), err)
+		}
+	}
+	job, err := r.ListByJob("job-1")
+	if err != nil || len(job) != 2 || job[0].ID != h1.ID {
+		t.Fatalf("by job %+v %v", job, err)
+	}
+	if l := job[1]; !l.Preview || !l.Truncated || l.Passed || l.OutputBytes != int64(len(big)) || len(l.Stdout) > previewOutputBytes {
+		t.Fatalf("large output row preview=%v truncated=%v passed=%v bytes=%d len=%d", l.Preview, l.Truncated, l.Passed, l.OutputBytes, len(l.Stdout))
+	}
+	out, err := r.GetOutput(batch[0].ID)
+	if err != nil || out.Stdout != big || out.MachineID != 2 {
+		t.Fatalf("output len %d %v", len(out.Stdout), err)
+	}
+
+	var samples []domain.HistorySample
+	err = r.WalkSamples(domain.HistoryStatsQuery{Since: base.Add(30 * time.Minute)}, func(s domain.HistorySample) error {
+		samples = append(samples, s)
+		return nil
+	})
+	if err != nil || len(samples) != 1 || samples[0].Command != "Uptime -p" || samples[0].Passed {
+		t.Fatalf("samples %+v %v", samples, err)
+	}
+	hits, err := r.Search(domain.HistorySearch{JobID: "job-2"})
+	if err != nil || len(hits) != 1 || hits[0].ID != batch[1].ID {
+		t.Fatalf("search by filter %+v %v", hits, err)
+	}
+	if _, err := r.Search(domain.HistorySearch{Query: "link"}); d != DialectSQLite && !errors.Is(err, ErrSearchUnavailable) {
+		t.Fatalf("full-text search on %s: %v", d, err)
+	}
+
+	old := domain.ExecHistory{MachineID: 3, IPMIIP: "10.0.1.3", Command: "true", StartedAt: time.Now().Add(-72 * time.Hour), FinishedAt: time.Now().Add(-72 * time.Hour)}
+	if err := r.Insert(&old); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Cleanup(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := r.ListRecent(10); len(list) != 3 {
+		t.Fatalf("%d rows after cleanup by age", len(list))
+	}
+	if err := r.Cleanup(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := r.ListRecent(10); len(list) != 1 || list[0].ID != batch[1].ID {
+		t.Fatalf("rows after cleanup by count %+v", list)
+	}
+	if _, err := r.GetOutput(batch[0].ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("output of removed row: %v", err)
+	}
+}
+
+func TestDialect_Rebind(t *testing.T) {
+	q := `SELECT "key" FROM t WHERE a = ? AND b = 'x?"y' AND c IN (?,?)`
+	if got := DialectSQLite.rebind(q); got != q {
+		t.Fatalf("sqlite %s", got)
+	}
+	if got, want := DialectPostgres.rebind(q), `SELECT "key" FROM t WHERE a = $1 AND b = 'x?"y' AND c IN ($2,$3)`; got != want {
+		t.Fatalf("postgres %s", got)
+	}
+	if got, want := DialectMySQL.rebind(q), "SELECT `key` FROM t WHERE a = ? AND b = 'x?\"y' AND c IN (?,?)"; got != want {
+		t.Fatalf("mysql %s", got)
+	}
+	if got := DialectPostgres.insertIgnore(`INSERT INTO g(name) VALUES (?)`); got != `INSERT INTO g(name) VALUES (?) ON CONFLICT DO NOTHING` {
+		t.Fatalf("postgres insert ignore %s", got)
+	}
+	if _, _, err := OpenDSN("oracle://u:secret@db/x"); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("unsupported dsn error must not echo the dsn: %v", err)
+	}
+}
@@ -2,35 +2,73 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/output"
 )
 
-type HistoryRepo struct{ db *sql.DB }
+const (
+	// inlineOutputBytes stdout + stderr 超过该大小时完整输出压缩后存入 exec_output，exec_history 只保留预览
+	inlineOutputBytes = 4 << 10
+	// previewOutputBytes 存入 exec_history 的每路输出预览长度
+	previewOutputBytes = 1 << 10
+)
+
+type HistoryRepo struct {
+	db  *sql.DB
+	d   Dialect
+	fts atomic.Int32 // 全文索引状态：0 未检测，1 可用，2 不可用 (SQLite 未编译 FTS5 或非 SQLite)
+}
 
-func NewHistoryRepo(db *sql.DB) *HistoryRepo { return &HistoryRepo{db: db} }
+// NewHistoryRepo 本地 SQLite 历史仓库
+func NewHistoryRepo(db *sql.DB) *HistoryRepo { return &HistoryRepo{db: db, d: DialectSQLite} }
 
-// EnsureSchema 创建历史表（若不存在）
+// NewHistoryRepoDialect 共享库 (PostgreSQL / MySQL) 的历史仓库；不支持全文检索
+func NewHistoryRepoDialect(db *sql.DB, d Dialect) *HistoryRepo { return &HistoryRepo{db: db, d: d} }
+
+// EnsureSchema 执行未应用的迁移 (见 MigrateDialect) 并创建全文索引；FTS5 依赖驱动是否编译该模块，不放入迁移
 func (r *HistoryRepo) EnsureSchema() error {
-	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS exec_history(
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		machine_id INTEGER,
-		ipmi_ip TEXT,
-		command TEXT,
-		stdout TEXT,
-		stderr TEXT,
-		exit_code INTEGER,
-		error_text TEXT,
-		started_at TIMESTAMP,
-		finished_at TIMESTAMP,
-		duration_ms INTEGER
-	)`)
-	return err
+	if _, err := MigrateDialect(r.db, r.d); err != nil {
+		return err
+	}
+	return r.ensureFTS()
 }
 
-func (r *HistoryRepo) Insert(h *domain.ExecHistory) error {
+// historyColumns 列表查询的列 (表别名 h)，顺序与 scanHistoryRow 一致
+func historyColumns(d Dialect) string {
+	return `h.id,h.machine_id,h.ipmi_ip,h.command,h.stdout,h.stderr,h.exit_code,h.error_text,h.started_at,h.finished_at,h.duration_ms,COALESCE(h.job_id,''),COALESCE(h.passed, h.exit_code = 0 AND COALESCE(h.error_text,'') = ''),COALESCE(h.assert_msg,''),COALESCE(h.user_name,''),` +
+		`COALESCE(h.truncated,FALSE),COALESCE(h.output_bytes, ` + d.byteLength("h.stdout") + ` + ` + d.byteLength("h.stderr") + `, 0),COALESCE(h.output_blob,FALSE)`
+}
+
+// scanHistoryRow 读取 historyColumns 及调用方追加的列
+func scanHistoryRow(rows *sql.Rows, extra ...any) (domain.ExecHistory, error) {
+	var h domain.ExecHistory
+	dest := append([]any{&h.ID, &h.MachineID, &h.IPMIIP, &h.Command, &h.Stdout, &h.Stderr, &h.ExitCode, &h.ErrorText, &h.StartedAt, &h.FinishedAt, &h.DurationMs, &h.JobID, &h.Passed, &h.AssertMsg, &h.User, &h.Truncated, &h.OutputBytes, &h.Preview}, extra...)
+	return h, rows.Scan(dest...)
+}
+
+func scanHistory(rows *sql.Rows) ([]domain.ExecHistory, error) {
+	defer rows.Close()
+	var list []domain.ExecHistory
+	for rows.Next() {
+		h, err := scanHistoryRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, h)
+	}
+	return list, rows.Err()
+}
+
+const insertHistorySQL = `INSERT INTO exec_history(machine_id,ipmi_ip,command,stdout,stderr,exit_code,error_text,started_at,finished_at,duration_ms,job_id,passed,assert_msg,user_name,truncated,output_bytes,output_blob)
+        VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+
+// insertHistory 写入一条记录 (大输出另存 blob)；fts 为 true 时同时写入全文索引
+func insertHistory(ex execer, d Dialect, h *domain.ExecHistory, fts bool) error {
 	now := time.Now()
 	if h.StartedAt.IsZero() {
 		h.StartedAt = now
@@ -38,77 +76,200 @@ func (r *HistoryRepo) Insert(h *domain.ExecHistory) error {
 	if h.FinishedAt.IsZero() {
 		h.FinishedAt = now
 	}
-	res, err := r.db.Exec(`INSERT INTO exec_history(machine_id,ipmi_ip,command,stdout,stderr,exit_code,error_text,started_at,finished_at,duration_ms)
-        VALUES (?,?,?,?,?,?,?,?,?,?)`, h.MachineID, h.IPMIIP, h.Command, h.Stdout, h.Stderr, h.ExitCode, h.ErrorText, h.StartedAt, h.FinishedAt, h.DurationMs)
+	size := h.OutputBytes
+	if size == 0 {
+		size = int64(len(h.Stdout) + len(h.Stderr))
+	}
+	stdout, stderr := h.Stdout, h.Stderr
+	blob := len(stdout)+len(stderr) > inlineOutputBytes
+	if blob {
+		stdout, stderr = output.Head(stdout, previewOutputBytes), output.Head(stderr, previewOutputBytes)
+	}
+	id, err := insertID(ex, d, insertHistorySQL, h.MachineID, h.IPMIIP, h.Command, stdout, stderr, h.ExitCode, h.ErrorText, h.StartedAt, h.FinishedAt, h.DurationMs, h.JobID, h.Passed, h.AssertMsg, h.User, h.Truncated, size, blob)
 	if err != nil {
 		return err
 	}
-	id, _ := res.LastInsertId()
+	if blob {
+		zo, err := output.Compress([]byte(h.Stdout))
+		if err != nil {
+			return err
+		}
+		ze, err := output.Compress([]byte(h.Stderr))
+		if err != nil {
+			return err
+		}
+		if _, err := ex.Exec(d.rebind(`INSERT INTO exec_output(history_id,encoding,stdout,stderr) VALUES (?,?,?,?)`), id, output.EncodingGzip, zo, ze); err != nil {
+			return err
+		}
+	}
+	if fts {
+		if err := indexHistory(ex, id, h.Command, h.Stdout, h.Stderr); err != nil {
+			return err
+		}
+	}
 	h.ID = id
 	return nil
 }
 
+// inTx 在事务中执行 fn，出错时回滚
+func (r *HistoryRepo) inTx(fn func(*sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Insert 写入一条记录 (大输出的 blob 与元数据在同一事务中)
+func (r *HistoryRepo) Insert(h *domain.ExecHistory) error {
+	defer observeQuery("history.insert", time.Now())
+	fts := r.ftsEnabled() // 事务外检测，避免单连接时死锁
+	return r.inTx(func(tx *sql.Tx) error { return insertHistory(tx, r.d, h, fts) })
+}
+
+// InsertBatch 在单个事务中写入多条记录 (全部成功或全部回滚)，成功后回填 ID
+func (r *HistoryRepo) InsertBatch(hs []domain.ExecHistory) error {
+	defer observeQuery("history.insert_batch", time.Now())
+	if len(hs) == 0 {
+		return nil
+	}
+	fts := r.ftsEnabled()
+	return r.inTx(func(tx *sql.Tx) error {
+		for i := range hs {
+			if err := insertHistory(tx, r.d, &hs[i], fts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetOutput 读取单条历史的完整输出 (列表只含预览时使用)；记录不存在时返回 sql.ErrNoRows
+func (r *HistoryRepo) GetOutput(id int64) (domain.HistoryOutput, error) {
+	defer observeQuery("history.get_output", time.Now())
+	o := domain.HistoryOutput{ID: id}
+	var blob bool
+	err := r.db.QueryRow(r.d.rebind(`SELECT machine_id,stdout,stderr,COALESCE(truncated,FALSE),COALESCE(output_bytes, `+r.d.byteLength("stdout")+` + `+r.d.byteLength("stderr")+`, 0),COALESCE(output_blob,FALSE) FROM exec_history WHERE id = ?`), id).
+		Scan(&o.MachineID, &o.Stdout, &o.Stderr, &o.Truncated, &o.OutputBytes, &blob)
+	if err != nil || !blob {
+		return o, err
+	}
+	var (
+		enc    string
+		zo, ze []byte
+	)
+	if err := r.db.QueryRow(r.d.rebind(`SELECT encoding,stdout,stderr FROM exec_output WHERE history_id = ?`), id).Scan(&enc, &zo, &ze); err != nil {
+		return o, fmt.Errorf("load output of history %d: %w", id, err)
+	}
+	so, err := output.Decompress(enc, zo)
+	if err != nil {
+		return o, fmt.Errorf("decode output of history %d: %w", id, err)
+	}
+	se, err := output.Decompress(enc, ze)
+	if err != nil {
+		return o, fmt.Errorf("decode output of history %d: %w", id, err)
+	}
+	o.Stdout, o.Stderr = string(so), string(se)
+	return o, nil
+}
+
 func (r *HistoryRepo) ListRecent(limit int) ([]domain.ExecHistory, error) {
+	defer observeQuery("history.list_recent", time.Now())
 	if limit <= 0 {
 		limit = 50
 	}
-	rows, err := r.db.Query(`SELECT id,machine_id,ipmi_ip,command,stdout,stderr,exit_code,error_text,started_at,finished_at,duration_ms FROM exec_history ORDER BY id DESC LIMIT ?`, limit)
+	rows, err := r.db.Query(r.d.rebind(`SELECT `+historyColumns(r.d)+` FROM exec_history h ORDER BY h.id DESC LIMIT ?`), limit)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var list []domain.ExecHistory
-	for rows.Next() {
-		var h domain.ExecHistory
-		if err := rows.Scan(&h.ID, &h.MachineID, &h.IPMIIP, &h.Command, &h.Stdout, &h.Stderr, &h.ExitCode, &h.ErrorText, &h.StartedAt, &h.FinishedAt, &h.DurationMs); err != nil {
-			return nil, err
-		}
-		list = append(list, h)
-	}
-	return list, nil
+	return scanHistory(rows)
 }
 
 // ListFiltered 支持按 ipmi_ip 与 command 关键字过滤 (模糊匹配)。传空表示忽略该条件。
 func (r *HistoryRepo) ListFiltered(limit int, ipmi, cmdLike string) ([]domain.ExecHistory, error) {
+	defer observeQuery("history.list_filtered", time.Now())
 	if limit <= 0 {
 		limit = 50
 	}
 	where := ""
 	args := []any{}
 	if ipmi != "" {
-		where += " AND ipmi_ip LIKE ?"
-		args = append(args, "%"+ipmi+"%")
+		where += " AND h.ipmi_ip " + r.d.like()
+		args = append(args, likeContains(ipmi))
 	}
 	if cmdLike != "" {
-		where += " AND command LIKE ?"
-		args = append(args, "%"+cmdLike+"%")
+		where += " AND h.command " + r.d.like()
+		args = append(args, likeContains(cmdLike))
 	}
-	q := `SELECT id,machine_id,ipmi_ip,command,stdout,stderr,exit_code,error_text,started_at,finished_at,duration_ms FROM exec_history WHERE 1=1` + where + ` ORDER BY id DESC LIMIT ?`
+	q := `SELECT ` + historyColumns(r.d) + ` FROM exec_history h WHERE 1=1` + where + ` ORDER BY h.id DESC LIMIT ?`
 	args = append(args, limit)
-	rows, err := r.db.Query(q, args...)
+	rows, err := r.db.Query(r.d.rebind(q), args...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var list []domain.ExecHistory
-	for rows.Next() {
-		var h domain.ExecHistory
-		if err := rows.Scan(&h.ID, &h.MachineID, &h.IPMIIP, &h.Command, &h.Stdout, &h.Stderr, &h.ExitCode, &h.ErrorText, &h.StartedAt, &h.FinishedAt, &h.DurationMs); err != nil {
-			return nil, err
-		}
-		list = append(list, h)
+	return scanHistory(rows)
+}
+
+// ListByJob 返回某个任务的全部历史 (按 id 升序)
+func (r *HistoryRepo) ListByJob(jobID string) ([]domain.ExecHistory, error) {
+	defer observeQuery("history.list_by_job", time.Now())
+	rows, err := r.db.Query(r.d.rebind(`SELECT `+historyColumns(r.d)+` FROM exec_history h WHERE h.job_id = ? ORDER BY h.id ASC`), jobID)
+	if err != nil {
+		return nil, err
 	}
-	return list, nil
+	return scanHistory(rows)
 }
 
 // Cleanup 根据保留天数与最大行数裁剪
 func (r *HistoryRepo) Cleanup(retentionDays, maxRows int) error {
+	defer observeQuery("history.cleanup", time.Now())
+	var removed int64
 	if retentionDays > 0 {
-		_, _ = r.db.Exec(`DELETE FROM exec_history WHERE started_at < datetime('now', ?)`, fmt.Sprintf("-%d days", retentionDays))
+		var (
+			res sql.Result
+			err error
+		)
+		if r.d == DialectSQLite {
+			res, err = r.db.Exec(`DELETE FROM exec_history WHERE started_at < datetime('now', ?)`, fmt.Sprintf("-%d days", retentionDays))
+		} else {
+			res, err = r.db.Exec(r.d.rebind(`DELETE FROM exec_history WHERE started_at < ?`), time.Now().AddDate(0, 0, -retentionDays))
+		}
+		if err != nil {
+			return fmt.Errorf("cleanup history by age: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		removed += n
 	}
 	if maxRows > 0 {
-		// 删除超过 maxRows 的最旧行
-		_, _ = r.db.Exec(`DELETE FROM exec_history WHERE id IN (SELECT id FROM exec_history ORDER BY id DESC LIMIT -1 OFFSET ?)`, maxRows)
+		// 删除超过 maxRows 的最旧行：id 自增，先取第 maxRows+1 新的 id 再按范围删除 (MySQL 不支持子查询中的 LIMIT)
+		var cut int64
+		err := r.db.QueryRow(r.d.rebind(`SELECT id FROM exec_history ORDER BY id DESC LIMIT 1 OFFSET ?`), maxRows).Scan(&cut)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("cleanup history by rows: %w", err)
+		}
+		if err == nil {
+			res, err := r.db.Exec(r.d.rebind(`DELETE FROM exec_history WHERE id <= ?`), cut)
+			if err != nil {
+				return fmt.Errorf("cleanup history by rows: %w", err)
+			}
+			n, _ := res.RowsAffected()
+			removed += n
+		}
+	}
+	if removed > 0 {
+		if _, err := r.db.Exec(`DELETE FROM exec_output WHERE history_id NOT IN (SELECT id FROM exec_history)`); err != nil {
+			return fmt.Errorf("cleanup history output: %w", err)
+		}
+		if r.ftsEnabled() {
+			if _, err := r.db.Exec(`DELETE FROM exec_history_fts WHERE rowid NOT IN (SELECT id FROM exec_history)`); err != nil {
+				return fmt.Errorf("cleanup history index: %w", err)
+			}
+		}
+		logger().Info("history cleanup", "removed", removed, "retention_days", retentionDays, "max_rows", maxRows)
 	}
 	return nil
 }
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/output"
+)
+
+const (
+	ftsUnknown int32 = iota
+	ftsOn
+	ftsOff
+
+	// ftsOutputBytes 每路输出写入全文索引的上限 (超出部分不可检索)
+	ftsOutputBytes = 256 << 10
+
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+
+	// historyTimeLayout started_at 以本地时间字符串保存，范围条件按前缀比较
+	historyTimeLayout = "2006-01-02 15:04:05"
+)
+
+// ErrSearchUnavailable SQLite 未编译 FTS5 或历史保存在共享库中，无法按关键字检索
+var ErrSearchUnavailable = errors.New("full-text search unavailable (needs the local sqlite database with fts5)")
+
+// ErrInvalidSearchQuery 关键字不是合法的 FTS5 查询 (如引号不成对、列名不存在)
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+// ftsColumns 全文索引的列 (可用作 column:term 过滤)
+var ftsColumns = []string{"command", "stdout", "stderr"}
+
+// ftsQuery 不含 FTS5 语法 (引号、括号、* / ^ / {}、列过滤、AND / OR / NOT) 的输入逐词加引号，
+// 使 eth0-up、10.0.0.1:623 这类普通输入按短语检索；含语法的输入原样交给 FTS5
+func ftsQuery(q string) string {
+	if strings.ContainsAny(q, `"()*^{}`) {
+		return q
+	}
+	terms := strings.Fields(q)
+	for _, t := range terms {
+		if t == "AND" || t == "OR" || t == "NOT" {
+			return q
+		}
+		if col, _, ok := strings.Cut(t, ":"); ok && slices.Contains(ftsColumns, strings.ToLower(col)) {
+			return q
+		}
+	}
+	for i, t := range terms {
+		terms[i] = `"` + t + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchError FTS5 查询语法错误映射为 ErrInvalidSearchQuery，其余包装为检索失败
+func searchError(err error) error {
+	msg := err.Error()
+	if strings.Contains(msg, "fts5: syntax error") || strings.Contains(msg, "unterminated string") || strings.Contains(msg, "no such column") {
+		return fmt.Errorf("%w: %v", ErrInvalidSearchQuery, err)
+	}
+	return fmt.Errorf("search history: %w", err)
+}
+
+// ensureFTS 创建全文索引表；首次创建时为已有历史补建索引。驱动不支持 FTS5 时只记录警告，共享库不建索引
+func (r *HistoryRepo) ensureFTS() error {
+	if r.d != DialectSQLite {
+		r.fts.Store(ftsOff)
+		return nil
+	}
+	var n int
+	if err := r.db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type='table' AND name='exec_history_fts'`).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		r.fts.Store(ftsOn)
+		return nil
+	}
+	_, err := r.db.Exec(`CREATE VIRTUAL TABLE exec_history_fts USING fts5(command, stdout, stderr, tokenize='unicode61')`)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			logger().Warn("sqlite fts5 not available, history search disabled", "error", err.Error())
+			r.fts.Store(ftsOff)
+			return nil
+		}
+		return fmt.Errorf("create history index: %w", err)
+	}
+	r.fts.Store(ftsOn)
+	return r.reindex()
+}
+
+// reindex 为全部历史建立索引；大输出从 exec_output 解压后写入
+func (r *HistoryRepo) reindex() error {
+	start := time.Now()
+	if _, err := r.db.Exec(`DELETE FROM exec_history_fts`); err != nil {
+		return fmt.Errorf("reindex history: %w", err)
+	}
+	res, err := r.db.Exec(`INSERT INTO exec_history_fts(rowid,command,stdout,stderr) SELECT id,command,stdout,stderr FROM exec_history WHERE COALESCE(output_blob,0) = 0`)
+	if err != nil {
+		return fmt.Errorf("reindex history: %w", err)
+	}
+	indexed, _ := res.RowsAffected()
+	rows, err := r.db.Query(`SELECT id,command FROM exec_history WHERE output_blob = 1`)
+	if err != nil {
+		return fmt.Errorf("reindex history: %w", err)
+	}
+	type blobRow struct {
+		id  int64
+		cmd string
+	}
+	var blobs []blobRow
+	for rows.Next() {
+		var b blobRow
+		if err := rows.Scan(&b.id, &b.cmd); err != nil {
+			rows.Close()
+			return fmt.Errorf("reindex history: %w", err)
+		}
+		blobs = append(blobs, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reindex history: %w", err)
+	}
+	for _, b := range blobs {
+		o, err := r.GetOutput(b.id)
+		if err != nil {
+			return fmt.Errorf("reindex history: %w", err)
+		}
+		if err := indexHistory(r.db, b.id, b.cmd, o.Stdout, o.Stderr); err != nil {
+			return fmt.Errorf("reindex history: %w", err)
+		}
+	}
+	if n := indexed + int64(len(blobs)); n > 0 {
+		logger().Info("history index built", "records", n, "duration_ms", time.Since(start).Milliseconds())
+	}
+	return nil
+}
+
+// ftsEnabled 全文索引是否可用 (未经 EnsureSchema 时按表是否存在判断)
+func (r *HistoryRepo) ftsEnabled() bool {
+	switch r.fts.Load() {
+	case ftsOn:
+		return true
+	case ftsOff:
+		return false
+	}
+	if r.d != DialectSQLite {
+		r.fts.Store(ftsOff)
+		return false
+	}
+	var n int
+	if err := r.db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type='table' AND name='exec_history_fts'`).Scan(&n); err != nil {
+		return false
+	}
+	if n > 0 {
+		r.fts.Store(ftsOn)
+		return true
+	}
+	r.fts.Store(ftsOff)
+	return false
+}
+
+// indexHistory 写入 (或替换) 一条记录的索引
+func indexHistory(ex execer, id int64, command, stdout, stderr string) error {
+	_, err := ex.Exec(`INSERT OR REPLACE INTO exec_history_fts(rowid,command,stdout,stderr) VALUES (?,?,?,?)`,
+		id, command, output.Head(stdout, ftsOutputBytes), output.Head(stderr, ftsOutputBytes))
+	return err
+}
+
+// Search 按 FTS5 查询与过滤条件检索历史。有 Query 时默认按相关度排序并返回高亮片段，否则按时间倒序；
+// q.Selector 由调用方解析为 MachineIDs，这里不处理
+func (r *HistoryRepo) Search(q domain.HistorySearch) ([]domain.HistoryHit, error) {
+	defer observeQuery("history.search", time.Now())
+	query := strings.TrimSpace(q.Query)
+	if query != "" && !r.ftsEnabled() {
+		return nil, ErrSearchUnavailable
+	}
+	var (
+		cols  = historyColumns(r.d) + `,''`
+		from  = ` FROM exec_history h`
+		where = ` WHERE 1=1`
+		order = ` ORDER BY h.id DESC`
+		args  []any
+	)
+	if query != "" {
+		cols = historyColumns(r.d) + `,snippet(exec_history_fts,-1,char(2),char(3),'…',16)`
+		from = ` FROM exec_history_fts JOIN exec_history h ON h.id = exec_history_fts.rowid`
+		where += ` AND exec_history_fts MATCH ?`
+		args = append(args, ftsQuery(query))
+		if q.Sort != "newest" {
+			order = ` ORDER BY exec_history_fts.rank, h.id DESC`
+		}
+	}
+	if q.ExitCode != nil {
+		where += ` AND h.exit_code = ?`
+		args = append(args, *q.ExitCode)
+	}
+	if q.Passed != nil {
+		where += ` AND COALESCE(h.passed, h.exit_code = 0 AND COALESCE(h.error_text,'') = '') = ?`
+		args = append(args, *q.Passed)
+	}
+	if q.JobID != "" {
+		where += ` AND h.job_id = ?`
+		args = append(args, q.JobID)
+	}
+	if len(q.MachineIDs) > 0 {
+		where += ` AND h.machine_id IN (?` + strings.Repeat(`,?`, len(q.MachineIDs)-1) + `)`
+		for _, id := range q.MachineIDs {
+			args = append(args, id)
+		}
+	}
+	if !q.Since.IsZero() {
+		where += ` AND h.started_at >= ?`
+		args = append(args, r.d.timeArg(q.Since))
+	}
+	if !q.Until.IsZero() {
+		where += ` AND h.started_at < ?`
+		args = append(args, r.d.timeArg(q.Until))
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	args = append(args, min(limit, maxSearchLimit), max(0, q.Offset))
+	rows, err := r.db.Query(r.d.rebind(`SELECT `+cols+from+where+order+` LIMIT ? OFFSET ?`), args...)
+	if err != nil {
+		return nil, searchError(err)
+	}
+	defer rows.Close()
+	var hits []domain.HistoryHit
+	for rows.Next() {
+		var raw sql.NullString
+		h, err := scanHistoryRow(rows, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("search history: %w", err)
+		}
+		hits = append(hits, domain.HistoryHit{ExecHistory: h, Snippet: highlight(raw.String)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, searchError(err)
+	}
+	return hits, nil
+}
+
+// highlight 转义片段中的 HTML，并把 snippet() 的 \x02 / \x03 标记换成 <mark>
+func highlight(s string) string {
+	if s == "" {
+		return ""
+	}
+	return strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(html.EscapeString(s))
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// WalkSamples 按时间正序遍历范围内的执行记录 (只读统计所需的列)；fn 返回错误时停止并返回该错误。
+// q.Selector 由调用方解析为 MachineIDs，这里不处理
+func (r *HistoryRepo) WalkSamples(q domain.HistoryStatsQuery, fn func(domain.HistorySample) error) error {
+	defer observeQuery("history.walk_samples", time.Now())
+	where := ` WHERE 1=1`
+	var args []any
+	if !q.Since.IsZero() {
+		where += ` AND started_at >= ?`
+		args = append(args, r.d.timeArg(q.Since))
+	}
+	if !q.Until.IsZero() {
+		where += ` AND started_at < ?`
+		args = append(args, r.d.timeArg(q.Until))
+	}
+	if q.Command != "" {
+		where += ` AND command = ?`
+		args = append(args, q.Command)
+	}
+	if len(q.MachineIDs) > 0 {
+		where += ` AND machine_id IN (?` + strings.Repeat(`,?`, len(q.MachineIDs)-1) + `)`
+		for _, id := range q.MachineIDs {
+			args = append(args, id)
+		}
+	}
+	rows, err := r.db.Query(r.d.rebind(`SELECT machine_id,ipmi_ip,command,started_at,duration_ms,COALESCE(passed, exit_code = 0 AND COALESCE(error_text,'') = ''),exit_code,COALESCE(error_text,'') FROM exec_history`+where+` ORDER BY id ASC`), args...)
+	if err != nil {
+		return fmt.Errorf("walk history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s domain.HistorySample
+		if err := rows.Scan(&s.MachineID, &s.IPMIIP, &s.Command, &s.StartedAt, &s.DurationMs, &s.Passed, &s.ExitCode, &s.ErrorText); err != nil {
+			return fmt.Errorf("walk history: %w", err)
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
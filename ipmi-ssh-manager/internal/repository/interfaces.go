@@ -1,6 +1,10 @@
 package repository
 
-import "github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+import (
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
 
 // MachineRepoIface 抽象机器仓库（本地或远程）。
 type MachineRepoIface interface {
@@ -11,18 +15,90 @@ type MachineRepoIface interface {
 	BulkUpsert([]domain.Machine) error
 	DeleteByIPMI(string) error
 	SearchByIPMI(string) ([]domain.Machine, error)
+	SelectMachines(string) ([]domain.Machine, error)       // 标签选择器，如 "rack=A12,role!=db"
+	Query(domain.MachineQuery) (domain.MachinePage, error) // 结构化查询 (过滤/CIDR/标签/排序/分页)；无 SQL 的实现可用 MachineQuery.Apply
+	ListGroups() ([]domain.Group, error)
+	DeleteGroup(string) error
 	EnsureSchema() error // 远程实现可为 no-op
 }
 
+// MachineRevisioner 可选：机器修订历史与回收站 (DeleteByIPMI 为软删除)
+type MachineRevisioner interface {
+	ForActor(string) MachineRepoIface // 以指定操作者写入修订记录
+	ListRevisions(string) ([]domain.MachineRevision, error)
+	GetRevision(int64) (domain.MachineRevision, error)
+	ListDeleted() ([]domain.Machine, error)
+	Restore(string) (domain.Machine, error)
+	Purge(string) error
+}
+
 // HistoryRepoIface 抽象历史仓库。
 type HistoryRepoIface interface {
 	Insert(*domain.ExecHistory) error
 	ListRecent(int) ([]domain.ExecHistory, error)
 	ListFiltered(int, string, string) ([]domain.ExecHistory, error)
+	ListByJob(string) ([]domain.ExecHistory, error)
 	Cleanup(int, int) error
 	EnsureSchema() error // 本地建表；远程 no-op
 }
 
+// HistoryBatchInserter 可选：单事务批量写入历史 (本地仓库实现；未实现时逐条 Insert)
+type HistoryBatchInserter interface {
+	InsertBatch([]domain.ExecHistory) error
+}
+
+// HistoryOutputLoader 可选：按 ID 读取完整输出 (列表中 ExecHistory.Preview 为 true 时只含预览)
+type HistoryOutputLoader interface {
+	GetOutput(int64) (domain.HistoryOutput, error)
+}
+
+// HistorySearcher 可选：全文检索历史输出 (本地仓库基于 SQLite FTS5 实现)
+type HistorySearcher interface {
+	Search(domain.HistorySearch) ([]domain.HistoryHit, error)
+}
+
+// HistorySampleWalker 可选：按时间正序遍历执行记录用于统计 (不读取输出)
+type HistorySampleWalker interface {
+	WalkSamples(domain.HistoryStatsQuery, func(domain.HistorySample) error) error
+}
+
+// UserRepoIface 抽象用户仓库 (令牌仅以哈希形式保存)。
+type UserRepoIface interface {
+	Create(*domain.User, string) error
+	Update(domain.User) error
+	SetTokenHash(string, string) error
+	Delete(string) error
+	GetByName(string) (domain.User, error)
+	GetByTokenHash(string) (domain.User, error)
+	List() ([]domain.User, error)
+	EnsureSchema() error
+}
+
+// AuditRepoIface 抽象审计日志仓库 (只追加)。
+type AuditRepoIface interface {
+	Append(*domain.AuditEntry) error
+	Query(domain.AuditQuery) ([]domain.AuditEntry, error)
+	Walk(func(domain.AuditEntry) error) error
+	EnsureSchema() error
+}
+
+// ApprovalRepoIface 抽象命令审批单仓库。
+type ApprovalRepoIface interface {
+	Create(domain.Approval) error
+	Get(string) (domain.Approval, error)
+	Transition(string, domain.ApprovalStatus, domain.ApprovalStatus, string, time.Time) error
+	List(domain.ApprovalStatus, int) ([]domain.Approval, error)
+	EnsureSchema() error
+}
+
 // 编译期断言本地实现满足接口
 var _ MachineRepoIface = (*MachineRepo)(nil)
+var _ MachineRevisioner = (*MachineRepo)(nil)
 var _ HistoryRepoIface = (*HistoryRepo)(nil)
+var _ HistoryBatchInserter = (*HistoryRepo)(nil)
+var _ HistoryOutputLoader = (*HistoryRepo)(nil)
+var _ HistorySearcher = (*HistoryRepo)(nil)
+var _ HistorySampleWalker = (*HistoryRepo)(nil)
+var _ UserRepoIface = (*UserRepo)(nil)
+var _ AuditRepoIface = (*AuditRepo)(nil)
+var _ ApprovalRepoIface = (*ApprovalRepo)(nil)
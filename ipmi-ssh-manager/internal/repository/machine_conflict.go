@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrVersionConflict 机器已被他人修改：保存时携带的 version 与库中不一致
+var ErrVersionConflict = errors.New("machine was modified by someone else")
+
+// ConflictError 单台机器的版本冲突；Current 为库中当前版本 (0 表示机器已被彻底删除)
+type ConflictError struct {
+	IPMIIP   string `json:"ipmi_ip"`
+	Expected int    `json:"expected"`
+	Current  int    `json:"current"`
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v: %s (version %d, current %d)", ErrVersionConflict, e.IPMIIP, e.Expected, e.Current)
+}
+
+func (e *ConflictError) Unwrap() error { return ErrVersionConflict }
+
+// BulkConflictError BulkUpsert 中因版本冲突跳过的条目 (其余 Applied 条已写入)
+type BulkConflictError struct {
+	Applied   int             `json:"applied"`
+	Conflicts []ConflictError `json:"conflicts"`
+}
+
+func (e *BulkConflictError) Error() string {
+	ips := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		ips[i] = fmt.Sprintf("%s (version %d, current %d)", c.IPMIIP, c.Expected, c.Current)
+	}
+	return fmt.Sprintf("%v: skipped %d machines: %s", ErrVersionConflict, len(e.Conflicts), strings.Join(ips, ", "))
+}
+
+func (e *BulkConflictError) Unwrap() error { return ErrVersionConflict }
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/pkg/secret"
+)
+
+// Query 结构化查询。字段条件 / remark 关键词 / 排序尽量下推到 SQL；
+// CIDR 与标签条件在内存中二次过滤，此时分页也在内存中完成。
+func (r *MachineRepo) Query(q domain.MachineQuery) (domain.MachinePage, error) {
+	defer observeQuery("machine.query", time.Now())
+	where, args, err := buildMachineWhere(q, r.d)
+	if err != nil {
+		return domain.MachinePage{}, err
+	}
+	order := "id"
+	if q.SortBy != "" {
+		if _, ok := machineQueryColumn(q.SortBy); !ok {
+			return domain.MachinePage{}, fmt.Errorf("unknown sort field %q", q.SortBy)
+		}
+		order = q.SortBy
+	}
+	if q.Desc {
+		order += " DESC"
+	} else {
+		order += " ASC"
+	}
+	if order != "id ASC" && order != "id DESC" {
+		order += ", id ASC" // 次序稳定
+	}
+	base := `SELECT id, ipmi_ip, ssh_ip, ssh_user, COALESCE(ssh_key,''), COALESCE(remark,''), COALESCE(created_at,''), COALESCE(zbx_id,''), COALESCE(attrs,''), version FROM machines WHERE deleted_at IS NULL` + where + ` ORDER BY ` + order
+
+	if q.NeedsPostFilter() {
+		list, err := r.queryMachines(base, args...)
+		if err != nil {
+			return domain.MachinePage{}, err
+		}
+		matched := list[:0]
+		for _, m := range list {
+			if q.MatchPost(m) {
+				matched = append(matched, m)
+			}
+		}
+		return domain.Paginate(matched, q.Offset, q.Limit), nil
+	}
+
+	var total int
+	if err := r.db.QueryRow(r.d.rebind(`SELECT COUNT(*) FROM machines WHERE deleted_at IS NULL`+where), args...).Scan(&total); err != nil {
+		return domain.MachinePage{}, err
+	}
+	if q.Limit > 0 {
+		base += ` LIMIT ? OFFSET ?`
+		args = append(args, q.Limit, q.Offset)
+	} else if q.Offset > 0 {
+		base += r.d.offsetOnly()
+		args = append(args, q.Offset)
+	}
+	list, err := r.queryMachines(base, args...)
+	if err != nil {
+		return domain.MachinePage{}, err
+	}
+	if list == nil {
+		list = []domain.Machine{}
+	}
+	return domain.MachinePage{Items: list, Total: total, Offset: q.Offset, Limit: q.Limit}, nil
+}
+
+// buildMachineWhere 将可下推的条件转换为 SQL 片段 (以 " AND" 开头)
+func buildMachineWhere(q domain.MachineQuery, d Dialect) (string, []any, error) {
+	var (
+		b    strings.Builder
+		args []any
+	)
+	for _, f := range q.Filters {
+		if f.Op == domain.FilterCIDR {
+			continue
+		}
+		col, ok := machineQueryColumn(f.Field)
+		if !ok {
+			return "", nil, fmt.Errorf("unknown field %q", f.Field)
+		}
+		var val any = f.Value
+		if f.Field == "id" && f.Op != domain.FilterContains {
+			n, err := strconv.Atoi(f.Value)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid id %q", f.Value)
+			}
+			val = n
+		}
+		switch f.Op {
+		case domain.FilterContains:
+			b.WriteString(" AND " + col + " " + d.like())
+			val = likeContains(f.Value)
+		case domain.FilterEq:
+			b.WriteString(" AND " + col + " = ?")
+		case domain.FilterNotEq:
+			b.WriteString(" AND " + col + " != ?")
+		case domain.FilterGt:
+			b.WriteString(" AND " + col + " > ?")
+		case domain.FilterLt:
+			b.WriteString(" AND " + col + " < ?")
+		default:
+			return "", nil, fmt.Errorf("unsupported operator %q", f.Op)
+		}
+		args = append(args, val)
+	}
+	for _, t := range q.Text {
+		b.WriteString(" AND COALESCE(remark,'') " + d.like())
+		args = append(args, likeContains(t))
+	}
+	return b.String(), args, nil
+}
+
+// machineQueryColumn 字段白名单 -> SQL 列表达式 (防注入)
+func machineQueryColumn(field string) (string, bool) {
+	switch field {
+	case "id", "ipmi_ip":
+		return field, true
+	case "ssh_ip", "ssh_user", "zbx_id", "remark", "created_at":
+		return "COALESCE(" + field + ",'')", true
+	}
+	return "", false
+}
+
+// queryMachines 执行查询并解码/解密/补齐标签分组
+func (r *MachineRepo) queryMachines(q string, args ...any) ([]domain.Machine, error) {
+	rows, err := r.db.Query(r.d.rebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []domain.Machine
+	for rows.Next() {
+		var m domain.Machine
+		var createdAtStr, attrsStr string
+		if err := rows.Scan(&m.ID, &m.IPMIIP, &m.SSHIP, &m.SSHUser, &m.SSHKey, &m.Remark, &createdAtStr, &m.ZBXID, &attrsStr, &m.Version); err != nil {
+			return nil, err
+		}
+		m.Attrs = decodeAttrs(attrsStr)
+		m.CreatedAt = parseMachineTime(createdAtStr)
+		if m.SSHKey != "" {
+			if p, e := secret.DecryptString(m.SSHKey); e == nil && p != "" {
+				m.SSHKey = p
+			}
+		}
+		list = append(list, m)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	return list, r.fillLabelsAndGroups(list)
+}
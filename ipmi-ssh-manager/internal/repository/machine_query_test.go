@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+func TestMachineRepo_Query(t *testing.T) {
+	db := openMemMachines(t)
+	defer db.Close()
+	repo := NewMachineRepo(db)
+	ms := []domain.Machine{
+		{IPMIIP: "10.0.0.11", SSHIP: "192.168.1.11", SSHUser: "root", Remark: "Rack move pending", Labels: map[string]string{"rack": "A12"}},
+		{IPMIIP: "10.0.0.12", SSHIP: "192.168.1.12:2222", SSHUser: "admin", Remark: "db primary", Labels: map[string]string{"rack": "A12", "role": "db"}},
+		{IPMIIP: "10.0.1.13", SSHIP: "192.168.2.13", SSHUser: "root", Remark: "rack spare"},
+		{IPMIIP: "172.16.0.14", SSHIP: "192.168.2.14", SSHUser: "root", ZBXID: "zbx-14"},
+	}
+	if err := repo.BulkUpsert(ms); err != nil {
+		t.Fatal(err)
+	}
+	all, err := repo.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"ssh_user=root", []string{"10.0.0.11", "10.0.1.13", "172.16.0.14"}},
+		{"ipmi_ip:10.0.0.0/24", []string{"10.0.0.11", "10.0.0.12"}},
+		{"ssh_ip:192.168.1.0/24 sort:-id", []string{"10.0.0.12", "10.0.0.11"}},
+		{"rack", []string{"10.0.0.11", "10.0.1.13"}},
+		{`remark:"rack move"`, []string{"10.0.0.11"}},
+		{"label:rack=A12 label:!role", []string{"10.0.0.11"}},
+		{"zbx_id!= sort:ipmi_ip", []string{"172.16.0.14"}},
+		{"ssh_user=root sort:-ipmi_ip offset:1 limit:1", []string{"10.0.1.13"}},
+	}
+	for _, c := range cases {
+		q, err := domain.ParseMachineQuery(c.query)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.query, err)
+		}
+		page, err := repo.Query(q)
+		if err != nil {
+			t.Fatalf("query %q: %v", c.query, err)
+		}
+		mem := q.Apply(all)
+		got, gotMem := ipsOf(page.Items), ipsOf(mem.Items)
+		if got != strings.Join(c.want, ",") {
+			t.Fatalf("query %q: want %v got %v", c.query, c.want, got)
+		}
+		if got != gotMem || page.Total != mem.Total {
+			t.Fatalf("query %q: sql %v/%d differs from in-memory %v/%d", c.query, got, page.Total, gotMem, mem.Total)
+		}
+	}
+	for _, bad := range []string{"ssh_key=x", "sort:ssh_key", "ipmi_ip:10.0.0.0/99", "limit:-1"} {
+		if _, err := domain.ParseMachineQuery(bad); err == nil {
+			t.Fatalf("expected parse error for %q", bad)
+		}
+	}
+}
+
+func ipsOf(ms []domain.Machine) string {
+	ips := make([]string, 0, len(ms))
+	for _, m := range ms {
+		ips = append(ips, m.IPMIIP)
+	}
+	return strings.Join(ips, ",")
+}
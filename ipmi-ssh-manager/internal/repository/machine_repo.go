@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"strings"
 	"time"
@@ -11,56 +12,35 @@ import (
 )
 
 type MachineRepo struct {
-	db *sql.DB
+	db    *sql.DB
+	d     Dialect
+	actor string // 写入修订记录的操作者 (见 ForActor)
 }
 
+// NewMachineRepo 本地 SQLite 机器仓库
 func NewMachineRepo(db *sql.DB) *MachineRepo {
-	return &MachineRepo{db: db}
+	return &MachineRepo{db: db, d: DialectSQLite}
 }
 
-// ensureSchema 可以在应用启动时调用以补齐缺失列 (幂等)。
+// NewMachineRepoDialect 共享库 (PostgreSQL / MySQL) 的机器仓库，db 由 OpenDSN 打开
+func NewMachineRepoDialect(db *sql.DB, d Dialect) *MachineRepo {
+	return &MachineRepo{db: db, d: d}
+}
+
+// EnsureSchema 执行未应用的迁移 (见 MigrateDialect，幂等)
 func (r *MachineRepo) EnsureSchema() error {
-	// 若主表不存在先创建
-	if _, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS machines(
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		ipmi_ip TEXT UNIQUE NOT NULL,
-		ssh_ip TEXT,
-		ssh_user TEXT,
-		ssh_key TEXT,
-		remark TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		zbx_id TEXT
-	)`); err != nil {
-		return err
-	}
-	// 添加缺失列 (sqlite 不支持 IF NOT EXISTS 列，需捕获错误忽略)
-	alterStatements := []string{
-		"ALTER TABLE machines ADD COLUMN ssh_key TEXT",
-		"ALTER TABLE machines ADD COLUMN remark TEXT",
-		"ALTER TABLE machines ADD COLUMN created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
-		"ALTER TABLE machines ADD COLUMN zbx_id TEXT",
-	}
-	for _, sql := range alterStatements {
-		if _, err := r.db.Exec(sql); err != nil {
-			// 如果是列已存在错误，忽略 (通过错误消息判断)
-			if !strings.Contains(strings.ToLower(err.Error()), "duplicate") &&
-				!strings.Contains(strings.ToLower(err.Error()), "exists") &&
-				!strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
-				// 其它错误才返回
-				return err
-			}
-		}
-	}
-	return nil
+	_, err := MigrateDialect(r.db, r.d)
+	return err
 }
 
 func (r *MachineRepo) SearchByIPMI(ip string) ([]domain.Machine, error) {
+	defer observeQuery("machine.search", time.Now())
 	if ip == "" {
 		ip = "%"
 	} else {
-		ip = "%" + ip + "%"
+		ip = likeContains(ip)
 	}
-	rows, err := r.db.Query(`SELECT id, ipmi_ip, ssh_ip, ssh_user, COALESCE(ssh_key,''), COALESCE(remark,''), COALESCE(created_at,''), COALESCE(zbx_id,'') FROM machines WHERE ipmi_ip LIKE ? ORDER BY id DESC`, ip)
+	rows, err := r.db.Query(r.d.rebind(`SELECT id, ipmi_ip, ssh_ip, ssh_user, COALESCE(ssh_key,''), COALESCE(remark,''), COALESCE(created_at,''), COALESCE(zbx_id,''), COALESCE(attrs,''), version FROM machines WHERE deleted_at IS NULL AND ipmi_ip `+r.d.like()+` ORDER BY id DESC`), ip)
 	if err != nil {
 		return nil, err
 	}
@@ -68,42 +48,44 @@ func (r *MachineRepo) SearchByIPMI(ip string) ([]domain.Machine, error) {
 	var list []domain.Machine
 	for rows.Next() {
 		var m domain.Machine
-		var createdAtStr string
-		if err := rows.Scan(&m.ID, &m.IPMIIP, &m.SSHIP, &m.SSHUser, &m.SSHKey, &m.Remark, &createdAtStr, &m.ZBXID); err != nil {
+		var createdAtStr, attrsStr string
+		if err := rows.Scan(&m.ID, &m.IPMIIP, &m.SSHIP, &m.SSHUser, &m.SSHKey, &m.Remark, &createdAtStr, &m.ZBXID, &attrsStr, &m.Version); err != nil {
 			return nil, err
 		}
-		if createdAtStr != "" {
-			// 尝试多种格式
-			if ts, e := time.Parse(time.RFC3339Nano, createdAtStr); e == nil {
-				m.CreatedAt = ts
-			}
-		}
+		m.Attrs = decodeAttrs(attrsStr)
+		m.CreatedAt = parseMachineTime(createdAtStr)
 		list = append(list, m)
 	}
-	return list, nil
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	return list, r.fillLabelsAndGroups(list)
 }
 
 func (r *MachineRepo) GetByIPMI(ip string) (domain.Machine, error) {
+	defer observeQuery("machine.get", time.Now())
 	var m domain.Machine
-	row := r.db.QueryRow(`SELECT id, ipmi_ip, ssh_ip, ssh_user, COALESCE(ssh_key,''), COALESCE(remark,''), COALESCE(created_at,''), COALESCE(zbx_id,'') FROM machines WHERE ipmi_ip = ? LIMIT 1`, ip)
-	var createdAtStr string
-	if err := row.Scan(&m.ID, &m.IPMIIP, &m.SSHIP, &m.SSHUser, &m.SSHKey, &m.Remark, &createdAtStr, &m.ZBXID); err != nil {
+	row := r.db.QueryRow(r.d.rebind(`SELECT id, ipmi_ip, ssh_ip, ssh_user, COALESCE(ssh_key,''), COALESCE(remark,''), COALESCE(created_at,''), COALESCE(zbx_id,''), COALESCE(attrs,''), version FROM machines WHERE ipmi_ip = ? AND deleted_at IS NULL LIMIT 1`), ip)
+	var createdAtStr, attrsStr string
+	if err := row.Scan(&m.ID, &m.IPMIIP, &m.SSHIP, &m.SSHUser, &m.SSHKey, &m.Remark, &createdAtStr, &m.ZBXID, &attrsStr, &m.Version); err != nil {
 		return domain.Machine{}, err
 	}
-	if createdAtStr != "" {
-		if ts, e := time.Parse(time.RFC3339Nano, createdAtStr); e == nil {
-			m.CreatedAt = ts
-		}
-	}
+	m.Attrs = decodeAttrs(attrsStr)
+	m.CreatedAt = parseMachineTime(createdAtStr)
 	if m.SSHKey != "" { // 解密（忽略错误，保持兼容）
 		if p, err := secret.DecryptString(m.SSHKey); err == nil && p != "" {
 			m.SSHKey = p
 		}
 	}
-	return m, nil
+	one := []domain.Machine{m}
+	if err := r.fillLabelsAndGroups(one); err != nil {
+		return domain.Machine{}, err
+	}
+	return one[0], nil
 }
 
 func (r *MachineRepo) GetByIDs(ids []int64) ([]domain.Machine, error) {
+	defer observeQuery("machine.get_by_ids", time.Now())
 	if len(ids) == 0 {
 		return []domain.Machine{}, nil
 	}
@@ -114,8 +96,8 @@ func (r *MachineRepo) GetByIDs(ids []int64) ([]domain.Machine, error) {
 		placeholders[i] = "?"
 		args[i] = id
 	}
-	q := `SELECT id, ipmi_ip, ssh_ip, ssh_user, COALESCE(ssh_key,''), COALESCE(remark,''), COALESCE(created_at,''), COALESCE(zbx_id,'') FROM machines WHERE id IN (` + strings.Join(placeholders, ",") + `)`
-	rows, err := r.db.Query(q, args...)
+	q := `SELECT id, ipmi_ip, ssh_ip, ssh_user, COALESCE(ssh_key,''), COALESCE(remark,''), COALESCE(created_at,''), COALESCE(zbx_id,''), COALESCE(attrs,''), version FROM machines WHERE deleted_at IS NULL AND id IN (` + strings.Join(placeholders, ",") + `)`
+	rows, err := r.db.Query(r.d.rebind(q), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -123,15 +105,12 @@ func (r *MachineRepo) GetByIDs(ids []int64) ([]domain.Machine, error) {
 	var list []domain.Machine
 	for rows.Next() {
 		var m domain.Machine
-		var createdAtStr string
-		if err := rows.Scan(&m.ID, &m.IPMIIP, &m.SSHIP, &m.SSHUser, &m.SSHKey, &m.Remark, &createdAtStr, &m.ZBXID); err != nil {
+		var createdAtStr, attrsStr string
+		if err := rows.Scan(&m.ID, &m.IPMIIP, &m.SSHIP, &m.SSHUser, &m.SSHKey, &m.Remark, &createdAtStr, &m.ZBXID, &attrsStr, &m.Version); err != nil {
 			return nil, err
 		}
-		if createdAtStr != "" {
-			if ts, e := time.Parse(time.RFC3339Nano, createdAtStr); e == nil {
-				m.CreatedAt = ts
-			}
-		}
+		m.Attrs = decodeAttrs(attrsStr)
+		m.CreatedAt = parseMachineTime(createdAtStr)
 		if m.SSHKey != "" {
 			if p, err := secret.DecryptString(m.SSHKey); err == nil && p != "" {
 				m.SSHKey = p
@@ -139,40 +118,31 @@ func (r *MachineRepo) GetByIDs(ids []int64) ([]domain.Machine, error) {
 		}
 		list = append(list, m)
 	}
-	return list, nil
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	return list, r.fillLabelsAndGroups(list)
 }
 
+// Save 插入或更新 (以 ipmi_ip 为唯一键) 并记录修订；保存回收站中的同名机器即恢复它。
+// m.Version 非 0 时只在库中版本一致时更新，否则返回 *ConflictError；成功后 m.Version 为新版本
 func (r *MachineRepo) Save(m *domain.Machine) error {
-	// 插入或更新 (通过唯一 ipmi_ip 约束实现 upsert 需要先保证唯一索引)
-	// 这里使用 INSERT OR REPLACE 可能导致 id 重新分配 (sqlite 行替换)。
-	// 更安全方式: 先尝试查询 id, 决定 INSERT 或 UPDATE。
-	ex, err := r.GetByIPMI(m.IPMIIP)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+	defer observeQuery("machine.save", time.Now())
+	tx, err := r.db.Begin()
+	if err != nil {
 		return err
 	}
-	if ex.ID == 0 { // insert
-		// 加密存储
-		encKey, _ := secret.EncryptString(m.SSHKey)
-		res, err := r.db.Exec(`INSERT INTO machines (ipmi_ip, ssh_ip, ssh_user, ssh_key, remark, zbx_id) VALUES (?,?,?,?,?,?)`, m.IPMIIP, m.SSHIP, m.SSHUser, encKey, m.Remark, m.ZBXID)
-		if err != nil {
-			return err
-		}
-		id, _ := res.LastInsertId()
-		m.ID = int(id)
-	} else { // update
-		encKey, _ := secret.EncryptString(m.SSHKey)
-		_, err := r.db.Exec(`UPDATE machines SET ssh_ip=?, ssh_user=?, ssh_key=?, remark=?, zbx_id=? WHERE ipmi_ip=?`, m.SSHIP, m.SSHUser, encKey, m.Remark, m.ZBXID, m.IPMIIP)
-		if err != nil {
-			return err
-		}
-		m.ID = ex.ID
+	defer tx.Rollback()
+	if err := r.upsert(tx, m); err != nil {
+		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
 // ListAll 返回全部机器（用于导出）。
 func (r *MachineRepo) ListAll() ([]domain.Machine, error) {
-	rows, err := r.db.Query(`SELECT id, ipmi_ip, ssh_ip, ssh_user, COALESCE(ssh_key,''), COALESCE(remark,''), COALESCE(created_at,''), COALESCE(zbx_id,'') FROM machines ORDER BY id ASC`)
+	defer observeQuery("machine.list_all", time.Now())
+	rows, err := r.db.Query(`SELECT id, ipmi_ip, ssh_ip, ssh_user, COALESCE(ssh_key,''), COALESCE(remark,''), COALESCE(created_at,''), COALESCE(zbx_id,''), COALESCE(attrs,''), version FROM machines WHERE deleted_at IS NULL ORDER BY id ASC`)
 	if err != nil {
 		return nil, err
 	}
@@ -180,15 +150,12 @@ func (r *MachineRepo) ListAll() ([]domain.Machine, error) {
 	var list []domain.Machine
 	for rows.Next() {
 		var m domain.Machine
-		var createdAtStr string
-		if err := rows.Scan(&m.ID, &m.IPMIIP, &m.SSHIP, &m.SSHUser, &m.SSHKey, &m.Remark, &createdAtStr, &m.ZBXID); err != nil {
+		var createdAtStr, attrsStr string
+		if err := rows.Scan(&m.ID, &m.IPMIIP, &m.SSHIP, &m.SSHUser, &m.SSHKey, &m.Remark, &createdAtStr, &m.ZBXID, &attrsStr, &m.Version); err != nil {
 			return nil, err
 		}
-		if createdAtStr != "" {
-			if ts, e := time.Parse(time.RFC3339Nano, createdAtStr); e == nil {
-				m.CreatedAt = ts
-			}
-		}
+		m.Attrs = decodeAttrs(attrsStr)
+		m.CreatedAt = parseMachineTime(createdAtStr)
 		if m.SSHKey != "" {
 			if p, e := secret.DecryptString(m.SSHKey); e == nil && p != "" {
 				m.SSHKey = p
@@ -196,12 +163,16 @@ func (r *MachineRepo) ListAll() ([]domain.Machine, error) {
 		}
 		list = append(list, m)
 	}
-	return list, nil
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	return list, r.fillLabelsAndGroups(list)
 }
 
 // BulkUpsert 批量插入/更新（以 ipmi_ip 作为唯一键）。
-// 若条目很多，使用事务一次性提交。
+// 若条目很多，使用事务一次性提交。版本冲突的条目跳过，其余照常写入，返回 *BulkConflictError
 func (r *MachineRepo) BulkUpsert(ms []domain.Machine) error {
+	defer observeQuery("machine.bulk_upsert", time.Now())
 	if len(ms) == 0 {
 		return nil
 	}
@@ -209,42 +180,333 @@ func (r *MachineRepo) BulkUpsert(ms []domain.Machine) error {
 	if err != nil {
 		return err
 	}
-	defer func() {
+	defer tx.Rollback()
+	var conflicts []ConflictError
+	for i := range ms {
+		err := r.upsert(tx, &ms[i])
+		var ce *ConflictError
+		if errors.As(err, &ce) {
+			conflicts = append(conflicts, *ce)
+			continue
+		}
 		if err != nil {
-			_ = tx.Rollback()
+			return err
 		}
-	}()
-	for i := range ms {
-		m := &ms[i]
-		// 直接复用 Save 逻辑，但使用 tx
-		var exID int
-		row := tx.QueryRow(`SELECT id FROM machines WHERE ipmi_ip = ? LIMIT 1`, m.IPMIIP)
-		_ = row.Scan(&exID)
-		encKey, _ := secret.EncryptString(m.SSHKey)
-		if exID == 0 { // insert
-			res, e := tx.Exec(`INSERT INTO machines (ipmi_ip, ssh_ip, ssh_user, ssh_key, remark, zbx_id) VALUES (?,?,?,?,?,?)`, m.IPMIIP, m.SSHIP, m.SSHUser, encKey, m.Remark, m.ZBXID)
-			if e != nil {
-				err = e
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return &BulkConflictError{Applied: len(ms) - len(conflicts), Conflicts: conflicts}
+	}
+	return nil
+}
+
+// upsert Save / BulkUpsert 的单条写入：先查询 id 再决定 INSERT 或 UPDATE (避免行替换导致 id 重新分配)
+func (r *MachineRepo) upsert(tx *sql.Tx, m *domain.Machine) error {
+	var (
+		exID, exVersion int
+		deleted         sql.NullString
+	)
+	err := tx.QueryRow(r.d.rebind(`SELECT id, deleted_at, version FROM machines WHERE ipmi_ip = ? LIMIT 1`), m.IPMIIP).Scan(&exID, &deleted, &exVersion)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if m.Version != 0 && m.Version != exVersion { // 不存在 (已彻底删除) 时 exVersion 为 0
+		return &ConflictError{IPMIIP: m.IPMIIP, Expected: m.Version, Current: exVersion}
+	}
+	// 加密存储
+	encKey, _ := secret.EncryptString(m.SSHKey)
+	action := domain.RevisionUpdate
+	if exID == 0 { // insert
+		id, err := insertID(tx, r.d, insertMachineSQL, m.IPMIIP, m.SSHIP, m.SSHUser, encKey, m.Remark, m.ZBXID, encodeAttrs(m.Attrs), machineCreatedAt())
+		if err != nil {
+			return err
+		}
+		m.ID, m.Version, action = int(id), 1, domain.RevisionCreate
+	} else { // update：版本条件防止与并发的修改交错
+		res, err := tx.Exec(r.d.rebind(updateMachineSQL), m.SSHIP, m.SSHUser, encKey, m.Remark, m.ZBXID, updateAttrs(m.Attrs), exID, exVersion)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			ce := &ConflictError{IPMIIP: m.IPMIIP, Expected: exVersion}
+			_ = tx.QueryRow(r.d.rebind(`SELECT version FROM machines WHERE id = ?`), exID).Scan(&ce.Current)
+			return ce
+		}
+		m.ID, m.Version = exID, exVersion+1
+		if deleted.Valid {
+			action = domain.RevisionRestore
+		}
+	}
+	if err := saveLabelsAndGroups(tx, r.d, m); err != nil {
+		return err
+	}
+	return r.recordRevision(tx, m.ID, action)
+}
+
+// DeleteByIPMI 根据 ipmi_ip 把机器移入回收站 (软删除，保留标签与分组以便恢复)；不存在时不报错
+func (r *MachineRepo) DeleteByIPMI(ip string) error {
+	defer observeQuery("machine.delete", time.Now())
+	if strings.TrimSpace(ip) == "" {
+		return errors.New("empty ip")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var id int
+	if err := tx.QueryRow(r.d.rebind(`SELECT id FROM machines WHERE ipmi_ip=? AND deleted_at IS NULL`), ip).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if _, err := tx.Exec(r.d.rebind(`UPDATE machines SET deleted_at=?, version=version+1 WHERE id=?`), revisionTime(), id); err != nil {
+		return err
+	}
+	if err := r.recordRevision(tx, id, domain.RevisionDelete); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SelectMachines 返回满足标签选择器的机器 (空选择器返回全部)。
+func (r *MachineRepo) SelectMachines(selector string) ([]domain.Machine, error) {
+	defer observeQuery("machine.select", time.Now())
+	sel, err := domain.ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	all, err := r.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	if sel.Empty() {
+		return all, nil
+	}
+	out := make([]domain.Machine, 0, len(all))
+	for _, m := range all {
+		if sel.Matches(m) {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// ListGroups 返回全部分组及成员数
+func (r *MachineRepo) ListGroups() ([]domain.Group, error) {
+	defer observeQuery("machine.list_groups", time.Now())
+	rows, err := r.db.Query(`SELECT g.id, g.name, COUNT(m.id) FROM machine_groups g LEFT JOIN machine_group_members gm ON gm.group_id = g.id LEFT JOIN machines m ON m.id = gm.machine_id AND m.deleted_at IS NULL GROUP BY g.id, g.name ORDER BY g.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []domain.Group
+	for rows.Next() {
+		var g domain.Group
+		if err := rows.Scan(&g.ID, &g.Name, &g.MemberCount); err != nil {
+			return nil, err
+		}
+		list = append(list, g)
+	}
+	return list, rows.Err()
+}
+
+// DeleteGroup 删除分组及其成员关系 (不删除机器)，并为原成员记录修订
+func (r *MachineRepo) DeleteGroup(name string) error {
+	defer observeQuery("machine.delete_group", time.Now())
+	if strings.TrimSpace(name) == "" {
+		return errors.New("empty group name")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(r.d.rebind(`SELECT gm.machine_id FROM machine_group_members gm JOIN machine_groups g ON g.id = gm.group_id WHERE g.name=?`), name)
+	if err != nil {
+		return err
+	}
+	var members []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		members = append(members, id)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(r.d.rebind(`DELETE FROM machine_group_members WHERE group_id IN (SELECT id FROM machine_groups WHERE name=?)`), name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(r.d.rebind(`DELETE FROM machine_groups WHERE name=?`), name); err != nil {
+		return err
+	}
+	for _, id := range members {
+		if _, err := tx.Exec(r.d.rebind(`UPDATE machines SET version=version+1 WHERE id=?`), id); err != nil {
+			return err
+		}
+		if err := r.recordRevision(tx, id, domain.RevisionUpdate); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const (
+	insertMachineSQL = `INSERT INTO machines (ipmi_ip, ssh_ip, ssh_user, ssh_key, remark, zbx_id, attrs, created_at, version) VALUES (?,?,?,?,?,?,?,?,1)`
+	updateMachineSQL = `UPDATE machines SET ssh_ip=?, ssh_user=?, ssh_key=?, remark=?, zbx_id=?, attrs=COALESCE(?, attrs), deleted_at=NULL, version=version+1 WHERE id=? AND version=?`
+)
+
+// machineCreatedAt 新机器的 created_at：与 SQLite CURRENT_TIMESTAMP 相同的 UTC 文本，各方言一致
+func machineCreatedAt() string { return time.Now().UTC().Format(time.DateTime) }
+
+// parseMachineTime 解析 created_at/deleted_at：兼容 RFC3339Nano（驱动转换或 revisionTime）与 time.DateTime（machineCreatedAt/CURRENT_TIMESTAMP），无法解析返回零值
+func parseMachineTime(s string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, time.DateTime} {
+		if ts, err := time.Parse(layout, s); err == nil {
+			return ts
+		}
+	}
+	return time.Time{}
+}
+
+// execer 同时兼容 *sql.DB 与 *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// saveLabelsAndGroups 覆盖写入标签与分组；字段为 nil 表示调用方未提供，保持原值不变。
+func saveLabelsAndGroups(ex execer, d Dialect, m *domain.Machine) error {
+	if m.Labels != nil {
+		if _, err := ex.Exec(d.rebind(`DELETE FROM machine_labels WHERE machine_id=?`), m.ID); err != nil {
+			return err
+		}
+		for k, v := range m.Labels {
+			if _, err := ex.Exec(d.rebind(`INSERT INTO machine_labels(machine_id, "key", value) VALUES (?,?,?)`), m.ID, k, v); err != nil {
+				return err
+			}
+		}
+	}
+	if m.Groups != nil {
+		if _, err := ex.Exec(d.rebind(`DELETE FROM machine_group_members WHERE machine_id=?`), m.ID); err != nil {
+			return err
+		}
+		for _, g := range m.Groups {
+			g = strings.TrimSpace(g)
+			if g == "" {
+				continue
+			}
+			if _, err := ex.Exec(d.rebind(d.insertIgnore(`INSERT INTO machine_groups(name) VALUES (?)`)), g); err != nil {
 				return err
 			}
-			id, _ := res.LastInsertId()
-			m.ID = int(id)
-		} else { // update
-			if _, e := tx.Exec(`UPDATE machines SET ssh_ip=?, ssh_user=?, ssh_key=?, remark=?, zbx_id=? WHERE ipmi_ip=?`, m.SSHIP, m.SSHUser, encKey, m.Remark, m.ZBXID, m.IPMIIP); e != nil {
-				err = e
+			var gid int64
+			if err := ex.QueryRow(d.rebind(`SELECT id FROM machine_groups WHERE name=?`), g).Scan(&gid); err != nil {
+				return err
+			}
+			if _, err := ex.Exec(d.rebind(d.insertIgnore(`INSERT INTO machine_group_members(group_id, machine_id) VALUES (?,?)`)), gid, m.ID); err != nil {
 				return err
 			}
-			m.ID = exID
 		}
 	}
-	return tx.Commit()
+	return nil
 }
 
-// DeleteByIPMI 根据 ipmi_ip 删除机器
-func (r *MachineRepo) DeleteByIPMI(ip string) error {
-	if strings.TrimSpace(ip) == "" {
-		return errors.New("empty ip")
+// fillLabelsAndGroups 为列表批量加载标签与分组 (需在主查询 rows 关闭后调用)。
+func (r *MachineRepo) fillLabelsAndGroups(list []domain.Machine) error {
+	return loadLabelsAndGroups(r.db, r.d, list)
+}
+
+// loadLabelsAndGroups 见 fillLabelsAndGroups；ex 可为事务
+func loadLabelsAndGroups(ex execer, d Dialect, list []domain.Machine) error {
+	if len(list) == 0 {
+		return nil
 	}
-	_, err := r.db.Exec(`DELETE FROM machines WHERE ipmi_ip=?`, ip)
-	return err
+	idx := make(map[int]int, len(list))
+	placeholders := make([]string, len(list))
+	args := make([]any, len(list))
+	for i, m := range list {
+		idx[m.ID] = i
+		placeholders[i] = "?"
+		args[i] = m.ID
+	}
+	in := strings.Join(placeholders, ",")
+	rows, err := ex.Query(d.rebind(`SELECT machine_id, "key", value FROM machine_labels WHERE machine_id IN (`+in+`)`), args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int
+		var k, v string
+		if err := rows.Scan(&id, &k, &v); err != nil {
+			rows.Close()
+			return err
+		}
+		m := &list[idx[id]]
+		if m.Labels == nil {
+			m.Labels = map[string]string{}
+		}
+		m.Labels[k] = v
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	rows, err = ex.Query(d.rebind(`SELECT gm.machine_id, g.name FROM machine_group_members gm JOIN machine_groups g ON g.id = gm.group_id WHERE gm.machine_id IN (`+in+`) ORDER BY g.name`), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		m := &list[idx[id]]
+		m.Groups = append(m.Groups, name)
+	}
+	return rows.Err()
+}
+
+// encodeAttrs 将自定义属性序列化为 JSON 存入 attrs 列；空 map 存空串。
+func encodeAttrs(attrs map[string]string) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// updateAttrs 更新时的 attrs 参数：nil 表示保留原值 (与标签、分组一致)，空 map 表示清空
+func updateAttrs(attrs map[string]string) any {
+	if attrs == nil {
+		return nil
+	}
+	return encodeAttrs(attrs)
+}
+
+// decodeAttrs 解析 attrs 列；格式损坏时返回 nil 以保持读取可用。
+func decodeAttrs(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	var attrs map[string]string
+	if err := json.Unmarshal([]byte(s), &attrs); err != nil {
+		return nil
+	}
+	return attrs
 }
@@ -3,6 +3,7 @@ package repository
 import (
 	"database/sql"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
@@ -15,8 +16,9 @@ func openMemMachines(t *testing.T) *sql.DB {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = db.Exec(`CREATE TABLE machines( id INTEGER PRIMARY KEY AUTOINCREMENT, ipmi_ip TEXT UNIQUE, ssh_ip TEXT, ssh_user TEXT, ssh_key TEXT, remark TEXT, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, zbx_id TEXT );`)
-	if err != nil {
+	// :memory: 每个连接独立，限制单连接保证各查询看到同一库
+	db.SetMaxOpenConns(1)
+	if err := NewMachineRepo(db).EnsureSchema(); err != nil {
 		t.Fatal(err)
 	}
 	return db
@@ -57,3 +59,88 @@ func TestMachineRepo_Save_EncryptsKey(t *testing.T) {
 		t.Fatalf("expected decrypted key got %q", got.SSHKey)
 	}
 }
+
+func TestMachineRepo_LabelsGroupsSelector(t *testing.T) {
+	db := openMemMachines(t)
+	defer db.Close()
+	repo := NewMachineRepo(db)
+	ms := []domain.Machine{
+		{IPMIIP: "10.1.0.1", Labels: map[string]string{"rack": "A12", "role": "web"}, Groups: []string{"prod"}},
+		{IPMIIP: "10.1.0.2", Labels: map[string]string{"rack": "A12", "role": "db"}, Groups: []string{"prod", "dba"}},
+		{IPMIIP: "10.1.0.3", Labels: map[string]string{"rack": "B01"}},
+	}
+	if err := repo.BulkUpsert(ms); err != nil {
+		t.Fatalf("bulk upsert: %v", err)
+	}
+	cases := map[string][]string{
+		"rack=A12,role!=db": {"10.1.0.1"},
+		"group=prod":        {"10.1.0.1", "10.1.0.2"},
+		"!role":             {"10.1.0.3"},
+		"":                  {"10.1.0.1", "10.1.0.2", "10.1.0.3"},
+	}
+	for sel, want := range cases {
+		got, err := repo.SelectMachines(sel)
+		if err != nil {
+			t.Fatalf("select %q: %v", sel, err)
+		}
+		var ips []string
+		for _, m := range got {
+			ips = append(ips, m.IPMIIP)
+		}
+		if strings.Join(ips, ",") != strings.Join(want, ",") {
+			t.Fatalf("select %q: want %v got %v", sel, want, ips)
+		}
+	}
+	if _, err := repo.SelectMachines("role!="); err != nil {
+		t.Fatalf("empty value should be allowed: %v", err)
+	}
+	if _, err := repo.SelectMachines("=x"); err == nil {
+		t.Fatalf("expected parse error for empty key")
+	}
+	// nil Attrs/Labels/Groups 不覆盖已有值，空 Attrs 清空
+	m := domain.Machine{IPMIIP: "10.1.0.2", SSHUser: "admin", Attrs: map[string]string{"hostname": "db-1"}}
+	if err := repo.Save(&m); err != nil {
+		t.Fatal(err)
+	}
+	m = domain.Machine{IPMIIP: "10.1.0.2", SSHUser: "admin"}
+	if err := repo.Save(&m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetByIPMI("10.1.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Labels["role"] != "db" || len(got.Groups) != 2 || got.Attrs["hostname"] != "db-1" {
+		t.Fatalf("attrs/labels/groups should be kept, got %+v", got)
+	}
+	m.Attrs = map[string]string{}
+	if err := repo.Save(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repo.GetByIPMI("10.1.0.2"); got.Attrs != nil {
+		t.Fatalf("empty attrs should clear, got %+v", got.Attrs)
+	}
+	groups, err := repo.ListGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Name != "dba" || groups[1].MemberCount != 2 {
+		t.Fatalf("unexpected groups %+v", groups)
+	}
+	if err := repo.DeleteByIPMI("10.1.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	// 软删除保留标签以便恢复，彻底删除时移除
+	var n int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM machine_labels`).Scan(&n)
+	if n != 5 {
+		t.Fatalf("labels of a trashed machine should be kept, left %d", n)
+	}
+	if err := repo.Purge("10.1.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.QueryRow(`SELECT COUNT(*) FROM machine_labels`).Scan(&n)
+	if n != 3 {
+		t.Fatalf("labels of a purged machine should be removed, left %d", n)
+	}
+}
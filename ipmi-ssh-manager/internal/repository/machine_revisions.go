@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/pkg/secret"
+)
+
+// ForActor 返回以 name 身份写入修订记录的仓库 (共享同一连接)
+func (r *MachineRepo) ForActor(name string) MachineRepoIface { return r.WithActor(name) }
+
+// WithActor 同 ForActor，返回具体类型
+func (r *MachineRepo) WithActor(name string) *MachineRepo {
+	c := *r
+	c.actor = name
+	return &c
+}
+
+// revisionTime 修订与软删除的时间：UTC RFC3339 文本，各方言一致且按字符串可排序
+func revisionTime() string { return time.Now().UTC().Format(time.RFC3339Nano) }
+
+// recordRevision 在 tx 中读取机器当前状态并追加一条修订；内容与上一条相同的 update 不记录
+func (r *MachineRepo) recordRevision(tx *sql.Tx, id int, action string) error {
+	ms, err := scanMachines(tx, r.d, `SELECT `+machineColumns+` FROM machines WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if len(ms) == 0 {
+		return fmt.Errorf("record revision: machine %d: %w", id, sql.ErrNoRows)
+	}
+	snap, err := json.Marshal(domain.SnapshotOf(ms[0]))
+	if err != nil {
+		return err
+	}
+	var (
+		last     int
+		lastSnap string
+	)
+	err = tx.QueryRow(r.d.rebind(`SELECT revision, snapshot FROM machine_revisions WHERE machine_id = ? ORDER BY revision DESC LIMIT 1`), id).Scan(&last, &lastSnap)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if action == domain.RevisionUpdate && lastSnap == string(snap) {
+		return nil
+	}
+	_, err = tx.Exec(r.d.rebind(`INSERT INTO machine_revisions(machine_id, ipmi_ip, revision, action, actor, snapshot, created_at) VALUES (?,?,?,?,?,?,?)`),
+		id, ms[0].IPMIIP, last+1, action, r.actor, string(snap), revisionTime())
+	return err
+}
+
+// ListRevisions 机器的修订记录 (新的在前)；按 IPMI IP 查询，彻底删除后重新登记的机器会包含此前的记录
+func (r *MachineRepo) ListRevisions(ipmi string) ([]domain.MachineRevision, error) {
+	defer observeQuery("machine.list_revisions", time.Now())
+	rows, err := r.db.Query(r.d.rebind(`SELECT `+revisionColumns+` FROM machine_revisions WHERE ipmi_ip = ? ORDER BY id DESC`), ipmi)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []domain.MachineRevision
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rev)
+	}
+	return list, rows.Err()
+}
+
+// GetRevision 按 ID 读取修订 (不存在时返回 sql.ErrNoRows)
+func (r *MachineRepo) GetRevision(id int64) (domain.MachineRevision, error) {
+	defer observeQuery("machine.get_revision", time.Now())
+	return scanRevision(r.db.QueryRow(r.d.rebind(`SELECT `+revisionColumns+` FROM machine_revisions WHERE id = ?`), id))
+}
+
+// ListDeleted 回收站中的机器 (最近删除的在前)
+func (r *MachineRepo) ListDeleted() ([]domain.Machine, error) {
+	defer observeQuery("machine.list_deleted", time.Now())
+	return scanMachines(r.db, r.d, `SELECT `+machineColumns+` FROM machines WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC`)
+}
+
+// Restore 把回收站中的机器恢复为正常状态；机器不在回收站时返回包装的 sql.ErrNoRows
+func (r *MachineRepo) Restore(ipmi string) (domain.Machine, error) {
+	defer observeQuery("machine.restore", time.Now())
+	tx, err := r.db.Begin()
+	if err != nil {
+		return domain.Machine{}, err
+	}
+	defer tx.Rollback()
+	id, err := deletedMachineID(tx, r.d, ipmi)
+	if err != nil {
+		return domain.Machine{}, err
+	}
+	if _, err := tx.Exec(r.d.rebind(`UPDATE machines SET deleted_at=NULL, version=version+1 WHERE id=?`), id); err != nil {
+		return domain.Machine{}, err
+	}
+	if err := r.recordRevision(tx, id, domain.RevisionRestore); err != nil {
+		return domain.Machine{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Machine{}, err
+	}
+	return r.GetByIPMI(ipmi)
+}
+
+// Purge 彻底删除回收站中的机器及其标签与分组关系；修订记录保留。机器不在回收站时返回包装的 sql.ErrNoRows
+func (r *MachineRepo) Purge(ipmi string) error {
+	defer observeQuery("machine.purge", time.Now())
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	id, err := deletedMachineID(tx, r.d, ipmi)
+	if err != nil {
+		return err
+	}
+	for _, q := range []string{
+		`DELETE FROM machine_labels WHERE machine_id=?`,
+		`DELETE FROM machine_group_members WHERE machine_id=?`,
+		`DELETE FROM machines WHERE id=?`,
+	} {
+		if _, err := tx.Exec(r.d.rebind(q), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deletedMachineID 回收站中机器的 id
+func deletedMachineID(ex execer, d Dialect, ipmi string) (int, error) {
+	var id int
+	err := ex.QueryRow(d.rebind(`SELECT id FROM machines WHERE ipmi_ip=? AND deleted_at IS NOT NULL`), ipmi).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("machine %s is not in the trash: %w", ipmi, err)
+	}
+	return id, err
+}
+
+const (
+	// machineColumns 含 deleted_at 的机器列 (与 scanMachines 对应)
+	machineColumns  = `id, ipmi_ip, ssh_ip, ssh_user, COALESCE(ssh_key,''), COALESCE(remark,''), COALESCE(created_at,''), COALESCE(zbx_id,''), COALESCE(attrs,''), COALESCE(deleted_at,''), version`
+	revisionColumns = `id, machine_id, ipmi_ip, revision, action, COALESCE(actor,''), snapshot, created_at`
+)
+
+// scanMachines 执行 machineColumns 查询并解码 / 解密，补齐标签与分组
+func scanMachines(ex execer, d Dialect, q string, args ...any) ([]domain.Machine, error) {
+	rows, err := ex.Query(d.rebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	var list []domain.Machine
+	for rows.Next() {
+		var m domain.Machine
+		var createdAtStr, attrsStr, deletedAtStr string
+		if err := rows.Scan(&m.ID, &m.IPMIIP, &m.SSHIP, &m.SSHUser, &m.SSHKey, &m.Remark, &createdAtStr, &m.ZBXID, &attrsStr, &deletedAtStr, &m.Version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.Attrs = decodeAttrs(attrsStr)
+		m.CreatedAt = parseMachineTime(createdAtStr)
+		m.DeletedAt = parseMachineTime(deletedAtStr)
+		if m.SSHKey != "" {
+			if p, e := secret.DecryptString(m.SSHKey); e == nil && p != "" {
+				m.SSHKey = p
+			}
+		}
+		list = append(list, m)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	return list, loadLabelsAndGroups(ex, d, list)
+}
+
+// scanRevision 解析 revisionColumns 的一行
+func scanRevision(sc interface{ Scan(...any) error }) (domain.MachineRevision, error) {
+	var (
+		rev             domain.MachineRevision
+		snap, createdAt string
+	)
+	if err := sc.Scan(&rev.ID, &rev.MachineID, &rev.IPMIIP, &rev.Revision, &rev.Action, &rev.Actor, &snap, &createdAt); err != nil {
+		return rev, err
+	}
+	if err := json.Unmarshal([]byte(snap), &rev.Snapshot); err != nil {
+		return rev, fmt.Errorf("revision %d snapshot: %w", rev.ID, err)
+	}
+	rev.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+	return rev, nil
+}
@@ -0,0 +1,325 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// migrationFS 按版本号排序的升级脚本，文件名为 NNNN_name.sql；已发布的脚本不可修改，变更表结构时新增文件。
+// migrations/ 为 SQLite，postgres/ 与 mysql/ 子目录为共享库的方言脚本 (只含机器与历史相关的表，版本号独立)
+//
+//go:embed migrations/*.sql migrations/postgres/*.sql migrations/mysql/*.sql
+var migrationFS embed.FS
+
+// ErrSchemaTooNew 数据库已应用了当前程序不认识的迁移 (由更新的版本创建)
+var ErrSchemaTooNew = errors.New("database schema is newer than this build")
+
+// Migration 一个版本的升级脚本
+type Migration struct {
+	Version  int    `json:"version"`
+	Name     string `json:"name"`
+	Checksum string `json:"checksum"` // 脚本的 sha256 (换行统一为 \n)
+	SQL      string `json:"-"`
+}
+
+// MigrationStatus 迁移的应用状态；Modified 表示已应用的脚本与当前内容不一致，Unknown 表示程序中没有该版本
+type MigrationStatus struct {
+	Migration
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"applied_at,omitzero"`
+	Modified  bool      `json:"modified,omitempty"`
+	Unknown   bool      `json:"unknown,omitempty"`
+}
+
+var (
+	// migrateMu 同一进程内各仓库的 EnsureSchema 串行执行迁移
+	migrateMu sync.Mutex
+	// reAddColumn 新增列语句；列已存在时跳过，兼容未记录版本的旧库
+	reAddColumn = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+["` + "`" + `]?(\w+)["` + "`" + `]?\s+ADD\s+(?:COLUMN\s+)?["` + "`" + `]?(\w+)`)
+)
+
+// Migrations 程序内置的全部 SQLite 迁移 (按版本升序)
+func Migrations() ([]Migration, error) {
+	return DialectMigrations(DialectSQLite)
+}
+
+// DialectMigrations 方言内置的全部迁移 (按版本升序)
+func DialectMigrations(d Dialect) ([]Migration, error) {
+	return loadMigrations(migrationFS, d.migrationDir())
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		base := strings.TrimSuffix(e.Name(), ".sql")
+		num, name, _ := strings.Cut(base, "_")
+		v, err := strconv.Atoi(num)
+		if err != nil || v <= 0 || name == "" {
+			return nil, fmt.Errorf("migration %s: file name must be NNNN_name.sql", e.Name())
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		script := strings.ReplaceAll(string(b), "\r\n", "\n")
+		sum := sha256.Sum256([]byte(script))
+		out = append(out, Migration{Version: v, Name: name, Checksum: hex.EncodeToString(sum[:]), SQL: script})
+	}
+	slices.SortFunc(out, func(a, b Migration) int { return a.Version - b.Version })
+	for i := 1; i < len(out); i++ {
+		if out[i].Version == out[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d (%s, %s)", out[i].Version, out[i-1].Name, out[i].Name)
+		}
+	}
+	return out, nil
+}
+
+// Migrate 按版本顺序应用未执行的 SQLite 迁移，每个版本一个事务 (失败时该版本整体回滚并停止)；返回本次应用的迁移
+func Migrate(db *sql.DB) ([]Migration, error) {
+	return MigrateDialect(db, DialectSQLite)
+}
+
+// MigrateDialect 按方言应用迁移。MySQL 的 DDL 会隐式提交，失败的版本可能部分生效，需人工处理后重试
+func MigrateDialect(db *sql.DB, d Dialect) ([]Migration, error) {
+	migs, err := DialectMigrations(d)
+	if err != nil {
+		return nil, err
+	}
+	return migrate(db, d, migs)
+}
+
+// PendingMigrations 尚未应用的 SQLite 迁移，不修改数据库 (dry-run)
+func PendingMigrations(db *sql.DB) ([]Migration, error) {
+	return PendingMigrationsDialect(db, DialectSQLite)
+}
+
+// PendingMigrationsDialect 方言尚未应用的迁移
+func PendingMigrationsDialect(db *sql.DB, d Dialect) ([]Migration, error) {
+	st, err := MigrationStateDialect(db, d)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	for _, s := range st {
+		if !s.Applied {
+			out = append(out, s.Migration)
+		}
+	}
+	return out, nil
+}
+
+// MigrationState 内置 SQLite 迁移与数据库中已应用版本的对照 (按版本升序)
+func MigrationState(db *sql.DB) ([]MigrationStatus, error) {
+	return MigrationStateDialect(db, DialectSQLite)
+}
+
+// MigrationStateDialect 方言内置迁移与数据库中已应用版本的对照
+func MigrationStateDialect(db *sql.DB, d Dialect) ([]MigrationStatus, error) {
+	migs, err := DialectMigrations(d)
+	if err != nil {
+		return nil, err
+	}
+	return migrationState(db, d, migs)
+}
+
+func migrationState(db *sql.DB, d Dialect, migs []Migration) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db, d)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MigrationStatus, 0, len(migs))
+	for _, m := range migs {
+		s := MigrationStatus{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			s.Applied, s.AppliedAt, s.Modified = true, a.AppliedAt, a.Checksum != m.Checksum
+			delete(applied, m.Version)
+		}
+		out = append(out, s)
+	}
+	for _, a := range applied {
+		out = append(out, a)
+	}
+	slices.SortFunc(out, func(a, b MigrationStatus) int { return a.Version - b.Version })
+	return out, nil
+}
+
+// appliedMigrations 读取 schema_migrations；表不存在时视为全部未应用
+func appliedMigrations(db *sql.DB, d Dialect) (map[int]MigrationStatus, error) {
+	var n int
+	if err := db.QueryRow(d.tableExistsSQL(), "schema_migrations").Scan(&n); err != nil {
+		return nil, err
+	}
+	out := map[int]MigrationStatus{}
+	if n == 0 {
+		return out, nil
+	}
+	rows, err := db.Query(`SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s MigrationStatus
+		var at string
+		if err := rows.Scan(&s.Version, &s.Name, &s.Checksum, &at); err != nil {
+			return nil, err
+		}
+		s.Applied, s.Unknown = true, true // 由调用方对照内置迁移后清除
+		s.AppliedAt, _ = time.Parse(time.RFC3339Nano, at)
+		out[s.Version] = s
+	}
+	return out, rows.Err()
+}
+
+func migrate(db *sql.DB, d Dialect, migs []Migration) ([]Migration, error) {
+	defer observeQuery("schema.migrate", time.Now())
+	migrateMu.Lock()
+	defer migrateMu.Unlock()
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations(
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	st, err := migrationState(db, d, migs)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range st {
+		if s.Unknown {
+			return nil, fmt.Errorf("%w: version %d (%s) is not known", ErrSchemaTooNew, s.Version, s.Name)
+		}
+		if s.Modified {
+			logger().Warn("applied schema migration differs from the embedded script", "dialect", d, "version", s.Version, "name", s.Name)
+		}
+	}
+	var done []Migration
+	for _, s := range st {
+		if s.Applied {
+			continue
+		}
+		ok, err := applyMigration(db, d, s.Migration)
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", s.Version, s.Name, err)
+		}
+		if ok {
+			logger().Info("schema migration applied", "dialect", d, "version", s.Version, "name", s.Name)
+			done = append(done, s.Migration)
+		}
+	}
+	return done, nil
+}
+
+// applyMigration 在事务中执行脚本并记录版本；其它进程已应用时返回 false
+func applyMigration(db *sql.DB, d Dialect, m Migration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	lock, unlock := d.migrationLock()
+	if lock != "" {
+		if _, err := tx.Exec(lock); err != nil {
+			return false, fmt.Errorf("lock migrations: %w", err)
+		}
+	}
+	if unlock != "" {
+		defer tx.Exec(unlock) // 提交前已解锁时 (事务已结束) 忽略错误
+	}
+	var n int
+	if err := tx.QueryRow(d.rebind(`SELECT count(*) FROM schema_migrations WHERE version = ?`), m.Version).Scan(&n); err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+	for _, stmt := range splitSQL(m.SQL) {
+		if g := reAddColumn.FindStringSubmatch(stmt); g != nil && d == DialectSQLite {
+			var exists int
+			if err := tx.QueryRow(`SELECT count(*) FROM pragma_table_info(?) WHERE name = ?`, g[1], g[2]).Scan(&exists); err != nil {
+				return false, err
+			}
+			if exists > 0 {
+				continue
+			}
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(d.rebind(`INSERT INTO schema_migrations(version, name, checksum, applied_at) VALUES (?,?,?,?)`),
+		m.Version, m.Name, m.Checksum, time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+		return false, err
+	}
+	if unlock != "" {
+		if _, err := tx.Exec(unlock); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// splitSQL 按分号拆分脚本，跳过 -- 注释与引号内的分号；CREATE TRIGGER 到 END 为止视为一条语句
+func splitSQL(script string) []string {
+	var (
+		out   []string
+		cur   strings.Builder
+		quote byte
+	)
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			out = append(out, s)
+		}
+		cur.Reset()
+	}
+	inTrigger := func() bool {
+		s := strings.ToUpper(strings.Join(strings.Fields(cur.String()), " "))
+		return (strings.HasPrefix(s, "CREATE TRIGGER") || strings.HasPrefix(s, "CREATE TEMP TRIGGER")) && !strings.HasSuffix(s, "END")
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && i+1 < len(script) && script[i+1] == '-':
+			j := strings.IndexByte(script[i:], '\n')
+			if j < 0 {
+				i = len(script)
+			} else {
+				i += j - 1 // 保留换行
+			}
+			continue
+		case c == ';' && !inTrigger():
+			flush()
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	flush()
+	return out
+}
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	_ "modernc.org/sqlite"
+)
+
+func openMemRaw(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableColumns(t *testing.T, db *sql.DB, table string) map[string]bool {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	cols := map[string]bool{}
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			t.Fatal(err)
+		}
+		cols[c] = true
+	}
+	return cols
+}
+
+func TestMigrate_FreshAndIdempotent(t *testing.T) {
+	db := openMemRaw(t)
+	all, err := Migrations()
+	if err != nil || len(all) == 0 {
+		t.Fatalf("embedded migrations: %d %v", len(all), err)
+	}
+	for i, m := range all {
+		if m.Version != i+1 || m.Checksum == "" {
+			t.Fatalf("migration %d: %+v", i, m)
+		}
+	}
+	pending, err := PendingMigrations(db)
+	if err != nil || len(pending) != len(all) {
+		t.Fatalf("pending on empty db: %d %v", len(pending), err)
+	}
+	done, err := Migrate(db)
+	if err != nil || len(done) != len(all) {
+		t.Fatalf("first migrate: %d %v", len(done), err)
+	}
+	if done, err := Migrate(db); err != nil || len(done) != 0 {
+		t.Fatalf("second migrate applied %d: %v", len(done), err)
+	}
+	st, err := MigrationState(db)
+	if err != nil || len(st) != len(all) {
+		t.Fatalf("state: %d %v", len(st), err)
+	}
+	for _, s := range st {
+		if !s.Applied || s.Modified || s.Unknown || s.AppliedAt.IsZero() {
+			t.Fatalf("status %+v", s)
+		}
+	}
+}
+
+// 最早的表结构 (只有机器与历史的基础列) 升级到最新
+func TestMigrate_UpgradeFromOldestSchema(t *testing.T) {
+	db := openMemRaw(t)
+	for _, stmt := range []string{
+		`CREATE TABLE machines(id INTEGER PRIMARY KEY AUTOINCREMENT, ipmi_ip TEXT UNIQUE NOT NULL, ssh_ip TEXT, ssh_user TEXT)`,
+		`CREATE TABLE exec_history(id INTEGER PRIMARY KEY AUTOINCREMENT, machine_id INTEGER, ipmi_ip TEXT, command TEXT, stdout TEXT, stderr TEXT, exit_code INTEGER, error_text TEXT, started_at TIMESTAMP, finished_at TIMESTAMP, duration_ms INTEGER)`,
+		`INSERT INTO machines(ipmi_ip, ssh_ip, ssh_user) VALUES ('10.0.0.1', '10.0.1.1', 'root')`,
+		`INSERT INTO exec_history(machine_id, ipmi_ip, command, stdout, stderr, exit_code, error_text, started_at, finished_at, duration_ms) VALUES (1, '10.0.0.1', 'uptime', 'up 3 days', '', 0, '', '2026-01-01 10:00:00', '2026-01-01 10:00:01', 1000)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := PendingMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	if tableColumns(t, db, "schema_migrations")["version"] {
+		t.Fatal("dry-run must not modify the database")
+	}
+
+	mRepo, hRepo := NewMachineRepo(db), NewHistoryRepo(db)
+	if err := mRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	for table, want := range map[string][]string{
+		"machines":     {"ssh_key", "remark", "created_at", "zbx_id", "attrs"},
+		"exec_history": {"job_id", "passed", "assert_msg", "user_name", "truncated", "output_bytes", "output_blob"},
+		"users":        {"token_hash"},
+		"audit_log":    {"prev_hash", "hash"},
+	} {
+		cols := tableColumns(t, db, table)
+		for _, c := range want {
+			if !cols[c] {
+				t.Errorf("%s.%s missing after upgrade", table, c)
+			}
+		}
+	}
+
+	old, err := mRepo.GetByIPMI("10.0.0.1")
+	if err != nil || old.SSHIP != "10.0.1.1" {
+		t.Fatalf("existing machine: %+v %v", old, err)
+	}
+	var created sql.NullString
+	if err := db.QueryRow(`SELECT created_at FROM machines WHERE id = 1`).Scan(&created); err != nil || !created.Valid {
+		t.Fatalf("created_at not backfilled: %v", err)
+	}
+	m := domain.Machine{IPMIIP: "10.0.0.2", SSHUser: "root", Labels: map[string]string{"rack": "A12"}, Groups: []string{"web"}}
+	if err := mRepo.Save(&m); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT created_at FROM machines WHERE id = ?`, m.ID).Scan(&created); err != nil || !created.Valid {
+		t.Fatalf("created_at not set on insert: %v", err)
+	}
+	if err := hRepo.Insert(&domain.ExecHistory{MachineID: int64(m.ID), IPMIIP: "10.0.0.2", Command: "uptime", JobID: "j1", Passed: true}); err != nil {
+		t.Fatal(err)
+	}
+	hs, err := hRepo.ListRecent(10)
+	if err != nil || len(hs) != 2 || hs[1].Stdout != "up 3 days" {
+		t.Fatalf("history after upgrade: %+v %v", hs, err)
+	}
+	if _, err := db.Exec(`INSERT INTO audit_log(ts, actor, action, prev_hash, hash) VALUES ('t', 'a', 'x', '', 'h')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`DELETE FROM audit_log`); err == nil {
+		t.Fatal("audit_log triggers not created")
+	}
+}
+
+// 由旧版 EnsureSchema 建好、但没有版本记录的库：已有列跳过，不报错
+func TestMigrate_AdoptsUnversionedSchema(t *testing.T) {
+	db := openMemRaw(t)
+	for _, stmt := range []string{
+		`CREATE TABLE machines(id INTEGER PRIMARY KEY AUTOINCREMENT, ipmi_ip TEXT UNIQUE NOT NULL, ssh_ip TEXT, ssh_user TEXT, ssh_key TEXT, remark TEXT, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, zbx_id TEXT, attrs TEXT)`,
+		`CREATE TABLE exec_history(id INTEGER PRIMARY KEY AUTOINCREMENT, machine_id INTEGER, ipmi_ip TEXT, command TEXT, stdout TEXT, stderr TEXT, exit_code INTEGER, error_text TEXT, started_at TIMESTAMP, finished_at TIMESTAMP, duration_ms INTEGER, job_id TEXT, passed INTEGER, assert_msg TEXT, user_name TEXT, truncated INTEGER)`,
+		`CREATE INDEX idx_exec_history_job ON exec_history(job_id)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if cols := tableColumns(t, db, "exec_history"); !cols["output_blob"] || !cols["truncated"] {
+		t.Fatalf("exec_history columns %v", cols)
+	}
+}
+
+func TestMigrate_RollbackAndTooNew(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0001_a.sql": {Data: []byte("-- first; with a semicolon in a comment\nCREATE TABLE a(x TEXT DEFAULT 'x;y');\r\nINSERT INTO a(x) VALUES ('it''s; fine');\n")},
+		"m/0002_b.sql": {Data: []byte("CREATE TABLE b(x);\nCREATE TRIGGER b_guard BEFORE DELETE ON b\nBEGIN SELECT RAISE(ABORT, 'no'); SELECT 1; END;\nINSERT INTO missing VALUES (1);\n")},
+	}
+	migs, err := loadMigrations(fsys, "m")
+	if err != nil || len(migs) != 2 {
+		t.Fatalf("load: %v", err)
+	}
+	if got := splitSQL(migs[1].SQL); len(got) != 3 || got[1] != "CREATE TRIGGER b_guard BEFORE DELETE ON b\nBEGIN SELECT RAISE(ABORT, 'no'); SELECT 1; END" {
+		t.Fatalf("split %q", got)
+	}
+	db := openMemRaw(t)
+	done, err := migrate(db, DialectSQLite, migs)
+	if err == nil || len(done) != 1 {
+		t.Fatalf("want failure after first migration, got %d %v", len(done), err)
+	}
+	var x string
+	if err := db.QueryRow(`SELECT x FROM a`).Scan(&x); err != nil || x != "it's; fine" {
+		t.Fatalf("migration 1: %q %v", x, err)
+	}
+	if tableColumns(t, db, "b")["x"] {
+		t.Fatal("failed migration must roll back")
+	}
+	st, err := migrationState(db, DialectSQLite, migs)
+	if err != nil || !st[0].Applied || st[1].Applied {
+		t.Fatalf("state %+v %v", st, err)
+	}
+
+	migs[1].SQL = "CREATE TABLE b(x);"
+	if _, err := migrate(db, DialectSQLite, migs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate(db, DialectSQLite, migs[:1]); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("older build: %v", err)
+	}
+	migs[0].Checksum = "changed"
+	if st, _ := migrationState(db, DialectSQLite, migs); !st[0].Modified {
+		t.Fatal("modified migration not detected")
+	}
+	if _, err := loadMigrations(fstest.MapFS{"m/01_a.sql": {}, "m/1_b.sql": {}}, "m"); err == nil {
+		t.Fatal("duplicate versions must fail")
+	}
+}
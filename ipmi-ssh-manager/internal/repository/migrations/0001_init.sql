@@ -0,0 +1,21 @@
+-- 最早的表结构：机器与执行历史
+CREATE TABLE IF NOT EXISTS machines(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ipmi_ip TEXT UNIQUE NOT NULL,
+	ssh_ip TEXT,
+	ssh_user TEXT
+);
+
+CREATE TABLE IF NOT EXISTS exec_history(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	machine_id INTEGER,
+	ipmi_ip TEXT,
+	command TEXT,
+	stdout TEXT,
+	stderr TEXT,
+	exit_code INTEGER,
+	error_text TEXT,
+	started_at TIMESTAMP,
+	finished_at TIMESTAMP,
+	duration_ms INTEGER
+);
@@ -0,0 +1,8 @@
+-- 机器的 SSH Key、备注、创建时间、Zabbix ID 与自定义属性
+-- (SQLite 不能新增默认值为 CURRENT_TIMESTAMP 的列，created_at 由写入语句填充)
+ALTER TABLE machines ADD COLUMN ssh_key TEXT;
+ALTER TABLE machines ADD COLUMN remark TEXT;
+ALTER TABLE machines ADD COLUMN created_at TIMESTAMP;
+ALTER TABLE machines ADD COLUMN zbx_id TEXT;
+ALTER TABLE machines ADD COLUMN attrs TEXT;
+UPDATE machines SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
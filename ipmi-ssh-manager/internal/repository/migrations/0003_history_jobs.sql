@@ -0,0 +1,6 @@
+-- 历史记录关联任务、断言结果与发起者
+ALTER TABLE exec_history ADD COLUMN job_id TEXT;
+ALTER TABLE exec_history ADD COLUMN passed INTEGER;
+ALTER TABLE exec_history ADD COLUMN assert_msg TEXT;
+ALTER TABLE exec_history ADD COLUMN user_name TEXT;
+CREATE INDEX IF NOT EXISTS idx_exec_history_job ON exec_history(job_id);
@@ -0,0 +1,21 @@
+-- 标签与分组 (分组与机器多对多)
+CREATE TABLE IF NOT EXISTS machine_labels(
+	machine_id INTEGER NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY(machine_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS machine_groups(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS machine_group_members(
+	group_id INTEGER NOT NULL,
+	machine_id INTEGER NOT NULL,
+	PRIMARY KEY(group_id, machine_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_machine_labels_kv ON machine_labels(key, value);
@@ -0,0 +1,10 @@
+-- server 模式的用户与访问令牌 (只保存令牌哈希)
+CREATE TABLE IF NOT EXISTS users(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL,
+	role TEXT NOT NULL,
+	groups_json TEXT,
+	token_hash TEXT UNIQUE,
+	disabled INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
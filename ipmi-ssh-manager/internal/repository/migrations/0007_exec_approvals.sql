@@ -0,0 +1,13 @@
+-- 需审批命令的审批单
+CREATE TABLE IF NOT EXISTS exec_approvals(
+	id TEXT PRIMARY KEY,
+	requester TEXT NOT NULL,
+	command TEXT NOT NULL,
+	machine_ids_json TEXT NOT NULL,
+	reasons_json TEXT,
+	status TEXT NOT NULL,
+	approver TEXT,
+	created_at TIMESTAMP NOT NULL,
+	decided_at TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,11 @@
+-- 输出截断标记与大输出的压缩存储 (列表查询不读取 exec_output)
+ALTER TABLE exec_history ADD COLUMN truncated INTEGER;
+ALTER TABLE exec_history ADD COLUMN output_bytes INTEGER;
+ALTER TABLE exec_history ADD COLUMN output_blob INTEGER;
+
+CREATE TABLE IF NOT EXISTS exec_output(
+	history_id INTEGER PRIMARY KEY,
+	encoding TEXT NOT NULL,
+	stdout BLOB,
+	stderr BLOB
+);
@@ -0,0 +1,16 @@
+-- 机器软删除与修订历史：deleted_at 非空表示在回收站中；machine_revisions 只追加，快照中的 SSH Key 只保存指纹
+ALTER TABLE machines ADD COLUMN deleted_at TEXT;
+
+CREATE TABLE IF NOT EXISTS machine_revisions(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	machine_id INTEGER NOT NULL,
+	ipmi_ip TEXT NOT NULL,
+	revision INTEGER NOT NULL,
+	action TEXT NOT NULL,
+	actor TEXT,
+	snapshot TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	UNIQUE(machine_id, revision)
+);
+
+CREATE INDEX IF NOT EXISTS idx_machine_revisions_ipmi ON machine_revisions(ipmi_ip);
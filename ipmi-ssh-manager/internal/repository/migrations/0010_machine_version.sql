@@ -0,0 +1,2 @@
+-- 乐观锁：每次修改机器 (含软删除、恢复与分组变化) version 加 1，携带 version 的更新只在版本一致时生效
+ALTER TABLE machines ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
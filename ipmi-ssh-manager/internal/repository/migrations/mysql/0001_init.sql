@@ -0,0 +1,65 @@
+-- 共享库 (MySQL 5.7+ / 8.0) 的机器、标签分组与执行历史，对应 SQLite 迁移 0001-0004、0008 的最终结构。
+-- 用户、审计与审批仍保存在本地 SQLite
+-- machines.created_at 与 SQLite 一致保存 UTC 文本 (YYYY-MM-DD HH:MM:SS)，由写入语句填充；
+-- 标签与分组名区分大小写 (utf8mb4_bin)，与 SQLite 的比较方式一致
+CREATE TABLE IF NOT EXISTS machines(
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	ipmi_ip VARCHAR(255) NOT NULL UNIQUE,
+	ssh_ip VARCHAR(255),
+	ssh_user VARCHAR(255),
+	ssh_key TEXT,
+	remark TEXT,
+	created_at VARCHAR(32),
+	zbx_id VARCHAR(255),
+	attrs TEXT
+) DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS machine_labels(
+	machine_id BIGINT NOT NULL,
+	`key` VARCHAR(191) NOT NULL,
+	value VARCHAR(512) NOT NULL DEFAULT '',
+	PRIMARY KEY(machine_id, `key`),
+	INDEX idx_machine_labels_kv(`key`, value(191))
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS machine_groups(
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(191) NOT NULL UNIQUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS machine_group_members(
+	group_id BIGINT NOT NULL,
+	machine_id BIGINT NOT NULL,
+	PRIMARY KEY(group_id, machine_id)
+);
+
+CREATE TABLE IF NOT EXISTS exec_history(
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	machine_id BIGINT,
+	ipmi_ip VARCHAR(255),
+	command TEXT,
+	stdout MEDIUMTEXT,
+	stderr MEDIUMTEXT,
+	exit_code INT,
+	error_text TEXT,
+	started_at DATETIME(6),
+	finished_at DATETIME(6),
+	duration_ms BIGINT,
+	job_id VARCHAR(64),
+	passed BOOLEAN,
+	assert_msg TEXT,
+	user_name VARCHAR(255),
+	truncated BOOLEAN,
+	output_bytes BIGINT,
+	output_blob BOOLEAN,
+	INDEX idx_exec_history_job(job_id),
+	INDEX idx_exec_history_started(started_at)
+) DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS exec_output(
+	history_id BIGINT PRIMARY KEY,
+	encoding VARCHAR(16) NOT NULL,
+	stdout LONGBLOB,
+	stderr LONGBLOB
+);
@@ -0,0 +1,15 @@
+-- 机器软删除与修订历史 (对应 SQLite 迁移 0009)
+ALTER TABLE machines ADD COLUMN deleted_at VARCHAR(40);
+
+CREATE TABLE IF NOT EXISTS machine_revisions(
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	machine_id BIGINT NOT NULL,
+	ipmi_ip VARCHAR(255) NOT NULL,
+	revision INT NOT NULL,
+	action VARCHAR(16) NOT NULL,
+	actor VARCHAR(255),
+	snapshot MEDIUMTEXT NOT NULL,
+	created_at VARCHAR(40) NOT NULL,
+	UNIQUE KEY uq_machine_revisions(machine_id, revision),
+	INDEX idx_machine_revisions_ipmi(ipmi_ip)
+) DEFAULT CHARSET=utf8mb4;
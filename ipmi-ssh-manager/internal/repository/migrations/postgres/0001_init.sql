@@ -0,0 +1,66 @@
+-- 共享库 (PostgreSQL) 的机器、标签分组与执行历史，对应 SQLite 迁移 0001-0004、0008 的最终结构。
+-- 用户、审计与审批仍保存在本地 SQLite
+-- machines.created_at 与 SQLite 一致保存 UTC 文本 (YYYY-MM-DD HH:MM:SS)，由写入语句填充
+CREATE TABLE IF NOT EXISTS machines(
+	id BIGSERIAL PRIMARY KEY,
+	ipmi_ip TEXT UNIQUE NOT NULL,
+	ssh_ip TEXT,
+	ssh_user TEXT,
+	ssh_key TEXT,
+	remark TEXT,
+	created_at TEXT,
+	zbx_id TEXT,
+	attrs TEXT
+);
+
+CREATE TABLE IF NOT EXISTS machine_labels(
+	machine_id BIGINT NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY(machine_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS machine_groups(
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS machine_group_members(
+	group_id BIGINT NOT NULL,
+	machine_id BIGINT NOT NULL,
+	PRIMARY KEY(group_id, machine_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_machine_labels_kv ON machine_labels(key, value);
+
+CREATE TABLE IF NOT EXISTS exec_history(
+	id BIGSERIAL PRIMARY KEY,
+	machine_id BIGINT,
+	ipmi_ip TEXT,
+	command TEXT,
+	stdout TEXT,
+	stderr TEXT,
+	exit_code INTEGER,
+	error_text TEXT,
+	started_at TIMESTAMPTZ,
+	finished_at TIMESTAMPTZ,
+	duration_ms BIGINT,
+	job_id TEXT,
+	passed BOOLEAN,
+	assert_msg TEXT,
+	user_name TEXT,
+	truncated BOOLEAN,
+	output_bytes BIGINT,
+	output_blob BOOLEAN
+);
+
+CREATE INDEX IF NOT EXISTS idx_exec_history_job ON exec_history(job_id);
+CREATE INDEX IF NOT EXISTS idx_exec_history_started ON exec_history(started_at);
+
+CREATE TABLE IF NOT EXISTS exec_output(
+	history_id BIGINT PRIMARY KEY,
+	encoding TEXT NOT NULL,
+	stdout BYTEA,
+	stderr BYTEA
+);
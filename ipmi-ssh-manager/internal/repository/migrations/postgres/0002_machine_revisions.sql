@@ -0,0 +1,16 @@
+-- 机器软删除与修订历史 (对应 SQLite 迁移 0009)
+ALTER TABLE machines ADD COLUMN IF NOT EXISTS deleted_at TEXT;
+
+CREATE TABLE IF NOT EXISTS machine_revisions(
+	id BIGSERIAL PRIMARY KEY,
+	machine_id BIGINT NOT NULL,
+	ipmi_ip TEXT NOT NULL,
+	revision INTEGER NOT NULL,
+	action TEXT NOT NULL,
+	actor TEXT,
+	snapshot TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	UNIQUE(machine_id, revision)
+);
+
+CREATE INDEX IF NOT EXISTS idx_machine_revisions_ipmi ON machine_revisions(ipmi_ip);
@@ -0,0 +1,2 @@
+-- 乐观锁版本号 (对应 SQLite 迁移 0010)
+ALTER TABLE machines ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// queryObserver 查询耗时回调 (op 形如 "machine.list_all")；未设置时零开销
+var queryObserver atomic.Pointer[func(op string, d time.Duration)]
+
+// SetQueryObserver 设置全局查询耗时回调，供 metrics 统计 SQLite 延迟；传 nil 取消
+func SetQueryObserver(f func(op string, d time.Duration)) {
+	if f == nil {
+		queryObserver.Store(nil)
+		return
+	}
+	queryObserver.Store(&f)
+}
+
+// repoLog 仓库层日志 (慢查询、历史清理)；未设置时使用 slog.Default()
+var (
+	repoLog   atomic.Pointer[slog.Logger]
+	slowQuery atomic.Int64 // 纳秒，<=0 不记录慢查询
+)
+
+// SetLogger 设置仓库层日志；耗时达到 slow 的调用记录为 Warn (slow<=0 不记录)
+func SetLogger(l *slog.Logger, slow time.Duration) {
+	repoLog.Store(l)
+	slowQuery.Store(int64(slow))
+}
+
+func logger() *slog.Logger {
+	if l := repoLog.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// observeQuery 用法：defer observeQuery("history.insert", time.Now())
+func observeQuery(op string, start time.Time) {
+	d := time.Since(start)
+	if f := queryObserver.Load(); f != nil {
+		(*f)(op, d)
+	}
+	if slow := slowQuery.Load(); slow > 0 && int64(d) >= slow {
+		logger().Warn("slow query", "op", op, "duration_ms", d.Milliseconds())
+	}
+}
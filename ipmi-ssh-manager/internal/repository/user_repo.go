@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// UserRepo 用户表；只保存访问令牌的哈希，令牌明文仅在创建 / 重置时返回一次
+type UserRepo struct{ db *sql.DB }
+
+func NewUserRepo(db *sql.DB) *UserRepo { return &UserRepo{db: db} }
+
+// EnsureSchema 执行未应用的迁移 (见 Migrate，幂等)
+func (r *UserRepo) EnsureSchema() error {
+	_, err := Migrate(r.db)
+	return err
+}
+
+const userColumns = `id,name,role,COALESCE(groups_json,''),disabled,created_at`
+
+// Create 新增用户，tokenHash 为访问令牌的哈希
+func (r *UserRepo) Create(u *domain.User, tokenHash string) error {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
+	res, err := r.db.Exec(`INSERT INTO users(name,role,groups_json,token_hash,disabled,created_at) VALUES (?,?,?,?,?,?)`,
+		u.Name, string(u.Role), encodeGroups(u.Groups), tokenHash, u.Disabled, u.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	u.ID = id
+	return nil
+}
+
+// Update 按名称更新角色 / 分组范围 / 禁用状态
+func (r *UserRepo) Update(u domain.User) error {
+	res, err := r.db.Exec(`UPDATE users SET role=?, groups_json=?, disabled=? WHERE name=?`, string(u.Role), encodeGroups(u.Groups), u.Disabled, u.Name)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// SetTokenHash 替换访问令牌 (旧令牌立即失效)
+func (r *UserRepo) SetTokenHash(name, tokenHash string) error {
+	res, err := r.db.Exec(`UPDATE users SET token_hash=? WHERE name=?`, tokenHash, name)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// Delete 按名称删除
+func (r *UserRepo) Delete(name string) error {
+	res, err := r.db.Exec(`DELETE FROM users WHERE name=?`, name)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// GetByName 不存在时返回 sql.ErrNoRows
+func (r *UserRepo) GetByName(name string) (domain.User, error) {
+	defer observeQuery("user.get", time.Now())
+	return scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE name=?`, name))
+}
+
+// GetByTokenHash 按令牌哈希查找；不存在时返回 sql.ErrNoRows
+func (r *UserRepo) GetByTokenHash(tokenHash string) (domain.User, error) {
+	defer observeQuery("user.get_by_token", time.Now())
+	return scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE token_hash=?`, tokenHash))
+}
+
+// List 全部用户 (按名称)
+func (r *UserRepo) List() ([]domain.User, error) {
+	rows, err := r.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []domain.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+type rowScanner interface{ Scan(dest ...any) error }
+
+func scanUser(row rowScanner) (domain.User, error) {
+	var (
+		u      domain.User
+		role   string
+		groups string
+	)
+	if err := row.Scan(&u.ID, &u.Name, &role, &groups, &u.Disabled, &u.CreatedAt); err != nil {
+		return domain.User{}, err
+	}
+	u.Role = domain.Role(role)
+	if groups != "" {
+		_ = json.Unmarshal([]byte(groups), &u.Groups)
+	}
+	return u, nil
+}
+
+func encodeGroups(groups []string) string {
+	if len(groups) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(groups)
+	return string(b)
+}
+
+// requireAffected 未命中任何行时返回 sql.ErrNoRows
+func requireAffected(res sql.Result) error {
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// OutputSample 参与分组的一台机器的输出
+type OutputSample struct {
+	MachineID int64
+	IPMIIP    string
+	SSHIP     string
+	Stdout    string
+	Stderr    string
+	ExitCode  int
+	Error     string
+}
+
+// NormalizeOptions 分组前的输出归一化选项 (全部关闭即按原文完全相同分组)
+type NormalizeOptions struct {
+	TrimSpace   bool `json:"trim_space"`   // 去除每行首尾空白及空行
+	IgnoreCase  bool `json:"ignore_case"`  // 忽略大小写
+	MaskNumbers bool `json:"mask_numbers"` // 数字替换为 <n> (时间戳/计数器)
+	MaskIPs     bool `json:"mask_ips"`     // IPv4 地址替换为 <ip>
+	MaskHost    bool `json:"mask_host"`    // 本机 IPMI / SSH IP 替换为 <host>
+}
+
+// OutputGroup 输出相同 (归一化后) 的一组机器
+type OutputGroup struct {
+	ExitCode   int      `json:"exit_code"`
+	Error      string   `json:"error,omitempty"`
+	Stdout     string   `json:"stdout"` // 组内第一台机器的原始输出
+	Stderr     string   `json:"stderr"`
+	Count      int      `json:"count"`
+	MachineIDs []int64  `json:"machine_ids"`
+	IPMIIPs    []string `json:"ipmi_ips"`
+	Majority   bool     `json:"majority"`       // 是否为多数组 (对比基准)
+	Diff       string   `json:"diff,omitempty"` // 相对多数组的行级差异 (多数组为空)
+}
+
+// OutputAnalysis 一批结果的分组汇总，Groups 按机器数降序
+type OutputAnalysis struct {
+	Total  int           `json:"total"`
+	Groups []OutputGroup `json:"groups"`
+}
+
+// SamplesFromResults 从实时结果构建样本
+func SamplesFromResults(rs []domain.ExecResult) []OutputSample {
+	out := make([]OutputSample, 0, len(rs))
+	for _, r := range rs {
+		out = append(out, OutputSample{MachineID: r.MachineID, IPMIIP: r.IPMIIP, SSHIP: r.SSHIP, Stdout: r.Stdout, Stderr: r.Stderr, ExitCode: r.ExitCode, Error: errToString(r.Err)})
+	}
+	return out
+}
+
+// SamplesFromHistory 从历史记录构建样本
+func SamplesFromHistory(hs []domain.ExecHistory) []OutputSample {
+	out := make([]OutputSample, 0, len(hs))
+	for _, h := range hs {
+		out = append(out, OutputSample{MachineID: h.MachineID, IPMIIP: h.IPMIIP, Stdout: h.Stdout, Stderr: h.Stderr, ExitCode: h.ExitCode, Error: h.ErrorText})
+	}
+	return out
+}
+
+var (
+	reNumber = regexp.MustCompile(`\d+`)
+	reIPv4   = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+)
+
+func normalizeOutput(s string, sm OutputSample, opts NormalizeOptions) string {
+	if opts.MaskHost {
+		for _, h := range []string{sm.IPMIIP, stripPort(sm.SSHIP)} {
+			if h != "" {
+				s = strings.ReplaceAll(s, h, "<host>")
+			}
+		}
+	}
+	if opts.MaskIPs {
+		s = reIPv4.ReplaceAllString(s, "<ip>")
+	}
+	if opts.MaskNumbers {
+		s = reNumber.ReplaceAllString(s, "<n>")
+	}
+	if opts.IgnoreCase {
+		s = strings.ToLower(s)
+	}
+	if opts.TrimSpace {
+		var lines []string
+		for _, l := range strings.Split(s, "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				lines = append(lines, l)
+			}
+		}
+		s = strings.Join(lines, "\n")
+	}
+	return s
+}
+
+func stripPort(addr string) string {
+	if i := strings.LastIndex(addr, ":"); i > 0 && !strings.Contains(addr[:i], ":") {
+		return addr[:i]
+	}
+	return addr
+}
+
+// AnalyzeOutputs 按 (退出码, 错误, 归一化 stdout/stderr) 分组，并给出各组相对多数组的差异
+func AnalyzeOutputs(samples []OutputSample, opts NormalizeOptions) OutputAnalysis {
+	idx := map[string]int{}
+	var groups []OutputGroup
+	for _, sm := range samples {
+		key := fmt.Sprintf("%d\x00%s\x00%s\x00%s", sm.ExitCode, sm.Error, normalizeOutput(sm.Stdout, sm, opts), normalizeOutput(sm.Stderr, sm, opts))
+		i, ok := idx[key]
+		if !ok {
+			i = len(groups)
+			idx[key] = i
+			groups = append(groups, OutputGroup{ExitCode: sm.ExitCode, Error: sm.Error, Stdout: sm.Stdout, Stderr: sm.Stderr})
+		}
+		g := &groups[i]
+		g.Count++
+		g.MachineIDs = append(g.MachineIDs, sm.MachineID)
+		g.IPMIIPs = append(g.IPMIIPs, sm.IPMIIP)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Count > groups[j].Count })
+	if len(groups) > 0 {
+		groups[0].Majority = true
+		base := renderGroup(groups[0])
+		for i := 1; i < len(groups); i++ {
+			groups[i].Diff = LineDiff(base, renderGroup(groups[i]))
+		}
+	}
+	return OutputAnalysis{Total: len(samples), Groups: groups}
+}
+
+// renderGroup 将退出码/错误/输出拼成便于逐行比较的文本
+func renderGroup(g OutputGroup) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "exit_code: %d\n", g.ExitCode)
+	if g.Error != "" {
+		fmt.Fprintf(&b, "error: %s\n", g.Error)
+	}
+	b.WriteString("--- stdout ---\n")
+	b.WriteString(strings.TrimRight(g.Stdout, "\n"))
+	b.WriteString("\n--- stderr ---\n")
+	b.WriteString(strings.TrimRight(g.Stderr, "\n"))
+	return b.String()
+}
+
+// diffMaxCells LCS 表规模上限，超过则不逐行比较以控制内存
+const diffMaxCells = 4_000_000
+
+// diffContext 差异块前后保留的上下文行数
+const diffContext = 2
+
+// LineDiff 生成 a -> b 的行级差异 ("-" 仅在 a，"+" 仅在 b，" " 上下文，"@@" 分隔块)
+func LineDiff(a, b string) string {
+	if a == b {
+		return ""
+	}
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(al)*len(bl) > diffMaxCells {
+		return fmt.Sprintf("@@ output too large to diff (%d vs %d lines) @@", len(al), len(bl))
+	}
+	// lcs[i][j] = al[i:] 与 bl[j:] 的最长公共子序列长度
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	type op struct {
+		kind byte
+		text string
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && j < len(bl) && al[i] == bl[j]:
+			ops = append(ops, op{' ', al[i]})
+			i++
+			j++
+		case j < len(bl) && (i == len(al) || lcs[i][j+1] >= lcs[i+1][j]):
+			ops = append(ops, op{'+', bl[j]})
+			j++
+		default:
+			ops = append(ops, op{'-', al[i]})
+			i++
+		}
+	}
+	// 仅输出变更行及其上下文
+	keep := make([]bool, len(ops))
+	for k, o := range ops {
+		if o.kind == ' ' {
+			continue
+		}
+		for c := max(0, k-diffContext); c <= min(len(ops)-1, k+diffContext); c++ {
+			keep[c] = true
+		}
+	}
+	var out strings.Builder
+	prev := -1
+	for k, o := range ops {
+		if !keep[k] {
+			continue
+		}
+		if prev != k-1 {
+			out.WriteString("@@\n")
+		}
+		out.WriteByte(o.kind)
+		out.WriteString(o.text)
+		out.WriteByte('\n')
+		prev = k
+	}
+	return out.String()
+}
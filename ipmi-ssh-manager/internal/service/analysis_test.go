@@ -0,0 +1,51 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeOutputs_GroupsAndDiff(t *testing.T) {
+	samples := []OutputSample{
+		{MachineID: 1, IPMIIP: "10.0.0.1", Stdout: "kernel 5.10\nntp ok\n"},
+		{MachineID: 2, IPMIIP: "10.0.0.2", Stdout: "kernel 5.10\nntp ok\n"},
+		{MachineID: 3, IPMIIP: "10.0.0.3", Stdout: "kernel 5.10\nntp FAIL\n", ExitCode: 1},
+		{MachineID: 4, IPMIIP: "10.0.0.4", Stdout: "  kernel 5.10\nntp ok  \n\n"},
+	}
+	a := AnalyzeOutputs(samples, NormalizeOptions{})
+	if a.Total != 4 || len(a.Groups) != 3 {
+		t.Fatalf("expected 3 exact groups, got %+v", a.Groups)
+	}
+	if !a.Groups[0].Majority || a.Groups[0].Count != 2 || a.Groups[0].Diff != "" {
+		t.Fatalf("unexpected majority group %+v", a.Groups[0])
+	}
+	var failing OutputGroup
+	for _, g := range a.Groups {
+		if g.ExitCode == 1 {
+			failing = g
+		}
+	}
+	for _, want := range []string{"-exit_code: 0", "+exit_code: 1", "-ntp ok", "+ntp FAIL"} {
+		if !strings.Contains(failing.Diff, want) {
+			t.Fatalf("diff missing %q:\n%s", want, failing.Diff)
+		}
+	}
+
+	a = AnalyzeOutputs(samples, NormalizeOptions{TrimSpace: true})
+	if len(a.Groups) != 2 || a.Groups[0].Count != 3 {
+		t.Fatalf("expected whitespace-normalized grouping, got %+v", a.Groups)
+	}
+}
+
+func TestAnalyzeOutputs_MaskHost(t *testing.T) {
+	samples := []OutputSample{
+		{MachineID: 1, IPMIIP: "10.0.0.1", SSHIP: "192.168.0.1:22", Stdout: "listen 192.168.0.1 pid 100"},
+		{MachineID: 2, IPMIIP: "10.0.0.2", SSHIP: "192.168.0.2", Stdout: "listen 192.168.0.2 pid 204"},
+	}
+	if n := len(AnalyzeOutputs(samples, NormalizeOptions{MaskHost: true}).Groups); n != 2 {
+		t.Fatalf("pid differs, expected 2 groups got %d", n)
+	}
+	if n := len(AnalyzeOutputs(samples, NormalizeOptions{MaskHost: true, MaskNumbers: true}).Groups); n != 1 {
+		t.Fatalf("expected 1 group after masking got %d", n)
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// assertion 预编译后的断言，每个任务编译一次供所有机器复用
+type assertion struct {
+	spec     *domain.Assertions
+	match    []*regexp.Regexp
+	notMatch []*regexp.Regexp
+	jsonKeys []string // JSONEquals 的有序键，保证消息顺序稳定
+}
+
+// compileAssertions 校验并预编译断言；nil/空断言返回 nil (使用默认判定)
+func compileAssertions(a *domain.Assertions) (*assertion, error) {
+	if a.Empty() {
+		return nil, nil
+	}
+	c := &assertion{spec: a}
+	for _, p := range a.StdoutMatch {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stdout_match %q: %w", p, err)
+		}
+		c.match = append(c.match, re)
+	}
+	for _, p := range a.StdoutNotMatch {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stdout_not_match %q: %w", p, err)
+		}
+		c.notMatch = append(c.notMatch, re)
+	}
+	for k := range a.JSONEquals {
+		if _, err := parseJSONPath(k); err != nil {
+			return nil, err
+		}
+		c.jsonKeys = append(c.jsonKeys, k)
+	}
+	sort.Strings(c.jsonKeys)
+	return c, nil
+}
+
+// ValidateAssertions 校验断言 (正则 / JSON 路径) 是否合法，便于在启动异步任务前提示错误
+func ValidateAssertions(a *domain.Assertions) error {
+	_, err := compileAssertions(a)
+	return err
+}
+
+// evaluate 返回是否通过及失败原因。执行错误 (连接失败/超时等) 一律判定失败。
+func (c *assertion) evaluate(stdout string, exitCode int, dur time.Duration, execErr error) (bool, string) {
+	if execErr != nil {
+		return false, "exec error: " + execErr.Error()
+	}
+	if c == nil {
+		if exitCode != 0 {
+			return false, fmt.Sprintf("exit code %d", exitCode)
+		}
+		return true, ""
+	}
+	var fails []string
+	allowed := c.spec.ExitCodes
+	if len(allowed) == 0 {
+		allowed = []int{0}
+	}
+	okCode := false
+	for _, code := range allowed {
+		if code == exitCode {
+			okCode = true
+			break
+		}
+	}
+	if !okCode {
+		fails = append(fails, fmt.Sprintf("exit code %d not in %v", exitCode, allowed))
+	}
+	for _, re := range c.match {
+		if !re.MatchString(stdout) {
+			fails = append(fails, fmt.Sprintf("stdout does not match /%s/", re))
+		}
+	}
+	for _, re := range c.notMatch {
+		if re.MatchString(stdout) {
+			fails = append(fails, fmt.Sprintf("stdout matches forbidden /%s/", re))
+		}
+	}
+	if maxMs := c.spec.MaxDurationMs; maxMs > 0 && dur.Milliseconds() > maxMs {
+		fails = append(fails, fmt.Sprintf("duration %dms exceeds %dms", dur.Milliseconds(), maxMs))
+	}
+	if len(c.jsonKeys) > 0 {
+		var doc any
+		if err := json.Unmarshal([]byte(stdout), &doc); err != nil {
+			fails = append(fails, "stdout is not valid JSON")
+		} else {
+			for _, k := range c.jsonKeys {
+				want := c.spec.JSONEquals[k]
+				got, ok := lookupJSONPath(doc, k)
+				if !ok {
+					fails = append(fails, fmt.Sprintf("json path %s not found", k))
+				} else if got != want {
+					fails = append(fails, fmt.Sprintf("json path %s = %q, want %q", k, got, want))
+				}
+			}
+		}
+	}
+	if len(fails) > 0 {
+		return false, strings.Join(fails, "; ")
+	}
+	return true, ""
+}
+
+// parseJSONPath 解析 "a.b[0].c" 为路径段；数字段表示数组下标
+func parseJSONPath(p string) ([]string, error) {
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "$"), ".")
+	if p == "" {
+		return nil, fmt.Errorf("empty json path")
+	}
+	p = strings.ReplaceAll(strings.ReplaceAll(p, "[", "."), "]", "")
+	segs := strings.Split(p, ".")
+	for _, s := range segs {
+		if s == "" {
+			return nil, fmt.Errorf("invalid json path %q", p)
+		}
+	}
+	return segs, nil
+}
+
+// lookupJSONPath 取路径值的字符串形式：字符串原样返回，其它类型返回 JSON 编码 (如 true / 3 / null)
+func lookupJSONPath(doc any, path string) (string, bool) {
+	segs, err := parseJSONPath(path)
+	if err != nil {
+		return "", false
+	}
+	cur := doc
+	for _, seg := range segs {
+		switch v := cur.(type) {
+		case map[string]any:
+			next, ok := v[seg]
+			if !ok {
+				return "", false
+			}
+			cur = next
+		case []any:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			cur = v[i]
+		default:
+			return "", false
+		}
+	}
+	if s, ok := cur.(string); ok {
+		return s, true
+	}
+	b, err := json.Marshal(cur)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+)
+
+// redacted 审计中代替敏感值
+const redacted = "[REDACTED]"
+
+// Auditor 写入与校验审计日志；nil 表示未启用审计 (如远程模式)，各方法为 no-op
+type Auditor struct {
+	repo repository.AuditRepoIface
+}
+
+func NewAuditor(repo repository.AuditRepoIface) *Auditor { return &Auditor{repo: repo} }
+
+// Record 记录一次变更；before / after 序列化为 JSON (nil 省略)，调用方负责去掉敏感字段
+// (domain.Machine 的 SSHKey 不参与序列化)
+func (a *Auditor) Record(actor, action, target string, before, after any) error {
+	if a == nil {
+		return nil
+	}
+	e := domain.AuditEntry{Actor: actor, Action: action, Target: target}
+	var err error
+	if e.Before, err = auditJSON(before); err != nil {
+		return err
+	}
+	if e.After, err = auditJSON(after); err != nil {
+		return err
+	}
+	return a.repo.Append(&e)
+}
+
+// execAudit 命令下发的审计内容 (不含密码)
+type execAudit struct {
+	Command    string             `json:"command"`
+	MachineIDs []int64            `json:"machine_ids,omitempty"`
+	Selector   string             `json:"selector,omitempty"`
+	Groups     []string           `json:"groups,omitempty"`
+	Timeout    int                `json:"timeout,omitempty"`
+	Parallel   int                `json:"parallel,omitempty"`
+	AuthMode   string             `json:"auth_mode,omitempty"`
+	Assertions *domain.Assertions `json:"assertions,omitempty"`
+}
+
+// RecordExec 记录一次命令下发 (目标为任务 ID)；应在执行前调用，失败时不应执行
+func (a *Auditor) RecordExec(task domain.ExecTask) error {
+	return a.Record(task.User, domain.AuditExec, task.JobID, nil, execAudit{
+		Command:    task.Command,
+		MachineIDs: task.MachineIDs,
+		Selector:   task.Selector,
+		Groups:     task.Groups,
+		Timeout:    task.Timeout,
+		Parallel:   task.Parallel,
+		AuthMode:   task.AuthMode,
+		Assertions: task.Assertions,
+	})
+}
+
+// policyAudit 策略判定的审计内容 (不含确认令牌)
+type policyAudit struct {
+	Command    string  `json:"command"`
+	MachineIDs []int64 `json:"machine_ids,omitempty"`
+	domain.PolicyDecision
+}
+
+// RecordPolicy 记录一次非 allow 的策略判定 (目标为任务 ID)，作为 Policy.OnDecision 使用
+func (a *Auditor) RecordPolicy(task domain.ExecTask, d domain.PolicyDecision) error {
+	d.ConfirmToken = ""
+	return a.Record(task.User, domain.AuditExecPolicy, task.JobID, nil, policyAudit{Command: task.Command, MachineIDs: task.MachineIDs, PolicyDecision: d})
+}
+
+// auditMachine 机器的审计快照：SSH Key 只记录是否设置
+type auditMachine struct {
+	domain.Machine
+	SSHKey string `json:"ssh_key,omitempty"`
+}
+
+// AuditMachine 返回可写入审计的机器快照 (私钥以占位符代替)
+func AuditMachine(m domain.Machine) any {
+	a := auditMachine{Machine: m}
+	if m.SSHKey != "" {
+		a.SSHKey = redacted
+	}
+	return a
+}
+
+// RedactedSecret 记录密钥类变更时使用的占位内容
+func RedactedSecret(field string) map[string]string { return map[string]string{field: redacted} }
+
+// Query 查询审计日志
+func (a *Auditor) Query(q domain.AuditQuery) ([]domain.AuditEntry, error) {
+	if a == nil {
+		return nil, errAuditDisabled
+	}
+	return a.repo.Query(q)
+}
+
+var errAuditDisabled = errors.New("audit log is not available")
+
+// Verify 从头校验哈希链：每条的 PrevHash 须等于上一条的 Hash，且 Hash 与内容一致
+func (a *Auditor) Verify() (domain.AuditVerifyResult, error) {
+	if a == nil {
+		return domain.AuditVerifyResult{}, errAuditDisabled
+	}
+	res := domain.AuditVerifyResult{OK: true}
+	errBroken := errors.New("broken")
+	err := a.repo.Walk(func(e domain.AuditEntry) error {
+		switch {
+		case e.PrevHash != res.HeadHash:
+			res.Reason = "prev_hash does not match the previous entry (entry inserted or removed before it)"
+		case e.ComputeHash() != e.Hash:
+			res.Reason = "hash does not match the entry content (entry modified)"
+		default:
+			res.Checked++
+			res.HeadHash = e.Hash
+			return nil
+		}
+		res.OK, res.BrokenID = false, e.ID
+		return errBroken
+	})
+	if err != nil && !errors.Is(err, errBroken) {
+		return domain.AuditVerifyResult{}, err
+	}
+	return res, nil
+}
+
+// RenderAudit 导出审计条目 (format=jsonl|csv)
+func RenderAudit(list []domain.AuditEntry, format string) (string, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "", "jsonl":
+		enc := json.NewEncoder(&buf)
+		for _, e := range list {
+			if err := enc.Encode(e); err != nil {
+				return "", err
+			}
+		}
+	case "csv":
+		w := csv.NewWriter(&buf)
+		_ = w.Write([]string{"id", "time", "actor", "action", "target", "before", "after", "prev_hash", "hash"})
+		for _, e := range list {
+			_ = w.Write([]string{strconv.FormatInt(e.ID, 10), e.Time.UTC().Format(domain.AuditTimeFormat), e.Actor, e.Action, e.Target, e.Before, e.After, e.PrevHash, e.Hash})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported audit export format %q (jsonl|csv)", format)
+	}
+	return buf.String(), nil
+}
+
+func auditJSON(v any) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
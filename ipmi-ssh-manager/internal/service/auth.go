@@ -0,0 +1,191 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+)
+
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrForbidden       = errors.New("permission denied")
+)
+
+// Authorize 检查用户是否拥有权限；失败返回包装 ErrForbidden 的错误
+func Authorize(u domain.User, p domain.Permission) error {
+	if u.Can(p) {
+		return nil
+	}
+	return fmt.Errorf("%w: user %q (%s) lacks %s", ErrForbidden, u.Name, u.Role, p)
+}
+
+// rePowerCommand 电源控制类命令 (关机 / 重启 / IPMI 电源操作)，需额外的 power 权限。
+// 命令名前可带路径 (/sbin/reboot) 或引号 ("reboot")，其后须是命令边界 (避免匹配 reboot.log 之类的文件名)
+var rePowerCommand = regexp.MustCompile(`(?i)(^|[;&|(\s"'\x60])(?:\S*/)?(reboot|poweroff|shutdown|halt|init\s+[06]|systemctl\s+(reboot|poweroff|halt|kexec)|ipmitool\b[^;&|]*\bpower\s+(on|off|cycle|reset|soft))($|[\s;&|)"'\x60])`)
+
+// IsPowerCommand 命令 (模板) 是否包含电源控制操作
+func IsPowerCommand(cmd string) bool { return rePowerCommand.MatchString(cmd) }
+
+// AuthorizeCommand 执行命令需 exec 权限，电源控制类命令另需 power 权限
+func AuthorizeCommand(u domain.User, cmd string) error {
+	if err := Authorize(u, domain.PermExec); err != nil {
+		return err
+	}
+	if IsPowerCommand(cmd) {
+		return Authorize(u, domain.PermPower)
+	}
+	return nil
+}
+
+// LocalUser 本机单用户模式 (桌面 / TUI / ipmictl) 的操作者：当前系统用户，拥有全部权限
+func LocalUser() domain.User {
+	name := os.Getenv("IPMI_USER")
+	if name == "" {
+		if cur, err := user.Current(); err == nil && cur.Username != "" {
+			name = cur.Username
+		} else {
+			name = "local"
+		}
+	}
+	return domain.User{Name: name, Role: domain.RoleAdmin}
+}
+
+// HashToken 令牌只以 SHA-256 哈希保存
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken 生成随机访问令牌
+func newToken() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return "ipmi_" + hex.EncodeToString(b)
+}
+
+var reUserName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+// UserService 用户管理与令牌认证
+type UserService struct {
+	repo repository.UserRepoIface
+}
+
+func NewUserService(repo repository.UserRepoIface) *UserService { return &UserService{repo: repo} }
+
+// Authenticate 由访问令牌解析用户；令牌无效或用户已禁用时返回 ErrUnauthenticated
+func (s *UserService) Authenticate(token string) (domain.User, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return domain.User{}, ErrUnauthenticated
+	}
+	u, err := s.repo.GetByTokenHash(HashToken(token))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && u.Disabled) {
+		return domain.User{}, ErrUnauthenticated
+	}
+	return u, err
+}
+
+// List 全部用户
+func (s *UserService) List() ([]domain.User, error) { return s.repo.List() }
+
+// Get 按名称查询
+func (s *UserService) Get(name string) (domain.User, error) { return s.repo.GetByName(name) }
+
+// Create 新建用户并返回其访问令牌 (仅此一次可见)
+func (s *UserService) Create(u domain.User) (domain.User, string, error) {
+	if err := validateUser(&u); err != nil {
+		return domain.User{}, "", err
+	}
+	token := newToken()
+	if err := s.repo.Create(&u, HashToken(token)); err != nil {
+		return domain.User{}, "", err
+	}
+	return u, token, nil
+}
+
+// Update 修改角色 / 分组范围 / 禁用状态；不允许移除最后一个可用的管理员
+func (s *UserService) Update(u domain.User) error {
+	if err := validateUser(&u); err != nil {
+		return err
+	}
+	if u.Role != domain.RoleAdmin || u.Disabled {
+		if err := s.keepAdmin(u.Name); err != nil {
+			return err
+		}
+	}
+	return s.repo.Update(u)
+}
+
+// Delete 删除用户；不允许删除最后一个可用的管理员
+func (s *UserService) Delete(name string) error {
+	if err := s.keepAdmin(name); err != nil {
+		return err
+	}
+	return s.repo.Delete(name)
+}
+
+// ResetToken 重新生成访问令牌，旧令牌立即失效
+func (s *UserService) ResetToken(name string) (string, error) {
+	token := newToken()
+	if err := s.repo.SetTokenHash(name, HashToken(token)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Bootstrap 尚无任何用户时创建管理员 name 并返回其令牌；已有用户时返回空串
+func (s *UserService) Bootstrap(name string) (string, error) {
+	list, err := s.repo.List()
+	if err != nil || len(list) > 0 {
+		return "", err
+	}
+	_, token, err := s.Create(domain.User{Name: name, Role: domain.RoleAdmin})
+	return token, err
+}
+
+// keepAdmin 当 name 是唯一可用的管理员时拒绝降级 / 禁用 / 删除
+func (s *UserService) keepAdmin(name string) error {
+	list, err := s.repo.List()
+	if err != nil {
+		return err
+	}
+	admins, isAdmin := 0, false
+	for _, u := range list {
+		if u.Role == domain.RoleAdmin && !u.Disabled {
+			admins++
+			isAdmin = isAdmin || u.Name == name
+		}
+	}
+	if isAdmin && admins == 1 {
+		return fmt.Errorf("cannot remove the last admin %q", name)
+	}
+	return nil
+}
+
+func validateUser(u *domain.User) error {
+	u.Name = strings.TrimSpace(u.Name)
+	if !reUserName.MatchString(u.Name) {
+		return fmt.Errorf("invalid user name %q", u.Name)
+	}
+	if !u.Role.Valid() {
+		return fmt.Errorf("invalid role %q (viewer|operator|admin)", u.Role)
+	}
+	groups := u.Groups[:0:0]
+	for _, g := range u.Groups {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	u.Groups = groups
+	return nil
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+)
+
+func TestUserService_TokensAndLastAdmin(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	repo := repository.NewUserRepo(db)
+	if err := repo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	us := NewUserService(repo)
+
+	adminToken, err := us.Bootstrap("admin")
+	if err != nil || adminToken == "" {
+		t.Fatalf("Bootstrap: %q %v", adminToken, err)
+	}
+	if again, err := us.Bootstrap("admin"); err != nil || again != "" {
+		t.Fatalf("second Bootstrap should be a no-op: %q %v", again, err)
+	}
+	if u, err := us.Authenticate(adminToken); err != nil || u.Name != "admin" || u.Role != domain.RoleAdmin {
+		t.Fatalf("Authenticate admin: %+v %v", u, err)
+	}
+	if _, err := us.Authenticate("bogus"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("bogus token: %v", err)
+	}
+
+	if _, _, err := us.Create(domain.User{Name: "bob", Role: "root"}); err == nil {
+		t.Fatal("invalid role accepted")
+	}
+	_, bobToken, err := us.Create(domain.User{Name: "bob", Role: domain.RoleOperator, Groups: []string{" web ", ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := us.Authenticate(bobToken)
+	if err != nil || len(bob.Groups) != 1 || bob.Groups[0] != "web" {
+		t.Fatalf("bob: %+v %v", bob, err)
+	}
+
+	// 令牌重置后旧令牌失效；禁用用户无法认证
+	newToken, err := us.ResetToken("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(bobToken); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("old token still valid: %v", err)
+	}
+	bob.Disabled = true
+	if err := us.Update(bob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(newToken); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("disabled user authenticated: %v", err)
+	}
+
+	// 唯一的管理员不能被降级或删除
+	if err := us.Update(domain.User{Name: "admin", Role: domain.RoleViewer}); err == nil {
+		t.Fatal("demoted the last admin")
+	}
+	if err := us.Delete("admin"); err == nil {
+		t.Fatal("deleted the last admin")
+	}
+	if err := us.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorizeCommand(t *testing.T) {
+	operator := domain.User{Name: "op", Role: domain.RoleOperator}
+	cases := []struct {
+		cmd   string
+		power bool
+	}{
+		{"uptime", false},
+		{"reboot", true},
+		{"sync && shutdown -h now", true},
+		{"systemctl reboot", true},
+		{"ipmitool -I lanplus -H {{.ipmi_ip}} chassis power cycle", true},
+		{"ipmitool chassis power status", false},
+		{"cat /var/log/reboot.log", false},
+		{"init 6", true},
+		{"/sbin/reboot", true},
+		{"sudo /usr/sbin/shutdown -h now", true},
+		{`"reboot"`, true},
+		{`bash -c 'poweroff'`, true},
+		{"echo $(/usr/bin/systemctl reboot)", true},
+		{"/usr/bin/ipmitool -H 10.0.0.1 power off", true},
+		{"ls /var/log/shutdown-2024.log", false},
+		{"grep halted /var/log/messages", false},
+		{"init 60", false},
+		{"echo `reboot`", true},
+	}
+	for _, c := range cases {
+		if got := IsPowerCommand(c.cmd); got != c.power {
+			t.Errorf("IsPowerCommand(%q) = %v, want %v", c.cmd, got, c.power)
+		}
+		err := AuthorizeCommand(operator, c.cmd)
+		if c.power != errors.Is(err, ErrForbidden) {
+			t.Errorf("AuthorizeCommand(operator, %q) = %v", c.cmd, err)
+		}
+	}
+	if err := AuthorizeCommand(domain.User{Name: "v", Role: domain.RoleViewer}, "uptime"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("viewer allowed to exec: %v", err)
+	}
+	if err := AuthorizeCommand(domain.User{Name: "a", Role: domain.RoleAdmin}, "reboot"); err != nil {
+		t.Errorf("admin denied: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/logging"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+)
+
+const (
+	backupPrefix = "machines_"
+	backupExt    = ".db"
+	// backupTimeLayout 备份文件名中的时间 (本地时间，按文件名排序即按时间排序)
+	backupTimeLayout = "20060102_150405.000"
+	// BackupPreRestore 恢复前自动备份的文件名后缀
+	BackupPreRestore = "pre-restore"
+)
+
+// BackupService 在线备份 / 恢复本地数据库：备份写入 dir，按数量保留最近 keep 个；
+// 恢复前校验文件与迁移版本，并先备份当前库
+type BackupService struct {
+	db   *sql.DB
+	dir  string
+	keep int
+	log  *slog.Logger
+
+	mu           sync.Mutex   // 备份、恢复与清理串行执行
+	afterRestore func() error // 恢复后重建依赖数据的状态 (如全文索引)
+	stop         chan struct{}
+}
+
+// NewBackupService keep<=0 时不自动删除旧备份
+func NewBackupService(db *sql.DB, dir string, keep int) *BackupService {
+	return &BackupService{db: db, dir: dir, keep: keep, log: slog.Default()}
+}
+
+// SetLogger 设置日志
+func (s *BackupService) SetLogger(l *slog.Logger) { s.log = l }
+
+// SetAfterRestore 恢复成功后调用 (如 HistoryRepo.EnsureSchema 重建全文索引)
+func (s *BackupService) SetAfterRestore(fn func() error) { s.afterRestore = fn }
+
+// Dir 备份目录
+func (s *BackupService) Dir() string { return s.dir }
+
+// Backup 立即备份；tag 非空时追加到文件名 (如 pre-restore)
+func (s *BackupService) Backup(tag string) (domain.BackupInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backupLocked(tag)
+}
+
+func (s *BackupService) backupLocked(tag string) (domain.BackupInfo, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return domain.BackupInfo{}, err
+	}
+	name := backupPrefix + time.Now().Format(backupTimeLayout)
+	if tag != "" {
+		name += "_" + tag
+	}
+	path := filepath.Join(s.dir, name+backupExt)
+	start := time.Now()
+	if err := repository.BackupTo(s.db, path); err != nil {
+		return domain.BackupInfo{}, err
+	}
+	info, err := repository.InspectBackup(path)
+	if err != nil {
+		return info, fmt.Errorf("verify backup: %w", err)
+	}
+	s.log.Info("database backup created", "file", info.Name, "bytes", info.Size, "schema_version", info.SchemaVersion, "duration_ms", time.Since(start).Milliseconds())
+	s.pruneLocked()
+	return info, nil
+}
+
+// List 备份目录中的备份 (新的在前)；只读取文件信息，不打开数据库
+func (s *BackupService) List() ([]domain.BackupInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []domain.BackupInfo
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), backupPrefix) || filepath.Ext(e.Name()) != backupExt {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, domain.BackupInfo{Name: e.Name(), Path: filepath.Join(s.dir, e.Name()), Size: fi.Size(), CreatedAt: fi.ModTime()})
+	}
+	slices.SortFunc(out, func(a, b domain.BackupInfo) int { return strings.Compare(b.Name, a.Name) })
+	return out, nil
+}
+
+// Path 备份名对应的文件路径；只接受备份目录中的文件名
+func (s *BackupService) Path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || !strings.HasPrefix(name, backupPrefix) || filepath.Ext(name) != backupExt {
+		return "", fmt.Errorf("%w: %q is not a backup name", repository.ErrInvalidBackup, name)
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+// Inspect 校验备份文件 (见 repository.InspectBackup)
+func (s *BackupService) Inspect(path string) (domain.BackupInfo, error) {
+	return repository.InspectBackup(path)
+}
+
+// Restore 校验 path 后先备份当前库，再把 path 恢复到当前库；返回恢复前的自动备份
+func (s *BackupService) Restore(path string) (domain.BackupInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, err := repository.InspectBackup(path)
+	if err != nil {
+		return domain.BackupInfo{}, err
+	}
+	pre, err := s.backupLocked(BackupPreRestore)
+	if err != nil {
+		return pre, fmt.Errorf("backup before restore: %w", err)
+	}
+	if err := repository.RestoreFrom(s.db, path); err != nil {
+		s.log.Error("database restore failed", "file", src.Name, "pre_restore", pre.Name, logging.Err(err))
+		return pre, err
+	}
+	if s.afterRestore != nil {
+		if err := s.afterRestore(); err != nil {
+			return pre, fmt.Errorf("after restore: %w", err)
+		}
+	}
+	s.log.Warn("database restored from backup", "file", src.Name, "schema_version", src.SchemaVersion, "machines", src.Machines, "history", src.History, "pre_restore", pre.Name)
+	return pre, nil
+}
+
+// Check 当前库的完整性检查
+func (s *BackupService) Check() (domain.IntegrityReport, error) {
+	return repository.IntegrityCheck(s.db)
+}
+
+// pruneLocked 只保留最近 keep 个备份
+func (s *BackupService) pruneLocked() {
+	if s.keep <= 0 {
+		return
+	}
+	list, err := s.List()
+	if err != nil {
+		s.log.Warn("list backups failed", logging.Err(err))
+		return
+	}
+	for _, b := range list[min(s.keep, len(list)):] {
+		if err := os.Remove(b.Path); err != nil {
+			s.log.Warn("remove old backup failed", "file", b.Name, logging.Err(err))
+		}
+	}
+}
+
+// Start 每隔 interval 备份一次 (interval<=0 不启动)；Stop 停止
+func (s *BackupService) Start(interval time.Duration) {
+	if interval <= 0 || s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if _, err := s.Backup(""); err != nil {
+					s.log.Error("scheduled database backup failed", logging.Err(err))
+				}
+			}
+		}
+	}(s.stop)
+}
+
+// Stop 停止定时备份
+func (s *BackupService) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
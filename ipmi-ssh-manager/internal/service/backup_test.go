@@ -0,0 +1,134 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+)
+
+func TestBackupService_BackupRestore(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	mRepo := repository.NewMachineRepo(db)
+	m := domain.Machine{IPMIIP: "10.0.0.1", SSHUser: "root", SSHKey: "-----BEGIN KEY-----"}
+	if err := mRepo.Save(&m); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.NewHistoryRepo(db).Insert(&domain.ExecHistory{MachineID: int64(m.ID), IPMIIP: m.IPMIIP, Command: "uptime"}); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewBackupService(db, filepath.Join(t.TempDir(), "backups"), 0)
+	rebuilt := 0
+	svc.SetAfterRestore(func() error { rebuilt++; return nil })
+
+	b1, err := svc.Backup("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, _ := repository.Migrations()
+	if b1.Machines != 1 || b1.History != 1 || b1.SchemaVersion != all[len(all)-1].Version {
+		t.Fatalf("backup info %+v", b1)
+	}
+	if fi, err := os.Stat(b1.Path); err != nil || (fi.Mode().Perm()&0077 != 0 && os.PathSeparator == '/') {
+		t.Fatalf("backup file mode: %v", err)
+	}
+	// 备份中的 SSH Key 与库中存储的形式一致 (不解密)
+	var stored, backedUp string
+	if err := db.QueryRow(`SELECT ssh_key FROM machines WHERE id = ?`, m.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	bdb, err := sql.Open("sqlite", b1.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bdb.QueryRow(`SELECT ssh_key FROM machines WHERE id = ?`, m.ID).Scan(&backedUp)
+	bdb.Close()
+	if err != nil || backedUp != stored {
+		t.Fatalf("backup ssh_key %q, stored %q (%v)", backedUp, stored, err)
+	}
+
+	if err := mRepo.Save(&domain.Machine{IPMIIP: "10.0.0.2", SSHUser: "root"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond) // 文件名精确到毫秒
+	pre, err := svc.Restore(b1.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pre.Machines != 2 || rebuilt != 1 {
+		t.Fatalf("pre-restore backup %+v, after-restore hooks %d", pre, rebuilt)
+	}
+	list, err := mRepo.ListAll()
+	if err != nil || len(list) != 1 || list[0].SSHKey != "-----BEGIN KEY-----" {
+		t.Fatalf("machines after restore: %+v %v", list, err)
+	}
+	rep, err := svc.Check()
+	if err != nil || !rep.OK || rep.SchemaVersion != b1.SchemaVersion || rep.Pending != 0 {
+		t.Fatalf("integrity %+v %v", rep, err)
+	}
+	if backups, _ := svc.List(); len(backups) != 2 || backups[0].Name != pre.Name {
+		t.Fatalf("list %+v", backups)
+	}
+}
+
+func TestBackupService_RejectsInvalidAndRetains(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	svc := NewBackupService(db, dir, 2)
+
+	junk := filepath.Join(dir, "machines_junk.db")
+	if err := os.WriteFile(junk, []byte("not a database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Restore(junk); !errors.Is(err, repository.ErrInvalidBackup) {
+		t.Fatalf("junk restore: %v", err)
+	}
+	if _, err := svc.Path("../machines.db"); err == nil {
+		t.Fatal("path outside the backup dir must be rejected")
+	}
+
+	b, err := svc.Backup("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 由更新版本创建的备份 (含未知迁移) 不能恢复
+	newer := filepath.Join(dir, "newer.db")
+	if err := repository.BackupTo(db, newer); err != nil {
+		t.Fatal(err)
+	}
+	ndb, err := sql.Open("sqlite", newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ndb.Exec(`INSERT INTO schema_migrations(version, name, checksum, applied_at) VALUES (9999, 'future', '', '')`)
+	ndb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Restore(newer); !errors.Is(err, repository.ErrSchemaTooNew) {
+		t.Fatalf("newer restore: %v", err)
+	}
+
+	for range 3 {
+		time.Sleep(2 * time.Millisecond)
+		if _, err := svc.Backup(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := svc.List()
+	if err != nil || len(list) != 2 {
+		t.Fatalf("retention kept %d backups: %v", len(list), err)
+	}
+	for _, x := range list {
+		if x.Name == b.Name {
+			t.Fatal("oldest backup should be pruned")
+		}
+	}
+}
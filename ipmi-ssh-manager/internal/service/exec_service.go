@@ -3,10 +3,18 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/logging"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/output"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
 )
 
@@ -28,29 +36,142 @@ type ExecService struct {
 	executor          SSHExecutor
 	maxParallel       int
 	mu                sync.Mutex
-	jobs              map[string]context.CancelFunc
+	jobs              map[string]*runningJob
 	globalKeyProvider func() string
+	policy            *Policy
+	observer          func(domain.ExecResult, time.Duration)
+	active            atomic.Int64
+	log               *slog.Logger
+	outputLimit       int // 任务未指定 MaxOutput 时每路输出的保留上限
+}
+
+// DefaultOutputLimit 每台机器 stdout / stderr 各自默认保留的字节数
+const DefaultOutputLimit = 1 << 20
+
+// runningJob StartBatch 启动的任务：取消函数与发起者等信息
+type runningJob struct {
+	cancel context.CancelFunc
+	info   domain.JobInfo
 }
 
 func NewExecService(repo repository.MachineRepoIface, writer *HistoryWriter, executor SSHExecutor, maxParallel int) *ExecService {
-	return &ExecService{repo: repo, hWriter: writer, executor: executor, maxParallel: maxParallel, jobs: make(map[string]context.CancelFunc)}
+	return &ExecService{repo: repo, hWriter: writer, executor: executor, maxParallel: maxParallel, jobs: make(map[string]*runningJob), log: slog.Default(), outputLimit: DefaultOutputLimit}
+}
+
+// SetOutputLimit 设置默认输出保留上限 (字节，<=0 不限制)；任务的 MaxOutput 优先
+func (s *ExecService) SetOutputLimit(n int) { s.outputLimit = n }
+
+// capture 按任务或默认上限创建单机输出捕获
+func (s *ExecService) capture(task domain.ExecTask) *output.Capture {
+	if task.MaxOutput > 0 {
+		return &output.Capture{Limit: task.MaxOutput}
+	}
+	return &output.Capture{Limit: s.outputLimit}
 }
 
+// SetLogger 设置日志；每台机器的日志带 job_id / machine_id / ipmi_ip，并经 context 传给执行器
+func (s *ExecService) SetLogger(l *slog.Logger) { s.log = l }
+
 // SetGlobalKeyProvider 设置获取全局私钥的函数（避免直接依赖 Backend 造成循环）
 func (s *ExecService) SetGlobalKeyProvider(f func() string) { s.globalKeyProvider = f }
 
+// SetPolicy 设置命令策略 (nil 表示不限制)；所有批量入口在执行前经策略检查
+func (s *ExecService) SetPolicy(p *Policy) { s.policy = p }
+
+// SetExecObserver 设置单机执行完成回调 (结果 + 耗时)，供 metrics 统计；需在执行前设置
+func (s *ExecService) SetExecObserver(f func(domain.ExecResult, time.Duration)) { s.observer = f }
+
+// ActiveRuns 正在进行的批量执行数 (BatchExec / StartBatch / StreamExec*)
+func (s *ExecService) ActiveRuns() int64 { return s.active.Load() }
+
+// HistoryStats 历史写入器统计；未配置写入器时为零值
+func (s *ExecService) HistoryStats() HistoryWriterStats {
+	if s.hWriter == nil {
+		return HistoryWriterStats{}
+	}
+	return s.hWriter.Stats()
+}
+
+// observe 记录单机结果日志并通知观察者
+func (s *ExecService) observe(r domain.ExecResult, d time.Duration) {
+	l := s.log.With(logging.KeyJobID, r.JobID, logging.KeyMachineID, r.MachineID, logging.KeyIPMIIP, r.IPMIIP)
+	if class := ErrorClass(r); class == "ok" {
+		l.Debug("exec host done", "exit_code", r.ExitCode, "duration_ms", d.Milliseconds())
+	} else {
+		l.Warn("exec host failed", "class", class, "exit_code", r.ExitCode, logging.Err(r.Err), "assert_msg", r.AssertMsg, "duration_ms", d.Milliseconds())
+	}
+	if s.observer != nil {
+		s.observer(r, d)
+	}
+}
+
+// begin 登记一次批量执行并记录开始日志，返回的函数在结束时调用
+func (s *ExecService) begin(task domain.ExecTask, targets int) func() {
+	s.active.Add(1)
+	start := time.Now()
+	l := s.log.With(logging.KeyJobID, task.JobID, logging.KeyUser, task.User)
+	l.Info("exec started", "command", task.Command, "targets", targets, "parallel", task.Parallel, "timeout_s", task.Timeout)
+	return func() {
+		s.active.Add(-1)
+		l.Info("exec finished", "duration_ms", time.Since(start).Milliseconds())
+	}
+}
+
+// hostContext 为单机执行附带关联字段的 logger
+func (s *ExecService) hostContext(ctx context.Context, task domain.ExecTask, m domain.Machine) context.Context {
+	return logging.NewContext(ctx, s.log.With(logging.KeyJobID, task.JobID, logging.KeyMachineID, m.ID, logging.KeyIPMIIP, m.IPMIIP))
+}
+
+// ErrorClass 将单机结果归类：ok / exit_code / assertion / timeout / canceled / policy / render / auth / connect / not_found / other
+func ErrorClass(r domain.ExecResult) string {
+	err := r.Err
+	switch {
+	case err == nil && r.Passed:
+		return "ok"
+	case err == nil && r.ExitCode != 0:
+		return "exit_code"
+	case err == nil:
+		return "assertion"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrCommandNotAllowed), errors.Is(err, ErrForbidden), errors.Is(err, ErrPolicyDenied):
+		return "policy"
+	}
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "render command") || strings.HasPrefix(msg, "parse command template"):
+		return "render"
+	case strings.Contains(msg, "unable to authenticate") || strings.HasPrefix(msg, "parse key"):
+		return "auth"
+	case msg == "machine not found":
+		return "not_found"
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return "connect"
+	}
+	return "other"
+}
+
 // StartBatch 启动一个带 jobID 的流批执行，返回 jobID（若传入为空则自动生成）。
-// 使用 StreamExec 语义（回调逐条）。
+// 使用 StreamExec 语义（回调逐条）。目标解析与策略检查同步完成，未放行时返回错误且不启动任务。
 func (s *ExecService) StartBatch(jobID string, task domain.ExecTask, cb func(domain.ExecResult)) (string, error) {
 	if jobID == "" {
-		jobID = time.Now().Format("20060102_150405.000")
+		jobID = NewJobID()
+	}
+	task.JobID = jobID
+	mMap, asrt, err := s.prepare(&task)
+	if err != nil {
+		return "", err
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	s.mu.Lock()
-	s.jobs[jobID] = cancel
+	s.jobs[jobID] = &runningJob{cancel: cancel, info: domain.JobInfo{ID: jobID, User: task.User, Command: task.Command, StartedAt: time.Now()}}
 	s.mu.Unlock()
 	go func() {
-		_ = s.StreamExecWithCtx(ctx, task, cb)
+		s.runStream(ctx, task, mMap, asrt, nil, cb)
 		s.mu.Lock()
 		delete(s.jobs, jobID)
 		s.mu.Unlock()
@@ -58,18 +179,49 @@ func (s *ExecService) StartBatch(jobID string, task domain.ExecTask, cb func(dom
 	return jobID, nil
 }
 
+// jobSeq 进程内递增序号，避免同一毫秒内启动的任务 ID 重复
+var jobSeq atomic.Uint64
+
+// NewJobID 生成基于时间的任务 ID (时间 + 序号)
+func NewJobID() string {
+	return fmt.Sprintf("%s_%d", time.Now().Format("20060102_150405.000"), jobSeq.Add(1))
+}
+
 // Cancel 取消指定 jobID
 func (s *ExecService) Cancel(jobID string) bool {
 	s.mu.Lock()
-	cancel, ok := s.jobs[jobID]
+	j, ok := s.jobs[jobID]
 	s.mu.Unlock()
 	if ok {
-		cancel()
+		j.cancel()
 		return true
 	}
 	return false
 }
 
+// Job 返回运行中任务的信息
+func (s *ExecService) Job(jobID string) (domain.JobInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[jobID]
+	if !ok {
+		return domain.JobInfo{}, false
+	}
+	return j.info, true
+}
+
+// ListJobs 运行中的任务 (按启动时间)
+func (s *ExecService) ListJobs() []domain.JobInfo {
+	s.mu.Lock()
+	list := make([]domain.JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		list = append(list, j.info)
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, k int) bool { return list[i].StartedAt.Before(list[k].StartedAt) })
+	return list
+}
+
 // HasJob 判断 job 是否仍在运行
 func (s *ExecService) HasJob(jobID string) bool {
 	s.mu.Lock()
@@ -85,29 +237,156 @@ func errToString(e error) string {
 	return e.Error()
 }
 
-// BatchExec 批量执行命令
-// 传入 ExecTask：Command / Timeout(s) / MachineIDs
-func (s *ExecService) BatchExec(task domain.ExecTask) ([]domain.ExecResult, error) {
-	if task.Command == "" {
-		return nil, errors.New("command empty")
+// commandFor 检查命令白名单，task.Render 为 true 时按机器渲染命令，渲染结果同样须通过白名单
+// (为电源控制类命令时另需 power 权限)；不允许或渲染失败时返回原始模板与错误
+func (s *ExecService) commandFor(task domain.ExecTask, m domain.Machine) (string, error) {
+	if s.policy != nil {
+		if err := s.policy.Allowed(task.Role, task.Command, m); err != nil {
+			return task.Command, err
+		}
+	}
+	if !task.Render {
+		return task.Command, nil
+	}
+	cmd, err := RenderCommand(task.Command, m)
+	if err != nil {
+		return task.Command, err
+	}
+	if s.policy != nil && cmd != task.Command {
+		if err := s.policy.AllowedRendered(task.Role, cmd, m); err != nil {
+			return task.Command, err
+		}
+	}
+	if task.Role != "" && IsPowerCommand(cmd) {
+		if err := Authorize(domain.User{Name: task.User, Role: task.Role}, domain.PermPower); err != nil {
+			return task.Command, err
+		}
+	}
+	return cmd, nil
+}
+
+// renderAndExec 先检查白名单并按机器渲染命令再执行；不允许或渲染失败时不下发命令，返回原始模板与错误。
+// onChunk 非空且执行器支持流式时实时回调输出片段。
+func (s *ExecService) renderAndExec(ctx context.Context, m domain.Machine, authMode, secret string, task domain.ExecTask, timeout time.Duration, onChunk func([]byte, bool)) (cmd, stdout, stderr string, code int, err error) {
+	ctx = s.hostContext(ctx, task, m)
+	cmd, err = s.commandFor(task, m)
+	if err != nil {
+		return cmd, "", "", -1, err
 	}
-	if len(task.MachineIDs) == 0 {
+	if se, ok := s.executor.(SSHStreamExecutor); ok && onChunk != nil {
+		stdout, stderr, code, err = se.StreamExec(ctx, m.SSHUser, m.SSHIP, authMode, secret, cmd, timeout, onChunk)
+	} else {
+		stdout, stderr, code, err = s.executor.Exec(ctx, m.SSHUser, m.SSHIP, authMode, secret, cmd, timeout)
+	}
+	// 执行器未按 ctx 中的上限截断时在此截断
+	stdout, stderr = output.FromContext(ctx).Fit(stdout, stderr)
+	return cmd, stdout, stderr, code, err
+}
+
+// resolveTargets 在执行时解析目标机器：显式 MachineIDs 在前，Selector 匹配的机器去重后追加到
+// task.MachineIDs 末尾。返回 ID -> Machine 映射 (未找到的 ID 不在映射中)。
+// task.Groups 非空时，选择器匹配到的范围外机器被忽略，显式指定范围外机器则整个任务被拒绝。
+func (s *ExecService) resolveTargets(task *domain.ExecTask) (map[int64]domain.Machine, error) {
+	scope := domain.User{Groups: task.Groups}
+	var selected []domain.Machine
+	if strings.TrimSpace(task.Selector) != "" {
+		ms, err := s.repo.SelectMachines(task.Selector)
+		if err != nil {
+			return nil, err
+		}
+		selected = ms
+	}
+	if len(task.MachineIDs) == 0 && len(selected) == 0 {
 		return nil, errors.New("no machines")
 	}
+	mMap := make(map[int64]domain.Machine, len(task.MachineIDs)+len(selected))
+	if len(task.MachineIDs) > 0 {
+		machines, err := s.repo.GetByIDs(task.MachineIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range machines {
+			if !scope.InScope(m) {
+				return nil, fmt.Errorf("%w: machine %s is outside groups %s", ErrForbidden, m.IPMIIP, strings.Join(task.Groups, ","))
+			}
+			mMap[int64(m.ID)] = m
+		}
+	}
+	ids := append([]int64(nil), task.MachineIDs...)
+	seen := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		seen[id] = struct{}{}
+	}
+	for _, m := range selected {
+		id := int64(m.ID)
+		if _, ok := seen[id]; ok || !scope.InScope(m) {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+		mMap[id] = m
+	}
+	task.MachineIDs = ids
+	return mMap, nil
+}
+
+// prepare 补全默认值、编译断言、解析目标并执行策略检查
+func (s *ExecService) prepare(task *domain.ExecTask) (map[int64]domain.Machine, *assertion, error) {
+	if task.Command == "" {
+		return nil, nil, errors.New("command empty")
+	}
 	if task.Timeout <= 0 {
 		task.Timeout = 30
 	}
-	timeout := time.Duration(task.Timeout) * time.Second
+	if task.JobID == "" {
+		task.JobID = NewJobID()
+	}
+	asrt, err := compileAssertions(task.Assertions)
+	if err != nil {
+		return nil, nil, err
+	}
+	mMap, err := s.resolveTargets(task)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.policy != nil {
+		if _, err := s.policy.Enforce(*task, mMap); err != nil {
+			s.log.Info("exec blocked by policy", logging.KeyJobID, task.JobID, logging.KeyUser, task.User, "command", task.Command, logging.Err(err))
+			return nil, nil, err
+		}
+	}
+	return mMap, asrt, nil
+}
 
-	// 取机器
-	machines, err := s.repo.GetByIDs(task.MachineIDs)
+// Admit 解析目标并执行策略检查，供逐机调用 SingleStream 的入口在执行前使用；
+// 成功时 task.MachineIDs 为解析后的最终目标
+func (s *ExecService) Admit(task *domain.ExecTask) error {
+	_, _, err := s.prepare(task)
+	return err
+}
+
+// CheckPolicy 预览策略判定 (不执行、不创建审批单)；需确认时返回确认令牌
+func (s *ExecService) CheckPolicy(task domain.ExecTask) (domain.PolicyDecision, error) {
+	mMap, err := s.resolveTargets(&task)
 	if err != nil {
-		return nil, err
+		return domain.PolicyDecision{}, err
 	}
-	mMap := make(map[int64]domain.Machine, len(machines))
-	for _, m := range machines {
-		mMap[int64(m.ID)] = m
+	if s.policy == nil {
+		return domain.PolicyDecision{Verdict: domain.VerdictAllow, Targets: len(task.MachineIDs), Granted: true}, nil
 	}
+	return s.policy.Preview(task, mMap), nil
+}
+
+// BatchExec 批量执行命令
+// 传入 ExecTask：Command / Timeout(s) / MachineIDs / Selector
+func (s *ExecService) BatchExec(task domain.ExecTask) ([]domain.ExecResult, error) {
+	// 取机器 (MachineIDs + Selector) 并检查策略
+	mMap, asrt, err := s.prepare(&task)
+	if err != nil {
+		return nil, err
+	}
+	defer s.begin(task, len(mMap))()
+	timeout := time.Duration(task.Timeout) * time.Second
 
 	var (
 		wg      sync.WaitGroup
@@ -131,7 +410,9 @@ func (s *ExecService) BatchExec(task domain.ExecTask) ([]domain.ExecResult, erro
 	for _, id := range task.MachineIDs {
 		m, ok := mMap[id]
 		if !ok {
-			add(domain.ExecResult{MachineID: id, Err: errors.New("machine not found")})
+			r := domain.ExecResult{JobID: task.JobID, MachineID: id, Err: errors.New("machine not found"), User: task.User}
+			add(r)
+			s.observe(r, 0)
 			continue
 		}
 		if sem != nil {
@@ -162,32 +443,49 @@ func (s *ExecService) BatchExec(task domain.ExecTask) ([]domain.ExecResult, erro
 			if authMode == "password" {
 				secret = task.Password
 			}
-			stdout, stderr, code, exErr := s.executor.Exec(ctx, mc.SSHUser, mc.SSHIP, authMode, secret, task.Command, timeout)
+			capt := s.capture(task)
+			cmd, stdout, stderr, code, exErr := s.renderAndExec(output.WithCapture(ctx, capt), mc, authMode, secret, task, timeout, nil)
 			finish := time.Now()
+			passed, assertMsg := asrt.evaluate(stdout, code, finish.Sub(start), exErr)
 			r := domain.ExecResult{
+				JobID:         task.JobID,
 				MachineID:     int64(mc.ID),
 				IPMIIP:        mc.IPMIIP,
 				SSHIP:         mc.SSHIP,
 				SSHUser:       mc.SSHUser,
+				Command:       cmd,
 				Stdout:        stdout,
 				Stderr:        stderr,
 				ExitCode:      code,
 				Err:           exErr,
 				UsedGlobalKey: usedGlobal,
+				Passed:        passed,
+				AssertMsg:     assertMsg,
+				User:          task.User,
+				Truncated:     capt.Truncated,
+				OutputBytes:   capt.Bytes(),
+				Groups:        mc.Groups,
 			}
 			add(r)
+			s.observe(r, finish.Sub(start))
 			if s.hWriter != nil {
 				h := domain.ExecHistory{
-					MachineID:  int64(mc.ID),
-					IPMIIP:     mc.IPMIIP,
-					Command:    task.Command,
-					Stdout:     stdout,
-					Stderr:     stderr,
-					ExitCode:   code,
-					ErrorText:  errToString(exErr),
-					StartedAt:  start,
-					FinishedAt: finish,
-					DurationMs: finish.Sub(start).Milliseconds(),
+					JobID:       task.JobID,
+					MachineID:   int64(mc.ID),
+					IPMIIP:      mc.IPMIIP,
+					Command:     cmd,
+					Stdout:      stdout,
+					Stderr:      stderr,
+					ExitCode:    code,
+					ErrorText:   errToString(exErr),
+					StartedAt:   start,
+					FinishedAt:  finish,
+					DurationMs:  finish.Sub(start).Milliseconds(),
+					Passed:      passed,
+					AssertMsg:   assertMsg,
+					User:        task.User,
+					Truncated:   capt.Truncated,
+					OutputBytes: capt.Bytes(),
 				}
 				s.hWriter.Write(h)
 			}
@@ -206,24 +504,27 @@ func (s *ExecService) StreamExec(task domain.ExecTask, cb func(domain.ExecResult
 
 // StreamExecWithCtx 支持外部 context 取消
 func (s *ExecService) StreamExecWithCtx(ctx context.Context, task domain.ExecTask, cb func(domain.ExecResult)) error {
-	if task.Command == "" {
-		return errors.New("command empty")
-	}
-	if len(task.MachineIDs) == 0 {
-		return errors.New("no machines")
-	}
-	if task.Timeout <= 0 {
-		task.Timeout = 30
-	}
-	timeout := time.Duration(task.Timeout) * time.Second
-	machines, err := s.repo.GetByIDs(task.MachineIDs)
+	return s.StreamExecChunks(ctx, task, nil, cb)
+}
+
+// ChunkFunc 实时输出片段回调，isErr 表示来自 stderr
+type ChunkFunc func(m domain.Machine, chunk []byte, isErr bool)
+
+// StreamExecChunks 在 StreamExecWithCtx 基础上，若执行器支持流式则通过 onChunk 实时推送各机输出片段；
+// onChunk 可能被多台机器并发调用。
+func (s *ExecService) StreamExecChunks(ctx context.Context, task domain.ExecTask, onChunk ChunkFunc, cb func(domain.ExecResult)) error {
+	mMap, asrt, err := s.prepare(&task)
 	if err != nil {
 		return err
 	}
-	mMap := make(map[int64]domain.Machine, len(machines))
-	for _, m := range machines {
-		mMap[int64(m.ID)] = m
-	}
+	s.runStream(ctx, task, mMap, asrt, onChunk, cb)
+	return nil
+}
+
+// runStream 对已解析的目标并发执行并逐条回调
+func (s *ExecService) runStream(ctx context.Context, task domain.ExecTask, mMap map[int64]domain.Machine, asrt *assertion, onChunk ChunkFunc, cb func(domain.ExecResult)) {
+	defer s.begin(task, len(mMap))()
+	timeout := time.Duration(task.Timeout) * time.Second
 	var wg sync.WaitGroup
 	var sem chan struct{}
 	limit := s.maxParallel
@@ -236,7 +537,9 @@ func (s *ExecService) StreamExecWithCtx(ctx context.Context, task domain.ExecTas
 	for _, id := range task.MachineIDs {
 		mc, ok := mMap[id]
 		if !ok {
-			cb(domain.ExecResult{MachineID: id, Err: errors.New("machine not found")})
+			r := domain.ExecResult{JobID: task.JobID, MachineID: id, Err: errors.New("machine not found"), User: task.User}
+			cb(r)
+			s.observe(r, 0)
 			continue
 		}
 		if sem != nil {
@@ -266,22 +569,38 @@ func (s *ExecService) StreamExecWithCtx(ctx context.Context, task domain.ExecTas
 			if authMode == "password" {
 				secret = task.Password
 			}
-			stdout, stderr, code, exErr := s.executor.Exec(cctx, m.SSHUser, m.SSHIP, authMode, secret, task.Command, timeout)
+			var chunkFn func([]byte, bool)
+			if onChunk != nil {
+				chunkFn = func(b []byte, isErr bool) { onChunk(m, b, isErr) }
+			}
+			capt := s.capture(task)
+			cmd, stdout, stderr, code, exErr := s.renderAndExec(output.WithCapture(cctx, capt), m, authMode, secret, task, timeout, chunkFn)
 			finish := time.Now()
-			res := domain.ExecResult{MachineID: int64(m.ID), IPMIIP: m.IPMIIP, SSHIP: m.SSHIP, SSHUser: m.SSHUser, Stdout: stdout, Stderr: stderr, ExitCode: code, Err: exErr, UsedGlobalKey: usedGlobal}
+			passed, assertMsg := asrt.evaluate(stdout, code, finish.Sub(start), exErr)
+			res := domain.ExecResult{JobID: task.JobID, MachineID: int64(m.ID), IPMIIP: m.IPMIIP, SSHIP: m.SSHIP, SSHUser: m.SSHUser, Command: cmd, Stdout: stdout, Stderr: stderr, ExitCode: code, Err: exErr, UsedGlobalKey: usedGlobal, Passed: passed, AssertMsg: assertMsg, User: task.User, Truncated: capt.Truncated, OutputBytes: capt.Bytes(), Groups: m.Groups}
 			cb(res)
+			s.observe(res, finish.Sub(start))
 			if s.hWriter != nil {
-				s.hWriter.Write(domain.ExecHistory{MachineID: int64(m.ID), IPMIIP: m.IPMIIP, Command: task.Command, Stdout: stdout, Stderr: stderr, ExitCode: code, ErrorText: errToString(exErr), StartedAt: start, FinishedAt: finish, DurationMs: finish.Sub(start).Milliseconds()})
+				s.hWriter.Write(domain.ExecHistory{JobID: task.JobID, MachineID: int64(m.ID), IPMIIP: m.IPMIIP, Command: cmd, Stdout: stdout, Stderr: stderr, ExitCode: code, ErrorText: errToString(exErr), StartedAt: start, FinishedAt: finish, DurationMs: finish.Sub(start).Milliseconds(), Passed: passed, AssertMsg: assertMsg, User: task.User, Truncated: capt.Truncated, OutputBytes: capt.Bytes()})
 			}
 		}(mc)
 	}
 	wg.Wait()
-	return nil
 }
 
 // 单机实时流执行帮助：返回完整结果并在过程中使用 chunkCb 回调
 func (s *ExecService) SingleStream(ctx context.Context, m domain.Machine, task domain.ExecTask, secret, authMode string, chunkCb func(int64, []byte, bool)) (domain.ExecResult, error) {
 	timeout := time.Duration(task.Timeout) * time.Second
+	asrt, err := compileAssertions(task.Assertions)
+	if err != nil {
+		return domain.ExecResult{JobID: task.JobID, MachineID: int64(m.ID), IPMIIP: m.IPMIIP, Err: err}, err
+	}
+	if !(domain.User{Groups: task.Groups}).InScope(m) {
+		err := fmt.Errorf("%w: machine %s is outside groups %s", ErrForbidden, m.IPMIIP, strings.Join(task.Groups, ","))
+		return domain.ExecResult{JobID: task.JobID, MachineID: int64(m.ID), IPMIIP: m.IPMIIP, Err: err, User: task.User}, err
+	}
+	capt := s.capture(task)
+	ctx = output.WithCapture(s.hostContext(ctx, task, m), capt)
 	start := time.Now()
 	usedGlobal := false
 	if authMode == "key" && secret == "" && s.globalKeyProvider != nil {
@@ -296,21 +615,27 @@ func (s *ExecService) SingleStream(ctx context.Context, m domain.Machine, task d
 	var stdout, stderr string
 	var code int
 	var exErr error
-	if se, ok := s.executor.(SSHStreamExecutor); ok { // 流式
-		so, er, c, e := se.StreamExec(ctx, m.SSHUser, m.SSHIP, authMode, secret, task.Command, timeout, func(b []byte, isErr bool) {
+	cmd, rErr := s.commandFor(task, m)
+	if rErr != nil {
+		code, exErr = -1, rErr
+	} else if se, ok := s.executor.(SSHStreamExecutor); ok { // 流式
+		so, er, c, e := se.StreamExec(ctx, m.SSHUser, m.SSHIP, authMode, secret, cmd, timeout, func(b []byte, isErr bool) {
 			if chunkCb != nil {
 				chunkCb(int64(m.ID), b, isErr)
 			}
 		})
 		stdout, stderr, code, exErr = so, er, c, e
 	} else { // 回退
-		so, er, c, e := s.executor.Exec(ctx, m.SSHUser, m.SSHIP, authMode, secret, task.Command, timeout)
+		so, er, c, e := s.executor.Exec(ctx, m.SSHUser, m.SSHIP, authMode, secret, cmd, timeout)
 		stdout, stderr, code, exErr = so, er, c, e
 	}
+	stdout, stderr = capt.Fit(stdout, stderr)
 	finish := time.Now()
-	res := domain.ExecResult{MachineID: int64(m.ID), IPMIIP: m.IPMIIP, SSHIP: m.SSHIP, SSHUser: m.SSHUser, Stdout: stdout, Stderr: stderr, ExitCode: code, Err: exErr, UsedGlobalKey: usedGlobal}
+	passed, assertMsg := asrt.evaluate(stdout, code, finish.Sub(start), exErr)
+	res := domain.ExecResult{JobID: task.JobID, MachineID: int64(m.ID), IPMIIP: m.IPMIIP, SSHIP: m.SSHIP, SSHUser: m.SSHUser, Command: cmd, Stdout: stdout, Stderr: stderr, ExitCode: code, Err: exErr, UsedGlobalKey: usedGlobal, Passed: passed, AssertMsg: assertMsg, User: task.User, Truncated: capt.Truncated, OutputBytes: capt.Bytes()}
+	s.observe(res, finish.Sub(start))
 	if s.hWriter != nil {
-		s.hWriter.Write(domain.ExecHistory{MachineID: int64(m.ID), IPMIIP: m.IPMIIP, Command: task.Command, Stdout: stdout, Stderr: stderr, ExitCode: code, ErrorText: errToString(exErr), StartedAt: start, FinishedAt: finish, DurationMs: finish.Sub(start).Milliseconds()})
+		s.hWriter.Write(domain.ExecHistory{JobID: task.JobID, MachineID: int64(m.ID), IPMIIP: m.IPMIIP, Command: cmd, Stdout: stdout, Stderr: stderr, ExitCode: code, ErrorText: errToString(exErr), StartedAt: start, FinishedAt: finish, DurationMs: finish.Sub(start).Milliseconds(), Passed: passed, AssertMsg: assertMsg, User: task.User, Truncated: capt.Truncated, OutputBytes: capt.Bytes()})
 	}
 	return res, nil
 }
@@ -2,6 +2,10 @@ package service
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,12 +22,12 @@ func openMemDB(t *testing.T) *sql.DB {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = db.Exec(`CREATE TABLE machines( id INTEGER PRIMARY KEY AUTOINCREMENT, ipmi_ip TEXT UNIQUE, ssh_ip TEXT, ssh_user TEXT, ssh_key TEXT, remark TEXT, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, zbx_id TEXT );`)
-	if err != nil {
+	// :memory: 每个连接独立，限制单连接保证各 goroutine 看到同一库
+	db.SetMaxOpenConns(1)
+	if err := repository.NewMachineRepo(db).EnsureSchema(); err != nil {
 		t.Fatal(err)
 	}
-	_, err = db.Exec(`CREATE TABLE exec_history( id INTEGER PRIMARY KEY AUTOINCREMENT, machine_id INTEGER, ipmi_ip TEXT, command TEXT, stdout TEXT, stderr TEXT, exit_code INTEGER, error_text TEXT, started_at TIMESTAMP, finished_at TIMESTAMP, duration_ms INTEGER );`)
-	if err != nil {
+	if err := repository.NewHistoryRepo(db).EnsureSchema(); err != nil {
 		t.Fatal(err)
 	}
 	return db
@@ -145,3 +149,337 @@ func TestHistoryRepo_Cleanup(t *testing.T) {
 		t.Fatalf("expected 1 row remained, got %d", len(rows))
 	}
 }
+
+// Test FTS5 search: phrase/prefix/boolean queries, filters, highlighted snippets and index backfill
+func TestHistoryRepo_Search(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	hRepo := repository.NewHistoryRepo(db)
+	now := time.Now()
+	bigOut := strings.Repeat("noise line\n", 1000) + "eth0 link down <driver>\n" // 关键字在预览之外，只在 blob 中
+	rows := []domain.ExecHistory{
+		{MachineID: 1, IPMIIP: "10.0.0.1", Command: "dmesg", Stdout: "kernel: eth0 link down\n", JobID: "j1", StartedAt: now.Add(-48 * time.Hour)},
+		{MachineID: 2, IPMIIP: "10.0.0.2", Command: "dmesg", Stdout: "kernel: eth0 link up\n", JobID: "j1", StartedAt: now.Add(-time.Hour)},
+		{MachineID: 2, IPMIIP: "10.0.0.2", Command: "systemctl status nginx", Stderr: "Connection timeout", ExitCode: 3, JobID: "j2", StartedAt: now},
+		{MachineID: 3, IPMIIP: "10.0.0.3", Command: "journalctl", Stdout: bigOut, JobID: "j2", StartedAt: now},
+	}
+	if err := hRepo.InsertBatch(rows); err != nil {
+		t.Fatal(err)
+	}
+	search := func(q domain.HistorySearch) []domain.HistoryHit {
+		t.Helper()
+		hits, err := hRepo.Search(q)
+		if err != nil {
+			t.Fatalf("search %+v: %v", q, err)
+		}
+		return hits
+	}
+	ids := func(hits []domain.HistoryHit) []int64 {
+		var out []int64
+		for _, h := range hits {
+			out = append(out, h.ID)
+		}
+		return out
+	}
+	if got := ids(search(domain.HistorySearch{Query: `"link down"`})); len(got) != 2 || !slices.Contains(got, rows[0].ID) || !slices.Contains(got, rows[3].ID) {
+		t.Fatalf("phrase: %v", got)
+	}
+	if got := ids(search(domain.HistorySearch{Query: `time*`})); len(got) != 1 || got[0] != rows[2].ID {
+		t.Fatalf("prefix: %v", got)
+	}
+	if got := ids(search(domain.HistorySearch{Query: `eth0 NOT down`})); len(got) != 1 || got[0] != rows[1].ID {
+		t.Fatalf("boolean: %v", got)
+	}
+	if got := search(domain.HistorySearch{Query: `stderr:timeout OR command:journalctl`, Sort: "newest"}); len(got) != 2 || got[0].ID != rows[3].ID {
+		t.Fatalf("column filter: %v", ids(got))
+	}
+
+	// 片段高亮且已转义
+	hit := search(domain.HistorySearch{Query: `driver`})
+	if len(hit) != 1 || !strings.Contains(hit[0].Snippet, "&lt;<mark>driver</mark>&gt;") || !hit[0].Preview {
+		t.Fatalf("snippet: %+v", hit)
+	}
+
+	// 过滤条件
+	code := 3
+	if got := ids(search(domain.HistorySearch{ExitCode: &code})); len(got) != 1 || got[0] != rows[2].ID {
+		t.Fatalf("exit code: %v", got)
+	}
+	if got := ids(search(domain.HistorySearch{Query: "eth0", JobID: "j1", MachineIDs: []int64{2}})); len(got) != 1 || got[0] != rows[1].ID {
+		t.Fatalf("job+machine: %v", got)
+	}
+	if got := ids(search(domain.HistorySearch{Query: "kernel", Since: now.Add(-2 * time.Hour)})); len(got) != 1 || got[0] != rows[1].ID {
+		t.Fatalf("since: %v", got)
+	}
+	if got := ids(search(domain.HistorySearch{Until: now.Add(-2 * time.Hour)})); len(got) != 1 || got[0] != rows[0].ID {
+		t.Fatalf("until: %v", got)
+	}
+	if got := search(domain.HistorySearch{Limit: 2, Offset: 3}); len(got) != 1 || got[0].ID != rows[0].ID {
+		t.Fatalf("paging: %v", ids(got))
+	}
+	for _, q := range []string{`"unterminated`, `a"b`, `eth0 AND`, `(eth0`} {
+		if _, err := hRepo.Search(domain.HistorySearch{Query: q}); !errors.Is(err, repository.ErrInvalidSearchQuery) {
+			t.Fatalf("bad query %q: %v", q, err)
+		}
+	}
+	// 连字符、冒号等普通输入按短语检索，不报语法错误
+	if err := hRepo.InsertBatch([]domain.ExecHistory{{MachineID: 4, IPMIIP: "10.0.0.4", Command: "ipmitool -H 10.0.0.1:623 sol", Stdout: "eth0-up done\n", StartedAt: now}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{"eth0-up", "10.0.0.1:623", "eth0-up 10.0.0.1:623"} {
+		if got := search(domain.HistorySearch{Query: q}); len(got) != 1 || got[0].MachineID != 4 {
+			t.Fatalf("plain query %q: %v", q, ids(got))
+		}
+	}
+
+	// 索引表丢失后重新建表会为已有记录 (含 blob) 补建索引
+	if _, err := db.Exec(`DROP TABLE exec_history_fts`); err != nil {
+		t.Fatal(err)
+	}
+	hRepo = repository.NewHistoryRepo(db)
+	if err := hRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(search(domain.HistorySearch{Query: `"link down"`})); len(got) != 2 {
+		t.Fatalf("after reindex: %v", got)
+	}
+	if err := hRepo.Cleanup(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	var indexed int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM exec_history_fts`).Scan(&indexed); err != nil || indexed != 1 {
+		t.Fatalf("index rows after cleanup %d, %v", indexed, err)
+	}
+}
+
+// Test capture limits, head/tail truncation and compressed output blobs with lazy loading
+func TestExecService_LargeOutput(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	repo := repository.NewMachineRepo(db)
+	m := domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root"}
+	if err := repo.Save(&m); err != nil {
+		t.Fatal(err)
+	}
+	hRepo := repository.NewHistoryRepo(db)
+	hWriter := NewHistoryWriter(hRepo, 60, 10)
+
+	var sb strings.Builder
+	for i := 0; sb.Len() < 200<<10; i++ {
+		fmt.Fprintf(&sb, "line %06d kernel: something happened\n", i)
+	}
+	big := sb.String()
+	mock := sshmock.NewMockExecutor()
+	mock.Set("journalctl", sshmock.MockResult{Stdout: big, Stderr: "warning\n"})
+	mock.Set("dmesg", sshmock.MockResult{Stdout: strings.Repeat("x", 8<<10)})
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up 1 day\n"})
+	svc := NewExecService(repo, hWriter, mock, 2)
+	svc.SetOutputLimit(64 << 10)
+	ids := []int64{int64(m.ID)}
+
+	res, err := svc.BatchExec(domain.ExecTask{Command: "journalctl", Timeout: 5, MachineIDs: ids, MaxOutput: 16 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := res[0]
+	if !r.Truncated || r.OutputBytes != int64(len(big)+len("warning\n")) || r.Stderr != "warning\n" {
+		t.Fatalf("result truncated=%v bytes=%d stderr=%q", r.Truncated, r.OutputBytes, r.Stderr)
+	}
+	if len(r.Stdout) > 17<<10 || !strings.HasPrefix(r.Stdout, "line 000000") || !strings.HasSuffix(r.Stdout, big[len(big)-100:]) || !strings.Contains(r.Stdout, "[truncated") {
+		t.Fatalf("stdout not head/tail truncated: %d bytes", len(r.Stdout))
+	}
+	// 未超过默认上限：不截断，但超过内联大小，存为压缩 blob
+	if res, _ := svc.BatchExec(domain.ExecTask{Command: "dmesg", Timeout: 5, MachineIDs: ids}); res[0].Truncated || len(res[0].Stdout) != 8<<10 {
+		t.Fatalf("dmesg should not be truncated: %v %d", res[0].Truncated, len(res[0].Stdout))
+	}
+	if _, err := svc.BatchExec(domain.ExecTask{Command: "uptime", Timeout: 5, MachineIDs: ids}); err != nil {
+		t.Fatal(err)
+	}
+	hWriter.Close()
+
+	rows, err := hRepo.ListRecent(10)
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("history %d rows, %v", len(rows), err)
+	}
+	small, dmesg, journal := rows[0], rows[1], rows[2]
+	if small.Preview || small.Stdout != "up 1 day\n" || small.OutputBytes != 9 {
+		t.Fatalf("small output row %+v", small)
+	}
+	if !journal.Preview || !journal.Truncated || journal.OutputBytes != r.OutputBytes || len(journal.Stdout) > 1<<10 {
+		t.Fatalf("large output row preview=%v truncated=%v bytes=%d len=%d", journal.Preview, journal.Truncated, journal.OutputBytes, len(journal.Stdout))
+	}
+	full, err := hRepo.GetOutput(journal.ID)
+	if err != nil || full.Stdout != r.Stdout || full.Stderr != "warning\n" || !full.Truncated {
+		t.Fatalf("full output: %v len=%d", err, len(full.Stdout))
+	}
+	if o, err := hRepo.GetOutput(dmesg.ID); err != nil || len(o.Stdout) != 8<<10 || o.Truncated {
+		t.Fatalf("dmesg output: %v len=%d", err, len(o.Stdout))
+	}
+	if _, err := hRepo.GetOutput(999); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("missing row: %v", err)
+	}
+
+	// 裁剪历史时一并删除对应的 blob
+	if err := hRepo.Cleanup(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	var blobs int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM exec_output`).Scan(&blobs); err != nil || blobs != 0 {
+		t.Fatalf("orphan blobs %d, %v", blobs, err)
+	}
+}
+
+// Test per-host command rendering with machine facts and custom attrs
+func TestExecService_RenderPerHost(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	repo := repository.NewMachineRepo(db)
+	m1 := domain.Machine{IPMIIP: "10.0.1.1", SSHIP: "10.0.0.1", SSHUser: "root", Attrs: map[string]string{"hostname": "node-a"}}
+	m2 := domain.Machine{IPMIIP: "10.0.1.2", SSHIP: "10.0.0.2", SSHUser: "root"}
+	if err := repo.Save(&m1); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(&m2); err != nil {
+		t.Fatal(err)
+	}
+	hRepo := repository.NewHistoryRepo(db)
+	hWriter := NewHistoryWriter(hRepo, 1, 10)
+	mock := sshmock.NewMockExecutor()
+	mock.Set("set-hostname node-a 10.0.1.1", sshmock.MockResult{ExitCode: 0})
+	svc := NewExecService(repo, hWriter, mock, 2)
+	res, err := svc.BatchExec(domain.ExecTask{Command: "set-hostname {{.hostname}} {{.ipmi_ip}}", Render: true, Timeout: 5, MachineIDs: []int64{int64(m1.ID), int64(m2.ID)}})
+	if err != nil {
+		t.Fatalf("exec error: %v", err)
+	}
+	for _, r := range res {
+		switch r.MachineID {
+		case int64(m1.ID):
+			if r.Command != "set-hostname node-a 10.0.1.1" || r.Err != nil || r.ExitCode != 0 {
+				t.Fatalf("unexpected rendered result: %+v", r)
+			}
+		case int64(m2.ID):
+			if r.Err == nil {
+				t.Fatalf("expected missing attr error for m2")
+			}
+		}
+	}
+	hWriter.Close()
+	rows, err := hRepo.ListFiltered(10, "10.0.1.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Command != "set-hostname node-a 10.0.1.1" {
+		t.Fatalf("history should store rendered command, got %+v", rows)
+	}
+}
+
+// Test commands are sent literally unless rendering is requested, and {{"{{"}} escapes in templates
+func TestExecService_RenderOptIn(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	repo := repository.NewMachineRepo(db)
+	m := domain.Machine{IPMIIP: "10.0.1.1", SSHIP: "10.0.0.1", SSHUser: "root"}
+	if err := repo.Save(&m); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewExecService(repo, nil, sshmock.NewMockExecutor(), 1)
+	literal := `docker inspect -f '{{.State.Status}}' web`
+	res, err := svc.BatchExec(domain.ExecTask{Command: literal, Timeout: 5, MachineIDs: []int64{int64(m.ID)}})
+	if err != nil || res[0].Err != nil || res[0].Command != literal {
+		t.Fatalf("literal command: %+v %v", res, err)
+	}
+	res, err = svc.BatchExec(domain.ExecTask{Command: `docker inspect -f '{{"{{"}}.State.Status}}' {{.ipmi_ip}}`, Render: true, Timeout: 5, MachineIDs: []int64{int64(m.ID)}})
+	if err != nil || res[0].Err != nil || res[0].Command != `docker inspect -f '{{.State.Status}}' 10.0.1.1` {
+		t.Fatalf("escaped template: %+v %v", res, err)
+	}
+}
+
+// Test selector targets are resolved at execution time and merged with MachineIDs
+func TestExecService_SelectorTargets(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	repo := repository.NewMachineRepo(db)
+	ms := []domain.Machine{
+		{IPMIIP: "10.0.2.1", SSHIP: "10.0.2.1", SSHUser: "root", Labels: map[string]string{"rack": "A12", "role": "web"}},
+		{IPMIIP: "10.0.2.2", SSHIP: "10.0.2.2", SSHUser: "root", Labels: map[string]string{"rack": "A12", "role": "db"}},
+		{IPMIIP: "10.0.2.3", SSHIP: "10.0.2.3", SSHUser: "root", Labels: map[string]string{"rack": "B01"}},
+	}
+	if err := repo.BulkUpsert(ms); err != nil {
+		t.Fatal(err)
+	}
+	mock := sshmock.NewMockExecutor()
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up\n"})
+	svc := NewExecService(repo, nil, mock, 2)
+	res, err := svc.BatchExec(domain.ExecTask{Command: "uptime", Timeout: 5, MachineIDs: []int64{int64(ms[0].ID), int64(ms[2].ID)}, Selector: "rack=A12"})
+	if err != nil {
+		t.Fatalf("exec error: %v", err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("expect 3 deduplicated targets got %d", len(res))
+	}
+	if _, err := svc.BatchExec(domain.ExecTask{Command: "uptime", Selector: "rack=Z99"}); err == nil {
+		t.Fatalf("expected no machines error for empty selection")
+	}
+}
+
+// Test task assertions decide pass/fail per host and are written to history
+func TestExecService_Assertions(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	repo := repository.NewMachineRepo(db)
+	m1 := domain.Machine{IPMIIP: "10.0.3.1", SSHIP: "10.0.3.1", SSHUser: "root", Attrs: map[string]string{"probe": "ok"}}
+	m2 := domain.Machine{IPMIIP: "10.0.3.2", SSHIP: "10.0.3.2", SSHUser: "root", Attrs: map[string]string{"probe": "bad"}}
+	for _, m := range []*domain.Machine{&m1, &m2} {
+		if err := repo.Save(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hRepo := repository.NewHistoryRepo(db)
+	hWriter := NewHistoryWriter(hRepo, 1, 10)
+	mock := sshmock.NewMockExecutor()
+	mock.Set("check ok", sshmock.MockResult{Stdout: `{"status":{"health":"green"},"disks":[{"state":"online"}]}`})
+	mock.Set("check bad", sshmock.MockResult{Stdout: `{"status":{"health":"red"},"disks":[{"state":"failed"}]} ERROR`, ExitCode: 2})
+	svc := NewExecService(repo, hWriter, mock, 2)
+	task := domain.ExecTask{Command: "check {{.probe}}", Render: true, Timeout: 5, MachineIDs: []int64{int64(m1.ID), int64(m2.ID)}, Assertions: &domain.Assertions{
+		ExitCodes:      []int{0, 1},
+		StdoutNotMatch: []string{"ERROR"},
+		JSONEquals:     map[string]string{"status.health": "green", "disks[0].state": "online"},
+	}}
+	res, err := svc.BatchExec(task)
+	if err != nil {
+		t.Fatalf("exec error: %v", err)
+	}
+	for _, r := range res {
+		if r.MachineID == int64(m1.ID) && (!r.Passed || r.AssertMsg != "") {
+			t.Fatalf("m1 should pass: %+v", r)
+		}
+		if r.MachineID == int64(m2.ID) {
+			if r.Passed {
+				t.Fatalf("m2 should fail")
+			}
+			for _, want := range []string{"exit code 2", "forbidden", "not valid JSON"} {
+				if !strings.Contains(r.AssertMsg, want) {
+					t.Fatalf("assert msg %q missing %q", r.AssertMsg, want)
+				}
+			}
+		}
+	}
+	hWriter.Close()
+	rows, err := hRepo.ListRecent(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passed := 0
+	for _, h := range rows {
+		if h.Passed {
+			passed++
+		}
+	}
+	if len(rows) != 2 || passed != 1 {
+		t.Fatalf("expected 1 of 2 passed rows in history, got %d of %d", passed, len(rows))
+	}
+	task.Assertions = &domain.Assertions{StdoutMatch: []string{"("}}
+	if _, err := svc.BatchExec(task); err == nil {
+		t.Fatalf("expected invalid regex error")
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// historyJournal 历史写入失败 (或队列长时间满) 时的落盘日志：每行一条 JSON，追加后 fsync；
+// 重放成功的记录从文件中移除。应用与 ipmictl 共用同一文件，读写时持有 <path>.lock 上的进程间锁
+type historyJournal struct {
+	path    string
+	mu      sync.Mutex
+	f       *os.File
+	lock    *os.File // 进程间锁文件：日志文件会被改名替换，锁不能加在它上面
+	pending int64    // 文件中的有效记录数 (其他进程的追加在下次读取时计入)
+}
+
+// journalRecord 日志中的一条记录；Attempts 为因非忙错误重放失败的次数
+type journalRecord struct {
+	domain.ExecHistory
+	Attempts int `json:"replay_attempts,omitempty"`
+}
+
+func openHistoryJournal(path string) (*historyJournal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	j := &historyJournal{path: path, lock: lock}
+	if err := j.reopen(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	release, err := j.acquire()
+	if err != nil {
+		j.f.Close()
+		lock.Close()
+		return nil, err
+	}
+	defer release()
+	list, _, err := j.load()
+	if err != nil {
+		j.f.Close()
+		lock.Close()
+		return nil, err
+	}
+	j.pending = int64(len(list))
+	return j, nil
+}
+
+func (j *historyJournal) reopen() error {
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	j.f = f
+	return nil
+}
+
+// acquire 获取进程间锁；文件已被其他进程改名替换时重新打开，保证追加写入当前文件。调用方持有 j.mu
+func (j *historyJournal) acquire() (release func(), err error) {
+	if err := lockFile(j.lock); err != nil {
+		return nil, err
+	}
+	release = func() { _ = unlockFile(j.lock) }
+	cur, err := os.Stat(j.path)
+	if fi, ferr := j.f.Stat(); err != nil || ferr != nil || !os.SameFile(cur, fi) {
+		j.f.Close()
+		if err := j.reopen(); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// append 追加并落盘；调用方持有 j.mu
+func (j *historyJournal) append(hs []domain.ExecHistory) error {
+	release, err := j.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, h := range hs {
+		h.ID = 0
+		if err := enc.Encode(h); err != nil {
+			return err
+		}
+	}
+	if _, err := j.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.pending += int64(len(hs))
+	return nil
+}
+
+// load 读取全部记录；崩溃时写了一半的行无法解析，计入 skipped 并跳过。调用方持有 j.mu 与进程间锁
+func (j *historyJournal) load() (list []journalRecord, skipped int, err error) {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), len(data)+1)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			skipped++
+			continue
+		}
+		list = append(list, rec)
+	}
+	return list, skipped, sc.Err()
+}
+
+// rewrite 以 rest 替换文件内容 (先写临时文件再改名，避免中途崩溃丢失)；调用方持有 j.mu 与进程间锁
+func (j *historyJournal) rewrite(rest []journalRecord) error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := writeRecords(f, rest); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	j.f.Close()
+	if err := os.Rename(tmp, j.path); err != nil {
+		_ = j.reopen()
+		return err
+	}
+	j.pending = int64(len(rest))
+	return j.reopen()
+}
+
+// reject 把多次重放仍失败的记录追加到 <path>.rejected，留待人工处理；调用方持有 j.mu 与进程间锁
+func (j *historyJournal) reject(recs []journalRecord) error {
+	f, err := os.OpenFile(j.path+".rejected", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := writeRecords(f, recs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeRecords 逐行写入并 fsync
+func writeRecords(f *os.File, recs []journalRecord) error {
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (j *historyJournal) Pending() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pending
+}
+
+// needsReplay 本进程记录有待重放的条数，或文件非空 (可能是其他进程落盘的记录)
+func (j *historyJournal) needsReplay() bool {
+	if j.Pending() > 0 {
+		return true
+	}
+	fi, err := os.Stat(j.path)
+	return err == nil && fi.Size() > 0
+}
+
+func (j *historyJournal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.lock.Close()
+	return j.f.Close()
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package service
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile 阻塞获取进程间排他锁 (flock)
+func lockFile(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func unlockFile(f *os.File) error { return syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }
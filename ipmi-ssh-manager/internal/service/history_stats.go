@@ -0,0 +1,313 @@
+package service
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+const (
+	// DefaultStatsWindow 未指定 Since 时统计的时间范围
+	DefaultStatsWindow = 7 * 24 * time.Hour
+	defaultStatsTop    = 10
+	// maxTrendBuckets 趋势时间桶上限，按小时超过时改为按天
+	maxTrendBuckets = 24 * 62
+
+	// 抖动判定：同一命令在同一台机器上成功 / 失败交替出现
+	flakyMinRuns     = 4
+	flakyMinFlipRate = 0.3
+)
+
+// RunStats 一组执行的汇总
+type RunStats struct {
+	Runs        int     `json:"runs"`
+	Passed      int     `json:"passed"`
+	Failed      int     `json:"failed"`
+	Timeouts    int     `json:"timeouts"`
+	SuccessRate float64 `json:"success_rate"` // 0~1，无执行时为 0
+	P50Ms       int64   `json:"p50_ms"`
+	P95Ms       int64   `json:"p95_ms"`
+	MaxMs       int64   `json:"max_ms"`
+}
+
+// HostStats 单台机器的执行统计
+type HostStats struct {
+	MachineID int64  `json:"machine_id"`
+	IPMIIP    string `json:"ipmi_ip"`
+	RunStats
+	Flips       int        `json:"flips"`     // 同一命令相邻两次结果不同的次数
+	FlipRate    float64    `json:"flip_rate"` // Flips / 可比较的相邻次数
+	Flaky       bool       `json:"flaky"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+}
+
+// CommandStats 单条命令的执行统计
+type CommandStats struct {
+	Command string `json:"command"`
+	RunStats
+	Hosts int `json:"hosts"` // 执行过的机器数
+}
+
+// HistoryAnalytics 一个时间范围内的历史统计
+type HistoryAnalytics struct {
+	Since        time.Time      `json:"since"`
+	Until        time.Time      `json:"until"`
+	Overall      RunStats       `json:"overall"`
+	Hosts        []HostStats    `json:"hosts"`         // 全部机器，成功率升序 (最差在前)
+	Slowest      []HostStats    `json:"slowest"`       // p95 耗时降序前 Top
+	MostTimeouts []HostStats    `json:"most_timeouts"` // 超时次数降序前 Top (只含有超时的机器)
+	Flaky        []HostStats    `json:"flaky"`         // 判定为抖动的机器，FlipRate 降序
+	Commands     []CommandStats `json:"commands"`      // 失败次数降序前 Top
+}
+
+// TrendSeries 趋势中的一条序列，各数组与 HistoryTrend.Times 一一对应；无执行的时间桶为 null
+type TrendSeries struct {
+	Key         string     `json:"key"`   // all / 机器 ID / 命令
+	Label       string     `json:"label"` // 显示名 (IPMI IP / 命令)
+	Runs        []int      `json:"runs"`
+	Failed      []int      `json:"failed"`
+	Timeouts    []int      `json:"timeouts"`
+	SuccessRate []*float64 `json:"success_rate"`
+	P50Ms       []*int64   `json:"p50_ms"`
+	P95Ms       []*int64   `json:"p95_ms"`
+}
+
+// HistoryTrend 按时间桶聚合的趋势 (可直接作为图表数据)
+type HistoryTrend struct {
+	Bucket string        `json:"bucket"` // hour | day
+	Times  []time.Time   `json:"times"`  // 各时间桶起点 (x 轴)
+	Series []TrendSeries `json:"series"` // 分组时按执行次数降序取前 Top
+}
+
+// NormalizeStatsQuery 补齐默认时间范围、粒度与条数
+func NormalizeStatsQuery(q domain.HistoryStatsQuery, now time.Time) domain.HistoryStatsQuery {
+	if q.Until.IsZero() {
+		q.Until = now
+	}
+	if q.Since.IsZero() {
+		q.Since = q.Until.Add(-DefaultStatsWindow)
+	}
+	if q.Top <= 0 {
+		q.Top = defaultStatsTop
+	}
+	if q.Bucket != "hour" && q.Bucket != "day" {
+		q.Bucket = "day"
+		if q.Until.Sub(q.Since) <= 48*time.Hour {
+			q.Bucket = "hour"
+		}
+	}
+	if q.Bucket == "hour" && q.Until.Sub(q.Since) > maxTrendBuckets*time.Hour {
+		q.Bucket = "day"
+	}
+	return q
+}
+
+// IsTimeout 历史中的错误文本是否为超时 (执行超时或连接超时)
+func IsTimeout(errText string) bool {
+	return strings.Contains(errText, "deadline exceeded") || strings.Contains(errText, "timeout")
+}
+
+// runAcc 累计一组执行，finish 时计算成功率与分位数
+type runAcc struct {
+	stats RunStats
+	durs  []int64
+}
+
+func (a *runAcc) add(s domain.HistorySample) {
+	a.stats.Runs++
+	if s.Passed {
+		a.stats.Passed++
+	} else {
+		a.stats.Failed++
+	}
+	if IsTimeout(s.ErrorText) {
+		a.stats.Timeouts++
+	}
+	a.durs = append(a.durs, s.DurationMs)
+}
+
+func (a *runAcc) finish() RunStats {
+	st := a.stats
+	if st.Runs == 0 {
+		return st
+	}
+	st.SuccessRate = float64(st.Passed) / float64(st.Runs)
+	slices.Sort(a.durs)
+	st.P50Ms, st.P95Ms, st.MaxMs = percentile(a.durs, 0.50), percentile(a.durs, 0.95), a.durs[len(a.durs)-1]
+	return st
+}
+
+// percentile 最近秩法，sorted 须已升序且非空
+func percentile(sorted []int64, p float64) int64 {
+	i := int(p*float64(len(sorted))+0.999999) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+// AnalyzeHistory 按机器与命令汇总成功率、耗时分位数、超时次数并识别抖动机器；samples 须按时间正序
+func AnalyzeHistory(samples []domain.HistorySample, q domain.HistoryStatsQuery) HistoryAnalytics {
+	type hostAcc struct {
+		runAcc
+		ip           string
+		last         map[string]bool // 命令 -> 上一次是否成功
+		flips, pairs int
+		lastFailure  time.Time
+	}
+	type cmdAcc struct {
+		runAcc
+		hosts map[int64]struct{}
+	}
+	var (
+		all   runAcc
+		hosts = map[int64]*hostAcc{}
+		cmds  = map[string]*cmdAcc{}
+	)
+	for _, s := range samples {
+		all.add(s)
+		h := hosts[s.MachineID]
+		if h == nil {
+			h = &hostAcc{ip: s.IPMIIP, last: map[string]bool{}}
+			hosts[s.MachineID] = h
+		}
+		h.add(s)
+		if prev, ok := h.last[s.Command]; ok {
+			h.pairs++
+			if prev != s.Passed {
+				h.flips++
+			}
+		}
+		h.last[s.Command] = s.Passed
+		if !s.Passed && s.StartedAt.After(h.lastFailure) {
+			h.lastFailure = s.StartedAt
+		}
+		c := cmds[s.Command]
+		if c == nil {
+			c = &cmdAcc{hosts: map[int64]struct{}{}}
+			cmds[s.Command] = c
+		}
+		c.add(s)
+		c.hosts[s.MachineID] = struct{}{}
+	}
+
+	out := HistoryAnalytics{Since: q.Since, Until: q.Until, Overall: all.finish(), Hosts: []HostStats{}, Slowest: []HostStats{}, MostTimeouts: []HostStats{}, Flaky: []HostStats{}, Commands: []CommandStats{}}
+	for id, h := range hosts {
+		hs := HostStats{MachineID: id, IPMIIP: h.ip, RunStats: h.finish(), Flips: h.flips}
+		if h.pairs > 0 {
+			hs.FlipRate = float64(h.flips) / float64(h.pairs)
+		}
+		hs.Flaky = hs.Passed > 0 && hs.Failed > 0 && hs.Runs >= flakyMinRuns && hs.FlipRate >= flakyMinFlipRate
+		if !h.lastFailure.IsZero() {
+			t := h.lastFailure
+			hs.LastFailure = &t
+		}
+		out.Hosts = append(out.Hosts, hs)
+	}
+	byID := func(a, b HostStats) int { return cmp.Compare(a.MachineID, b.MachineID) }
+	sortHosts := func(list []HostStats, key func(a, b HostStats) int) []HostStats {
+		list = slices.Clone(list)
+		slices.SortStableFunc(list, func(a, b HostStats) int { return cmp.Or(key(a, b), byID(a, b)) })
+		return list
+	}
+	out.Hosts = sortHosts(out.Hosts, func(a, b HostStats) int {
+		return cmp.Or(cmp.Compare(a.SuccessRate, b.SuccessRate), cmp.Compare(b.Failed, a.Failed))
+	})
+	out.Slowest = sortHosts(out.Hosts, func(a, b HostStats) int { return cmp.Compare(b.P95Ms, a.P95Ms) })
+	out.Slowest = out.Slowest[:min(q.Top, len(out.Slowest))]
+	for _, h := range sortHosts(out.Hosts, func(a, b HostStats) int { return cmp.Compare(b.Timeouts, a.Timeouts) }) {
+		if h.Timeouts == 0 || len(out.MostTimeouts) >= q.Top {
+			break
+		}
+		out.MostTimeouts = append(out.MostTimeouts, h)
+	}
+	for _, h := range sortHosts(out.Hosts, func(a, b HostStats) int { return cmp.Compare(b.FlipRate, a.FlipRate) }) {
+		if h.Flaky {
+			out.Flaky = append(out.Flaky, h)
+		}
+	}
+	for name, c := range cmds {
+		out.Commands = append(out.Commands, CommandStats{Command: name, RunStats: c.finish(), Hosts: len(c.hosts)})
+	}
+	slices.SortFunc(out.Commands, func(a, b CommandStats) int {
+		return cmp.Or(cmp.Compare(b.Failed, a.Failed), cmp.Compare(b.Runs, a.Runs), strings.Compare(a.Command, b.Command))
+	})
+	out.Commands = out.Commands[:min(q.Top, len(out.Commands))]
+	return out
+}
+
+// bucketStart 时间所在桶的起点 (本地时间)
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.In(time.Local)
+	if bucket == "hour" {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.Local)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	if bucket == "hour" {
+		return t.Add(time.Hour)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// BuildHistoryTrend 按时间桶 (及可选的机器 / 命令分组) 汇总执行；q 须经 NormalizeStatsQuery 处理
+func BuildHistoryTrend(samples []domain.HistorySample, q domain.HistoryStatsQuery) HistoryTrend {
+	tr := HistoryTrend{Bucket: q.Bucket, Times: []time.Time{}, Series: []TrendSeries{}}
+	index := map[time.Time]int{}
+	for t := bucketStart(q.Since, q.Bucket); t.Before(q.Until); t = nextBucket(t, q.Bucket) {
+		index[t] = len(tr.Times)
+		tr.Times = append(tr.Times, t)
+	}
+	type group struct {
+		key, label string
+		runs       int
+		buckets    []runAcc
+	}
+	groups := map[string]*group{}
+	var order []*group
+	if q.GroupBy == "" { // 不分组时总有一条整体序列
+		g := &group{key: "all", label: "全部", buckets: make([]runAcc, len(tr.Times))}
+		groups[g.key], order = g, append(order, g)
+	}
+	for _, s := range samples {
+		i, ok := index[bucketStart(s.StartedAt, q.Bucket)]
+		if !ok {
+			continue
+		}
+		key, label := "all", "全部"
+		switch q.GroupBy {
+		case "machine":
+			key, label = strconv.FormatInt(s.MachineID, 10), s.IPMIIP
+		case "command":
+			key, label = s.Command, s.Command
+		}
+		g := groups[key]
+		if g == nil {
+			g = &group{key: key, label: label, buckets: make([]runAcc, len(tr.Times))}
+			groups[key] = g
+			order = append(order, g)
+		}
+		g.runs++
+		g.buckets[i].add(s)
+	}
+	slices.SortStableFunc(order, func(a, b *group) int { return cmp.Compare(b.runs, a.runs) })
+	for _, g := range order[:min(q.Top, len(order))] {
+		ts := TrendSeries{Key: g.key, Label: g.label}
+		for i := range g.buckets {
+			st := g.buckets[i].finish()
+			ts.Runs = append(ts.Runs, st.Runs)
+			ts.Failed = append(ts.Failed, st.Failed)
+			ts.Timeouts = append(ts.Timeouts, st.Timeouts)
+			if st.Runs == 0 {
+				ts.SuccessRate, ts.P50Ms, ts.P95Ms = append(ts.SuccessRate, nil), append(ts.P50Ms, nil), append(ts.P95Ms, nil)
+				continue
+			}
+			ts.SuccessRate, ts.P50Ms, ts.P95Ms = append(ts.SuccessRate, &st.SuccessRate), append(ts.P50Ms, &st.P50Ms), append(ts.P95Ms, &st.P95Ms)
+		}
+		tr.Series = append(tr.Series, ts)
+	}
+	return tr
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+)
+
+func TestAnalyzeHistory_HostsAndTrend(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	hRepo := repository.NewHistoryRepo(db)
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)
+	var rows []domain.ExecHistory
+	add := func(mid int64, ip, cmd string, at time.Time, ms int64, ok bool, errText string) {
+		code := 0
+		if !ok && errText == "" {
+			code = 1
+		}
+		rows = append(rows, domain.ExecHistory{MachineID: mid, IPMIIP: ip, Command: cmd, StartedAt: at, FinishedAt: at, DurationMs: ms, Passed: ok, ExitCode: code, ErrorText: errText})
+	}
+	for i := range 10 {
+		at := day.Add(time.Duration(i) * time.Hour)
+		add(1, "10.0.0.1", "uptime", at, int64(100+i), true, "")          // 稳定
+		add(2, "10.0.0.2", "uptime", at, int64(1000*(i+1)), i%2 == 0, "") // 成败交替，且越来越慢
+		if i < 7 {
+			add(3, "10.0.0.3", "uptime", at, 30000, true, "")
+		} else { // 最后连续超时
+			add(3, "10.0.0.3", "uptime", at, 30000, false, "context deadline exceeded")
+		}
+	}
+	add(1, "10.0.0.1", "dmesg", day.Add(-24*time.Hour), 50, false, "") // 范围外
+	if err := hRepo.InsertBatch(rows); err != nil {
+		t.Fatal(err)
+	}
+
+	q := NormalizeStatsQuery(domain.HistoryStatsQuery{Since: day, Until: day.Add(24 * time.Hour), Top: 2}, time.Now())
+	if q.Bucket != "hour" {
+		t.Fatalf("bucket for 24h = %s", q.Bucket)
+	}
+	var samples []domain.HistorySample
+	if err := hRepo.WalkSamples(q, func(s domain.HistorySample) error { samples = append(samples, s); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 30 {
+		t.Fatalf("window samples = %d", len(samples))
+	}
+
+	a := AnalyzeHistory(samples, q)
+	if a.Overall.Runs != 30 || a.Overall.Failed != 8 || a.Overall.Timeouts != 3 {
+		t.Fatalf("overall %+v", a.Overall)
+	}
+	if len(a.Hosts) != 3 || a.Hosts[0].MachineID != 2 || a.Hosts[0].SuccessRate != 0.5 || a.Hosts[2].MachineID != 1 {
+		t.Fatalf("hosts by success rate %+v", a.Hosts)
+	}
+	if len(a.Slowest) != 2 || a.Slowest[0].MachineID != 3 || a.Slowest[1].P95Ms != 10000 || a.Slowest[1].P50Ms != 5000 {
+		t.Fatalf("slowest %+v", a.Slowest)
+	}
+	if len(a.MostTimeouts) != 1 || a.MostTimeouts[0].MachineID != 3 || a.MostTimeouts[0].Timeouts != 3 {
+		t.Fatalf("timeouts %+v", a.MostTimeouts)
+	}
+	// 机器 3 只在最后连续失败 (一次翻转)，不算抖动
+	if len(a.Flaky) != 1 || a.Flaky[0].MachineID != 2 || a.Flaky[0].FlipRate != 1 || a.Flaky[0].LastFailure == nil {
+		t.Fatalf("flaky %+v", a.Flaky)
+	}
+	if len(a.Commands) != 1 || a.Commands[0].Command != "uptime" || a.Commands[0].Hosts != 3 {
+		t.Fatalf("commands %+v", a.Commands)
+	}
+
+	tr := BuildHistoryTrend(samples, q)
+	if len(tr.Times) != 24 || len(tr.Series) != 1 || tr.Series[0].Key != "all" {
+		t.Fatalf("trend %d buckets, %d series", len(tr.Times), len(tr.Series))
+	}
+	s := tr.Series[0]
+	if s.Runs[0] != 3 || *s.SuccessRate[1] != 2.0/3 || s.SuccessRate[23] != nil || s.Runs[23] != 0 {
+		t.Fatalf("overall series runs=%v", s.Runs)
+	}
+	q.GroupBy = "machine"
+	tr = BuildHistoryTrend(samples, q)
+	if len(tr.Series) != 2 || tr.Series[0].Label != "10.0.0.1" {
+		t.Fatalf("grouped series %+v", tr.Series)
+	}
+	if NormalizeStatsQuery(domain.HistoryStatsQuery{}, time.Now()).Bucket != "day" {
+		t.Fatal("default 7-day window should bucket by day")
+	}
+}
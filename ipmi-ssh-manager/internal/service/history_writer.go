@@ -1,22 +1,58 @@
 package service
 
 import (
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/logging"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
 )
 
+// HistoryWriter 异步批量写入执行历史。
+// 队列满时 Write 阻塞等待 (背压)，超过 blockTimeout 仍无空位则写入落盘日志 (journal)；
+// 批量写库在单个事务中完成，SQLITE_BUSY 时退避重试，仍失败的批次同样落盘，之后自动重放。
+// 只有未配置 journal 或落盘失败时才会丢失记录 (计入 Dropped / Failed)。
 type HistoryWriter struct {
 	repo          repository.HistoryRepoIface
 	ch            chan domain.ExecHistory
 	stop          chan struct{}
 	flushInterval time.Duration
 	batchSize     int
+	blockTimeout  time.Duration
 	wg            sync.WaitGroup
+	log           *slog.Logger
+	journal       atomic.Pointer[historyJournal]
+
+	closeMu sync.RWMutex // Write 持读锁发送，Close 持写锁标记关闭，保证关闭后不再入队
+	closed  bool
+
+	written, failed, dropped, spilled, replayed, retries atomic.Int64
 }
 
+// HistoryWriterStats 写入队列统计
+type HistoryWriterStats struct {
+	QueueDepth     int   `json:"queue_depth"`     // 队列中待写入条数
+	QueueCapacity  int   `json:"queue_capacity"`  // 队列容量
+	Written        int64 `json:"written"`         // 累计写入成功 (含重放)
+	Failed         int64 `json:"failed"`          // 累计写库失败且无法落盘而丢失
+	Dropped        int64 `json:"dropped"`         // 累计因队列满且无法落盘而丢失
+	Spilled        int64 `json:"spilled"`         // 累计写入落盘日志
+	Replayed       int64 `json:"replayed"`        // 累计从落盘日志重放入库
+	Retries        int64 `json:"retries"`         // 累计因数据库忙重试次数
+	JournalPending int64 `json:"journal_pending"` // 落盘日志中待重放条数
+	JournalEnabled bool  `json:"journal_enabled"`
+}
+
+const (
+	defaultHistoryBlockTimeout = 2 * time.Second
+	historyBusyRetries         = 5
+	historyRetryBaseDelay      = 50 * time.Millisecond
+	historyReplayMaxAttempts   = 3 // 同一条记录因非忙错误重放失败的次数上限，达到后移入 <journal>.rejected
+)
+
 func NewHistoryWriter(repo repository.HistoryRepoIface, flushSec int, batchSize int) *HistoryWriter {
 	if flushSec <= 0 {
 		flushSec = 2
@@ -24,22 +60,40 @@ func NewHistoryWriter(repo repository.HistoryRepoIface, flushSec int, batchSize
 	if batchSize <= 0 {
 		batchSize = 20
 	}
-	hw := &HistoryWriter{repo: repo, ch: make(chan domain.ExecHistory, batchSize*4), stop: make(chan struct{}), flushInterval: time.Duration(flushSec) * time.Second, batchSize: batchSize}
+	hw := &HistoryWriter{repo: repo, ch: make(chan domain.ExecHistory, batchSize*4), stop: make(chan struct{}), flushInterval: time.Duration(flushSec) * time.Second, batchSize: batchSize, blockTimeout: defaultHistoryBlockTimeout, log: slog.Default()}
 	hw.wg.Add(1)
 	go hw.loop()
 	return hw
 }
 
+// SetLogger 设置日志 (写库失败、落盘、丢失时记录)；需在写入前设置
+func (w *HistoryWriter) SetLogger(l *slog.Logger) { w.log = l }
+
+// SetBlockTimeout 队列满时 Write 最长等待时间 (<=0 不等待，直接落盘)；需在写入前设置
+func (w *HistoryWriter) SetBlockTimeout(d time.Duration) { w.blockTimeout = d }
+
+// SetJournal 启用落盘日志 (如 <DataDir>/history.journal) 并同步重放上次遗留的记录，返回重放条数
+func (w *HistoryWriter) SetJournal(path string) (int, error) {
+	j, err := openHistoryJournal(path)
+	if err != nil {
+		return 0, err
+	}
+	if old := w.journal.Swap(j); old != nil {
+		_ = old.close()
+	}
+	if !j.needsReplay() {
+		return 0, nil
+	}
+	return w.replay(j, false)
+}
+
 func (w *HistoryWriter) loop() {
 	defer w.wg.Done()
 	ticker := time.NewTicker(w.flushInterval)
 	defer ticker.Stop()
 	batch := make([]domain.ExecHistory, 0, w.batchSize)
 	flush := func() {
-		for i := range batch {
-			h := batch[i]
-			_ = w.repo.Insert(&h)
-		}
+		w.flush(batch)
 		batch = batch[:0]
 	}
 	for {
@@ -52,8 +106,23 @@ func (w *HistoryWriter) loop() {
 		case <-ticker.C:
 			if len(batch) > 0 {
 				flush()
+			} else if j := w.journal.Load(); j != nil && j.needsReplay() {
+				_, _ = w.replay(j, false)
 			}
 		case <-w.stop:
+			// Close 之后不会再有入队，取尽队列中剩余的记录
+			for {
+				select {
+				case h := <-w.ch:
+					batch = append(batch, h)
+					if len(batch) >= w.batchSize {
+						flush()
+					}
+					continue
+				default:
+				}
+				break
+			}
 			if len(batch) > 0 {
 				flush()
 			}
@@ -62,11 +131,225 @@ func (w *HistoryWriter) loop() {
 	}
 }
 
+// flush 写入一批；失败时落盘。batch 在返回后会被复用
+func (w *HistoryWriter) flush(batch []domain.ExecHistory) {
+	rest, err := w.insert(batch)
+	w.written.Add(int64(len(batch) - len(rest)))
+	if err == nil {
+		if j := w.journal.Load(); j != nil && j.needsReplay() { // 数据库恢复后顺带重放
+			_, _ = w.replay(j, true)
+		}
+		return
+	}
+	w.spill(rest, err)
+}
+
+// insert 批量写入 (支持时单事务，否则逐条)，数据库忙时退避重试；返回未写入的记录
+func (w *HistoryWriter) insert(batch []domain.ExecHistory) ([]domain.ExecHistory, error) {
+	rest := batch
+	var err error
+	for attempt := 0; ; attempt++ {
+		rest, err = w.insertOnce(rest)
+		if err == nil || !repository.IsBusy(err) || attempt >= historyBusyRetries {
+			return rest, err
+		}
+		w.retries.Add(1)
+		time.Sleep(historyRetryBaseDelay << attempt)
+	}
+}
+
+func (w *HistoryWriter) insertOnce(batch []domain.ExecHistory) ([]domain.ExecHistory, error) {
+	if bi, ok := w.repo.(repository.HistoryBatchInserter); ok {
+		if err := bi.InsertBatch(batch); err != nil {
+			return batch, err
+		}
+		return nil, nil
+	}
+	for i := range batch {
+		if err := w.repo.Insert(&batch[i]); err != nil {
+			return batch[i:], err
+		}
+	}
+	return nil, nil
+}
+
+// spill 将无法入库的记录写入落盘日志；没有 journal 或落盘失败时记为丢失
+func (w *HistoryWriter) spill(hs []domain.ExecHistory, cause error) {
+	if j := w.journal.Load(); j != nil {
+		j.mu.Lock()
+		err := j.append(hs)
+		j.mu.Unlock()
+		if err == nil {
+			w.spilled.Add(int64(len(hs)))
+			w.log.Warn("history write failed, spilled to journal", "records", len(hs), logging.KeyJobID, hs[0].JobID, logging.Err(cause))
+			return
+		}
+		cause = err
+	}
+	w.failed.Add(int64(len(hs)))
+	for _, h := range hs {
+		w.log.Error("history record lost", logging.KeyJobID, h.JobID, logging.KeyMachineID, h.MachineID, logging.KeyIPMIIP, h.IPMIIP, logging.Err(cause))
+	}
+}
+
+// replay 按批重放落盘日志 (持有进程间锁，多个进程不会重复重放)；数据库忙时保留剩余记录等待下次。
+// 非忙错误的批次逐条定位失败记录，其余照常写入；确认数据库可写 (healthy 或本轮有记录写入) 时累计其失败次数，
+// 达到 historyReplayMaxAttempts 后移入 <journal>.rejected 并计入 Failed，不再阻塞之后的重放
+func (w *HistoryWriter) replay(j *historyJournal, healthy bool) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	release, err := j.acquire()
+	if err != nil {
+		w.log.Error("history journal lock failed", logging.Err(err))
+		return 0, err
+	}
+	defer release()
+	list, skipped, err := j.load()
+	if err != nil {
+		w.log.Error("history journal read failed", logging.Err(err))
+		return 0, err
+	}
+	if skipped > 0 {
+		w.log.Warn("history journal has unreadable lines, skipped", "lines", skipped)
+	}
+	var keep, bad []journalRecord
+	var lastErr error
+	done := 0
+	replayed := func(n int) {
+		done += n
+		w.written.Add(int64(n))
+		w.replayed.Add(int64(n))
+	}
+replay:
+	for i := 0; i < len(list); i += w.batchSize {
+		end := min(i+w.batchSize, len(list))
+		batch := make([]domain.ExecHistory, 0, end-i)
+		for _, rec := range list[i:end] {
+			batch = append(batch, rec.ExecHistory)
+		}
+		rest, err := w.insert(batch)
+		replayed(len(batch) - len(rest))
+		if err == nil {
+			continue
+		}
+		from := end - len(rest)
+		if repository.IsBusy(err) {
+			keep = append(keep, list[from:]...)
+			lastErr = err
+			break
+		}
+		for k, rec := range list[from:end] {
+			_, ierr := w.insert([]domain.ExecHistory{rec.ExecHistory})
+			switch {
+			case ierr == nil:
+				replayed(1)
+			case repository.IsBusy(ierr):
+				keep = append(keep, list[from+k:]...)
+				lastErr = ierr
+				break replay
+			default:
+				bad = append(bad, rec)
+				lastErr = ierr
+			}
+		}
+	}
+	if len(bad) > 0 && (healthy || done > 0) {
+		var rejected []journalRecord
+		for _, rec := range bad {
+			if rec.Attempts++; rec.Attempts >= historyReplayMaxAttempts {
+				rejected = append(rejected, rec)
+			} else {
+				keep = append(keep, rec)
+			}
+		}
+		if len(rejected) > 0 {
+			if err := j.reject(rejected); err != nil {
+				w.log.Error("history journal reject failed", logging.Err(err))
+				keep = append(keep, rejected...)
+			} else {
+				w.failed.Add(int64(len(rejected)))
+				for _, rec := range rejected {
+					w.log.Error("history record rejected after repeated replay failures", logging.KeyJobID, rec.JobID, logging.KeyMachineID, rec.MachineID, logging.KeyIPMIIP, rec.IPMIIP, "attempts", rec.Attempts, "file", j.path+".rejected")
+				}
+			}
+		}
+	} else {
+		keep = append(keep, bad...)
+	}
+	if err := j.rewrite(keep); err != nil {
+		w.log.Error("history journal rewrite failed", logging.Err(err))
+		return done, err
+	}
+	if len(keep) > 0 {
+		w.log.Warn("history journal replay paused", "replayed", done, "pending", len(keep), logging.Err(lastErr))
+		return done, lastErr
+	}
+	w.log.Info("history journal replayed", "records", done)
+	return done, nil
+}
+
+// Write 入队；队列满时最多阻塞 blockTimeout，仍无空位则落盘
 func (w *HistoryWriter) Write(h domain.ExecHistory) {
+	w.closeMu.RLock()
+	defer w.closeMu.RUnlock()
+	if w.closed {
+		w.spillOrDrop(h)
+		return
+	}
 	select {
 	case w.ch <- h:
-	default: /* drop if full */
+		return
+	default:
 	}
+	if w.blockTimeout > 0 {
+		t := time.NewTimer(w.blockTimeout)
+		defer t.Stop()
+		select {
+		case w.ch <- h:
+			return
+		case <-t.C:
+		}
+	}
+	w.spillOrDrop(h)
 }
 
-func (w *HistoryWriter) Close() { close(w.stop); w.wg.Wait() }
+func (w *HistoryWriter) spillOrDrop(h domain.ExecHistory) {
+	if j := w.journal.Load(); j != nil {
+		j.mu.Lock()
+		err := j.append([]domain.ExecHistory{h})
+		j.mu.Unlock()
+		if err == nil {
+			w.spilled.Add(1)
+			w.log.Warn("history queue full, record spilled to journal", logging.KeyJobID, h.JobID, logging.KeyMachineID, h.MachineID, logging.KeyIPMIIP, h.IPMIIP)
+			return
+		}
+		w.log.Error("history journal append failed", logging.Err(err))
+	}
+	w.dropped.Add(1)
+	w.log.Error("history queue full, record dropped", logging.KeyJobID, h.JobID, logging.KeyMachineID, h.MachineID, logging.KeyIPMIIP, h.IPMIIP)
+}
+
+func (w *HistoryWriter) Stats() HistoryWriterStats {
+	st := HistoryWriterStats{QueueDepth: len(w.ch), QueueCapacity: cap(w.ch), Written: w.written.Load(), Failed: w.failed.Load(), Dropped: w.dropped.Load(), Spilled: w.spilled.Load(), Replayed: w.replayed.Load(), Retries: w.retries.Load()}
+	if j := w.journal.Load(); j != nil {
+		st.JournalEnabled = true
+		st.JournalPending = j.Pending()
+	}
+	return st
+}
+
+// Close 写完队列中的全部记录并关闭落盘日志后返回；之后的 Write 计入 Dropped
+func (w *HistoryWriter) Close() {
+	w.closeMu.Lock()
+	if w.closed {
+		w.closeMu.Unlock()
+		return
+	}
+	w.closed = true
+	w.closeMu.Unlock()
+	close(w.stop)
+	w.wg.Wait()
+	if j := w.journal.Swap(nil); j != nil {
+		_ = j.close()
+	}
+}
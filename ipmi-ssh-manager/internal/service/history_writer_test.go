@@ -0,0 +1,243 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/logging"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+)
+
+// flakyHistoryRepo 按 fail 返回的错误使批量写入失败；gate 非空时写入前阻塞
+type flakyHistoryRepo struct {
+	*repository.HistoryRepo
+	mu    sync.Mutex
+	fail  func() error
+	gate  chan struct{}
+	calls int
+}
+
+func (r *flakyHistoryRepo) InsertBatch(hs []domain.ExecHistory) error {
+	if r.gate != nil {
+		<-r.gate
+	}
+	r.mu.Lock()
+	r.calls++
+	fail := r.fail
+	r.mu.Unlock()
+	if fail != nil {
+		if err := fail(); err != nil {
+			return err
+		}
+	}
+	return r.HistoryRepo.InsertBatch(hs)
+}
+
+func (r *flakyHistoryRepo) setFail(f func() error) {
+	r.mu.Lock()
+	r.fail = f
+	r.mu.Unlock()
+}
+
+func countHistory(t *testing.T, repo repository.HistoryRepoIface) int {
+	t.Helper()
+	list, err := repo.ListRecent(10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(list)
+}
+
+func TestHistoryWriter_DrainOnClose(t *testing.T) {
+	hRepo := repository.NewHistoryRepo(openMemDB(t))
+	w := NewHistoryWriter(hRepo, 60, 10) // flush 间隔很长，只能靠批量与 Close 写入
+	w.SetLogger(logging.Discard())
+	for i := range 95 {
+		w.Write(domain.ExecHistory{JobID: "j", MachineID: int64(i), Command: "uptime"})
+	}
+	w.Close()
+	if n := countHistory(t, hRepo); n != 95 {
+		t.Fatalf("rows = %d, want 95", n)
+	}
+	st := w.Stats()
+	if st.Written != 95 || st.Dropped != 0 || st.Failed != 0 {
+		t.Fatalf("stats = %+v", st)
+	}
+	w.Write(domain.ExecHistory{JobID: "late"}) // 关闭后不会阻塞
+	if w.Stats().Dropped != 1 {
+		t.Fatalf("write after close should count as dropped: %+v", w.Stats())
+	}
+}
+
+func TestHistoryWriter_BusyRetryAndJournal(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "history.journal")
+	repo := &flakyHistoryRepo{HistoryRepo: repository.NewHistoryRepo(openMemDB(t))}
+
+	// 前两次数据库忙，之后成功：重试后写入，不落盘
+	busy := 2
+	repo.setFail(func() error {
+		if busy > 0 {
+			busy--
+			return errors.New("database is locked (5) (SQLITE_BUSY)")
+		}
+		return nil
+	})
+	w := NewHistoryWriter(repo, 60, 5)
+	w.SetLogger(logging.Discard())
+	if _, err := w.SetJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		w.Write(domain.ExecHistory{JobID: "busy", MachineID: int64(i)})
+	}
+	// 非忙错误：不重试，整批落盘
+	for deadline := time.Now().Add(3 * time.Second); w.Stats().Written < 5 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	repo.setFail(func() error { return errors.New("disk I/O error") })
+	for i := range 5 {
+		w.Write(domain.ExecHistory{JobID: "spill", MachineID: int64(i)})
+	}
+	w.Close()
+	st := w.Stats()
+	if st.Retries != 2 || st.Written != 5 || st.Spilled != 5 || st.Failed != 0 || st.Dropped != 0 {
+		t.Fatalf("stats = %+v", st)
+	}
+
+	// 模拟崩溃时写了一半的行
+	f, err := os.OpenFile(journal, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"job_id":"torn","mach`)
+	f.Close()
+
+	// 重启：数据库恢复，SetJournal 重放遗留记录并清空日志
+	repo.setFail(nil)
+	w2 := NewHistoryWriter(repo, 60, 2)
+	w2.SetLogger(logging.Discard())
+	n, err := w2.SetJournal(journal)
+	if err != nil || n != 5 {
+		t.Fatalf("replayed %d, %v", n, err)
+	}
+	if st := w2.Stats(); st.JournalPending != 0 || st.Replayed != 5 || !st.JournalEnabled {
+		t.Fatalf("stats after replay = %+v", st)
+	}
+	w2.Close()
+	if rows := countHistory(t, repo); rows != 10 {
+		t.Fatalf("rows = %d, want 10", rows)
+	}
+	if fi, err := os.Stat(journal); err != nil || fi.Size() != 0 {
+		t.Fatalf("journal should be empty: %v %v", fi, err)
+	}
+}
+
+func TestHistoryWriter_BackpressureSpill(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "history.journal")
+	repo := &flakyHistoryRepo{HistoryRepo: repository.NewHistoryRepo(openMemDB(t)), gate: make(chan struct{})}
+	w := NewHistoryWriter(repo, 60, 1) // 队列容量 4
+	w.SetLogger(logging.Discard())
+	w.SetBlockTimeout(20 * time.Millisecond)
+	if _, err := w.SetJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	// 第 1 条被写入协程取走并阻塞在 gate，接下来 4 条填满队列，其余 3 条超时后落盘
+	for i := range 8 {
+		w.Write(domain.ExecHistory{JobID: "bp", MachineID: int64(i)})
+	}
+	if st := w.Stats(); st.Spilled != 3 || st.JournalPending != 3 || st.Dropped != 0 {
+		t.Fatalf("stats = %+v", st)
+	}
+	close(repo.gate)
+	w.Close()
+	st := w.Stats()
+	if st.Written != 8 || st.Replayed != 3 {
+		t.Fatalf("stats after close = %+v", st)
+	}
+	if rows := countHistory(t, repo); rows != 8 {
+		t.Fatalf("rows = %d, want 8", rows)
+	}
+}
+
+func TestHistoryWriter_SharedJournal(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "history.journal")
+	repo := repository.NewHistoryRepo(openMemDB(t))
+	// 两个写入器共用一个日志文件 (模拟应用与 ipmictl 两个进程)
+	a := NewHistoryWriter(repo, 60, 10)
+	a.SetLogger(logging.Discard())
+	b := NewHistoryWriter(repo, 60, 10)
+	b.SetLogger(logging.Discard())
+	for _, w := range []*HistoryWriter{a, b} {
+		if _, err := w.SetJournal(journal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cause := errors.New("disk I/O error")
+	a.spill([]domain.ExecHistory{{JobID: "a1"}, {JobID: "a2"}}, cause)
+	if n, err := b.replay(b.journal.Load(), true); err != nil || n != 2 {
+		t.Fatalf("first replay %d %v", n, err)
+	}
+	// b 改名重写后 a 的追加必须进入新文件
+	a.spill([]domain.ExecHistory{{JobID: "a3"}}, cause)
+	if n, err := b.replay(b.journal.Load(), true); err != nil || n != 1 {
+		t.Fatalf("second replay %d %v", n, err)
+	}
+	if n, err := a.replay(a.journal.Load(), true); err != nil || n != 0 {
+		t.Fatalf("replay by a must not duplicate: %d %v", n, err)
+	}
+	a.Close()
+	b.Close()
+	if rows := countHistory(t, repo); rows != 3 {
+		t.Fatalf("rows = %d, want 3", rows)
+	}
+}
+
+// poisonHistoryRepo 批量中含 JobID 为 poison 的记录时整批以非忙错误失败
+type poisonHistoryRepo struct{ *repository.HistoryRepo }
+
+func (r poisonHistoryRepo) InsertBatch(hs []domain.ExecHistory) error {
+	for _, h := range hs {
+		if h.JobID == "poison" {
+			return errors.New("constraint failed")
+		}
+	}
+	return r.HistoryRepo.InsertBatch(hs)
+}
+
+func TestHistoryWriter_RejectsPoisonRecord(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "history.journal")
+	repo := poisonHistoryRepo{repository.NewHistoryRepo(openMemDB(t))}
+	w := NewHistoryWriter(repo, 60, 10)
+	w.SetLogger(logging.Discard())
+	if _, err := w.SetJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	w.spill([]domain.ExecHistory{{JobID: "ok1"}, {JobID: "poison"}, {JobID: "ok2"}}, errors.New("disk I/O error"))
+
+	// 问题记录不阻塞其余记录；多次失败后移入 .rejected 并计入 Failed
+	j := w.journal.Load()
+	if n, err := w.replay(j, false); err == nil || n != 2 {
+		t.Fatalf("first replay %d %v", n, err)
+	}
+	for range historyReplayMaxAttempts - 1 {
+		_, _ = w.replay(j, true)
+	}
+	st := w.Stats()
+	if st.Failed != 1 || st.Replayed != 2 || st.JournalPending != 0 {
+		t.Fatalf("stats = %+v", st)
+	}
+	data, err := os.ReadFile(journal + ".rejected")
+	if err != nil || !strings.Contains(string(data), `"poison"`) || strings.Count(string(data), "\n") != 1 {
+		t.Fatalf("rejected file %q %v", data, err)
+	}
+	w.Close()
+	if rows := countHistory(t, repo); rows != 2 {
+		t.Fatalf("rows = %d, want 2", rows)
+	}
+}
@@ -0,0 +1,448 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+)
+
+// 策略拦截错误 (均包装在 *PolicyError 中)
+var (
+	ErrPolicyDenied     = errors.New("command denied by policy")
+	ErrConfirmRequired  = errors.New("command requires confirmation")
+	ErrApprovalRequired = errors.New("command requires approval by another user")
+	// ErrCommandNotAllowed 命令不在角色 / 分组白名单内 (逐机返回在 ExecResult.Err 中)
+	ErrCommandNotAllowed = errors.New("command not in allow-list")
+)
+
+// PolicyError 策略未放行；Decision 携带确认令牌或审批单 ID
+type PolicyError struct {
+	Decision domain.PolicyDecision
+	err      error
+}
+
+func (e *PolicyError) Error() string {
+	msg := e.err.Error()
+	if len(e.Decision.Reasons) > 0 {
+		msg += ": " + strings.Join(e.Decision.Reasons, "; ")
+	}
+	switch {
+	case e.Decision.ConfirmToken != "":
+		msg += " (resubmit with confirm token " + e.Decision.ConfirmToken + ")"
+	case e.Decision.ApprovalID != "":
+		msg += " (approval " + e.Decision.ApprovalID + ")"
+	}
+	return msg
+}
+
+func (e *PolicyError) Unwrap() error { return e.err }
+
+// PolicyRule 按正则匹配命令 (渲染前的模板)；Action 为 deny / warn (需确认) / approve (需他人审批)
+type PolicyRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"`
+}
+
+// PolicyConfig 命令策略配置 (IPMI_POLICY_FILE，JSON)
+type PolicyConfig struct {
+	Rules           []PolicyRule `json:"rules"`
+	ConfirmTargets  int          `json:"confirm_targets"`  // 目标机器数 >= N 时需确认 (0 不限)
+	ApprovalTargets int          `json:"approval_targets"` // 目标机器数 >= N 时需他人审批 (0 不限)
+	ApprovalTTLSec  int          `json:"approval_ttl_sec"` // 审批单有效期 (默认 3600)
+	AllowLists      AllowLists   `json:"allow_lists"`
+}
+
+// AllowLists 命令白名单：配置了白名单的角色只能执行其中的命令；机器所属的每个配置了白名单的分组都须允许该命令。
+// 命令模板与按机器渲染后的命令都须匹配 (连续空白视为一个空格)：含 {{...}} 的条目对渲染结果先按同一机器渲染再比较；
+// `*` 匹配任意字符，`re:` 前缀为正则 (整串匹配)。
+type AllowLists struct {
+	Roles  map[string][]string `json:"roles,omitempty"`
+	Groups map[string][]string `json:"groups,omitempty"`
+}
+
+// DefaultPolicyConfig 未提供策略文件时使用
+func DefaultPolicyConfig() PolicyConfig {
+	return PolicyConfig{
+		Rules: []PolicyRule{
+			{Name: "rm-root", Pattern: `\brm\s+(-\S+\s+)*(/|/\*)(\s|;|&|\||$)`, Action: "deny"},
+			{Name: "fork-bomb", Pattern: `:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`, Action: "deny"},
+			{Name: "mkfs", Pattern: `\bmkfs(\.\w+)?\s`, Action: "approve"},
+			{Name: "dd-to-device", Pattern: `\bdd\b.*\bof=/dev/`, Action: "approve"},
+			{Name: "power", Pattern: `\b(reboot|poweroff|shutdown|halt)\b|\binit\s+[06]\b|\bipmitool\b.*\bpower\s+(off|cycle|reset)\b`, Action: "warn"},
+		},
+		ConfirmTargets:  20,
+		ApprovalTargets: 100,
+		ApprovalTTLSec:  3600,
+	}
+}
+
+// LoadPolicyConfig 读取策略文件；文件不存在时返回默认策略
+func LoadPolicyConfig(path string) (PolicyConfig, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultPolicyConfig(), nil
+	}
+	if err != nil {
+		return PolicyConfig{}, err
+	}
+	var cfg PolicyConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return PolicyConfig{}, fmt.Errorf("policy %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// compileAllowEntry 白名单条目转为整串匹配的正则
+func compileAllowEntry(entry string) (*regexp.Regexp, error) {
+	if expr, ok := strings.CutPrefix(entry, "re:"); ok {
+		return regexp.Compile(`^(?:` + expr + `)$`)
+	}
+	parts := strings.Split(normalizeCommand(entry), "*")
+	for i, s := range parts {
+		parts[i] = regexp.QuoteMeta(s)
+	}
+	return regexp.Compile(`^` + strings.Join(parts, ".*") + `$`)
+}
+
+// allowEntry 白名单条目；raw 含模板时检查渲染结果需按机器重新渲染
+type allowEntry struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+// match m 非空且条目含模板时，先按 m 渲染条目再匹配 (用于渲染后的命令)
+func (e allowEntry) match(cmd string, m *domain.Machine) bool {
+	if m == nil || !strings.Contains(e.raw, "{{") {
+		return e.re.MatchString(cmd)
+	}
+	raw, err := RenderCommand(e.raw, *m)
+	if err != nil {
+		return false
+	}
+	re, err := compileAllowEntry(raw)
+	return err == nil && re.MatchString(cmd)
+}
+
+func compileAllowLists(lists map[string][]string) (map[string][]allowEntry, error) {
+	out := make(map[string][]allowEntry, len(lists))
+	for name, entries := range lists {
+		res := make([]allowEntry, 0, len(entries))
+		for _, e := range entries {
+			re, err := compileAllowEntry(e)
+			if err != nil {
+				return nil, fmt.Errorf("allow-list %q entry %q: %w", name, e, err)
+			}
+			res = append(res, allowEntry{raw: e, re: re})
+		}
+		out[name] = res
+	}
+	return out, nil
+}
+
+func normalizeCommand(cmd string) string { return strings.Join(strings.Fields(cmd), " ") }
+
+func matchAny(entries []allowEntry, cmd string, m *domain.Machine) bool {
+	for _, e := range entries {
+		if e.match(cmd, m) {
+			return true
+		}
+	}
+	return false
+}
+
+type policyRule struct {
+	name    string
+	re      *regexp.Regexp
+	verdict domain.PolicyVerdict
+}
+
+// Policy 命令下发前的护栏：按规则与目标数量判定放行 / 确认 / 审批 / 拒绝。
+// 确认令牌由发起者、命令与目标确定 (10 分钟内有效)，用于防止误操作而非安全边界；
+// 审批需另一名用户通过 Approve 批准，单次有效。
+type Policy struct {
+	rules           []policyRule
+	confirmTargets  int
+	approvalTargets int
+	approvalTTL     time.Duration
+	approvals       repository.ApprovalRepoIface
+	roleAllow       map[string][]allowEntry
+	groupAllow      map[string][]allowEntry
+	now             func() time.Time
+
+	// OnDecision 非 allow 的判定 (放行或拦截) 都会回调，用于记录审计
+	OnDecision func(task domain.ExecTask, d domain.PolicyDecision)
+}
+
+// NewPolicy 编译规则；approvals 为 nil 时需审批的命令一律拒绝
+func NewPolicy(cfg PolicyConfig, approvals repository.ApprovalRepoIface) (*Policy, error) {
+	p := &Policy{confirmTargets: cfg.ConfirmTargets, approvalTargets: cfg.ApprovalTargets, approvalTTL: time.Duration(cfg.ApprovalTTLSec) * time.Second, approvals: approvals, now: time.Now}
+	if p.approvalTTL <= 0 {
+		p.approvalTTL = time.Hour
+	}
+	var err error
+	if p.roleAllow, err = compileAllowLists(cfg.AllowLists.Roles); err != nil {
+		return nil, err
+	}
+	if p.groupAllow, err = compileAllowLists(cfg.AllowLists.Groups); err != nil {
+		return nil, err
+	}
+	for _, r := range cfg.Rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("policy rule %q: %w", r.Name, err)
+		}
+		var v domain.PolicyVerdict
+		switch r.Action {
+		case "deny":
+			v = domain.VerdictDeny
+		case "warn", "confirm":
+			v = domain.VerdictConfirm
+		case "approve":
+			v = domain.VerdictApprove
+		default:
+			return nil, fmt.Errorf("policy rule %q: unknown action %q (deny|warn|approve)", r.Name, r.Action)
+		}
+		p.rules = append(p.rules, policyRule{name: r.Name, re: re, verdict: v})
+	}
+	return p, nil
+}
+
+// Allowed 检查命令 (模板) 是否在发起者角色与机器所属分组的白名单内
+func (p *Policy) Allowed(role domain.Role, command string, m domain.Machine) error {
+	return p.allowed(role, command, m, nil)
+}
+
+// AllowedRendered 检查按机器 m 渲染后的命令：属性值决定最终命令，模板通过白名单后渲染结果仍须通过
+func (p *Policy) AllowedRendered(role domain.Role, rendered string, m domain.Machine) error {
+	return p.allowed(role, rendered, m, &m)
+}
+
+func (p *Policy) allowed(role domain.Role, command string, m domain.Machine, renderFor *domain.Machine) error {
+	cmd := normalizeCommand(command)
+	if res, ok := p.roleAllow[string(role)]; ok && !matchAny(res, cmd, renderFor) {
+		return fmt.Errorf("%w of role %s", ErrCommandNotAllowed, role)
+	}
+	for _, g := range m.Groups {
+		if res, ok := p.groupAllow[g]; ok && !matchAny(res, cmd, renderFor) {
+			return fmt.Errorf("%w of group %s (machine %s)", ErrCommandNotAllowed, g, m.IPMIIP)
+		}
+	}
+	return nil
+}
+
+var verdictRank = map[domain.PolicyVerdict]int{domain.VerdictAllow: 0, domain.VerdictConfirm: 1, domain.VerdictApprove: 2, domain.VerdictDeny: 3}
+
+// Evaluate 仅判定 (不校验令牌 / 审批)；targets 为解析后的目标数，
+// rendered 为各机器渲染后的命令，规则匹配模板或任一渲染结果即生效
+func (p *Policy) Evaluate(command string, targets int, rendered ...string) domain.PolicyDecision {
+	d := domain.PolicyDecision{Verdict: domain.VerdictAllow, Targets: targets}
+	raise := func(v domain.PolicyVerdict, reason string) {
+		d.Reasons = append(d.Reasons, reason)
+		if verdictRank[v] > verdictRank[d.Verdict] {
+			d.Verdict = v
+		}
+	}
+	for _, r := range p.rules {
+		if r.re.MatchString(command) || slices.ContainsFunc(rendered, r.re.MatchString) {
+			raise(r.verdict, "rule "+r.name)
+		}
+	}
+	if p.approvalTargets > 0 && targets >= p.approvalTargets {
+		raise(domain.VerdictApprove, fmt.Sprintf("%d targets >= approval threshold %d", targets, p.approvalTargets))
+	} else if p.confirmTargets > 0 && targets >= p.confirmTargets {
+		raise(domain.VerdictConfirm, fmt.Sprintf("%d targets >= confirm threshold %d", targets, p.confirmTargets))
+	}
+	if d.Verdict == domain.VerdictAllow {
+		d.Granted = true
+	}
+	return d
+}
+
+// renderedCommands task.Render 时各目标机器渲染后与模板不同的命令 (去重)；
+// 渲染失败的机器执行时即失败，不参与判定
+func renderedCommands(task domain.ExecTask, targets map[int64]domain.Machine) []string {
+	if !task.Render {
+		return nil
+	}
+	var out []string
+	for _, m := range targets {
+		if cmd, err := RenderCommand(task.Command, m); err == nil && cmd != task.Command && !slices.Contains(out, cmd) {
+			out = append(out, cmd)
+		}
+	}
+	return out
+}
+
+// Preview 判定并在需要确认时给出令牌 (不创建审批单)，供前端下发前提示；targets 为解析后的目标机器
+func (p *Policy) Preview(task domain.ExecTask, targets map[int64]domain.Machine) domain.PolicyDecision {
+	d := p.Evaluate(task.Command, len(task.MachineIDs), renderedCommands(task, targets)...)
+	if d.Verdict == domain.VerdictConfirm {
+		d.ConfirmToken = p.confirmToken(task, p.now())
+	}
+	return d
+}
+
+// Enforce 判定并校验确认令牌 / 审批；task.MachineIDs 须为解析后的最终目标，targets 为对应的机器。
+// 需审批且未携带审批单时创建待审批单，通过 PolicyError.Decision.ApprovalID 返回。
+func (p *Policy) Enforce(task domain.ExecTask, targets map[int64]domain.Machine) (domain.PolicyDecision, error) {
+	d := p.Evaluate(task.Command, len(task.MachineIDs), renderedCommands(task, targets)...)
+	var err error
+	switch d.Verdict {
+	case domain.VerdictConfirm:
+		if p.validConfirm(task) {
+			d.Granted = true
+		} else {
+			d.ConfirmToken = p.confirmToken(task, p.now())
+			err = &PolicyError{Decision: d, err: ErrConfirmRequired}
+		}
+	case domain.VerdictApprove:
+		err = p.checkApproval(task, &d)
+	case domain.VerdictDeny:
+		err = &PolicyError{Decision: d, err: ErrPolicyDenied}
+	}
+	if d.Verdict != domain.VerdictAllow && p.OnDecision != nil {
+		logged := d
+		logged.ConfirmToken = ""
+		p.OnDecision(task, logged)
+	}
+	return d, err
+}
+
+// confirmWindow 确认令牌的时间窗口 (接受当前与上一个窗口)
+const confirmWindow = 10 * time.Minute
+
+func (p *Policy) confirmToken(task domain.ExecTask, at time.Time) string {
+	ids := slices.Clone(task.MachineIDs)
+	slices.Sort(ids)
+	parts := []string{task.User, task.Command, strconv.FormatInt(at.Unix()/int64(confirmWindow/time.Second), 10)}
+	for _, id := range ids {
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:6])
+}
+
+func (p *Policy) validConfirm(task domain.ExecTask) bool {
+	if task.Confirm == "" {
+		return false
+	}
+	now := p.now()
+	return task.Confirm == p.confirmToken(task, now) || task.Confirm == p.confirmToken(task, now.Add(-confirmWindow))
+}
+
+// checkApproval 使用已批准的审批单 (单次有效)，或创建新的待审批单
+func (p *Policy) checkApproval(task domain.ExecTask, d *domain.PolicyDecision) error {
+	if p.approvals == nil {
+		return &PolicyError{Decision: *d, err: fmt.Errorf("%w (approvals are not available)", ErrApprovalRequired)}
+	}
+	if task.ApprovalID == "" {
+		a := domain.Approval{ID: newApprovalID(), Requester: task.User, Command: task.Command, MachineIDs: task.MachineIDs, Reasons: d.Reasons, Status: domain.ApprovalPending, CreatedAt: p.now()}
+		a.ExpiresAt = a.CreatedAt.Add(p.approvalTTL)
+		if err := p.approvals.Create(a); err != nil {
+			return err
+		}
+		d.ApprovalID = a.ID
+		return &PolicyError{Decision: *d, err: ErrApprovalRequired}
+	}
+	d.ApprovalID = task.ApprovalID
+	a, err := p.approvals.Get(task.ApprovalID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &PolicyError{Decision: *d, err: fmt.Errorf("%w: approval %s not found", ErrApprovalRequired, task.ApprovalID)}
+	}
+	if err != nil {
+		return err
+	}
+	if a.Requester != task.User || a.Command != task.Command || !sameTargets(a.MachineIDs, task.MachineIDs) {
+		return &PolicyError{Decision: *d, err: fmt.Errorf("%w: approval %s was granted for a different request", ErrApprovalRequired, a.ID)}
+	}
+	if a.Status == domain.ApprovalApproved && p.now().After(a.ExpiresAt) {
+		a.Status = domain.ApprovalExpired
+	}
+	if a.Status != domain.ApprovalApproved {
+		return &PolicyError{Decision: *d, err: fmt.Errorf("%w: approval %s is %s", ErrApprovalRequired, a.ID, a.Status)}
+	}
+	if err := p.approvals.Transition(a.ID, domain.ApprovalApproved, domain.ApprovalUsed, "", p.now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) { // 并发下已被使用
+			return &PolicyError{Decision: *d, err: fmt.Errorf("%w: approval %s was already used", ErrApprovalRequired, a.ID)}
+		}
+		return err
+	}
+	d.Granted = true
+	return nil
+}
+
+// Decide 批准或驳回待审批单；审批人不能是发起者
+func (p *Policy) Decide(id, approver string, approve bool) (domain.Approval, error) {
+	if p.approvals == nil {
+		return domain.Approval{}, errors.New("approvals are not available")
+	}
+	a, err := p.approvals.Get(id)
+	if err != nil {
+		return domain.Approval{}, err
+	}
+	if a.Requester == approver {
+		return domain.Approval{}, fmt.Errorf("%w: requester cannot approve their own request", ErrForbidden)
+	}
+	if a.Status != domain.ApprovalPending {
+		return domain.Approval{}, fmt.Errorf("approval %s is %s", id, a.Status)
+	}
+	to := domain.ApprovalRejected
+	if approve {
+		to = domain.ApprovalApproved
+		if p.now().After(a.ExpiresAt) {
+			to = domain.ApprovalExpired
+		}
+	}
+	now := p.now()
+	if err := p.approvals.Transition(id, domain.ApprovalPending, to, approver, now); err != nil {
+		return domain.Approval{}, err
+	}
+	a.Status, a.Approver, a.DecidedAt = to, approver, now
+	if to == domain.ApprovalExpired {
+		return a, fmt.Errorf("approval %s has expired", id)
+	}
+	return a, nil
+}
+
+// Approval 查询审批单
+func (p *Policy) Approval(id string) (domain.Approval, error) {
+	if p.approvals == nil {
+		return domain.Approval{}, errors.New("approvals are not available")
+	}
+	return p.approvals.Get(id)
+}
+
+// Approvals 审批单列表 (status 为空表示全部)
+func (p *Policy) Approvals(status domain.ApprovalStatus, limit int) ([]domain.Approval, error) {
+	if p.approvals == nil {
+		return nil, nil
+	}
+	return p.approvals.List(status, limit)
+}
+
+func sameTargets(a, b []int64) bool {
+	x, y := slices.Clone(a), slices.Clone(b)
+	slices.Sort(x)
+	slices.Sort(y)
+	return slices.Equal(x, y)
+}
+
+func newApprovalID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return "apr_" + hex.EncodeToString(b)
+}
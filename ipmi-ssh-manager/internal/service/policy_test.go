@@ -0,0 +1,304 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	sshmock "github.com/QingMing-Bot/ipmi-ssh-manager/internal/ssh"
+)
+
+func TestPolicy_Evaluate(t *testing.T) {
+	p, err := NewPolicy(DefaultPolicyConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		cmd     string
+		targets int
+		want    domain.PolicyVerdict
+	}{
+		{"uptime", 1, domain.VerdictAllow},
+		{"rm -rf /", 1, domain.VerdictDeny},
+		{"rm -rf --no-preserve-root /*", 1, domain.VerdictDeny},
+		{"rm -rf /tmp/cache", 1, domain.VerdictAllow},
+		{"sudo reboot", 1, domain.VerdictConfirm},
+		{"ipmitool chassis power cycle", 1, domain.VerdictConfirm},
+		{"mkfs.ext4 /dev/sdb1", 1, domain.VerdictApprove},
+		{"uptime", 20, domain.VerdictConfirm},
+		{"reboot", 100, domain.VerdictApprove},
+		{"rm -rf / ", 500, domain.VerdictDeny},
+	}
+	for _, c := range cases {
+		if d := p.Evaluate(c.cmd, c.targets); d.Verdict != c.want || d.Granted != (c.want == domain.VerdictAllow) {
+			t.Errorf("Evaluate(%q, %d) = %+v, want %s", c.cmd, c.targets, d, c.want)
+		}
+	}
+	if _, err := NewPolicy(PolicyConfig{Rules: []PolicyRule{{Name: "x", Pattern: "a", Action: "block"}}}, nil); err == nil {
+		t.Fatal("unknown action accepted")
+	}
+}
+
+// Test rendered values cannot smuggle commands past policy rules, allow-lists or the power permission
+func TestPolicy_RenderedCommands(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	mRepo := repository.NewMachineRepo(db)
+	safe := domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root", Attrs: map[string]string{"x": "/tmp/cache"}}
+	wipe := domain.Machine{IPMIIP: "10.0.0.2", SSHIP: "10.0.0.2", SSHUser: "root", Attrs: map[string]string{"x": "-rf /"}}
+	chain := domain.Machine{IPMIIP: "10.0.0.3", SSHIP: "10.0.0.3", SSHUser: "root", Attrs: map[string]string{"x": "/tmp; rm -rf /"}, Remark: "old (spare)"}
+	power := domain.Machine{IPMIIP: "10.0.0.4", SSHIP: "10.0.0.4", SSHUser: "root", Attrs: map[string]string{"x": "reboot"}}
+	for _, m := range []*domain.Machine{&safe, &wipe, &chain, &power} {
+		if err := mRepo.Save(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := NewPolicy(PolicyConfig{
+		Rules:      DefaultPolicyConfig().Rules,
+		AllowLists: AllowLists{Roles: map[string][]string{"operator": {"rm {{.x}}", "{{.x}}"}}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := sshmock.NewMockExecutor()
+	svc := NewExecService(mRepo, nil, mock, 2)
+	svc.SetPolicy(p)
+
+	// 模板本身不匹配拒绝规则，渲染结果匹配时整个任务被拒绝
+	task := domain.ExecTask{Command: "rm {{.x}}", Render: true, Timeout: 5, MachineIDs: []int64{int64(safe.ID), int64(wipe.ID)}, Role: domain.RoleOperator}
+	if d, err := svc.CheckPolicy(task); err != nil || d.Verdict != domain.VerdictDeny {
+		t.Fatalf("rendered deny preview: %+v %v", d, err)
+	}
+	if _, err := svc.BatchExec(task); !errors.Is(err, ErrPolicyDenied) {
+		t.Fatalf("rendered deny: %v", err)
+	}
+	// 引用含元字符的值渲染失败，未引用时不受影响
+	task.MachineIDs = []int64{int64(safe.ID), int64(chain.ID)}
+	res, err := svc.BatchExec(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		if r.MachineID == int64(chain.ID) && (r.Err == nil || !strings.Contains(r.Err.Error(), "shell metacharacters")) {
+			t.Fatalf("metacharacters: %+v", r)
+		}
+		if r.MachineID == int64(safe.ID) && (r.Err != nil || r.Command != "rm /tmp/cache") {
+			t.Fatalf("safe host: %+v", r)
+		}
+	}
+	if cmd, err := RenderCommand("echo {{.ipmi_ip}}", chain); err != nil || cmd != "echo 10.0.0.3" {
+		t.Fatalf("unreferenced unsafe value: %q %v", cmd, err)
+	}
+	// 渲染为电源控制命令：匹配确认规则，且需 power 权限
+	task = domain.ExecTask{Command: "{{.x}}", Render: true, Timeout: 5, MachineIDs: []int64{int64(power.ID)}, Role: domain.RoleOperator}
+	if _, err := svc.BatchExec(task); !errors.Is(err, ErrConfirmRequired) {
+		t.Fatalf("rendered power command without confirm: %v", err)
+	}
+	svc.SetPolicy(nil)
+	res, err = svc.BatchExec(task)
+	if err != nil || len(res) != 1 || !errors.Is(res[0].Err, ErrForbidden) {
+		t.Fatalf("rendered power command: %+v %v", res, err)
+	}
+
+	// 模板通过白名单，渲染结果 (不含元字符) 不在白名单内时同样拦截；含模板的条目按同一机器渲染后比较
+	word := domain.Machine{IPMIIP: "10.0.0.5", SSHIP: "10.0.0.5", SSHUser: "root", Attrs: map[string]string{"x": "uptime", "host": "web01"}}
+	spread := domain.Machine{IPMIIP: "10.0.0.6", SSHIP: "10.0.0.6", SSHUser: "root", Attrs: map[string]string{"x": "rm -rf /*", "host": "web02 --static"}}
+	for _, m := range []*domain.Machine{&word, &spread} {
+		if err := mRepo.Save(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err = NewPolicy(PolicyConfig{AllowLists: AllowLists{Roles: map[string][]string{"operator": {`re:\S+`, "hostnamectl set-hostname {{.host}}"}}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.SetPolicy(p)
+	for _, c := range []struct {
+		cmd     string
+		blocked bool
+	}{
+		{"{{.x}}", true},
+		{"hostnamectl set-hostname {{.host}}", false},
+	} {
+		task = domain.ExecTask{Command: c.cmd, Render: true, Timeout: 5, MachineIDs: []int64{int64(word.ID), int64(spread.ID)}, Role: domain.RoleOperator}
+		res, err := svc.BatchExec(task)
+		if err != nil || len(res) != 2 {
+			t.Fatalf("%s: %+v %v", c.cmd, res, err)
+		}
+		for _, r := range res {
+			if r.MachineID == int64(word.ID) && r.Err != nil {
+				t.Fatalf("%s on allowed host: %+v", c.cmd, r)
+			}
+			if r.MachineID == int64(spread.ID) && errors.Is(r.Err, ErrCommandNotAllowed) != c.blocked {
+				t.Fatalf("%s on spreading host: %+v", c.cmd, r)
+			}
+		}
+	}
+}
+
+func TestPolicy_ConfirmAndApproval(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	mRepo := repository.NewMachineRepo(db)
+	var ids []int64
+	for i := 1; i <= 3; i++ {
+		m := domain.Machine{IPMIIP: fmt.Sprintf("10.0.0.%d", i), SSHIP: fmt.Sprintf("10.0.0.%d", i), SSHUser: "root"}
+		if err := mRepo.Save(&m); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int64(m.ID))
+	}
+	aRepo := repository.NewApprovalRepo(db)
+	if err := aRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPolicy(PolicyConfig{
+		Rules:           []PolicyRule{{Name: "power", Pattern: `\breboot\b`, Action: "warn"}, {Name: "wipe", Pattern: `\bwipefs\b`, Action: "approve"}},
+		ApprovalTargets: 3,
+	}, aRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged []domain.PolicyDecision
+	p.OnDecision = func(_ domain.ExecTask, d domain.PolicyDecision) { logged = append(logged, d) }
+	mock := sshmock.NewMockExecutor()
+	mock.Set("reboot", sshmock.MockResult{})
+	mock.Set("wipefs -a /dev/sdb", sshmock.MockResult{})
+	svc := NewExecService(mRepo, nil, mock, 2)
+	svc.SetPolicy(p)
+
+	// 需确认：无令牌被拦截，携带返回的令牌后放行；其他用户不能复用该令牌
+	task := domain.ExecTask{Command: "reboot", MachineIDs: ids[:1], User: "alice"}
+	_, err = svc.StartBatch("", task, func(domain.ExecResult) {})
+	var pe *PolicyError
+	if !errors.Is(err, ErrConfirmRequired) || !errors.As(err, &pe) || pe.Decision.ConfirmToken == "" {
+		t.Fatalf("reboot without confirm: %v", err)
+	}
+	if preview, err := svc.CheckPolicy(task); err != nil || preview.ConfirmToken != pe.Decision.ConfirmToken {
+		t.Fatalf("CheckPolicy: %+v %v", preview, err)
+	}
+	if len(svc.ListJobs()) != 0 {
+		t.Fatal("blocked job was started")
+	}
+	bob := task
+	bob.User, bob.Confirm = "bob", pe.Decision.ConfirmToken
+	if _, err := svc.BatchExec(bob); !errors.Is(err, ErrConfirmRequired) {
+		t.Fatalf("token reused by another user: %v", err)
+	}
+	task.Confirm = pe.Decision.ConfirmToken
+	if rs, err := svc.BatchExec(task); err != nil || len(rs) != 1 {
+		t.Fatalf("confirmed reboot: %+v %v", rs, err)
+	}
+
+	// 需审批：创建待审批单，发起者不能自批，他人批准后单次有效
+	task = domain.ExecTask{Command: "wipefs -a /dev/sdb", MachineIDs: ids, User: "alice"}
+	_, err = svc.BatchExec(task)
+	if !errors.Is(err, ErrApprovalRequired) || !errors.As(err, &pe) || pe.Decision.ApprovalID == "" {
+		t.Fatalf("wipefs without approval: %v", err)
+	}
+	id := pe.Decision.ApprovalID
+	if _, err := p.Decide(id, "alice", true); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("self approval: %v", err)
+	}
+	task.ApprovalID = id
+	if _, err := svc.BatchExec(task); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("pending approval accepted: %v", err)
+	}
+	if a, err := p.Decide(id, "bob", true); err != nil || a.Status != domain.ApprovalApproved || a.Approver != "bob" {
+		t.Fatalf("approve: %+v %v", a, err)
+	}
+	other := task
+	other.MachineIDs = ids[:2]
+	if _, err := svc.BatchExec(other); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("approval used for different targets: %v", err)
+	}
+	if rs, err := svc.BatchExec(task); err != nil || len(rs) != 3 {
+		t.Fatalf("approved wipefs: %+v %v", rs, err)
+	}
+	if _, err := svc.BatchExec(task); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("approval reused: %v", err)
+	}
+	if list, err := p.Approvals(domain.ApprovalUsed, 0); err != nil || len(list) != 1 || list[0].ID != id {
+		t.Fatalf("used approvals: %+v %v", list, err)
+	}
+
+	if _, err := svc.BatchExec(domain.ExecTask{Command: "uptime", MachineIDs: ids[:1], User: "alice"}); err != nil {
+		t.Fatalf("allowed command: %v", err)
+	}
+	for _, d := range logged {
+		if d.Verdict == domain.VerdictAllow || d.ConfirmToken != "" {
+			t.Fatalf("unexpected logged decision %+v", d)
+		}
+	}
+	if len(logged) != 8 { // 预览不记录
+		t.Fatalf("logged %d decisions, want 8", len(logged))
+	}
+}
+
+func TestExecService_AllowLists(t *testing.T) {
+	db := openMemDB(t)
+	defer db.Close()
+	mRepo := repository.NewMachineRepo(db)
+	web := domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root", Groups: []string{"web"}}
+	dbm := domain.Machine{IPMIIP: "10.0.0.2", SSHIP: "10.0.0.2", SSHUser: "root", Groups: []string{"web", "db"}}
+	for _, m := range []*domain.Machine{&web, &dbm} {
+		if err := mRepo.Save(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := []int64{int64(web.ID), int64(dbm.ID)}
+	p, err := NewPolicy(PolicyConfig{AllowLists: AllowLists{
+		Roles:  map[string][]string{"operator": {"uptime", "systemctl status *", `re:df( -h)?`}},
+		Groups: map[string][]string{"db": {"uptime", "df -h"}},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := sshmock.NewMockExecutor()
+	for _, c := range []string{"uptime", "systemctl status nginx", "df -h", "systemctl restart nginx"} {
+		mock.Set(c, sshmock.MockResult{Stdout: "ok\n"})
+	}
+	svc := NewExecService(mRepo, nil, mock, 2)
+	svc.SetPolicy(p)
+
+	run := func(role domain.Role, cmd string) map[int64]error {
+		rs, err := svc.BatchExec(domain.ExecTask{Command: cmd, MachineIDs: ids, Role: role, User: "u"})
+		if err != nil {
+			t.Fatalf("%s %q: %v", role, cmd, err)
+		}
+		out := map[int64]error{}
+		for _, r := range rs {
+			out[r.MachineID] = r.Err
+			if r.Err != nil && (r.Passed || r.Stdout != "") {
+				t.Fatalf("blocked target executed: %+v", r)
+			}
+		}
+		return out
+	}
+	if errs := run(domain.RoleOperator, "uptime"); errs[ids[0]] != nil || errs[ids[1]] != nil {
+		t.Fatalf("uptime: %v", errs)
+	}
+	// 角色允许但 db 分组不允许：仅 db 机器被拦截
+	errs := run(domain.RoleOperator, "systemctl   status nginx")
+	if errs[ids[0]] != nil || !errors.Is(errs[ids[1]], ErrCommandNotAllowed) || !strings.Contains(errs[ids[1]].Error(), "group db") {
+		t.Fatalf("systemctl status: %v", errs)
+	}
+	if errs := run(domain.RoleOperator, "df -h"); errs[ids[0]] != nil || errs[ids[1]] != nil {
+		t.Fatalf("df -h: %v", errs)
+	}
+	// 角色白名单之外：全部机器被拦截
+	errs = run(domain.RoleOperator, "systemctl restart nginx")
+	if !errors.Is(errs[ids[0]], ErrCommandNotAllowed) || !strings.Contains(errs[ids[0]].Error(), "role operator") || errs[ids[1]] == nil {
+		t.Fatalf("systemctl restart: %v", errs)
+	}
+	// 未配置白名单的角色只受分组限制
+	if errs := run(domain.RoleAdmin, "systemctl restart nginx"); errs[ids[0]] != nil || !errors.Is(errs[ids[1]], ErrCommandNotAllowed) {
+		t.Fatalf("admin restart: %v", errs)
+	}
+	if _, err := NewPolicy(PolicyConfig{AllowLists: AllowLists{Roles: map[string][]string{"viewer": {"re:("}}}}, nil); err == nil {
+		t.Fatal("invalid allow-list regex accepted")
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// MachineFacts 返回命令模板可引用的机器字段。
+// 内置键: id / ipmi_ip / ssh_ip / ssh_user / zbx_id / remark；
+// 自定义属性 (Machine.Attrs) 以原键名合并，不覆盖内置键。
+func MachineFacts(m domain.Machine) map[string]string {
+	facts := make(map[string]string, 6+len(m.Attrs))
+	for k, v := range m.Attrs {
+		facts[k] = v
+	}
+	facts["id"] = strconv.Itoa(m.ID)
+	facts["ipmi_ip"] = m.IPMIIP
+	facts["ssh_ip"] = m.SSHIP
+	facts["ssh_user"] = m.SSHUser
+	facts["zbx_id"] = m.ZBXID
+	facts["remark"] = m.Remark
+	return facts
+}
+
+// RenderCommand 按机器渲染命令模板，例如:
+//
+//	hostnamectl set-hostname {{.hostname}}
+//	ipmitool lan set 1 ipaddr {{.ipmi_ip}}
+//
+// 仅在 ExecTask.Render 为 true 时使用。被引用的值不能含 shell 元字符 (见 shellMeta)，
+// 避免属性值绕过针对模板的策略规则与白名单；不含 "{{" 的命令原样返回；引用不存在的键视为错误，避免把空值下发到机器。
+// 模板中需要原样保留的 "{{" 写作 {{"{{"}}，如 docker inspect -f '{{"{{"}}.State.Status}}'。
+func RenderCommand(cmd string, m domain.Machine) (string, error) {
+	if !strings.Contains(cmd, "{{") {
+		return cmd, nil
+	}
+	tpl, err := template.New("cmd").Option("missingkey=error").Parse(cmd)
+	if err != nil {
+		return "", fmt.Errorf("parse command template: %w", err)
+	}
+	facts := MachineFacts(m)
+	out, err := execTemplate(tpl, facts)
+	if err != nil {
+		return "", fmt.Errorf("render command for %s: %w", m.IPMIIP, err)
+	}
+	// 含元字符的值逐个替换为空串重新渲染，结果不同说明模板引用了该值
+	for _, k := range slices.Sorted(maps.Keys(facts)) {
+		v := facts[k]
+		if !strings.ContainsAny(v, shellMeta) {
+			continue
+		}
+		facts[k] = ""
+		alt, _ := execTemplate(tpl, facts)
+		facts[k] = v
+		if alt != out {
+			return "", fmt.Errorf("render command for %s: value of %q contains shell metacharacters", m.IPMIIP, k)
+		}
+	}
+	return out, nil
+}
+
+// shellMeta 不允许出现在模板引用值中的字符 (命令分隔、替换、重定向、引号与换行)
+const shellMeta = ";&|$`<>()\\'\"\n\r"
+
+func execTemplate(tpl *template.Template, facts map[string]string) (string, error) {
+	var b strings.Builder
+	err := tpl.Execute(&b, facts)
+	return b.String(), err
+}
@@ -1,18 +1,22 @@
 package ssh
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	gssh "golang.org/x/crypto/ssh"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/logging"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/output"
 )
 
 // Executor 是一个简单的 SSH 执行器，实现 Exec(ctx, user, addr, key, cmd, timeout)
@@ -20,6 +24,7 @@ import (
 type Executor struct {
 	pool *ConnectionPool
 	sem  chan struct{}
+	log  *slog.Logger // context 未携带 logger 时使用
 }
 
 // NewExecutor 创建执行器。maxParallel <=0 表示不限制。
@@ -28,10 +33,26 @@ func NewExecutor(maxParallel int) *Executor {
 	if maxParallel > 0 {
 		sem = make(chan struct{}, maxParallel)
 	}
-	return &Executor{pool: NewConnectionPool(), sem: sem}
+	return &Executor{pool: NewConnectionPool(), sem: sem, log: slog.Default()}
 }
 
+// SetLogger 设置默认日志；调用方可通过 logging.NewContext 传入带 job_id 等字段的 logger
+func (e *Executor) SetLogger(l *slog.Logger) { e.log = l }
+
+// connect 从连接池取连接，失败时记录日志
+func (e *Executor) connect(ctx context.Context, user, addr, authMode, keyOrPass string) (*gssh.Client, error) {
+	client, err := e.pool.Get(user, addr, authMode+":"+keyOrPass)
+	if err != nil {
+		logging.FromContext(ctx, e.log).Warn("ssh connect failed", "addr", addr, "ssh_user", user, "auth", authMode, logging.Err(err))
+	}
+	return client, err
+}
+
+// PoolStats 返回底层连接池统计
+func (e *Executor) PoolStats() PoolStats { return e.pool.Stats() }
+
 // Exec 执行命令并返回 stdout/stderr/exitCode。
+// ctx 携带 output.Capture 时按其上限边读边截断 (只保留开头与结尾)，并回填原始字节数。
 func (e *Executor) Exec(ctx context.Context, user, addr, authMode, keyOrPass, cmd string, timeout time.Duration) (string, string, int, error) {
 	if user == "" || addr == "" {
 		return "", "", -1, errors.New("user/addr empty")
@@ -48,7 +69,7 @@ func (e *Executor) Exec(ctx context.Context, user, addr, authMode, keyOrPass, cm
 	}
 
 	// 获取/建立连接
-	client, err := e.pool.Get(user, addr, authMode+":"+keyOrPass)
+	client, err := e.connect(ctx, user, addr, authMode, keyOrPass)
 	if err != nil {
 		return "", "", -1, err
 	}
@@ -60,9 +81,11 @@ func (e *Executor) Exec(ctx context.Context, user, addr, authMode, keyOrPass, cm
 	}
 	defer session.Close()
 
-	var stdout, stderr bytes.Buffer
-	session.Stdout = &stdout
-	session.Stderr = &stderr
+	capt := output.FromContext(ctx)
+	stdout, stderr := capt.NewBuffer(), capt.NewBuffer()
+	session.Stdout = stdout
+	session.Stderr = stderr
+	defer capt.Finish(stdout, stderr)
 
 	done := make(chan error, 1)
 	go func() { done <- session.Run(cmd) }()
@@ -71,6 +94,7 @@ func (e *Executor) Exec(ctx context.Context, user, addr, authMode, keyOrPass, cm
 	case <-ctx.Done():
 		// 强制关闭底层连接以中断
 		_ = client.Close()
+		logging.FromContext(ctx, e.log).Debug("ssh command interrupted, connection closed", "addr", addr, logging.Err(ctx.Err()))
 		return stdout.String(), stderr.String(), -1, context.DeadlineExceeded
 	case err = <-done:
 	}
@@ -87,7 +111,7 @@ func (e *Executor) Exec(ctx context.Context, user, addr, authMode, keyOrPass, cm
 }
 
 // StreamExec 以流式方式执行命令，实时回调标准输出/错误。回调参数 isErr 表示是否来自 stderr。
-// 最终返回完整 stdout/stderr 与 exitCode。
+// 最终返回 stdout/stderr (按 ctx 中 output.Capture 的上限截断，回调不受影响) 与 exitCode。
 func (e *Executor) StreamExec(ctx context.Context, user, addr, authMode, keyOrPass, cmd string, timeout time.Duration, onChunk func(data []byte, isErr bool)) (string, string, int, error) {
 	if user == "" || addr == "" {
 		return "", "", -1, errors.New("user/addr empty")
@@ -102,7 +126,7 @@ func (e *Executor) StreamExec(ctx context.Context, user, addr, authMode, keyOrPa
 		e.sem <- struct{}{}
 		defer func() { <-e.sem }()
 	}
-	client, err := e.pool.Get(user, addr, authMode+":"+keyOrPass)
+	client, err := e.connect(ctx, user, addr, authMode, keyOrPass)
 	if err != nil {
 		return "", "", -1, err
 	}
@@ -123,7 +147,9 @@ func (e *Executor) StreamExec(ctx context.Context, user, addr, authMode, keyOrPa
 		return "", "", -1, err
 	}
 	// 读取循环
-	var stdoutBuf, stderrBuf bytes.Buffer
+	capt := output.FromContext(ctx)
+	stdoutBuf, stderrBuf := capt.NewBuffer(), capt.NewBuffer()
+	defer capt.Finish(stdoutBuf, stderrBuf)
 	wg := sync.WaitGroup{}
 	// 使用 io.ReadCloser (stdoutPipe/stderrPipe) 包装到 goroutine 中
 	wg.Add(2)
@@ -168,6 +194,7 @@ func (e *Executor) StreamExec(ctx context.Context, user, addr, authMode, keyOrPa
 	select {
 	case <-ctx.Done():
 		_ = client.Close()
+		logging.FromContext(ctx, e.log).Debug("ssh command interrupted, connection closed", "addr", addr, logging.Err(ctx.Err()))
 		runErr = context.DeadlineExceeded
 	case runErr = <-waitCh:
 	}
@@ -195,6 +222,16 @@ func makeKey(user, addr, key string) poolKey {
 type ConnectionPool struct {
 	mu      sync.Mutex
 	clients map[poolKey]*gssh.Client
+
+	dials, dialErrors, evictions atomic.Int64
+}
+
+// PoolStats 连接池统计 (供 metrics 采集)
+type PoolStats struct {
+	Open       int   // 当前缓存的连接数
+	Dials      int64 // 累计新建连接次数
+	DialErrors int64 // 累计建连失败次数 (含认证失败)
+	Evictions  int64 // 累计因健康检测失败被移除的连接
 }
 
 func NewConnectionPool() *ConnectionPool { return &ConnectionPool{clients: map[poolKey]*gssh.Client{}} }
@@ -213,6 +250,7 @@ func (p *ConnectionPool) Get(user, addr, key string) (*gssh.Client, error) {
 		p.mu.Lock()
 		delete(p.clients, pk)
 		p.mu.Unlock()
+		p.evictions.Add(1)
 	} else {
 		p.mu.Unlock()
 	}
@@ -238,8 +276,10 @@ func (p *ConnectionPool) Get(user, addr, key string) (*gssh.Client, error) {
 	if _, _, errSplit := net.SplitHostPort(addr); errSplit != nil {
 		target = addr + ":22"
 	}
+	p.dials.Add(1)
 	c, err := gssh.Dial("tcp", target, conf)
 	if err != nil {
+		p.dialErrors.Add(1)
 		return nil, err
 	}
 	p.mu.Lock()
@@ -248,6 +288,13 @@ func (p *ConnectionPool) Get(user, addr, key string) (*gssh.Client, error) {
 	return c, nil
 }
 
+func (p *ConnectionPool) Stats() PoolStats {
+	p.mu.Lock()
+	open := len(p.clients)
+	p.mu.Unlock()
+	return PoolStats{Open: open, Dials: p.dials.Load(), DialErrors: p.dialErrors.Load(), Evictions: p.evictions.Load()}
+}
+
 func (p *ConnectionPool) CloseAll() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -0,0 +1,85 @@
+package tui
+
+import "unicode/utf8"
+
+type keyKind int
+
+const (
+	keyRune keyKind = iota
+	keyEnter
+	keyEsc
+	keyBackspace
+	keyTab
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyPgUp
+	keyPgDn
+	keyHome
+	keyEnd
+	keyCtrlC
+	keyCtrlU
+)
+
+// key 一次按键；kind 为 keyRune 时 r 为输入字符
+type key struct {
+	kind keyKind
+	r    rune
+}
+
+func (k key) is(r rune) bool { return k.kind == keyRune && k.r == r }
+
+// csiKeys ESC [ / ESC O 序列 (去掉前缀) 到按键
+var csiKeys = map[string]keyKind{
+	"A": keyUp, "B": keyDown, "C": keyRight, "D": keyLeft,
+	"H": keyHome, "F": keyEnd, "1~": keyHome, "4~": keyEnd, "7~": keyHome, "8~": keyEnd,
+	"5~": keyPgUp, "6~": keyPgDn,
+}
+
+// parseKeys 解析一次读取到的原始输入 (raw 模式)；不认识的转义序列被忽略
+func parseKeys(b []byte) []key {
+	var ks []key
+	for len(b) > 0 {
+		c := b[0]
+		switch {
+		case c == 0x1b:
+			if len(b) == 1 || (b[1] != '[' && b[1] != 'O') {
+				ks = append(ks, key{kind: keyEsc})
+				b = b[1:]
+				continue
+			}
+			j := 2 // 终止字节范围 0x40-0x7e
+			for j < len(b) && (b[j] < 0x40 || b[j] > 0x7e) {
+				j++
+			}
+			if j >= len(b) { // 不完整序列
+				return ks
+			}
+			if k, ok := csiKeys[string(b[2:j+1])]; ok {
+				ks = append(ks, key{kind: k})
+			}
+			b = b[j+1:]
+			continue
+		case c == '\r' || c == '\n':
+			ks = append(ks, key{kind: keyEnter})
+		case c == 0x7f || c == 0x08:
+			ks = append(ks, key{kind: keyBackspace})
+		case c == '\t':
+			ks = append(ks, key{kind: keyTab})
+		case c == 0x03:
+			ks = append(ks, key{kind: keyCtrlC})
+		case c == 0x15:
+			ks = append(ks, key{kind: keyCtrlU})
+		case c < 0x20:
+			// 其它控制字符忽略
+		default:
+			r, n := utf8.DecodeRune(b)
+			ks = append(ks, key{kind: keyRune, r: r})
+			b = b[n:]
+			continue
+		}
+		b = b[1:]
+	}
+	return ks
+}
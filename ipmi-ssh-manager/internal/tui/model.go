@@ -0,0 +1,649 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+type view int
+
+const (
+	viewMachines view = iota
+	viewExec
+	viewHistory
+)
+
+// prompt 底部输入行，回车提交、Esc 取消
+type prompt struct {
+	label  string
+	buf    []rune
+	submit func(m *model, s string)
+}
+
+// model 界面状态；只在主循环 goroutine 中读写，后台任务经 post 投递变更
+type model struct {
+	opts Options
+	ctx  context.Context
+	post func(func(*model))
+
+	view          view
+	width, height int
+	status        string
+	prompt        *prompt
+	quit          bool
+
+	// 机器页
+	machines []domain.Machine // 当前过滤结果
+	total    int
+	filter   string
+	mCursor  int
+	mTop     int
+	selected map[int64]domain.Machine // 跨过滤保留的多选
+
+	// 执行页
+	job *execJob
+
+	// 历史页
+	history   []domain.ExecHistory
+	hFilter   string
+	hCursor   int
+	hTop      int
+	detail    []string // 非空时显示单条历史详情
+	detailTop int
+}
+
+func newModel(ctx context.Context, opts Options, post func(func(*model))) *model {
+	return &model{opts: opts, ctx: ctx, post: post, width: 80, height: 24, selected: map[int64]domain.Machine{}}
+}
+
+// bodyHeight 除去顶部标签栏与底部提示行的可用行数
+func (m *model) bodyHeight() int { return max(1, m.height-2) }
+
+func (m *model) handleKey(k key) {
+	if k.kind == keyCtrlC {
+		m.cancelJob()
+		m.quit = true
+		return
+	}
+	if m.prompt != nil {
+		m.promptKey(k)
+		return
+	}
+	if m.view == viewHistory && m.detail != nil {
+		m.detailKey(k)
+		return
+	}
+	switch {
+	case k.is('q'):
+		m.cancelJob()
+		m.quit = true
+		return
+	case k.is('1'):
+		m.view = viewMachines
+		return
+	case k.is('2'):
+		if m.job == nil {
+			m.status = "no job yet: select machines and press x"
+			return
+		}
+		m.view = viewExec
+		return
+	case k.is('3'):
+		m.view = viewHistory
+		m.loadHistory()
+		return
+	case k.kind == keyTab:
+		m.view = (m.view + 1) % 3
+		if m.view == viewExec && m.job == nil {
+			m.view = viewHistory
+		}
+		if m.view == viewHistory {
+			m.loadHistory()
+		}
+		return
+	}
+	switch m.view {
+	case viewMachines:
+		m.machinesKey(k)
+	case viewExec:
+		m.execKey(k)
+	case viewHistory:
+		m.historyKey(k)
+	}
+}
+
+func (m *model) promptKey(k key) {
+	p := m.prompt
+	switch k.kind {
+	case keyEsc:
+		m.prompt = nil
+	case keyEnter:
+		m.prompt = nil
+		p.submit(m, strings.TrimSpace(string(p.buf)))
+	case keyBackspace:
+		if len(p.buf) > 0 {
+			p.buf = p.buf[:len(p.buf)-1]
+		}
+	case keyCtrlU:
+		p.buf = nil
+	case keyRune:
+		p.buf = append(p.buf, k.r)
+	}
+}
+
+func (m *model) ask(label, initial string, submit func(*model, string)) {
+	m.prompt = &prompt{label: label, buf: []rune(initial), submit: submit}
+}
+
+// moveCursor 处理通用的列表移动键，返回是否已处理
+func moveCursor(k key, cursor *int, n, page int) bool {
+	switch {
+	case k.kind == keyUp || k.is('k'):
+		*cursor--
+	case k.kind == keyDown || k.is('j'):
+		*cursor++
+	case k.kind == keyPgUp:
+		*cursor -= page
+	case k.kind == keyPgDn:
+		*cursor += page
+	case k.kind == keyHome || k.is('g'):
+		*cursor = 0
+	case k.kind == keyEnd || k.is('G'):
+		*cursor = n - 1
+	default:
+		return false
+	}
+	*cursor = max(0, min(*cursor, n-1))
+	return true
+}
+
+// ---- 机器页 ----
+
+func (m *model) machinesKey(k key) {
+	if moveCursor(k, &m.mCursor, len(m.machines), m.bodyHeight()-1) {
+		return
+	}
+	switch {
+	case k.is(' '):
+		if m.mCursor < len(m.machines) {
+			mc := m.machines[m.mCursor]
+			if _, ok := m.selected[int64(mc.ID)]; ok {
+				delete(m.selected, int64(mc.ID))
+			} else {
+				m.selected[int64(mc.ID)] = mc
+			}
+			m.mCursor = min(m.mCursor+1, len(m.machines)-1)
+		}
+	case k.is('a'):
+		for _, mc := range m.machines {
+			m.selected[int64(mc.ID)] = mc
+		}
+		m.status = fmt.Sprintf("%d selected", len(m.selected))
+	case k.is('n'):
+		m.selected = map[int64]domain.Machine{}
+		m.status = "selection cleared"
+	case k.is('/'):
+		m.ask("filter (e.g. ssh_user=root ipmi_ip:10.0.0.0/24 label:rack=A12)", m.filter, func(m *model, s string) { m.applyFilter(s) })
+	case k.is('r'):
+		m.applyFilter(m.filter)
+	case k.is('x') || k.is(':') || k.kind == keyEnter:
+		targets := m.targets()
+		if len(targets) == 0 {
+			m.status = "no machines selected"
+			return
+		}
+		m.ask(fmt.Sprintf("run on %d host(s)", len(targets)), "", func(m *model, s string) { m.startExec(s, targets) })
+	case k.is('K'):
+		if m.opts.SetGlobalKey == nil {
+			return
+		}
+		m.ask("global SSH key file", "", func(m *model, path string) { m.loadGlobalKey(path) })
+	}
+}
+
+// applyFilter 以结构化查询语法过滤机器
+func (m *model) applyFilter(s string) {
+	q, err := domain.ParseMachineQuery(s)
+	if err != nil {
+		m.status = "filter: " + err.Error()
+		return
+	}
+	page, err := m.opts.Machines.Query(q)
+	if err != nil {
+		m.status = "query failed: " + err.Error()
+		return
+	}
+	m.filter = s
+	m.machines = page.Items
+	m.total = page.Total
+	m.mCursor = max(0, min(m.mCursor, len(m.machines)-1))
+	m.status = fmt.Sprintf("%d machines", page.Total)
+}
+
+// targets 已选机器 (按 ID 排序)；未选择时取光标所在机器
+func (m *model) targets() []domain.Machine {
+	if len(m.selected) == 0 {
+		if m.mCursor < len(m.machines) {
+			return []domain.Machine{m.machines[m.mCursor]}
+		}
+		return nil
+	}
+	out := make([]domain.Machine, 0, len(m.selected))
+	for _, mc := range m.selected {
+		out = append(out, mc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (m *model) loadGlobalKey(path string) {
+	if path == "" {
+		return
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+	if err := m.opts.SetGlobalKey(string(b)); err != nil {
+		m.status = "set key: " + err.Error()
+		return
+	}
+	m.status = "global SSH key loaded"
+}
+
+// ---- 执行页 ----
+
+type paneState int
+
+const (
+	paneQueued paneState = iota
+	paneRunning
+	paneOK
+	paneFailed
+)
+
+// maxPaneLines 每台机器保留的输出行数上限
+const maxPaneLines = 5000
+
+type paneLine struct {
+	text  string
+	isErr bool
+}
+
+// pane 单台机器的实时输出
+type pane struct {
+	machine   domain.Machine
+	state     paneState
+	lines     []paneLine
+	partial   [2]string // stdout / stderr 未结束的行
+	gotChunks bool
+	result    domain.ExecResult
+}
+
+func (p *pane) write(b []byte, isErr bool) {
+	p.gotChunks = true
+	i := 0
+	if isErr {
+		i = 1
+	}
+	parts := strings.Split(p.partial[i]+string(b), "\n")
+	for _, l := range parts[:len(parts)-1] {
+		p.addLine(l, isErr)
+	}
+	p.partial[i] = parts[len(parts)-1]
+}
+
+func (p *pane) addLine(l string, isErr bool) {
+	p.lines = append(p.lines, paneLine{text: sanitize(l), isErr: isErr})
+	if len(p.lines) > maxPaneLines {
+		p.lines = p.lines[len(p.lines)-maxPaneLines:]
+	}
+}
+
+func (p *pane) finish(r domain.ExecResult) {
+	if !p.gotChunks { // 执行器不支持流式，一次性写入
+		p.write([]byte(r.Stdout), false)
+		p.write([]byte(r.Stderr), true)
+	}
+	for i, rest := range p.partial {
+		if rest != "" {
+			p.addLine(rest, i == 1)
+		}
+		p.partial[i] = ""
+	}
+	if r.Err != nil {
+		p.addLine("error: "+r.Err.Error(), true)
+	} else if r.AssertMsg != "" {
+		p.addLine("failed: "+r.AssertMsg, true)
+	}
+	p.result = r
+	p.state = paneOK
+	if !r.Passed {
+		p.state = paneFailed
+	}
+}
+
+// execJob 一次批量执行
+type execJob struct {
+	id      string
+	command string
+	cancel  context.CancelFunc
+	panes   []*pane
+	running int // 未结束的机器数
+	focus   int
+	zoom    bool
+	scroll  int // 放大时自底部向上滚动的行数
+	cols    int // 最近一次布局的列数 (上下移动焦点用)
+}
+
+func (j *execJob) counts() (ok, failed int) {
+	for _, p := range j.panes {
+		switch p.state {
+		case paneOK:
+			ok++
+		case paneFailed:
+			failed++
+		}
+	}
+	return
+}
+
+func (m *model) startExec(command string, targets []domain.Machine) {
+	if command == "" {
+		return
+	}
+	if m.job != nil && m.job.running > 0 {
+		m.status = "a job is still running (press c in Exec view to cancel)"
+		return
+	}
+	timeout := m.opts.Timeout
+	if timeout <= 0 {
+		timeout = 30
+	}
+	task := domain.ExecTask{JobID: service.NewJobID(), Command: command, Timeout: timeout, Stream: true, AuthMode: "key", User: m.opts.User, Role: m.opts.Role}
+	for _, mc := range targets {
+		task.MachineIDs = append(task.MachineIDs, int64(mc.ID))
+	}
+	m.submitExec(task, targets)
+}
+
+// submitExec 记录审计并经命令策略检查后启动；策略要求确认或审批时提示输入后重新提交
+func (m *model) submitExec(task domain.ExecTask, targets []domain.Machine) {
+	if err := m.opts.Audit.RecordExec(task); err != nil { // 审计写入失败时不下发
+		m.status = "audit: " + err.Error()
+		return
+	}
+	if err := m.opts.Exec.Admit(&task); err != nil {
+		var pe *service.PolicyError
+		switch {
+		case errors.As(err, &pe) && pe.Decision.ConfirmToken != "":
+			token := pe.Decision.ConfirmToken
+			m.ask(fmt.Sprintf("%s; type yes to run", strings.Join(pe.Decision.Reasons, ", ")), "", func(m *model, s string) {
+				if s != "yes" {
+					m.status = "cancelled"
+					return
+				}
+				task.Confirm = token
+				m.submitExec(task, targets)
+			})
+		case errors.As(err, &pe) && pe.Decision.ApprovalID != "" && task.ApprovalID == "":
+			m.ask(fmt.Sprintf("needs approval by another user (ipmictl approval approve %s); enter to run once approved", pe.Decision.ApprovalID), pe.Decision.ApprovalID, func(m *model, s string) {
+				task.ApprovalID = s
+				m.submitExec(task, targets)
+			})
+		default:
+			m.status = err.Error()
+		}
+		return
+	}
+	command := task.Command
+	ctx, cancel := context.WithCancel(m.ctx)
+	job := &execJob{id: task.JobID, command: command, cancel: cancel, running: len(targets)}
+	for _, mc := range targets {
+		job.panes = append(job.panes, &pane{machine: mc})
+	}
+	m.job = job
+	m.view = viewExec
+	m.status = fmt.Sprintf("job %s started on %d host(s)", job.id, len(targets))
+
+	parallel := m.opts.Parallel
+	if parallel <= 0 {
+		parallel = defaultParallel
+	}
+	sem := make(chan struct{}, parallel)
+	post, execSvc := m.post, m.opts.Exec
+	var wg sync.WaitGroup
+	for _, p := range job.panes {
+		wg.Add(1)
+		go func(p *pane) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				r := domain.ExecResult{JobID: job.id, MachineID: int64(p.machine.ID), IPMIIP: p.machine.IPMIIP, Err: ctx.Err()}
+				post(func(m *model) { m.paneDone(job, p, r) })
+				return
+			}
+			post(func(*model) { p.state = paneRunning })
+			r, _ := execSvc.SingleStream(ctx, p.machine, task, p.machine.SSHKey, "key", func(_ int64, b []byte, isErr bool) {
+				chunk := append([]byte(nil), b...) // 执行器可能复用缓冲
+				post(func(*model) { p.write(chunk, isErr) })
+			})
+			post(func(m *model) { m.paneDone(job, p, r) })
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+}
+
+func (m *model) paneDone(job *execJob, p *pane, r domain.ExecResult) {
+	p.finish(r)
+	job.running--
+	if job.running == 0 && m.job == job {
+		ok, failed := job.counts()
+		m.status = fmt.Sprintf("job %s done: %d ok, %d failed", job.id, ok, failed)
+	}
+}
+
+func (m *model) cancelJob() {
+	if m.job != nil && m.job.running > 0 {
+		m.job.cancel()
+		m.status = "cancelling job " + m.job.id
+	}
+}
+
+func (m *model) execKey(k key) {
+	j := m.job
+	if j == nil || len(j.panes) == 0 {
+		return
+	}
+	switch {
+	case k.kind == keyLeft || k.is('h'):
+		j.focus--
+	case k.kind == keyRight || k.is('l'):
+		j.focus++
+	case k.kind == keyUp || k.is('k'):
+		if j.zoom {
+			j.scroll++
+		} else {
+			j.focus -= max(1, j.cols)
+		}
+	case k.kind == keyDown || k.is('j'):
+		if j.zoom {
+			j.scroll--
+		} else {
+			j.focus += max(1, j.cols)
+		}
+	case k.kind == keyPgUp:
+		j.scroll += m.bodyHeight() - 2
+	case k.kind == keyPgDn:
+		j.scroll -= m.bodyHeight() - 2
+	case k.is('z') || k.kind == keyEnter:
+		j.zoom = !j.zoom
+		j.scroll = 0
+	case k.kind == keyEsc:
+		if j.zoom {
+			j.zoom = false
+		} else {
+			m.view = viewMachines
+		}
+	case k.is('c'):
+		m.cancelJob()
+	case k.is('x') || k.is(':'):
+		targets := make([]domain.Machine, len(j.panes))
+		for i, p := range j.panes {
+			targets[i] = p.machine
+		}
+		m.ask(fmt.Sprintf("run on %d host(s)", len(targets)), j.command, func(m *model, s string) { m.startExec(s, targets) })
+	}
+	j.focus = max(0, min(j.focus, len(j.panes)-1))
+	j.scroll = max(0, j.scroll)
+}
+
+// ---- 历史页 ----
+
+// loadHistory 过滤语法: "ip:10.0.0.1 uptime" (ip: 匹配 IPMI，其余匹配命令)，"job:<id>" 查看整个任务
+func (m *model) loadHistory() {
+	var (
+		hs  []domain.ExecHistory
+		err error
+	)
+	ip, job, words := "", "", []string(nil)
+	for _, f := range strings.Fields(m.hFilter) {
+		switch {
+		case strings.HasPrefix(f, "ip:"):
+			ip = strings.TrimPrefix(f, "ip:")
+		case strings.HasPrefix(f, "job:"):
+			job = strings.TrimPrefix(f, "job:")
+		default:
+			words = append(words, f)
+		}
+	}
+	limit := m.opts.HistoryLimit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	switch {
+	case job != "":
+		hs, err = m.opts.History.ListByJob(job)
+	case ip != "" || len(words) > 0:
+		hs, err = m.opts.History.ListFiltered(limit, ip, strings.Join(words, " "))
+	default:
+		hs, err = m.opts.History.ListRecent(limit)
+	}
+	if err != nil {
+		m.status = "history: " + err.Error()
+		return
+	}
+	m.history = hs
+	m.hCursor = max(0, min(m.hCursor, len(hs)-1))
+	m.status = fmt.Sprintf("%d history records", len(hs))
+}
+
+func (m *model) historyKey(k key) {
+	if moveCursor(k, &m.hCursor, len(m.history), m.bodyHeight()-1) {
+		return
+	}
+	switch {
+	case k.is('/'):
+		m.ask("history filter (ip:<ipmi> job:<id> words...)", m.hFilter, func(m *model, s string) {
+			m.hFilter = s
+			m.hCursor = 0
+			m.loadHistory()
+		})
+	case k.is('r'):
+		m.loadHistory()
+	case k.is('J'):
+		if m.hCursor < len(m.history) && m.history[m.hCursor].JobID != "" {
+			m.hFilter = "job:" + m.history[m.hCursor].JobID
+			m.hCursor = 0
+			m.loadHistory()
+		}
+	case k.kind == keyEsc:
+		if m.hFilter != "" {
+			m.hFilter = ""
+			m.loadHistory()
+		}
+	case k.kind == keyEnter:
+		if m.hCursor < len(m.history) {
+			m.detail = historyDetail(m.fullHistory(m.history[m.hCursor]))
+			m.detailTop = 0
+		}
+	}
+}
+
+func (m *model) detailKey(k key) {
+	if k.kind == keyEsc || k.kind == keyEnter || k.is('q') {
+		m.detail = nil
+		return
+	}
+	moveCursor(k, &m.detailTop, len(m.detail), m.bodyHeight())
+}
+
+// fullHistory 列表中只有输出预览时按 ID 加载完整输出
+func (m *model) fullHistory(h domain.ExecHistory) domain.ExecHistory {
+	loader, ok := m.opts.History.(repository.HistoryOutputLoader)
+	if !h.Preview || !ok {
+		return h
+	}
+	o, err := loader.GetOutput(h.ID)
+	if err != nil {
+		m.status = "history output: " + err.Error()
+		return h
+	}
+	h.Stdout, h.Stderr, h.Preview = o.Stdout, o.Stderr, false
+	return h
+}
+
+func historyDetail(h domain.ExecHistory) []string {
+	lines := []string{
+		fmt.Sprintf("job:      %s", h.JobID),
+		fmt.Sprintf("machine:  #%d %s", h.MachineID, h.IPMIIP),
+		fmt.Sprintf("command:  %s", h.Command),
+		fmt.Sprintf("started:  %s (%s)", h.StartedAt.Format(time.DateTime), time.Duration(h.DurationMs)*time.Millisecond),
+		fmt.Sprintf("exit:     %d  passed: %v", h.ExitCode, h.Passed),
+	}
+	if h.ErrorText != "" {
+		lines = append(lines, "error:    "+h.ErrorText)
+	}
+	if h.AssertMsg != "" {
+		lines = append(lines, "assert:   "+h.AssertMsg)
+	}
+	if h.Truncated {
+		lines = append(lines, fmt.Sprintf("output:   truncated, %d bytes in total (head and tail kept)", h.OutputBytes))
+	}
+	if h.Preview {
+		lines = append(lines, "output:   preview only")
+	}
+	lines = append(lines, "", "--- stdout ---")
+	lines = append(lines, splitOutput(h.Stdout)...)
+	lines = append(lines, "--- stderr ---")
+	lines = append(lines, splitOutput(h.Stderr)...)
+	return lines
+}
+
+func splitOutput(s string) []string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return nil
+	}
+	out := strings.Split(s, "\n")
+	for i := range out {
+		out[i] = sanitize(out[i])
+	}
+	return out
+}
@@ -0,0 +1,365 @@
+package tui
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rivo/uniseg"
+)
+
+const (
+	styleReset   = "\x1b[0m"
+	styleBold    = "\x1b[1m"
+	styleDim     = "\x1b[2m"
+	styleReverse = "\x1b[7m"
+	styleRed     = "\x1b[31m"
+	styleGreen   = "\x1b[32m"
+	styleYellow  = "\x1b[33m"
+	styleCyan    = "\x1b[36m"
+)
+
+// minPaneWidth / minPaneHeight 网格布局中单个输出窗格的最小尺寸
+const (
+	minPaneWidth  = 48
+	minPaneHeight = 6
+)
+
+var reANSI = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b[@-_]`)
+
+// sanitize 去掉远端输出中的转义序列与控制字符，避免破坏布局；\r 只保留最后一段 (进度条)
+func sanitize(s string) string {
+	if i := strings.LastIndexByte(strings.TrimRight(s, "\r"), '\r'); i >= 0 {
+		s = s[i+1:]
+	}
+	s = reANSI.ReplaceAllString(s, "")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t':
+			return ' '
+		case r < 0x20 || r == 0x7f:
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// fit 按显示宽度截断或补空格到恰好 w 列 (中文等宽字符按 2 列计)
+func fit(s string, w int) string {
+	if w <= 0 {
+		return ""
+	}
+	var b strings.Builder
+	used := 0
+	state := -1
+	rest := s
+	for len(rest) > 0 {
+		var cluster string
+		var cw int
+		cluster, rest, cw, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		if used+cw > w || (used+cw == w && len(rest) > 0) {
+			if used < w { // 截断标记
+				b.WriteString("…")
+				used++
+			}
+			break
+		}
+		b.WriteString(cluster)
+		used += cw
+	}
+	if used < w {
+		b.WriteString(strings.Repeat(" ", w-used))
+	}
+	return b.String()
+}
+
+// scrollTop 让光标保持在可见窗口内
+func scrollTop(cursor, top, visible int) int {
+	if cursor < top {
+		return cursor
+	}
+	if visible > 0 && cursor >= top+visible {
+		return cursor - visible + 1
+	}
+	return max(0, top)
+}
+
+// render 生成整屏内容，恰好 height 行 (不含换行)
+func (m *model) render() []string {
+	lines := []string{m.renderTabs()}
+	var body []string
+	switch m.view {
+	case viewMachines:
+		body = m.renderMachines()
+	case viewExec:
+		body = m.renderExec()
+	case viewHistory:
+		if m.detail != nil {
+			body = m.renderDetail()
+		} else {
+			body = m.renderHistory()
+		}
+	}
+	h := m.bodyHeight()
+	for i := 0; i < h; i++ {
+		if i < len(body) {
+			lines = append(lines, body[i])
+		} else {
+			lines = append(lines, fit("", m.width))
+		}
+	}
+	return append(lines, m.renderFooter())
+}
+
+func (m *model) renderTabs() string {
+	var b strings.Builder
+	used := 0
+	for i, name := range []string{"1 Machines", "2 Exec", "3 History"} {
+		label := " " + name + " "
+		if view(i) == m.view {
+			b.WriteString(styleReverse + label + styleReset)
+		} else {
+			b.WriteString(label)
+		}
+		used += len(label)
+	}
+	if rest := m.width - used; rest > 0 {
+		b.WriteString(styleDim + fit(" "+m.status, rest) + styleReset)
+	}
+	return b.String()
+}
+
+func (m *model) renderFooter() string {
+	w := m.width - 1 // 最后一行不写满，避免终端自动换行滚屏
+	if m.prompt != nil {
+		text := []rune(m.prompt.label + ": " + string(m.prompt.buf))
+		for len(text) > 0 && uniseg.StringWidth(string(text)) > w-1 { // 输入过长时显示尾部
+			text = text[1:]
+		}
+		return styleBold + fit(string(text)+"█", w) + styleReset
+	}
+	var help string
+	switch {
+	case m.view == viewMachines:
+		help = "↑↓ move  space select  a all  n none  / filter  x run  r reload  K global key  tab/1-3 views  q quit"
+	case m.view == viewExec && m.job != nil && m.job.zoom:
+		help = "↑↓ PgUp/PgDn scroll  z/esc unzoom  ←→ prev/next host  c cancel  x rerun  q quit"
+	case m.view == viewExec:
+		help = "arrows focus  z zoom  c cancel  x rerun  esc machines  q quit"
+	case m.view == viewHistory && m.detail != nil:
+		help = "↑↓ PgUp/PgDn scroll  esc back"
+	default:
+		help = "↑↓ move  enter detail  / filter  J whole job  esc clear filter  r reload  q quit"
+	}
+	return styleDim + fit(help, w) + styleReset
+}
+
+func (m *model) renderMachines() []string {
+	w := m.width
+	cols := []struct {
+		title string
+		width int
+	}{{"", 3}, {"ID", 6}, {"IPMI_IP", 16}, {"SSH_IP", 22}, {"USER", 9}, {"GROUPS", 14}, {"LABELS", 26}, {"REMARK", 0}}
+	fixed := 0
+	for _, c := range cols {
+		fixed += c.width
+	}
+	cols[len(cols)-1].width = max(8, w-fixed)
+	row := func(cells ...string) string {
+		var b strings.Builder
+		for i, c := range cols {
+			b.WriteString(fit(cells[i], c.width))
+		}
+		return fit(b.String(), w)
+	}
+	title := make([]string, len(cols))
+	for i, c := range cols {
+		title[i] = c.title
+	}
+	title[0] = fmt.Sprintf("%d", len(m.selected))
+	out := []string{styleBold + row(title...) + styleReset}
+
+	visible := m.bodyHeight() - 1
+	m.mTop = scrollTop(m.mCursor, m.mTop, visible)
+	if len(m.machines) == 0 {
+		return append(out, fit("  (no machines match)", w))
+	}
+	for i := m.mTop; i < len(m.machines) && i < m.mTop+visible; i++ {
+		mc := m.machines[i]
+		mark := "[ ]"
+		if _, ok := m.selected[int64(mc.ID)]; ok {
+			mark = "[x]"
+		}
+		line := row(mark, fmt.Sprintf("%d", mc.ID), mc.IPMIIP, mc.SSHIP, mc.SSHUser, strings.Join(mc.Groups, ","), formatLabels(mc.Labels), mc.Remark)
+		if i == m.mCursor {
+			line = styleReverse + line + styleReset
+		} else if mark == "[x]" {
+			line = styleCyan + line + styleReset
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + labels[k]
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *model) renderExec() []string {
+	j := m.job
+	w := m.width
+	if j == nil {
+		return []string{fit("  no job", w)}
+	}
+	ok, failed := j.counts()
+	head := fmt.Sprintf(" job %s  %d/%d done  ok %d  failed %d  $ %s", j.id, len(j.panes)-j.running, len(j.panes), ok, failed, j.command)
+	out := []string{styleBold + fit(head, w) + styleReset}
+	area := m.bodyHeight() - 1
+	if area < 2 || len(j.panes) == 0 {
+		return out
+	}
+	if j.zoom {
+		return append(out, renderPane(j.panes[j.focus], w, area, true, j.scroll)...)
+	}
+	n := len(j.panes)
+	cols := max(1, min(n, w/minPaneWidth))
+	j.cols = cols
+	gridRows := (n + cols - 1) / cols
+	paneH := max(minPaneHeight, area/gridRows)
+	paneH = min(paneH, area)
+	perPage := max(1, area/paneH) // 每页可见的窗格行数
+	firstRow := (j.focus / cols) / perPage * perPage
+	for r := firstRow; r < gridRows && r < firstRow+perPage; r++ {
+		block := make([]string, paneH)
+		for c := 0; c < cols; c++ {
+			pw := w / cols
+			if c == cols-1 {
+				pw = w - pw*(cols-1)
+			}
+			idx := r*cols + c
+			var pl []string
+			if idx < n {
+				pl = renderPane(j.panes[idx], pw, paneH, idx == j.focus, 0)
+			} else {
+				pl = make([]string, paneH)
+				for i := range pl {
+					pl[i] = fit("", pw)
+				}
+			}
+			for i := range block {
+				block[i] += pl[i]
+			}
+		}
+		out = append(out, block...)
+	}
+	return out
+}
+
+// renderPane 标题行 + 输出末尾若干行；scroll 为自底部向上偏移
+func renderPane(p *pane, w, h int, focused bool, scroll int) []string {
+	icon, color := "·", styleDim
+	switch p.state {
+	case paneRunning:
+		icon, color = "●", styleYellow
+	case paneOK:
+		icon, color = "✓", styleGreen
+	case paneFailed:
+		icon, color = "✗", styleRed
+	}
+	status := map[paneState]string{paneQueued: "queued", paneRunning: "running"}[p.state]
+	if p.state == paneOK || p.state == paneFailed {
+		status = fmt.Sprintf("exit %d", p.result.ExitCode)
+	}
+	title := fmt.Sprintf(" %s %s  %s", icon, p.machine.IPMIIP, status)
+	style := color
+	if focused {
+		style = styleReverse + color
+	}
+	out := []string{style + fit(title, w) + styleReset}
+
+	visible := h - 1
+	lines := p.lines
+	// 未结束的行也显示 (实时进度)
+	for i, rest := range p.partial {
+		if rest != "" {
+			lines = append(lines[:len(lines):len(lines)], paneLine{text: sanitize(rest), isErr: i == 1})
+		}
+	}
+	end := max(0, len(lines)-min(scroll, max(0, len(lines)-visible)))
+	start := max(0, end-visible)
+	for _, l := range lines[start:end] {
+		text := " " + fit(l.text, w-2) + "│"
+		if w < 3 {
+			text = fit(l.text, w)
+		}
+		if l.isErr {
+			text = styleRed + text + styleReset
+		}
+		out = append(out, text)
+	}
+	for len(out) < h {
+		out = append(out, fit("", w-1)+"│")
+	}
+	return out
+}
+
+func (m *model) renderHistory() []string {
+	w := m.width
+	cols := []int{20, 16, 6, 9, 4, 0}
+	cols[len(cols)-1] = max(10, w-20-16-6-9-4)
+	row := func(cells ...string) string {
+		var b strings.Builder
+		for i, cw := range cols {
+			b.WriteString(fit(cells[i], cw))
+		}
+		return fit(b.String(), w)
+	}
+	title := "TIME"
+	if m.hFilter != "" {
+		title = "TIME [" + m.hFilter + "]"
+	}
+	out := []string{styleBold + row(title, "IPMI_IP", "EXIT", "DUR", "OK", "COMMAND") + styleReset}
+	visible := m.bodyHeight() - 1
+	m.hTop = scrollTop(m.hCursor, m.hTop, visible)
+	if len(m.history) == 0 {
+		return append(out, fit("  (no history)", w))
+	}
+	for i := m.hTop; i < len(m.history) && i < m.hTop+visible; i++ {
+		h := m.history[i]
+		okMark := "✓"
+		if !h.Passed {
+			okMark = "✗"
+		}
+		dur := (time.Duration(h.DurationMs) * time.Millisecond).Round(time.Millisecond).String()
+		line := row(h.StartedAt.Format(time.DateTime), h.IPMIIP, fmt.Sprintf("%d", h.ExitCode), dur, okMark, sanitize(h.Command))
+		switch {
+		case i == m.hCursor:
+			line = styleReverse + line + styleReset
+		case !h.Passed:
+			line = styleRed + line + styleReset
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func (m *model) renderDetail() []string {
+	visible := m.bodyHeight()
+	m.detailTop = max(0, min(m.detailTop, len(m.detail)-visible))
+	var out []string
+	for i := m.detailTop; i < len(m.detail) && i < m.detailTop+visible; i++ {
+		out = append(out, fit(" "+m.detail[i], m.width))
+	}
+	return out
+}
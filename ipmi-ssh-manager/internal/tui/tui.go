@@ -0,0 +1,147 @@
+// Package tui 终端界面 (IPMI_MODE=tui)：机器列表过滤与多选、命令输入、
+// 按机器分窗格的实时输出 (ExecService.SingleStream) 以及历史浏览，不依赖 Wails 或图形环境。
+package tui
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+const (
+	defaultParallel     = 10
+	defaultHistoryLimit = 200
+	frameInterval       = 33 * time.Millisecond // 最高约 30 帧/秒，同时检测窗口尺寸变化
+)
+
+// Options TUI 依赖与参数
+type Options struct {
+	Machines     repository.MachineRepoIface
+	History      repository.HistoryRepoIface
+	Exec         *service.ExecService
+	Parallel     int                    // 同时执行的机器数 (<=0 默认 10)
+	Timeout      int                    // 单机超时秒数 (<=0 默认 30)
+	HistoryLimit int                    // 历史页加载条数 (<=0 默认 200)
+	SetGlobalKey func(key string) error // 可选：K 键加载全局私钥
+	User         string                 // 发起者，写入历史与审计
+	Role         domain.Role            // 发起者角色 (命令白名单)
+	Audit        *service.Auditor       // 可选：记录下发的命令
+}
+
+// Run 接管终端直到用户退出或 ctx 结束；stdin 必须是终端
+func Run(ctx context.Context, opts Options) error {
+	inFd, outFd := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(inFd) || !term.IsTerminal(outFd) {
+		return errors.New("tui: stdin/stdout is not a terminal")
+	}
+	oldState, err := term.MakeRaw(inFd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(inFd, oldState)
+
+	out := bufio.NewWriterSize(os.Stdout, 64<<10)
+	out.WriteString("\x1b[?1049h\x1b[?25l") // 备用屏幕 + 隐藏光标
+	defer func() {
+		out.WriteString("\x1b[?25h\x1b[?1049l")
+		out.Flush()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	updates := make(chan func(*model), 1024)
+	m := newModel(ctx, opts, func(f func(*model)) {
+		select {
+		case updates <- f:
+		case <-ctx.Done():
+		}
+	})
+	if w, h, err := term.GetSize(outFd); err == nil {
+		m.width, m.height = w, h
+	}
+	m.applyFilter("")
+
+	input := make(chan []byte)
+	go readInput(os.Stdin, input) // 退出时阻塞在 Read 上的 goroutine 随进程结束
+
+	ticker := time.NewTicker(frameInterval)
+	defer ticker.Stop()
+	dirty := true
+	for !m.quit {
+		if dirty {
+			draw(out, m.render())
+			dirty = false
+		}
+		select {
+		case <-ctx.Done():
+			m.cancelJob()
+			return ctx.Err()
+		case b, ok := <-input:
+			if !ok {
+				m.cancelJob()
+				return nil
+			}
+			for _, k := range parseKeys(b) {
+				m.handleKey(k)
+			}
+			dirty = true
+		case f := <-updates:
+			f(m)
+			// 合并同一帧内的全部更新，输出密集时不逐片段重绘
+			for drained := false; !drained; {
+				select {
+				case f := <-updates:
+					f(m)
+				default:
+					drained = true
+				}
+			}
+			// 等到下一帧再绘制
+			<-ticker.C
+			dirty = true
+		case <-ticker.C:
+			if w, h, err := term.GetSize(outFd); err == nil && (w != m.width || h != m.height) {
+				m.width, m.height = w, h
+				out.WriteString("\x1b[2J")
+				dirty = true
+			}
+		}
+	}
+	return nil
+}
+
+func readInput(r io.Reader, ch chan<- []byte) {
+	defer close(ch)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			ch <- append([]byte(nil), buf[:n]...)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// draw 从左上角整屏重绘；每行已按宽度补齐，\x1b[K 清除宽字符残留
+func draw(out *bufio.Writer, lines []string) {
+	out.WriteString("\x1b[H")
+	for i, l := range lines {
+		out.WriteString(l)
+		out.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			out.WriteString("\r\n")
+		}
+	}
+	out.Flush()
+}
@@ -0,0 +1,209 @@
+package tui
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+// chunkExecutor 按行分片回调的流式执行器；命令 "fail" 返回退出码 2
+type chunkExecutor struct{}
+
+func (chunkExecutor) Exec(ctx context.Context, user, addr, authMode, keyOrPass, cmd string, timeout time.Duration) (string, string, int, error) {
+	return chunkExecutor{}.StreamExec(ctx, user, addr, authMode, keyOrPass, cmd, timeout, nil)
+}
+
+func (chunkExecutor) StreamExec(ctx context.Context, user, addr, authMode, keyOrPass, cmd string, timeout time.Duration, onChunk func([]byte, bool)) (string, string, int, error) {
+	if cmd == "fail" {
+		if onChunk != nil {
+			onChunk([]byte("boom\n"), true)
+		}
+		return "", "boom\n", 2, nil
+	}
+	out := "hello from " + addr + "\nsecond line\n"
+	if onChunk != nil {
+		onChunk([]byte("hello from "), false) // 不完整的行跨片段拼接
+		onChunk([]byte(addr+"\nsecond line\n"), false)
+	}
+	return out, "", 0, nil
+}
+
+type testEnv struct {
+	m         *model
+	updates   chan func(*model)
+	hWriter   *service.HistoryWriter
+	closeOnce sync.Once
+}
+
+// flushHistory 关闭写入器使历史全部落盘
+func (e *testEnv) flushHistory() { e.closeOnce.Do(e.hWriter.Close) }
+
+func newTestEnv(t *testing.T) *testEnv {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	mRepo := repository.NewMachineRepo(db)
+	hRepo := repository.NewHistoryRepo(db)
+	if err := mRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	for _, mc := range []domain.Machine{
+		{IPMIIP: "10.0.0.1", SSHIP: "10.0.1.1", SSHUser: "root", SSHKey: "k", Labels: map[string]string{"rack": "A"}},
+		{IPMIIP: "10.0.0.2", SSHIP: "10.0.1.2", SSHUser: "root", SSHKey: "k", Labels: map[string]string{"rack": "A"}},
+		{IPMIIP: "10.0.0.3", SSHIP: "10.0.1.3", SSHUser: "admin", SSHKey: "k", Labels: map[string]string{"rack": "B"}, Remark: "数据库主机"},
+	} {
+		if err := mRepo.Save(&mc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hWriter := service.NewHistoryWriter(hRepo, 1, 10)
+	env := &testEnv{updates: make(chan func(*model), 1024), hWriter: hWriter}
+	t.Cleanup(env.flushHistory)
+	opts := Options{Machines: mRepo, History: hRepo, Exec: service.NewExecService(mRepo, hWriter, chunkExecutor{}, 4), Parallel: 2}
+	env.m = newModel(context.Background(), opts, func(f func(*model)) { env.updates <- f })
+	env.m.width, env.m.height = 120, 30
+	env.m.applyFilter("")
+	return env
+}
+
+// typeKeys 逐键输入 (字符串按原始字节解析，如 "\r" 为回车)
+func (e *testEnv) typeKeys(s string) {
+	for _, k := range parseKeys([]byte(s)) {
+		e.m.handleKey(k)
+	}
+}
+
+// waitJob 在当前 goroutine 中应用后台更新直到任务结束
+func (e *testEnv) waitJob(t *testing.T) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for e.m.job.running > 0 {
+		select {
+		case f := <-e.updates:
+			f(e.m)
+		case <-deadline:
+			t.Fatal("job did not finish")
+		}
+	}
+}
+
+var reStyle = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+func (e *testEnv) screen() string {
+	return reStyle.ReplaceAllString(strings.Join(e.m.render(), "\n"), "")
+}
+
+func TestParseKeys(t *testing.T) {
+	ks := parseKeys([]byte("a\x1b[A\x1b[6~\r\x7f\x1b中\x03"))
+	want := []key{{kind: keyRune, r: 'a'}, {kind: keyUp}, {kind: keyPgDn}, {kind: keyEnter}, {kind: keyBackspace}, {kind: keyEsc}, {kind: keyRune, r: '中'}, {kind: keyCtrlC}}
+	if len(ks) != len(want) {
+		t.Fatalf("got %v, want %v", ks, want)
+	}
+	for i := range want {
+		if ks[i] != want[i] {
+			t.Fatalf("key %d: got %v, want %v", i, ks[i], want[i])
+		}
+	}
+}
+
+func TestFitAndSanitize(t *testing.T) {
+	cases := []struct {
+		in   string
+		w    int
+		want string
+	}{
+		{"abc", 5, "abc  "},
+		{"abcdef", 4, "abc…"},
+		{"数据库主机", 6, "数据… "}, // 宽字符放不下时补空格
+		{"数据", 4, "数据"},
+	}
+	for _, c := range cases {
+		if got := fit(c.in, c.w); got != c.want {
+			t.Errorf("fit(%q, %d) = %q, want %q", c.in, c.w, got, c.want)
+		}
+	}
+	if got := sanitize("\x1b[31mred\x1b[0m\tx 10%\r 55%"); got != " 55%" {
+		t.Errorf("sanitize = %q", got)
+	}
+}
+
+func TestModel_FilterSelectExec(t *testing.T) {
+	e := newTestEnv(t)
+	if len(e.m.machines) != 3 {
+		t.Fatalf("initial machines %d", len(e.m.machines))
+	}
+	e.typeKeys("/label:rack=A\r")
+	if len(e.m.machines) != 2 {
+		t.Fatalf("filtered machines %d (status %q)", len(e.m.machines), e.m.status)
+	}
+	e.typeKeys("a") // 全选过滤结果
+	e.typeKeys("/\x15\r")
+	if len(e.m.machines) != 3 || len(e.m.selected) != 2 {
+		t.Fatalf("after clearing filter: %d machines, %d selected", len(e.m.machines), len(e.m.selected))
+	}
+	e.typeKeys("xuptime\r")
+	if e.m.view != viewExec || e.m.job == nil || len(e.m.job.panes) != 2 {
+		t.Fatalf("exec not started: view %v job %+v", e.m.view, e.m.job)
+	}
+	e.waitJob(t)
+	scr := e.screen()
+	for _, want := range []string{"2/2 done", "ok 2", "10.0.0.1", "10.0.0.2", "hello from 10.0.1.1", "second line", "exit 0"} {
+		if !strings.Contains(scr, want) {
+			t.Fatalf("screen missing %q:\n%s", want, scr)
+		}
+	}
+	if strings.Contains(scr, "10.0.0.3") {
+		t.Fatalf("unselected host ran:\n%s", scr)
+	}
+
+	// 放大查看并重跑失败命令
+	e.typeKeys("z")
+	if !e.m.job.zoom {
+		t.Fatal("zoom not toggled")
+	}
+	e.typeKeys("x\x15fail\r")
+	e.waitJob(t)
+	scr = e.screen()
+	if !strings.Contains(scr, "failed 2") || !strings.Contains(scr, "boom") || !strings.Contains(scr, "exit 2") {
+		t.Fatalf("failure not rendered:\n%s", scr)
+	}
+
+	// 历史页：落盘后按 IPMI 过滤，并查看整个任务
+	e.flushHistory()
+	e.typeKeys("3")
+	if len(e.m.history) != 4 {
+		t.Fatalf("history %d records (status %q)", len(e.m.history), e.m.status)
+	}
+	e.typeKeys("/ip:10.0.0.2 uptime\r")
+	if len(e.m.history) != 1 || e.m.history[0].Command != "uptime" {
+		t.Fatalf("filtered history %+v", e.m.history)
+	}
+	e.typeKeys("J")
+	if len(e.m.history) != 2 || !strings.HasPrefix(e.m.hFilter, "job:") {
+		t.Fatalf("job history %d records, filter %q", len(e.m.history), e.m.hFilter)
+	}
+	e.typeKeys("\r")
+	if scr := e.screen(); !strings.Contains(scr, "--- stdout ---") || !strings.Contains(scr, "second line") {
+		t.Fatalf("detail not shown:\n%s", scr)
+	}
+	e.typeKeys("\x1bq")
+	if !e.m.quit {
+		t.Fatal("q should quit")
+	}
+}
@@ -0,0 +1,42 @@
+package wailsapi
+
+import (
+	"math"
+	"slices"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+// QueryAudit 查询审计日志 (按 ID 倒序)
+func (b *Backend) QueryAudit(q domain.AuditQuery) ([]domain.AuditEntry, error) {
+	if err := b.require(domain.PermViewAudit); err != nil {
+		return nil, err
+	}
+	return b.audit.Query(q)
+}
+
+// ExportAudit 导出审计日志 (format=jsonl|csv，按 ID 升序；q.Limit<=0 导出全部匹配条目)；
+// 条目含 prev_hash / hash，可离线校验
+func (b *Backend) ExportAudit(format string, q domain.AuditQuery) (string, error) {
+	if err := b.require(domain.PermViewAudit); err != nil {
+		return "", err
+	}
+	if q.Limit <= 0 {
+		q.Limit = math.MaxInt32
+	}
+	list, err := b.audit.Query(q)
+	if err != nil {
+		return "", err
+	}
+	slices.Reverse(list)
+	return service.RenderAudit(list, format)
+}
+
+// VerifyAudit 校验审计日志哈希链，返回首个被篡改的条目
+func (b *Backend) VerifyAudit() (domain.AuditVerifyResult, error) {
+	if err := b.require(domain.PermViewAudit); err != nil {
+		return domain.AuditVerifyResult{}, err
+	}
+	return b.audit.Verify()
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/events"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/logging"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/pkg/importexport"
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 func errToString(e error) string {
@@ -22,22 +26,139 @@ func errToString(e error) string {
 	return e.Error()
 }
 
-// Backend 暴露给 Wails 前端的绑定对象
+// Backend 暴露给 Wails 前端的绑定对象。
+// 方法以调用者身份做权限检查：桌面 / 本机模式为 service.LocalUser()，server 模式由 ForUser 绑定认证用户。
 type Backend struct {
+	*backendCore
+	user *domain.User // 调用者；nil 表示本机单用户模式
+}
+
+// backendCore 各调用者共享的状态
+type backendCore struct {
 	db           *sql.DB
 	repo         repository.MachineRepoIface
 	hRepo        repository.HistoryRepoIface
 	execSvc      *service.ExecService
-	ctx          context.Context // wails runtime context for events
-	globalSSHKey string          // 内存保存的全局 SSH Key (加密存储可后续落盘)
+	users        *service.UserService   // 为空时不支持用户管理 (如远程模式)
+	audit        *service.Auditor       // 为空时不记录审计
+	backups      *service.BackupService // 为空时不支持备份 / 恢复 (如远程模式)
+	policy       *service.Policy        // 命令策略，为空时不限制
+	local        domain.User            // 本机模式的操作者
+	bus          events.Bus             // 事件出口 (Wails / WebSocket)，为空时事件类方法不可用
+	globalSSHKey atomic.Pointer[string] // 内存保存的全局 SSH Key (HTTP 模式下设置与执行协程并发读写)
+	log          *slog.Logger
+	logFile      string // JSON 日志文件，为空时 TailLogs 不可用
+
+	jobMu      sync.Mutex
+	jobResults map[string][]domain.ExecResult // 最近任务的结果 (供分析使用)
+	jobOrder   []string                       // 任务先后顺序，超出 maxKeptJobs 淘汰最旧
 }
 
+// maxKeptJobs 内存中保留结果的最近任务数；更早的任务从历史记录读取
+const maxKeptJobs = 20
+
 func NewBackend(db *sql.DB, repo repository.MachineRepoIface, hRepo repository.HistoryRepoIface, execSvc *service.ExecService) *Backend {
-	return &Backend{db: db, repo: repo, hRepo: hRepo, execSvc: execSvc}
+	core := &backendCore{db: db, repo: repo, hRepo: hRepo, execSvc: execSvc, local: service.LocalUser(), jobResults: map[string][]domain.ExecResult{}, log: slog.Default()}
+	if execSvc != nil {
+		// 机器未配置单独私钥时回退使用全局私钥 (不经 GetGlobalSSHKey 的权限检查)
+		execSvc.SetGlobalKeyProvider(core.globalKey)
+	}
+	return &Backend{backendCore: core}
+}
+
+// SetUserService 启用用户管理 (本地数据库)
+func (b *Backend) SetUserService(users *service.UserService) { b.users = users }
+
+// SetAuditor 启用审计日志 (本地数据库)
+func (b *Backend) SetAuditor(a *service.Auditor) { b.audit = a }
+
+// SetBackupService 启用数据库备份 / 恢复 (本地数据库)
+func (b *Backend) SetBackupService(s *service.BackupService) { b.backups = s }
+
+// SetPolicy 启用命令策略 (同时作用于 ExecService 的所有执行入口)
+func (b *Backend) SetPolicy(p *service.Policy) {
+	b.policy = p
+	b.execSvc.SetPolicy(p)
+}
+
+// SetLogger 设置日志及其 JSON 文件路径 (供 TailLogs 读取)；l 为 nil 时使用 slog.Default()
+func (b *Backend) SetLogger(l *slog.Logger, file string) {
+	if l == nil {
+		l = slog.Default()
+	}
+	b.log = l
+	b.logFile = file
+}
+
+// ForUser 返回以 u 身份调用的 Backend (共享同一状态)；server 模式每个请求使用
+func (b *Backend) ForUser(u domain.User) *Backend {
+	return &Backend{backendCore: b.backendCore, user: &u}
+}
+
+// actor 当前调用者
+func (b *Backend) actor() domain.User {
+	if b.user != nil {
+		return *b.user
+	}
+	return b.local
 }
 
+func (b *Backend) require(p domain.Permission) error { return service.Authorize(b.actor(), p) }
+
+// visible 过滤出调用者分组范围内的机器
+func (b *Backend) visible(list []domain.Machine) []domain.Machine {
+	u := b.actor()
+	if !u.Scoped() {
+		return list
+	}
+	out := list[:0:0]
+	for _, m := range list {
+		if u.InScope(m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// requireScope 机器须在调用者分组范围内
+func (b *Backend) requireScope(m domain.Machine) error {
+	if u := b.actor(); !u.InScope(m) {
+		return fmt.Errorf("%w: machine %s is outside groups %s", service.ErrForbidden, m.IPMIIP, strings.Join(u.Groups, ","))
+	}
+	return nil
+}
+
+// prepareTask 检查执行权限，写入发起者与分组范围并记录审计 (审计失败时不执行)
+func (b *Backend) prepareTask(task *domain.ExecTask) error {
+	u := b.actor()
+	if err := service.AuthorizeCommand(u, task.Command); err != nil {
+		return err
+	}
+	if strings.TrimSpace(task.Command) == "" {
+		return errors.New("command empty")
+	}
+	task.User = u.Name
+	task.Groups = u.Groups
+	task.Role = u.Role
+	if task.JobID == "" {
+		task.JobID = service.NewJobID()
+	}
+	return b.audit.RecordExec(*task)
+}
+
+// record 以当前调用者记录审计
+func (b *Backend) record(action, target string, before, after any) error {
+	return b.audit.Record(b.actor().Name, action, target, before, after)
+}
+
+// CurrentUser 当前调用者 (前端据此隐藏无权限的操作)
+func (b *Backend) CurrentUser() domain.User { return b.actor() }
+
 // MachinesLookup 根据给定 IPMI 列表顺序返回已登记的机器；未找到的以空结构跳过（前端可提示缺失）
 func (b *Backend) MachinesLookup(ipmis []string) ([]domain.Machine, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
 	var res []domain.Machine
 	seen := make(map[string]struct{})
 	for _, ip := range ipmis {
@@ -59,24 +180,89 @@ func (b *Backend) MachinesLookup(ipmis []string) ([]domain.Machine, error) {
 		}
 		res = append(res, m)
 	}
-	return res, nil
+	return b.visible(res), nil
 }
 
-// ListMachines 全量列表
-func (b *Backend) ListMachines() ([]domain.Machine, error) { return b.repo.ListAll() }
+// ListMachines 全量列表 (分组受限的用户只看到范围内机器)
+func (b *Backend) ListMachines() ([]domain.Machine, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
+	list, err := b.repo.ListAll()
+	return b.visible(list), err
+}
 
-// UpsertMachine 保存或更新
-func (b *Backend) UpsertMachine(m domain.Machine) error { return b.repo.Save(&m) }
+// UpsertMachine 保存或更新；分组受限的用户只能维护范围内的机器。
+// 同 IPMI 的机器在回收站中时保存会将其恢复，范围检查同样针对回收站中的记录。
+// m.Version 为编辑前读取的版本时，期间被他人修改则返回 *repository.ConflictError (0 表示直接覆盖)。
+// 私钥不经 JSON 下发给前端，m.SSHKey 为空时沿用已有私钥
+func (b *Backend) UpsertMachine(m domain.Machine) error {
+	if err := b.require(domain.PermEditMachines); err != nil {
+		return err
+	}
+	var before any
+	old, err := b.repo.GetByIPMI(m.IPMIIP)
+	exists := err == nil
+	if errors.Is(err, sql.ErrNoRows) {
+		if rv, rerr := b.revisioner(); rerr == nil {
+			if old, exists, err = findTrashed(rv, m.IPMIIP); err != nil {
+				return err
+			}
+		}
+	}
+	if exists {
+		before = service.AuditMachine(old)
+		if m.SSHKey == "" {
+			m.SSHKey = old.SSHKey
+		}
+	}
+	if b.actor().Scoped() {
+		if err := b.requireScope(m); err != nil {
+			return err
+		}
+		if before != nil {
+			if err := b.requireScope(old); err != nil {
+				return err
+			}
+		}
+	}
+	if err := b.machineRepo().Save(&m); err != nil {
+		return err
+	}
+	return b.record(domain.AuditMachineUpsert, m.IPMIIP, before, service.AuditMachine(m))
+}
 
-// DeleteMachine 删除
-func (b *Backend) DeleteMachine(ipmi string) error { return b.repo.DeleteByIPMI(ipmi) }
+// DeleteMachine 删除 (支持回收站的仓库为移入回收站，可用 RestoreMachine 恢复)
+func (b *Backend) DeleteMachine(ipmi string) error {
+	if err := b.require(domain.PermEditMachines); err != nil {
+		return err
+	}
+	old, err := b.repo.GetByIPMI(ipmi)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil // 不存在视为已删除
+	}
+	if err != nil {
+		return err
+	}
+	if err := b.requireScope(old); err != nil {
+		return err
+	}
+	if err := b.machineRepo().DeleteByIPMI(ipmi); err != nil {
+		return err
+	}
+	return b.record(domain.AuditMachineDelete, ipmi, service.AuditMachine(old), nil)
+}
 
 // Execute 批量执行
 func (b *Backend) Execute(command string, ids []int64, timeoutSec int, parallel int, authMode string, password string) ([]domain.ExecResult, error) {
 	if timeoutSec <= 0 {
 		timeoutSec = 30
 	}
-	return b.execSvc.BatchExec(domain.ExecTask{Command: command, Timeout: timeoutSec, MachineIDs: ids, Parallel: parallel, AuthMode: authMode, Password: password})
+	task := domain.ExecTask{Command: command, Timeout: timeoutSec, MachineIDs: ids, Parallel: parallel, AuthMode: authMode, Password: password}
+	if err := b.prepareTask(&task); err != nil {
+		return nil, err
+	}
+	return b.execSvc.BatchExec(task)
 }
 
 // ExecuteStream 逐个返回结果: 前端可轮询或未来通过事件机制。
@@ -85,19 +271,53 @@ func (b *Backend) ExecuteStream(command string, ids []int64, timeoutSec int, par
 	if timeoutSec <= 0 {
 		timeoutSec = 30
 	}
-	err := b.execSvc.StreamExec(domain.ExecTask{Command: command, Timeout: timeoutSec, MachineIDs: ids, Parallel: parallel, AuthMode: authMode, Password: password}, func(r domain.ExecResult) {
+	task := domain.ExecTask{Command: command, Timeout: timeoutSec, MachineIDs: ids, Parallel: parallel, AuthMode: authMode, Password: password}
+	if err := b.prepareTask(&task); err != nil {
+		return nil, err
+	}
+	err := b.execSvc.StreamExec(task, func(r domain.ExecResult) {
 		out = append(out, r)
 	})
 	return out, err
 }
 
-// SetCtx 在 OnStartup 时注入 wails context
-func (b *Backend) SetCtx(ctx context.Context) { b.ctx = ctx }
+// SetCtx 在 OnStartup 时注入 wails context，事件经 Wails runtime 推送
+func (b *Backend) SetCtx(ctx context.Context) { b.bus = events.NewWailsBus(ctx) }
+
+// SetEventBus 设置事件总线 (server 模式 / 测试)
+func (b *Backend) SetEventBus(bus events.Bus) { b.bus = bus }
+
+// eventsReady 是否可以推送事件
+func (b *Backend) eventsReady() bool { return b.bus != nil }
+
+// resultEvent 由执行结果构建 exec_result 事件
+func resultEvent(r domain.ExecResult, progress float64) events.ExecResult {
+	return events.ExecResult{
+		JobID:         r.JobID,
+		MachineID:     r.MachineID,
+		IPMIIP:        r.IPMIIP,
+		SSHIP:         r.SSHIP,
+		SSHUser:       r.SSHUser,
+		Command:       r.Command,
+		Stdout:        r.Stdout,
+		Stderr:        r.Stderr,
+		ExitCode:      r.ExitCode,
+		Error:         errToString(r.Err),
+		Progress:      progress,
+		UsedGlobalKey: r.UsedGlobalKey,
+		Passed:        r.Passed,
+		AssertMsg:     r.AssertMsg,
+		User:          r.User,
+		Truncated:     r.Truncated,
+		OutputBytes:   r.OutputBytes,
+		Groups:        r.Groups,
+	}
+}
 
 // ExecuteStreamEvents 使用事件逐条推送结果 (事件名: exec_result)
 // 前端： runtime.EventsOn("exec_result", cb)
 func (b *Backend) ExecuteStreamEvents(command string, ids []int64, timeoutSec int, parallel int, authMode string, password string, stream bool) error {
-	if b.ctx == nil {
+	if !b.eventsReady() {
 		// 退化为聚合返回
 		_, err := b.ExecuteStream(command, ids, timeoutSec, parallel, authMode, password)
 		return err
@@ -105,53 +325,126 @@ func (b *Backend) ExecuteStreamEvents(command string, ids []int64, timeoutSec in
 	if timeoutSec <= 0 {
 		timeoutSec = 30
 	}
+	task := domain.ExecTask{Command: command, Timeout: timeoutSec, MachineIDs: ids, Parallel: parallel, AuthMode: authMode, Password: password, Stream: stream}
+	if err := b.prepareTask(&task); err != nil {
+		return err
+	}
 	total := len(ids)
-	var done int64
-	return b.execSvc.StreamExec(domain.ExecTask{Command: command, Timeout: timeoutSec, MachineIDs: ids, Parallel: parallel, AuthMode: authMode, Password: password, Stream: stream}, func(r domain.ExecResult) {
+	var (
+		mu   sync.Mutex // 回调并发执行：计数与推送一起加锁，保证进度单调
+		done int64
+	)
+	return b.execSvc.StreamExec(task, func(r domain.ExecResult) {
+		mu.Lock()
+		defer mu.Unlock()
 		done++
-		payload := map[string]any{
-			"machine_id":      r.MachineID,
-			"ipmi_ip":         r.IPMIIP,
-			"ssh_ip":          r.SSHIP,
-			"ssh_user":        r.SSHUser,
-			"stdout":          r.Stdout,
-			"stderr":          r.Stderr,
-			"exit_code":       r.ExitCode,
-			"error":           "",
-			"progress":        float64(done) / float64(total),
-			"used_global_key": r.UsedGlobalKey,
-		}
-		if r.Err != nil {
-			payload["error"] = r.Err.Error()
-		}
-		runtime.EventsEmit(b.ctx, "exec_result", payload)
+		ev := resultEvent(r, progress(done, total))
+		ev.JobID = "" // 临时流执行不属于任务，前端据此与 StartJob 的事件区分
+		b.bus.Publish(ev)
 	})
 }
 
 // StartJob 启动带 jobID 的流执行 (事件推送)；返回 jobID
 func (b *Backend) StartJob(jobID string, command string, ids []int64, timeoutSec int, parallel int, authMode string, password string, stream bool) (string, error) {
-	if b.ctx == nil {
+	if !b.eventsReady() {
 		return "", errors.New("context not ready")
 	}
 	if timeoutSec <= 0 {
 		timeoutSec = 30
 	}
-	total := len(ids)
-	var done int64
-	jid, err := b.execSvc.StartBatch(jobID, domain.ExecTask{Command: command, Timeout: timeoutSec, MachineIDs: ids, Parallel: parallel, AuthMode: authMode, Password: password, Stream: stream}, func(r domain.ExecResult) {
-		done++
-		payload := map[string]any{
-			"job_id":          jobID,
-			"machine_id":      r.MachineID,
-			"ipmi_ip":         r.IPMIIP,
-			"stdout":          r.Stdout,
-			"stderr":          r.Stderr,
-			"exit_code":       r.ExitCode,
-			"error":           errToString(r.Err),
-			"progress":        float64(done) / float64(total),
-			"used_global_key": r.UsedGlobalKey,
+	return b.startJob(jobID, domain.ExecTask{Command: command, Timeout: timeoutSec, MachineIDs: ids, Parallel: parallel, AuthMode: authMode, Password: password, Stream: stream})
+}
+
+// StartJobWithSelector 与 StartJob 相同，额外按标签选择器 (如 "rack=A12,role!=db") 定位机器；
+// 选择器在任务真正执行时解析。
+func (b *Backend) StartJobWithSelector(jobID string, command string, ids []int64, selector string, timeoutSec int, parallel int, authMode string, password string, stream bool) (string, error) {
+	if !b.eventsReady() {
+		return "", errors.New("context not ready")
+	}
+	if timeoutSec <= 0 {
+		timeoutSec = 30
+	}
+	if _, err := domain.ParseSelector(selector); err != nil {
+		return "", err
+	}
+	return b.startJob(jobID, domain.ExecTask{Command: command, Timeout: timeoutSec, MachineIDs: ids, Selector: selector, Parallel: parallel, AuthMode: authMode, Password: password, Stream: stream})
+}
+
+// JobRequest 任务请求 (参数较多时使用结构体，便于后续扩展)
+type JobRequest struct {
+	JobID       string             `json:"job_id"`
+	Command     string             `json:"command"`
+	MachineIDs  []int64            `json:"machine_ids"`
+	Selector    string             `json:"selector"`
+	TimeoutSec  int                `json:"timeout_sec"`
+	Parallel    int                `json:"parallel"`
+	AuthMode    string             `json:"auth_mode"`
+	Password    string             `json:"password"`
+	Stream      bool               `json:"stream"`
+	Assertions  *domain.Assertions `json:"assertions,omitempty"`
+	Confirm     string             `json:"confirm,omitempty"`       // 策略要求确认时的确认令牌
+	ApprovalID  string             `json:"approval_id,omitempty"`   // 策略要求审批时已批准的审批单
+	MaxOutputKB int                `json:"max_output_kb,omitempty"` // 每台机器 stdout / stderr 各自保留上限 (KiB)，0 使用全局默认
+	Render      bool               `json:"render,omitempty"`        // 按机器渲染命令模板 ({{.ipmi_ip}} 等)
+}
+
+// task 转换为执行任务
+func (req JobRequest) task() domain.ExecTask {
+	return domain.ExecTask{Command: req.Command, Timeout: req.TimeoutSec, MachineIDs: req.MachineIDs, Selector: req.Selector, Parallel: req.Parallel, AuthMode: req.AuthMode, Password: req.Password, Stream: req.Stream, Assertions: req.Assertions, Confirm: req.Confirm, ApprovalID: req.ApprovalID, MaxOutput: req.MaxOutputKB << 10, Render: req.Render}
+}
+
+// StartJobRequest 以结构体参数启动任务，支持选择器、断言 (exec_result 事件携带 passed / assert_msg)
+// 以及策略要求的确认令牌 / 审批单
+func (b *Backend) StartJobRequest(req JobRequest) (string, error) {
+	if !b.eventsReady() {
+		return "", errors.New("context not ready")
+	}
+	if req.TimeoutSec <= 0 {
+		req.TimeoutSec = 30
+	}
+	if _, err := domain.ParseSelector(req.Selector); err != nil {
+		return "", err
+	}
+	if err := service.ValidateAssertions(req.Assertions); err != nil {
+		return "", err
+	}
+	return b.startJob(req.JobID, req.task())
+}
+
+// estimateTargets 估算任务机器数用于进度计算 (选择器的最终结果以执行时为准)
+func (b *Backend) estimateTargets(task domain.ExecTask) int {
+	if strings.TrimSpace(task.Selector) == "" {
+		return len(task.MachineIDs)
+	}
+	seen := make(map[int64]struct{}, len(task.MachineIDs))
+	for _, id := range task.MachineIDs {
+		seen[id] = struct{}{}
+	}
+	if ms, err := b.repo.SelectMachines(task.Selector); err == nil {
+		for _, m := range b.visible(ms) {
+			seen[int64(m.ID)] = struct{}{}
 		}
-		runtime.EventsEmit(b.ctx, "exec_result", payload)
+	}
+	return len(seen)
+}
+
+func (b *Backend) startJob(jobID string, task domain.ExecTask) (string, error) {
+	task.JobID = jobID
+	if err := b.prepareTask(&task); err != nil {
+		return "", err
+	}
+	total := b.estimateTargets(task)
+	bus := b.bus
+	var (
+		mu   sync.Mutex
+		done int64
+	)
+	jid, err := b.execSvc.StartBatch(task.JobID, task, func(r domain.ExecResult) {
+		b.keepResult(r)
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		bus.Publish(resultEvent(r, progress(done, total)))
 	})
 	if err == nil {
 		go func(id string) {
@@ -160,7 +453,7 @@ func (b *Backend) StartJob(jobID string, command string, ids []int64, timeoutSec
 				select {
 				case <-time.After(300 * time.Millisecond):
 					if !b.execSvc.HasJob(id) { // 已结束
-						runtime.EventsEmit(b.ctx, "exec_job_done", map[string]any{"job_id": id})
+						bus.Publish(events.JobDone{JobID: id, User: task.User})
 						return
 					}
 				}
@@ -172,7 +465,7 @@ func (b *Backend) StartJob(jobID string, command string, ids []int64, timeoutSec
 
 // ExecuteStreamChunks: 若 stream=true 并且底层支持，将实时发送 exec_chunk 事件 {machine_id, ipmi_ip, chunk, is_err, job_id(optional)}
 func (b *Backend) ExecuteStreamChunks(command string, ids []int64, timeoutSec int, parallel int, authMode string, password string) error {
-	if b.ctx == nil {
+	if !b.eventsReady() {
 		return errors.New("context not ready")
 	}
 	if timeoutSec <= 0 {
@@ -180,6 +473,12 @@ func (b *Backend) ExecuteStreamChunks(command string, ids []int64, timeoutSec in
 	}
 	// 直接逐机处理，利用 ExecService.SingleStream 以便 chunk 回调
 	task := domain.ExecTask{Command: command, Timeout: timeoutSec, MachineIDs: ids, Parallel: parallel, AuthMode: authMode, Password: password, Stream: true}
+	if err := b.prepareTask(&task); err != nil {
+		return err
+	}
+	if err := b.execSvc.Admit(&task); err != nil { // 分组范围与命令策略
+		return err
+	}
 	machines, err := b.repo.GetByIDs(ids)
 	if err != nil {
 		return err
@@ -218,30 +517,400 @@ func (b *Backend) ExecuteStreamChunks(command string, ids []int64, timeoutSec in
 			if authModeUse == "password" {
 				secret = task.Password
 			}
-			_, _ = b.execSvc.SingleStream(ctx, mm, task, secret, authModeUse, func(mid int64, chunk []byte, isErr bool) {
-				runtime.EventsEmit(b.ctx, "exec_chunk", map[string]any{"machine_id": mid, "ipmi_ip": mm.IPMIIP, "chunk": string(chunk), "is_err": isErr})
-			})
+			if _, err := b.execSvc.SingleStream(ctx, mm, task, secret, authModeUse, func(mid int64, chunk []byte, isErr bool) {
+				b.bus.Publish(events.ExecChunk{MachineID: mid, IPMIIP: mm.IPMIIP, Chunk: string(chunk), IsErr: isErr, User: task.User, Groups: mm.Groups})
+			}); err != nil {
+				b.log.Warn("stream exec rejected", logging.KeyJobID, task.JobID, logging.KeyMachineID, mm.ID, logging.KeyIPMIIP, mm.IPMIIP, logging.Err(err))
+			}
 		}(m)
 	}
 	wg.Wait()
 	return nil
 }
 
-// CancelJob 取消指定 job
-func (b *Backend) CancelJob(jobID string) bool { return b.execSvc.Cancel(jobID) }
+// CommandPreview 单台机器渲染后的命令 (Error 非空表示模板无法渲染)
+type CommandPreview struct {
+	MachineID int64  `json:"machine_id"`
+	IPMIIP    string `json:"ipmi_ip"`
+	Command   string `json:"command"`
+	Error     string `json:"error,omitempty"`
+}
+
+// PreviewCommand 按机器渲染命令模板但不执行，供前端下发前核对
+func (b *Backend) PreviewCommand(command string, ids []int64) ([]CommandPreview, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
+	machines, err := b.repo.GetByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	machines = b.visible(machines)
+	out := make([]CommandPreview, 0, len(machines))
+	for _, m := range machines {
+		cmd, rErr := service.RenderCommand(command, m)
+		out = append(out, CommandPreview{MachineID: int64(m.ID), IPMIIP: m.IPMIIP, Command: cmd, Error: errToString(rErr)})
+	}
+	return out, nil
+}
+
+// progress 计算进度 (0~1)；选择器估算偏小时封顶为 1
+func progress(done int64, total int) float64 {
+	if total <= 0 || done >= int64(total) {
+		return 1
+	}
+	return float64(done) / float64(total)
+}
+
+// SelectMachines 按标签选择器预览匹配的机器
+func (b *Backend) SelectMachines(selector string) ([]domain.Machine, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
+	list, err := b.repo.SelectMachines(selector)
+	return b.visible(list), err
+}
+
+// QueryMachines 结构化查询机器，语法见 domain.ParseMachineQuery，
+// 例如 `ssh_user=root ipmi_ip:10.0.0.0/24 label:rack=A12 sort:-created_at`；
+// offset/limit > 0 时覆盖查询语句中的分页参数。
+func (b *Backend) QueryMachines(query string, offset, limit int) (domain.MachinePage, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return domain.MachinePage{}, err
+	}
+	q, err := domain.ParseMachineQuery(query)
+	if err != nil {
+		return domain.MachinePage{}, err
+	}
+	if offset > 0 {
+		q.Offset = offset
+	}
+	if limit > 0 {
+		q.Limit = limit
+	}
+	if !b.actor().Scoped() {
+		return b.repo.Query(q)
+	}
+	// 分组受限：取全部匹配结果过滤后再分页，保证 Total 准确
+	all := q
+	all.Offset, all.Limit = 0, 0
+	page, err := b.repo.Query(all)
+	if err != nil {
+		return domain.MachinePage{}, err
+	}
+	return domain.Paginate(b.visible(page.Items), q.Offset, q.Limit), nil
+}
+
+// ListGroups 列出全部分组 (分组受限的用户只看到自己的分组)
+func (b *Backend) ListGroups() ([]domain.Group, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
+	list, err := b.repo.ListGroups()
+	if u := b.actor(); err == nil && u.Scoped() {
+		out := list[:0:0]
+		for _, g := range list {
+			if u.HasGroup(g.Name) {
+				out = append(out, g)
+			}
+		}
+		list = out
+	}
+	return list, err
+}
+
+// DeleteGroup 删除分组 (不删除机器)
+func (b *Backend) DeleteGroup(name string) error {
+	if err := b.require(domain.PermEditMachines); err != nil {
+		return err
+	}
+	if u := b.actor(); !u.HasGroup(name) {
+		return fmt.Errorf("%w: group %s is outside groups %s", service.ErrForbidden, name, strings.Join(u.Groups, ","))
+	}
+	if err := b.machineRepo().DeleteGroup(name); err != nil {
+		return err
+	}
+	return b.record(domain.AuditGroupDelete, name, nil, nil)
+}
+
+// keepResult 记录任务结果；新任务出现时淘汰超出上限的最旧任务
+func (b *Backend) keepResult(r domain.ExecResult) {
+	b.jobMu.Lock()
+	defer b.jobMu.Unlock()
+	if _, ok := b.jobResults[r.JobID]; !ok {
+		b.jobOrder = append(b.jobOrder, r.JobID)
+		if len(b.jobOrder) > maxKeptJobs {
+			delete(b.jobResults, b.jobOrder[0])
+			b.jobOrder = b.jobOrder[1:]
+		}
+	}
+	b.jobResults[r.JobID] = append(b.jobResults[r.JobID], r)
+}
+
+// AnalyzeJob 将任务结果按 (归一化后) 输出分组并给出相对多数组的差异；
+// 优先使用内存中的最近任务结果，否则读取该任务的历史记录 (分组受限的用户总是读取历史以便按机器过滤)。
+func (b *Backend) AnalyzeJob(jobID string, opts service.NormalizeOptions) (service.OutputAnalysis, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return service.OutputAnalysis{}, err
+	}
+	if !b.actor().Scoped() {
+		b.jobMu.Lock()
+		rs := append([]domain.ExecResult(nil), b.jobResults[jobID]...)
+		b.jobMu.Unlock()
+		if len(rs) > 0 {
+			return service.AnalyzeOutputs(service.SamplesFromResults(rs), opts), nil
+		}
+	}
+	hs, err := b.jobHistory(jobID)
+	if err != nil {
+		return service.OutputAnalysis{}, err
+	}
+	return service.AnalyzeOutputs(service.SamplesFromHistory(hs), opts), nil
+}
+
+// CancelJob 取消指定 job；只能取消自己发起的任务，管理员 (manage_users) 可取消任意任务
+func (b *Backend) CancelJob(jobID string) bool {
+	u := b.actor()
+	if !u.Can(domain.PermExec) {
+		return false
+	}
+	if j, ok := b.execSvc.Job(jobID); !ok || (j.User != u.Name && !u.Can(domain.PermManageUsers)) {
+		return false
+	}
+	return b.execSvc.Cancel(jobID)
+}
+
+// ListJobs 运行中的任务及发起者
+func (b *Backend) ListJobs() ([]domain.JobInfo, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
+	return b.execSvc.ListJobs(), nil
+}
 
 // RecentHistory 最近历史
 func (b *Backend) RecentHistory(limit int) ([]domain.ExecHistory, error) {
-	return b.hRepo.ListRecent(limit)
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
+	list, err := b.hRepo.ListRecent(limit)
+	if err != nil {
+		return nil, err
+	}
+	return b.visibleHistory(list)
 }
 
 // RecentHistoryFiltered 过滤历史
 func (b *Backend) RecentHistoryFiltered(limit int, ipmi, cmd string) ([]domain.ExecHistory, error) {
-	return b.hRepo.ListFiltered(limit, ipmi, cmd)
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
+	list, err := b.hRepo.ListFiltered(limit, ipmi, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return b.visibleHistory(list)
+}
+
+// HistoryWriterStats 历史写入统计 (队列、重试、落盘待重放及丢失条数)
+func (b *Backend) HistoryWriterStats() (service.HistoryWriterStats, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return service.HistoryWriterStats{}, err
+	}
+	return b.execSvc.HistoryStats(), nil
+}
+
+// HistoryOutput 按 ID 读取单条历史的完整输出 (列表只返回预览，preview=true 时需单独加载)
+func (b *Backend) HistoryOutput(id int64) (domain.HistoryOutput, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return domain.HistoryOutput{}, err
+	}
+	loader, ok := b.hRepo.(repository.HistoryOutputLoader)
+	if !ok {
+		return domain.HistoryOutput{}, errors.New("history output is not available in this mode")
+	}
+	o, err := loader.GetOutput(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return o, fmt.Errorf("history %d not found", id)
+	}
+	if err != nil {
+		return o, err
+	}
+	hs, err := b.visibleHistory([]domain.ExecHistory{{ID: id, MachineID: o.MachineID}})
+	if err != nil {
+		return domain.HistoryOutput{}, err
+	}
+	if len(hs) == 0 { // 分组范围外的记录按不存在处理
+		return domain.HistoryOutput{}, fmt.Errorf("history %d not found", id)
+	}
+	return o, nil
 }
 
-// ImportMachines 导入 (format=json|csv)
+// SearchHistory 全文检索历史输出 (FTS5 语法：短语、前缀、AND/OR/NOT)，可按退出码、时间、任务与机器标签过滤；
+// 结果限于调用者分组范围，Snippet 为已转义的高亮片段
+func (b *Backend) SearchHistory(q domain.HistorySearch) ([]domain.HistoryHit, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
+	searcher, ok := b.hRepo.(repository.HistorySearcher)
+	if !ok {
+		return nil, errors.New("history search is not available in this mode")
+	}
+	ids, none, err := b.historyMachines(q.Selector, q.MachineIDs)
+	if err != nil || none {
+		return nil, err
+	}
+	q.MachineIDs = ids
+	return searcher.Search(q)
+}
+
+// HistoryAnalytics 时间范围内 (默认最近 7 天) 按机器与命令的成功率、p50/p95 耗时、超时最多与抖动的机器
+func (b *Backend) HistoryAnalytics(q domain.HistoryStatsQuery) (service.HistoryAnalytics, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return service.HistoryAnalytics{}, err
+	}
+	q = service.NormalizeStatsQuery(q, time.Now())
+	samples, err := b.historySamples(q)
+	if err != nil {
+		return service.HistoryAnalytics{}, err
+	}
+	return service.AnalyzeHistory(samples, q), nil
+}
+
+// HistoryTrend 按小时 / 天聚合的成功率与耗时序列，可按机器或命令分组 (取执行次数最多的 Top 组)
+func (b *Backend) HistoryTrend(q domain.HistoryStatsQuery) (service.HistoryTrend, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return service.HistoryTrend{}, err
+	}
+	q = service.NormalizeStatsQuery(q, time.Now())
+	if q.GroupBy != "" && q.GroupBy != "machine" && q.GroupBy != "command" {
+		return service.HistoryTrend{}, fmt.Errorf("invalid group_by %q (machine|command)", q.GroupBy)
+	}
+	samples, err := b.historySamples(q)
+	if err != nil {
+		return service.HistoryTrend{}, err
+	}
+	return service.BuildHistoryTrend(samples, q), nil
+}
+
+// historySamples 读取统计范围内的执行记录 (已按分组范围与选择器过滤)
+func (b *Backend) historySamples(q domain.HistoryStatsQuery) ([]domain.HistorySample, error) {
+	walker, ok := b.hRepo.(repository.HistorySampleWalker)
+	if !ok {
+		return nil, errors.New("history analytics is not available in this mode")
+	}
+	ids, none, err := b.historyMachines(q.Selector, q.MachineIDs)
+	if err != nil || none {
+		return nil, err
+	}
+	q.MachineIDs = ids
+	var samples []domain.HistorySample
+	err = walker.WalkSamples(q, func(s domain.HistorySample) error {
+		samples = append(samples, s)
+		return nil
+	})
+	return samples, err
+}
+
+// historyMachines 将选择器与调用者分组范围解析为机器 ID 过滤条件 (与 ids 取交集)；
+// 无需限制时原样返回 ids，none 为 true 表示没有可见的机器
+func (b *Backend) historyMachines(selector string, ids []int64) (_ []int64, none bool, _ error) {
+	if selector == "" && !b.actor().Scoped() {
+		return ids, false, nil
+	}
+	var (
+		ms  []domain.Machine
+		err error
+	)
+	if selector != "" {
+		ms, err = b.repo.SelectMachines(selector)
+	} else {
+		ms, err = b.repo.ListAll()
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	allowed := make(map[int64]struct{})
+	for _, m := range b.visible(ms) {
+		allowed[int64(m.ID)] = struct{}{}
+	}
+	out := ids[:0:0]
+	if len(ids) > 0 {
+		for _, id := range ids {
+			if _, ok := allowed[id]; ok {
+				out = append(out, id)
+			}
+		}
+	} else {
+		for id := range allowed {
+			out = append(out, id)
+		}
+	}
+	return out, len(out) == 0, nil // 选择器未命中或均在分组范围外
+}
+
+// jobHistory 任务的历史记录 (已按分组范围过滤，预览记录补齐完整输出)
+func (b *Backend) jobHistory(jobID string) ([]domain.ExecHistory, error) {
+	hs, err := b.hRepo.ListByJob(jobID)
+	if err == nil {
+		hs, err = b.visibleHistory(hs)
+	}
+	if err == nil && len(hs) == 0 {
+		err = errors.New("job not found: " + jobID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hs, b.loadFullOutput(hs)
+}
+
+// loadFullOutput 为只含预览的记录补齐完整输出 (原地修改)
+func (b *Backend) loadFullOutput(hs []domain.ExecHistory) error {
+	loader, ok := b.hRepo.(repository.HistoryOutputLoader)
+	if !ok {
+		return nil
+	}
+	for i := range hs {
+		if !hs[i].Preview {
+			continue
+		}
+		o, err := loader.GetOutput(hs[i].ID)
+		if err != nil {
+			return err
+		}
+		hs[i].Stdout, hs[i].Stderr, hs[i].Preview = o.Stdout, o.Stderr, false
+	}
+	return nil
+}
+
+// visibleHistory 过滤出调用者分组范围内机器的历史 (分组受限时按当前机器归属判断)
+func (b *Backend) visibleHistory(list []domain.ExecHistory) ([]domain.ExecHistory, error) {
+	if !b.actor().Scoped() {
+		return list, nil
+	}
+	machines, err := b.repo.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[int64]struct{})
+	for _, m := range b.visible(machines) {
+		allowed[int64(m.ID)] = struct{}{}
+	}
+	out := list[:0:0]
+	for _, h := range list {
+		if _, ok := allowed[h.MachineID]; ok {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+// ImportMachines 导入 (format=json|csv)；JSON 中带 version 的条目版本不一致时跳过，
+// 返回已写入条数与 *repository.BulkConflictError
 func (b *Backend) ImportMachines(data string, format string) (int, error) {
+	if err := b.require(domain.PermEditMachines); err != nil {
+		return 0, err
+	}
 	var ms []domain.Machine
 	var err error
 	if format == "csv" {
@@ -252,19 +921,56 @@ func (b *Backend) ImportMachines(data string, format string) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	if err = b.repo.BulkUpsert(ms); err != nil {
+	if b.actor().Scoped() {
+		for _, m := range ms {
+			if err := b.requireScope(m); err != nil {
+				return 0, err
+			}
+		}
+	}
+	err = b.machineRepo().BulkUpsert(ms)
+	var bce *repository.BulkConflictError
+	if err != nil && !errors.As(err, &bce) {
 		return 0, err
 	}
-	return len(ms), nil
+	skipped := map[string]bool{}
+	if bce != nil {
+		for _, c := range bce.Conflicts {
+			skipped[c.IPMIIP] = true
+		}
+	}
+	ipmis := make([]string, 0, len(ms))
+	for _, m := range ms {
+		if !skipped[m.IPMIIP] {
+			ipmis = append(ipmis, m.IPMIIP)
+		}
+	}
+	after := map[string]any{"count": len(ipmis), "ipmi_ips": ipmis}
+	if bce != nil {
+		after["conflicts"] = bce.Conflicts
+	}
+	if aerr := b.record(domain.AuditMachineImport, format, nil, after); aerr != nil {
+		return len(ipmis), aerr
+	}
+	return len(ipmis), err
 }
 
 // ExportMachines 导出 (format=json|csv)
 // ExportMachines 支持脱敏选项 redact=true 去除 ssh_key
+// 未脱敏导出需要 view_secrets 权限
 func (b *Backend) ExportMachines(format string, redact bool) (string, error) {
+	perm := domain.PermView
+	if !redact {
+		perm = domain.PermViewSecrets
+	}
+	if err := b.require(perm); err != nil {
+		return "", err
+	}
 	list, err := b.repo.ListAll()
 	if err != nil {
 		return "", err
 	}
+	list = b.visible(list)
 	if redact {
 		for i := range list {
 			list[i].SSHKey = "" // 去掉敏感
@@ -278,19 +984,40 @@ func (b *Backend) ExportMachines(format string, redact bool) (string, error) {
 
 // SetGlobalSSHKey 设置全局 SSH 私钥（当前仅存内存）
 func (b *Backend) SetGlobalSSHKey(key string) error {
+	if err := b.require(domain.PermViewSecrets); err != nil {
+		return err
+	}
 	key = strings.TrimSpace(key)
 	if key == "" {
 		return errors.New("empty key")
 	}
-	b.globalSSHKey = key
-	return nil
+	b.globalSSHKey.Store(&key)
+	return b.record(domain.AuditGlobalKeySet, "", nil, service.RedactedSecret("ssh_key"))
 }
 
-// HasGlobalSSHKey 返回是否已设置全局私钥
-func (b *Backend) HasGlobalSSHKey() bool { return b.globalSSHKey != "" }
+// HasGlobalSSHKey 返回是否已设置全局私钥 (执行前提示用，需 exec 权限)
+func (b *Backend) HasGlobalSSHKey() (bool, error) {
+	if err := b.require(domain.PermExec); err != nil {
+		return false, err
+	}
+	return b.globalKey() != "", nil
+}
+
+// GetGlobalSSHKey 返回当前全局私钥（可能为空），需 view_secrets 权限
+func (b *Backend) GetGlobalSSHKey() (string, error) {
+	if err := b.require(domain.PermViewSecrets); err != nil {
+		return "", err
+	}
+	return b.globalKey(), nil
+}
 
-// GetGlobalSSHKey 返回当前全局私钥（可能为空）
-func (b *Backend) GetGlobalSSHKey() string { return b.globalSSHKey }
+// globalKey 当前全局私钥，未设置时为空
+func (c *backendCore) globalKey() string {
+	if k := c.globalSSHKey.Load(); k != nil {
+		return *k
+	}
+	return ""
+}
 
 // Shutdown 钩子
 func (b *Backend) Shutdown(ctx context.Context) error { return nil }
@@ -0,0 +1,662 @@
+package wailsapi
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/events"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+	sshmock "github.com/QingMing-Bot/ipmi-ssh-manager/internal/ssh"
+)
+
+// newTestBackend 内存库 + MockExecutor；返回 backend 与已登记机器 ID
+func newTestBackend(t *testing.T, ips ...string) (*Backend, *sshmock.MockExecutor, []int64) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	mRepo := repository.NewMachineRepo(db)
+	if err := mRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	hRepo := repository.NewHistoryRepo(db)
+	if err := hRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	hWriter := service.NewHistoryWriter(hRepo, 1, 10)
+	t.Cleanup(hWriter.Close)
+	mock := sshmock.NewMockExecutor()
+	var ids []int64
+	for _, ip := range ips {
+		m := domain.Machine{IPMIIP: ip, SSHIP: ip, SSHUser: "root", SSHKey: "k"}
+		if err := mRepo.Save(&m); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int64(m.ID))
+	}
+	return NewBackend(db, mRepo, hRepo, service.NewExecService(mRepo, hWriter, mock, 4)), mock, ids
+}
+
+func TestBackend_StartJobEvents(t *testing.T) {
+	b, mock, ids := newTestBackend(t, "10.0.0.1", "10.0.0.2", "10.0.0.3")
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up\n"})
+	bus := events.NewMemoryBus()
+	b.SetEventBus(bus)
+
+	jid, err := b.StartJob("", "uptime", ids, 5, 2, "key", "", false)
+	if err != nil || jid == "" {
+		t.Fatalf("StartJob: %q %v", jid, err)
+	}
+	if !bus.WaitFor(events.NameExecJobDone, 5*time.Second) {
+		t.Fatal("exec_job_done not received")
+	}
+	evs := bus.Events()
+	if len(evs) != len(ids)+1 {
+		t.Fatalf("got %d events, want %d: %+v", len(evs), len(ids)+1, evs)
+	}
+	seen := map[int64]bool{}
+	last := 0.0
+	for i, ev := range evs[:len(ids)] {
+		r, ok := ev.(events.ExecResult)
+		if !ok {
+			t.Fatalf("event %d: got %T, want ExecResult", i, ev)
+		}
+		if r.JobID != jid || r.Stdout != "up\n" || !r.Passed || r.Error != "" {
+			t.Fatalf("event %d: unexpected %+v", i, r)
+		}
+		if r.Progress <= last {
+			t.Fatalf("event %d: progress %v not increasing (prev %v)", i, r.Progress, last)
+		}
+		last = r.Progress
+		seen[r.MachineID] = true
+	}
+	if last != 1 || len(seen) != len(ids) {
+		t.Fatalf("final progress %v, machines %v", last, seen)
+	}
+	if done, ok := evs[len(evs)-1].(events.JobDone); !ok || done.JobID != jid {
+		t.Fatalf("last event %+v, want JobDone{%s}", evs[len(evs)-1], jid)
+	}
+}
+
+func TestBackend_StartJobFailureAndAssertions(t *testing.T) {
+	b, mock, ids := newTestBackend(t, "10.0.0.1")
+	mock.Set("false", sshmock.MockResult{ExitCode: 1})
+	bus := events.NewMemoryBus()
+	b.SetEventBus(bus)
+
+	if _, err := b.StartJobRequest(JobRequest{JobID: "j1", Command: "false", MachineIDs: ids, Assertions: &domain.Assertions{ExitCodes: []int{0}}}); err != nil {
+		t.Fatal(err)
+	}
+	if !bus.WaitFor(events.NameExecJobDone, 5*time.Second) {
+		t.Fatal("exec_job_done not received")
+	}
+	evs := bus.Events()
+	r, ok := evs[0].(events.ExecResult)
+	if !ok || r.JobID != "j1" || r.ExitCode != 1 || r.Passed || r.AssertMsg == "" {
+		t.Fatalf("unexpected first event %+v", evs[0])
+	}
+	if _, ok := evs[1].(events.JobDone); !ok {
+		t.Fatalf("unexpected second event %+v", evs[1])
+	}
+}
+
+func TestBackend_EventsRequireBus(t *testing.T) {
+	b, mock, ids := newTestBackend(t, "10.0.0.1")
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up\n"})
+	if _, err := b.StartJob("", "uptime", ids, 5, 1, "key", "", false); err == nil {
+		t.Fatal("StartJob without bus should fail")
+	}
+
+	// 临时流执行：结果事件不携带 job_id (前端据此区分任务事件)
+	bus := events.NewMemoryBus()
+	b.SetEventBus(bus)
+	if err := b.ExecuteStreamEvents("uptime", ids, 5, 1, "key", "", false); err != nil {
+		t.Fatal(err)
+	}
+	evs := bus.Events()
+	if len(evs) != 1 {
+		t.Fatalf("got %d events", len(evs))
+	}
+	if r := evs[0].(events.ExecResult); r.JobID != "" || r.Progress != 1 || r.Stdout != "up\n" {
+		t.Fatalf("unexpected event %+v", r)
+	}
+}
+
+func TestBackend_PermissionsAndScope(t *testing.T) {
+	b, mock, ids := newTestBackend(t, "10.0.0.1", "10.0.0.2")
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up\n"})
+	web := domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root", Groups: []string{"web"}}
+	if err := b.UpsertMachine(web); err != nil { // 本机模式为管理员
+		t.Fatal(err)
+	}
+
+	viewer := b.ForUser(domain.User{Name: "vic", Role: domain.RoleViewer})
+	if list, err := viewer.ListMachines(); err != nil || len(list) != 2 {
+		t.Fatalf("viewer list: %d %v", len(list), err)
+	}
+	if _, err := viewer.Execute("uptime", ids, 5, 1, "key", ""); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("viewer exec: %v", err)
+	}
+	if _, err := viewer.ExportMachines("json", false); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("viewer unredacted export: %v", err)
+	}
+	if err := b.SetGlobalSSHKey("GLOBAL-KEY"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := viewer.HasGlobalSSHKey(); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("viewer has global key: %v", err)
+	}
+
+	op := b.ForUser(domain.User{Name: "olga", Role: domain.RoleOperator, Groups: []string{"web"}})
+	list, err := op.ListMachines()
+	if err != nil || len(list) != 1 || list[0].IPMIIP != "10.0.0.1" {
+		t.Fatalf("scoped list: %+v %v", list, err)
+	}
+	if page, err := op.QueryMachines("", 0, 10); err != nil || page.Total != 1 {
+		t.Fatalf("scoped query: %+v %v", page, err)
+	}
+	if _, err := op.Execute("uptime", ids, 5, 1, "key", ""); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("out-of-scope exec: %v", err)
+	}
+	if _, err := op.Execute("reboot", ids[:1], 5, 1, "key", ""); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("operator power command: %v", err)
+	}
+	if err := op.DeleteMachine("10.0.0.2"); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("out-of-scope delete: %v", err)
+	}
+	if has, err := op.HasGlobalSSHKey(); err != nil || !has {
+		t.Fatalf("operator has global key: %v %v", has, err)
+	}
+	if _, err := op.GetGlobalSSHKey(); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("operator read global key: %v", err)
+	}
+	if key, err := b.GetGlobalSSHKey(); err != nil || key != "GLOBAL-KEY" {
+		t.Fatalf("admin read global key: %q %v", key, err)
+	}
+	rs, err := op.Execute("uptime", ids[:1], 5, 1, "key", "")
+	if err != nil || len(rs) != 1 || rs[0].User != "olga" {
+		t.Fatalf("scoped exec: %+v %v", rs, err)
+	}
+
+	// 选择器匹配到的范围外机器被忽略；任务与历史记录发起者
+	bus := events.NewMemoryBus()
+	b.SetEventBus(bus)
+	jid, err := op.StartJobWithSelector("", "uptime", nil, "group=web", 5, 1, "key", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bus.WaitFor(events.NameExecJobDone, 5*time.Second) {
+		t.Fatal("exec_job_done not received")
+	}
+	if r := bus.Events()[0].(events.ExecResult); r.User != "olga" || r.MachineID != ids[0] {
+		t.Fatalf("unexpected event %+v", r)
+	}
+	if b.CancelJob(jid) {
+		t.Fatal("cancelled a finished job")
+	}
+	var hs []domain.ExecHistory
+	for deadline := time.Now().Add(5 * time.Second); len(hs) < 2 && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if hs, err = viewer.RecentHistory(10); err != nil { // 历史批量异步落盘
+			t.Fatal(err)
+		}
+	}
+	if len(hs) != 2 {
+		t.Fatalf("history: %d records", len(hs))
+	}
+	for _, h := range hs {
+		if h.User != "olga" {
+			t.Fatalf("history user %q", h.User)
+		}
+	}
+}
+
+func TestBackend_Audit(t *testing.T) {
+	b, mock, ids := newTestBackend(t, "10.0.0.1")
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up\n"})
+	aRepo := repository.NewAuditRepo(b.db)
+	if err := aRepo.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	b.SetAuditor(service.NewAuditor(aRepo))
+
+	if err := b.UpsertMachine(domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.9", SSHUser: "root", SSHKey: "secret-key"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Execute("uptime", ids, 5, 1, "password", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteMachine("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := b.QueryAudit(domain.AuditQuery{})
+	if err != nil || len(list) != 3 {
+		t.Fatalf("QueryAudit: %+v %v", list, err)
+	}
+	if list[0].Action != domain.AuditMachineDelete || list[1].Action != domain.AuditExec || list[2].Action != domain.AuditMachineUpsert {
+		t.Fatalf("unexpected actions: %+v", list)
+	}
+	if list[2].Before == "" || !strings.Contains(list[2].After, "10.0.0.9") {
+		t.Fatalf("upsert before/after: %+v", list[2])
+	}
+	out, err := b.ExportAudit("jsonl", domain.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "secret-key") || strings.Contains(out, "hunter2") {
+		t.Fatalf("secret leaked into audit export: %s", out)
+	}
+	if res, err := b.VerifyAudit(); err != nil || !res.OK || res.Checked != 3 {
+		t.Fatalf("VerifyAudit: %+v %v", res, err)
+	}
+
+	op := b.ForUser(domain.User{Name: "olga", Role: domain.RoleOperator})
+	if _, err := op.QueryAudit(domain.AuditQuery{}); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("operator audit query: %v", err)
+	}
+}
+
+func TestBackend_HistoryOutputLazyLoad(t *testing.T) {
+	b, mock, ids := newTestBackend(t, "10.0.0.1", "10.0.0.2")
+	big := strings.Repeat("0123456789abcdef\n", 1000)
+	mock.Set("journalctl", sshmock.MockResult{Stdout: big})
+	if err := b.UpsertMachine(domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root", Groups: []string{"web"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Execute("journalctl", ids, 5, 2, "key", ""); err != nil {
+		t.Fatal(err)
+	}
+	var hs []domain.ExecHistory
+	for deadline := time.Now().Add(5 * time.Second); len(hs) < 2 && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		hs, _ = b.RecentHistory(10)
+	}
+	if len(hs) != 2 {
+		t.Fatalf("history: %d records", len(hs))
+	}
+	for _, h := range hs {
+		if !h.Preview || len(h.Stdout) >= len(big) || h.OutputBytes != int64(len(big)) {
+			t.Fatalf("list should carry a preview only: preview=%v len=%d", h.Preview, len(h.Stdout))
+		}
+		o, err := b.HistoryOutput(h.ID)
+		if err != nil || o.Stdout != big {
+			t.Fatalf("full output: %v len=%d", err, len(o.Stdout))
+		}
+	}
+
+	// 分组受限用户只能读取范围内机器的输出
+	op := b.ForUser(domain.User{Name: "olga", Role: domain.RoleOperator, Groups: []string{"web"}})
+	for _, h := range hs {
+		_, err := op.HistoryOutput(h.ID)
+		if inScope := h.MachineID == ids[0]; inScope != (err == nil) {
+			t.Fatalf("machine %d scoped read: %v", h.MachineID, err)
+		}
+	}
+	if _, err := b.HistoryOutput(12345); err == nil {
+		t.Fatal("missing history should fail")
+	}
+}
+
+func TestBackend_SearchHistory(t *testing.T) {
+	b, mock, ids := newTestBackend(t, "10.0.0.1", "10.0.0.2", "10.0.0.3")
+	mock.Set("dmesg", sshmock.MockResult{Stdout: "kernel: eth0 link down\n"})
+	for i, m := range []domain.Machine{
+		{IPMIIP: "10.0.0.1", Groups: []string{"web"}, Labels: map[string]string{"rack": "A12"}},
+		{IPMIIP: "10.0.0.2", Labels: map[string]string{"rack": "A12"}},
+		{IPMIIP: "10.0.0.3", Labels: map[string]string{"rack": "B01"}},
+	} {
+		m.SSHIP, m.SSHUser = m.IPMIIP, "root"
+		if err := b.UpsertMachine(m); err != nil {
+			t.Fatalf("machine %d: %v", i, err)
+		}
+	}
+	if _, err := b.Execute("dmesg", ids, 5, 3, "key", ""); err != nil {
+		t.Fatal(err)
+	}
+	var hits []domain.HistoryHit
+	for deadline := time.Now().Add(5 * time.Second); len(hits) < 3 && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		hits, _ = b.SearchHistory(domain.HistorySearch{Query: `"link down"`})
+	}
+	if len(hits) != 3 || !strings.Contains(hits[0].Snippet, "<mark>link down</mark>") {
+		t.Fatalf("search: %+v", hits)
+	}
+	if hits, err := b.SearchHistory(domain.HistorySearch{Query: "eth*", Selector: "rack=A12"}); err != nil || len(hits) != 2 {
+		t.Fatalf("selector: %d hits, %v", len(hits), err)
+	}
+
+	// 分组受限用户只能检索范围内机器的历史
+	op := b.ForUser(domain.User{Name: "olga", Role: domain.RoleOperator, Groups: []string{"web"}})
+	if hits, err := op.SearchHistory(domain.HistorySearch{Query: "eth0"}); err != nil || len(hits) != 1 || hits[0].MachineID != ids[0] {
+		t.Fatalf("scoped search: %+v, %v", hits, err)
+	}
+	for _, q := range []domain.HistorySearch{{Query: "eth0", Selector: "rack=B01"}, {MachineIDs: []int64{ids[1]}}} {
+		if hits, err := op.SearchHistory(q); err != nil || len(hits) != 0 {
+			t.Fatalf("out of scope %+v: %d hits, %v", q, len(hits), err)
+		}
+	}
+	if _, err := b.SearchHistory(domain.HistorySearch{Query: `"unterminated`}); err == nil {
+		t.Fatal("bad query should fail")
+	}
+}
+
+func TestBackend_HistoryAnalytics(t *testing.T) {
+	b, mock, ids := newTestBackend(t, "10.0.0.1", "10.0.0.2")
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up\n"})
+	if err := b.UpsertMachine(domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root", Groups: []string{"web"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{"uptime", "missing-cmd"} {
+		if _, err := b.Execute(cmd, ids, 5, 2, "key", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var a service.HistoryAnalytics
+	for deadline := time.Now().Add(5 * time.Second); a.Overall.Runs < 4 && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		a, _ = b.HistoryAnalytics(domain.HistoryStatsQuery{})
+	}
+	if a.Overall.Runs != 4 || a.Overall.Failed != 2 || len(a.Hosts) != 2 || len(a.Commands) != 2 || a.Commands[0].Command != "missing-cmd" {
+		t.Fatalf("analytics %+v", a)
+	}
+	tr, err := b.HistoryTrend(domain.HistoryStatsQuery{Since: time.Now().Add(-time.Hour), GroupBy: "command"})
+	if err != nil || tr.Bucket != "hour" || len(tr.Series) != 2 {
+		t.Fatalf("trend %+v, %v", tr, err)
+	}
+	if _, err := b.HistoryTrend(domain.HistoryStatsQuery{GroupBy: "rack"}); err == nil {
+		t.Fatal("invalid group_by should fail")
+	}
+
+	// 分组受限用户只统计范围内的机器
+	op := b.ForUser(domain.User{Name: "olga", Role: domain.RoleOperator, Groups: []string{"web"}})
+	if a, err := op.HistoryAnalytics(domain.HistoryStatsQuery{}); err != nil || a.Overall.Runs != 2 || len(a.Hosts) != 1 || a.Hosts[0].MachineID != ids[0] {
+		t.Fatalf("scoped analytics %+v, %v", a, err)
+	}
+	if a, err := op.HistoryAnalytics(domain.HistoryStatsQuery{MachineIDs: []int64{ids[1]}}); err != nil || a.Overall.Runs != 0 {
+		t.Fatalf("out of scope analytics %+v, %v", a.Overall, err)
+	}
+}
+
+func TestBackend_ExportReport(t *testing.T) {
+	b, mock, ids := newTestBackend(t, "10.0.0.1", "10.0.0.2")
+	cmd := "ipmitool -U admin -P s3cr3t chassis status"
+	mock.Set(cmd, sshmock.MockResult{Stdout: "System Power : on\n<b>ok</b>\n"})
+	if err := b.UpsertMachine(domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root", Groups: []string{"web"}}); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := b.Execute(cmd, ids, 5, 2, "key", "")
+	if err != nil || len(rs) != 2 {
+		t.Fatalf("execute: %v", err)
+	}
+	jobID := rs[0].JobID
+
+	// 历史异步写入，等待任务可导出
+	var f ReportFile
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if f, err = b.ExportReport(ReportRequest{Format: "html", JobID: jobID}); err == nil && bytes.Count(f.Data, []byte("<details")) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job export: %v", err)
+		}
+	}
+	page := string(f.Data)
+	if !strings.HasSuffix(f.Name, ".html") || !strings.HasPrefix(f.MIME, "text/html") || strings.Contains(page, "s3cr3t") || !strings.Contains(page, "-P [REDACTED]") || !strings.Contains(page, "&lt;b&gt;ok&lt;/b&gt;") {
+		t.Fatalf("html report %s %s:\n%s", f.Name, f.MIME, page)
+	}
+	if f, err := b.ExportReport(ReportRequest{Format: "xlsx", JobID: jobID}); err != nil || !bytes.HasPrefix(f.Data, []byte("PK")) {
+		t.Fatalf("xlsx report: %v", err)
+	}
+	if f, err := b.ExportReport(ReportRequest{Format: "csv", JobID: jobID, NoRedact: true}); err != nil || !strings.Contains(string(f.Data), "s3cr3t") {
+		t.Fatalf("raw csv report: %v", err)
+	}
+	if _, err := b.ExportReport(ReportRequest{Format: "pdf", JobID: jobID}); err == nil {
+		t.Fatal("unsupported format should fail")
+	}
+
+	// 按条件导出历史；分组受限用户只能导出范围内的机器且不能导出原文
+	f, err = b.ExportReport(ReportRequest{Format: "jsonl", History: domain.HistorySearch{Query: "chassis"}})
+	if err != nil || strings.Count(strings.TrimSpace(string(f.Data)), "\n") != 1 {
+		t.Fatalf("history export: %v\n%s", err, f.Data)
+	}
+	op := b.ForUser(domain.User{Name: "olga", Role: domain.RoleOperator, Groups: []string{"web"}})
+	f, err = op.ExportReport(ReportRequest{Format: "csv", History: domain.HistorySearch{JobID: jobID}})
+	if err != nil || strings.Count(string(f.Data), "10.0.0.") != 1 || !strings.Contains(string(f.Data), "10.0.0.1") {
+		t.Fatalf("scoped export: %v\n%s", err, f.Data)
+	}
+	if _, err := op.ExportReport(ReportRequest{Format: "csv", JobID: jobID, NoRedact: true}); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("operator raw export: %v", err)
+	}
+
+	// 已存的凭据 (SSH 口令、凭据类属性) 在输出中没有前缀时同样脱敏
+	if err := b.UpsertMachine(domain.Machine{IPMIIP: "10.0.0.2", SSHIP: "10.0.0.2", SSHUser: "root", SSHKey: "plain-ssh-pass", Attrs: map[string]string{"ipmi_password": "Hunt3rPass", "rack": "A1"}}); err != nil {
+		t.Fatal(err)
+	}
+	mock.Set("cat creds", sshmock.MockResult{Stdout: "bmc Hunt3rPass ssh plain-ssh-pass rack A1\n"})
+	rs, err = b.Execute("cat creds", ids[1:], 5, 1, "key", "")
+	if err != nil || len(rs) != 1 {
+		t.Fatalf("execute: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if f, err = b.ExportReport(ReportRequest{Format: "csv", JobID: rs[0].JobID}); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil || strings.Contains(string(f.Data), "Hunt3rPass") || strings.Contains(string(f.Data), "plain-ssh-pass") || !strings.Contains(string(f.Data), "bmc [REDACTED] ssh [REDACTED] rack A1") {
+		t.Fatalf("stored credentials in report: %v\n%s", err, f.Data)
+	}
+}
+
+func TestBackend_BackupRestore(t *testing.T) {
+	b, _, _ := newTestBackend(t, "10.0.0.1")
+	if _, err := b.CreateBackup(); !errors.Is(err, errNoBackupService) {
+		t.Fatalf("without backup service: %v", err)
+	}
+	b.SetBackupService(service.NewBackupService(b.db, t.TempDir(), 0))
+	info, err := b.CreateBackup()
+	if err != nil || info.Machines != 1 {
+		t.Fatalf("backup %+v %v", info, err)
+	}
+	if err := b.UpsertMachine(domain.Machine{IPMIIP: "10.0.0.2", SSHIP: "10.0.0.2", SSHUser: "root"}); err != nil {
+		t.Fatal(err)
+	}
+	op := b.ForUser(domain.User{Name: "olga", Role: domain.RoleOperator})
+	if _, err := op.RestoreBackup(info.Name); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("operator restore: %v", err)
+	}
+	if _, err := b.RestoreBackup("../" + info.Name); err == nil {
+		t.Fatal("restore outside the backup dir must fail")
+	}
+	time.Sleep(2 * time.Millisecond)
+	pre, err := b.RestoreBackup(info.Name)
+	if err != nil || pre.Machines != 2 {
+		t.Fatalf("restore: %+v %v", pre, err)
+	}
+	if ms, err := b.ListMachines(); err != nil || len(ms) != 1 {
+		t.Fatalf("machines after restore: %d %v", len(ms), err)
+	}
+	if list, err := b.ListBackups(); err != nil || len(list) != 2 {
+		t.Fatalf("backups: %+v %v", list, err)
+	}
+	if rep, err := b.CheckIntegrity(); err != nil || !rep.OK {
+		t.Fatalf("integrity: %+v %v", rep, err)
+	}
+}
+
+func TestBackend_VersionConflicts(t *testing.T) {
+	b, _, _ := newTestBackend(t, "10.0.0.1", "10.0.0.2")
+	ms, err := b.ListMachines()
+	if err != nil || ms[0].Version != 1 {
+		t.Fatalf("list %+v %v", ms, err)
+	}
+	alice, bob := ms[0], ms[0]
+	alice.Remark = "alice"
+	if err := b.UpsertMachine(alice); err != nil {
+		t.Fatal(err)
+	}
+	bob.Remark = "bob"
+	var ce *repository.ConflictError
+	if err := b.UpsertMachine(bob); !errors.As(err, &ce) || ce.Current != 2 {
+		t.Fatalf("stale upsert: %v", err)
+	}
+	// JSON 导入中过期的条目被跳过，其余写入
+	data := `[{"ipmi_ip":"10.0.0.1","ssh_user":"root","remark":"import","version":1},{"ipmi_ip":"10.0.0.2","ssh_user":"root","remark":"import","version":1},{"ipmi_ip":"10.0.0.3","ssh_user":"root"}]`
+	n, err := b.ImportMachines(data, "json")
+	var bce *repository.BulkConflictError
+	if n != 2 || !errors.As(err, &bce) || len(bce.Conflicts) != 1 || bce.Conflicts[0].IPMIIP != "10.0.0.1" {
+		t.Fatalf("import %d %v", n, err)
+	}
+	if m, _ := b.MachinesLookup([]string{"10.0.0.1", "10.0.0.2"}); m[0].Remark != "alice" || m[1].Remark != "import" || m[1].Version != 2 {
+		t.Fatalf("machines after import %+v", m)
+	}
+	// 前端表单保存不带私钥 (SSHKey 不参与 JSON 序列化)，已有私钥保留
+	if err := b.UpsertMachine(domain.Machine{IPMIIP: "10.0.0.1", SSHUser: "root", SSHKey: "KEY-1"}); err != nil {
+		t.Fatal(err)
+	}
+	ms, _ = b.ListMachines()
+	form := ms[0]
+	form.SSHKey, form.Remark = "", "edited"
+	if err := b.UpsertMachine(form); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := b.repo.GetByIPMI("10.0.0.1"); err != nil || m.SSHKey != "KEY-1" || m.Remark != "edited" {
+		t.Fatalf("key after form save %q %q %v", m.SSHKey, m.Remark, err)
+	}
+}
+
+func TestBackend_MachineHistoryAndTrash(t *testing.T) {
+	b, _, _ := newTestBackend(t, "10.0.0.1", "10.0.0.2")
+	olga := b.ForUser(domain.User{Name: "olga", Role: domain.RoleOperator, Groups: []string{"web"}})
+	if err := b.UpsertMachine(domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.1", SSHUser: "root", SSHKey: "k", Groups: []string{"web"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := olga.UpsertMachine(domain.Machine{IPMIIP: "10.0.0.1", SSHIP: "10.0.0.9", SSHUser: "root", SSHKey: "secret-key", Groups: []string{"web"}}); err != nil {
+		t.Fatal(err)
+	}
+	revs, err := olga.MachineRevisions("10.0.0.1")
+	if err != nil || len(revs) != 3 || revs[0].Actor != "olga" || revs[2].Action != domain.RevisionCreate {
+		t.Fatalf("revisions %+v %v", revs, err)
+	}
+	diff, err := olga.DiffMachineRevisions(revs[2].ID, revs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for _, c := range diff.Changes {
+		fields = append(fields, c.Field)
+		if strings.Contains(c.Old+c.New, "secret-key") {
+			t.Fatalf("secret leaked into diff: %+v", c)
+		}
+	}
+	if strings.Join(fields, ",") != "ssh_ip,ssh_key,groups" {
+		t.Fatalf("diff fields %v", fields)
+	}
+	if _, err := olga.MachineRevisions("10.0.0.2"); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("out-of-scope revisions: %v", err)
+	}
+
+	if err := olga.DeleteMachine("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteMachine("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := olga.ListMachines(); err != nil || len(list) != 0 {
+		t.Fatalf("machines after delete %+v %v", list, err)
+	}
+	trash, err := olga.ListTrash()
+	if err != nil || len(trash) != 1 || trash[0].IPMIIP != "10.0.0.1" {
+		t.Fatalf("scoped trash %+v %v", trash, err)
+	}
+	if err := olga.PurgeMachine("10.0.0.2"); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("out-of-scope purge: %v", err)
+	}
+	// 以相同 IPMI 保存不能接管回收站中范围外的机器
+	if err := olga.UpsertMachine(domain.Machine{IPMIIP: "10.0.0.2", SSHIP: "10.0.0.2", SSHUser: "root", Groups: []string{"web"}}); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("out-of-scope upsert over trash: %v", err)
+	}
+	if trash, err := b.ListTrash(); err != nil || len(trash) != 2 {
+		t.Fatalf("trash after rejected upsert %+v %v", trash, err)
+	}
+	viewer := b.ForUser(domain.User{Name: "vic", Role: domain.RoleViewer})
+	if _, err := viewer.RestoreMachine("10.0.0.1"); !errors.Is(err, service.ErrForbidden) {
+		t.Fatalf("viewer restore: %v", err)
+	}
+	m, err := olga.RestoreMachine("10.0.0.1")
+	if err != nil || m.SSHIP != "10.0.0.9" || m.SSHKey != "secret-key" {
+		t.Fatalf("restore %+v %v", m, err)
+	}
+	if _, err := olga.RestoreMachine("10.0.0.1"); err == nil {
+		t.Fatal("restoring a live machine must fail")
+	}
+	if err := b.PurgeMachine("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	if trash, _ := b.ListTrash(); len(trash) != 0 {
+		t.Fatalf("trash after purge %+v", trash)
+	}
+	if revs, _ := b.MachineRevisions("10.0.0.1"); len(revs) != 5 || revs[0].Action != domain.RevisionRestore || revs[1].Action != domain.RevisionDelete {
+		t.Fatalf("revisions after restore %+v", revs)
+	}
+}
+
+// 设置全局私钥与执行协程读取并发进行 (以 -race 运行时检测数据竞争)
+func TestBackend_GlobalKeyConcurrentSet(t *testing.T) {
+	b, mock, _ := newTestBackend(t)
+	mock.Set("uptime", sshmock.MockResult{Stdout: "up\n", DelayMs: 1})
+	for _, ip := range []string{"10.0.1.1", "10.0.1.2", "10.0.1.3", "10.0.1.4"} {
+		if err := b.UpsertMachine(domain.Machine{IPMIIP: ip, SSHIP: ip, SSHUser: "root"}); err != nil { // 无单独私钥，回退全局私钥
+			t.Fatal(err)
+		}
+	}
+	list, err := b.ListMachines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, m := range list {
+		ids = append(ids, int64(m.ID))
+	}
+	if err := b.SetGlobalSSHKey("KEY-0"); err != nil {
+		t.Fatal(err)
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := b.SetGlobalSSHKey("KEY-" + strings.Repeat("x", i%7)); err != nil {
+				t.Error(err)
+				return
+			}
+			if has, err := b.HasGlobalSSHKey(); err != nil || !has {
+				t.Errorf("has global key: %v %v", has, err)
+				return
+			}
+		}
+	}()
+	for range 3 {
+		rs, err := b.Execute("uptime", ids, 5, 4, "key", "")
+		if err != nil || len(rs) != len(ids) {
+			t.Fatalf("exec: %+v %v", rs, err)
+		}
+		for _, r := range rs {
+			if r.Err != nil {
+				t.Fatalf("exec with global key: %+v", r)
+			}
+		}
+	}
+	close(stop)
+	<-done
+}
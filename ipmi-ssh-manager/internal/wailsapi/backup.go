@@ -0,0 +1,70 @@
+package wailsapi
+
+import (
+	"errors"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+var errNoBackupService = errors.New("database backup is not available")
+
+// backupService 备份 / 恢复 / 完整性检查需 manage_data 权限
+func (b *Backend) backupService() (*service.BackupService, error) {
+	if err := b.require(domain.PermManageData); err != nil {
+		return nil, err
+	}
+	if b.backups == nil {
+		return nil, errNoBackupService
+	}
+	return b.backups, nil
+}
+
+// ListBackups 备份目录中的备份 (新的在前)
+func (b *Backend) ListBackups() ([]domain.BackupInfo, error) {
+	s, err := b.backupService()
+	if err != nil {
+		return nil, err
+	}
+	return s.List()
+}
+
+// CreateBackup 立即备份当前数据库 (VACUUM INTO)，返回校验后的备份信息
+func (b *Backend) CreateBackup() (domain.BackupInfo, error) {
+	s, err := b.backupService()
+	if err != nil {
+		return domain.BackupInfo{}, err
+	}
+	info, err := s.Backup("")
+	if err != nil {
+		return info, err
+	}
+	return info, b.record(domain.AuditDBBackup, info.Name, nil, info)
+}
+
+// RestoreBackup 用备份目录中的 name 覆盖当前数据库：先校验文件与迁移版本，并自动备份当前库 (返回该备份)。
+// 恢复后审计日志为备份时的状态，本次恢复作为新条目追加
+func (b *Backend) RestoreBackup(name string) (domain.BackupInfo, error) {
+	s, err := b.backupService()
+	if err != nil {
+		return domain.BackupInfo{}, err
+	}
+	path, err := s.Path(name)
+	if err != nil {
+		return domain.BackupInfo{}, err
+	}
+	pre, err := s.Restore(path)
+	if err != nil {
+		return pre, err
+	}
+	return pre, b.record(domain.AuditDBRestore, name, pre, nil)
+}
+
+// CheckIntegrity 对当前数据库执行 PRAGMA integrity_check 并检查迁移状态
+func (b *Backend) CheckIntegrity() (domain.IntegrityReport, error) {
+	s, err := b.backupService()
+	if err != nil {
+		return domain.IntegrityReport{}, err
+	}
+	return s.Check()
+}
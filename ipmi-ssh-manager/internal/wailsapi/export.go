@@ -0,0 +1,145 @@
+package wailsapi
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/pkg/importexport"
+)
+
+// ReportRequest 导出请求：JobID 非空时导出该任务的结果，否则按 History 条件导出历史 (单次最多 500 条，可用 offset 分页)
+type ReportRequest struct {
+	Format   string               `json:"format"` // csv | jsonl | html | xlsx
+	JobID    string               `json:"job_id,omitempty"`
+	History  domain.HistorySearch `json:"history"`
+	Title    string               `json:"title,omitempty"`
+	NoRedact bool                 `json:"no_redact,omitempty"` // 不对命令与输出脱敏 (需要 view_secrets 权限)
+}
+
+// ReportFile 导出的文件；Data 在 JSON 中为 base64
+type ReportFile struct {
+	Name string `json:"name"`
+	MIME string `json:"mime"`
+	Data []byte `json:"data"`
+}
+
+// ExportReport 将任务结果或筛选后的历史导出为 CSV / JSONL / 自包含 HTML 报告 / XLSX；
+// 默认对命令、输出与错误信息中的密码、令牌、私钥脱敏，结果限于调用者分组范围
+func (b *Backend) ExportReport(req ReportRequest) (ReportFile, error) {
+	perm := domain.PermView
+	if req.NoRedact {
+		perm = domain.PermViewSecrets
+	}
+	if err := b.require(perm); err != nil {
+		return ReportFile{}, err
+	}
+	if req.Format == "" {
+		req.Format = "jsonl"
+	}
+	if !slices.Contains(importexport.ReportFormats, req.Format) {
+		return ReportFile{}, fmt.Errorf("unsupported report format %q (csv|jsonl|html|xlsx)", req.Format)
+	}
+	now := time.Now()
+	rep := importexport.Report{Title: req.Title, GeneratedAt: now}
+	name := "history"
+	if req.JobID != "" {
+		rows, err := b.jobReportRows(req.JobID)
+		if err != nil {
+			return ReportFile{}, err
+		}
+		rep.Rows, name = rows, "job_"+req.JobID
+		if rep.Title == "" {
+			rep.Title = "任务 " + req.JobID + " 执行结果"
+		}
+	} else {
+		hits, err := b.SearchHistory(req.History)
+		if err != nil {
+			return ReportFile{}, err
+		}
+		hs := make([]domain.ExecHistory, 0, len(hits))
+		for _, h := range hits {
+			hs = append(hs, h.ExecHistory)
+		}
+		if err := b.loadFullOutput(hs); err != nil {
+			return ReportFile{}, err
+		}
+		rep.Rows = importexport.RowsFromHistory(hs)
+		if rep.Title == "" {
+			rep.Title = "执行历史"
+		}
+	}
+	if !req.NoRedact {
+		extra, err := b.reportSecrets(rep.Rows)
+		if err != nil {
+			return ReportFile{}, err
+		}
+		rep = rep.Redact(extra...)
+	}
+	data, err := importexport.RenderReport(rep, req.Format)
+	if err != nil {
+		return ReportFile{}, err
+	}
+	return ReportFile{Name: "ipmi_" + name + "_" + now.Format("20060102_150405") + "." + req.Format, MIME: importexport.ReportMIME(req.Format), Data: data}, nil
+}
+
+// reSecretAttr 名称表明是凭据的自定义属性 (如 ipmi_password、bmc_pass、api_token)
+var reSecretAttr = regexp.MustCompile(`(?i)(pass|pwd|secret|token|credential|api[_-]?key)`)
+
+// minRedactLen 作为原文整体替换的凭据最短长度，过短的值 (如单个字符) 替换会破坏整份报告
+const minRedactLen = 4
+
+// reportSecrets 报告涉及机器的已存凭据 (解密后的 SSH 私钥 / 口令、凭据类属性) 与全局私钥，作为脱敏的 extra：
+// 输出回显或渲染进命令的口令没有 -P / password= 之类的前缀时也会被隐藏
+func (b *Backend) reportSecrets(rows []importexport.ReportRow) ([]string, error) {
+	var ids []int64
+	for _, row := range rows {
+		if row.MachineID != 0 && !slices.Contains(ids, row.MachineID) {
+			ids = append(ids, row.MachineID)
+		}
+	}
+	var out []string
+	add := func(s string) {
+		if s = strings.TrimSpace(s); len(s) >= minRedactLen && !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	if len(ids) > 0 {
+		ms, err := b.repo.GetByIDs(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range ms {
+			add(m.SSHKey)
+			for k, v := range m.Attrs {
+				if reSecretAttr.MatchString(k) {
+					add(v)
+				}
+			}
+		}
+	}
+	add(b.globalKey())
+	// 长的先替换，避免较短的值先替换后长值不再完整
+	slices.SortFunc(out, func(x, y string) int { return len(y) - len(x) })
+	return out, nil
+}
+
+// jobReportRows 任务的报告行：优先读取历史 (含开始时间与耗时)，尚未写入历史时使用内存中的最近结果
+func (b *Backend) jobReportRows(jobID string) ([]importexport.ReportRow, error) {
+	hs, err := b.jobHistory(jobID)
+	if err == nil {
+		return importexport.RowsFromHistory(hs), nil
+	}
+	if !b.actor().Scoped() {
+		b.jobMu.Lock()
+		rs := append([]domain.ExecResult(nil), b.jobResults[jobID]...)
+		b.jobMu.Unlock()
+		if len(rs) > 0 {
+			return importexport.RowsFromResults(rs), nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,20 @@
+package wailsapi
+
+import (
+	"errors"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/logging"
+)
+
+// TailLogs 读取最近的系统日志 (JSON 文件，含已轮转的旧文件)，按时间正序返回；
+// 可按最低级别 / job_id / 子串过滤。日志包含所有用户的命令，与审计同需 view_audit 权限
+func (b *Backend) TailLogs(q logging.TailQuery) ([]logging.Entry, error) {
+	if err := b.require(domain.PermViewAudit); err != nil {
+		return nil, err
+	}
+	if b.logFile == "" {
+		return nil, errors.New("file logging is not enabled")
+	}
+	return logging.Tail(b.logFile, q)
+}
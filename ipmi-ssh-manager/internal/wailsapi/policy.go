@@ -0,0 +1,69 @@
+package wailsapi
+
+import (
+	"errors"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+// CheckPolicy 预览命令策略判定 (不执行)：需确认时返回确认令牌，前端提示后随 JobRequest.confirm 提交
+func (b *Backend) CheckPolicy(req JobRequest) (domain.PolicyDecision, error) {
+	u := b.actor()
+	if err := service.AuthorizeCommand(u, req.Command); err != nil {
+		return domain.PolicyDecision{}, err
+	}
+	if _, err := domain.ParseSelector(req.Selector); err != nil {
+		return domain.PolicyDecision{}, err
+	}
+	task := req.task()
+	task.User, task.Groups, task.Role = u.Name, u.Groups, u.Role
+	return b.execSvc.CheckPolicy(task)
+}
+
+// ListApprovals 命令审批单 (status 为空表示全部，按创建时间倒序)
+func (b *Backend) ListApprovals(status string) ([]domain.Approval, error) {
+	if err := b.require(domain.PermExec); err != nil {
+		return nil, err
+	}
+	if b.policy == nil {
+		return nil, nil
+	}
+	return b.policy.Approvals(domain.ApprovalStatus(status), 0)
+}
+
+// ApproveExec 批准他人的下发请求；审批人须有权执行该命令且目标在其分组范围内
+func (b *Backend) ApproveExec(id string) (domain.Approval, error) { return b.decideApproval(id, true) }
+
+// RejectExec 驳回他人的下发请求
+func (b *Backend) RejectExec(id string) (domain.Approval, error) { return b.decideApproval(id, false) }
+
+func (b *Backend) decideApproval(id string, approve bool) (domain.Approval, error) {
+	if b.policy == nil {
+		return domain.Approval{}, errors.New("command policy is not enabled")
+	}
+	a, err := b.policy.Approval(id)
+	if err != nil {
+		return domain.Approval{}, err
+	}
+	u := b.actor()
+	if err := service.AuthorizeCommand(u, a.Command); err != nil {
+		return domain.Approval{}, err
+	}
+	if u.Scoped() {
+		machines, err := b.repo.GetByIDs(a.MachineIDs)
+		if err != nil {
+			return domain.Approval{}, err
+		}
+		for _, m := range machines {
+			if err := b.requireScope(m); err != nil {
+				return domain.Approval{}, err
+			}
+		}
+	}
+	a, err = b.policy.Decide(id, u.Name, approve)
+	if err != nil {
+		return a, err
+	}
+	return a, b.record(domain.AuditApproval, a.ID, nil, map[string]any{"status": a.Status, "requester": a.Requester, "command": a.Command})
+}
@@ -0,0 +1,156 @@
+package wailsapi
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+var errNoMachineHistory = errors.New("machine history and trash are not available")
+
+// machineRepo 写入机器时使用的仓库：支持修订记录时以调用者身份记录
+func (b *Backend) machineRepo() repository.MachineRepoIface {
+	if rv, ok := b.repo.(repository.MachineRevisioner); ok {
+		return rv.ForActor(b.actor().Name)
+	}
+	return b.repo
+}
+
+// revisioner 以调用者身份访问修订记录与回收站 (远程仓库不支持)
+func (b *Backend) revisioner() (repository.MachineRevisioner, error) {
+	rv, ok := b.machineRepo().(repository.MachineRevisioner)
+	if !ok {
+		return nil, errNoMachineHistory
+	}
+	return rv, nil
+}
+
+// snapshotScope 修订快照对应的机器 (用于分组范围检查)
+func snapshotScope(s domain.MachineSnapshot) domain.Machine {
+	return domain.Machine{IPMIIP: s.IPMIIP, Groups: s.Groups}
+}
+
+// MachineRevisions 机器的修订记录 (新的在前)；分组受限的用户按最近一次快照的分组检查范围
+func (b *Backend) MachineRevisions(ipmi string) ([]domain.MachineRevision, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
+	rv, err := b.revisioner()
+	if err != nil {
+		return nil, err
+	}
+	list, err := rv.ListRevisions(ipmi)
+	if err != nil || len(list) == 0 {
+		return list, err
+	}
+	if err := b.requireScope(snapshotScope(list[0].Snapshot)); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DiffMachineRevisions 同一机器两个修订间的字段差异 (从 fromID 到 toID)；SSH Key 只比较指纹
+func (b *Backend) DiffMachineRevisions(fromID, toID int64) (domain.MachineRevisionDiff, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return domain.MachineRevisionDiff{}, err
+	}
+	rv, err := b.revisioner()
+	if err != nil {
+		return domain.MachineRevisionDiff{}, err
+	}
+	from, err := rv.GetRevision(fromID)
+	if err != nil {
+		return domain.MachineRevisionDiff{}, fmt.Errorf("revision %d: %w", fromID, err)
+	}
+	to, err := rv.GetRevision(toID)
+	if err != nil {
+		return domain.MachineRevisionDiff{}, fmt.Errorf("revision %d: %w", toID, err)
+	}
+	if from.IPMIIP != to.IPMIIP {
+		return domain.MachineRevisionDiff{}, fmt.Errorf("revisions %d and %d belong to different machines", fromID, toID)
+	}
+	if _, err := b.MachineRevisions(from.IPMIIP); err != nil { // 与 MachineRevisions 相同的范围检查
+		return domain.MachineRevisionDiff{}, err
+	}
+	return domain.MachineRevisionDiff{From: from, To: to, Changes: domain.DiffSnapshots(from.Snapshot, to.Snapshot)}, nil
+}
+
+// ListTrash 回收站中的机器 (最近删除的在前)
+func (b *Backend) ListTrash() ([]domain.Machine, error) {
+	if err := b.require(domain.PermView); err != nil {
+		return nil, err
+	}
+	rv, err := b.revisioner()
+	if err != nil {
+		return nil, err
+	}
+	list, err := rv.ListDeleted()
+	return b.visible(list), err
+}
+
+// trashed 回收站中的机器 (须在调用者分组范围内)
+func (b *Backend) trashed(rv repository.MachineRevisioner, ipmi string) (domain.Machine, error) {
+	m, ok, err := findTrashed(rv, ipmi)
+	if err != nil {
+		return domain.Machine{}, err
+	}
+	if !ok {
+		return domain.Machine{}, fmt.Errorf("machine %s is not in the trash", ipmi)
+	}
+	return m, b.requireScope(m)
+}
+
+// findTrashed 按 IPMI 查找回收站中的机器 (不做权限检查)
+func findTrashed(rv repository.MachineRevisioner, ipmi string) (domain.Machine, bool, error) {
+	list, err := rv.ListDeleted()
+	if err != nil {
+		return domain.Machine{}, false, err
+	}
+	for _, m := range list {
+		if m.IPMIIP == ipmi {
+			return m, true, nil
+		}
+	}
+	return domain.Machine{}, false, nil
+}
+
+// RestoreMachine 从回收站恢复机器
+func (b *Backend) RestoreMachine(ipmi string) (domain.Machine, error) {
+	if err := b.require(domain.PermEditMachines); err != nil {
+		return domain.Machine{}, err
+	}
+	rv, err := b.revisioner()
+	if err != nil {
+		return domain.Machine{}, err
+	}
+	if _, err := b.trashed(rv, ipmi); err != nil {
+		return domain.Machine{}, err
+	}
+	m, err := rv.Restore(ipmi)
+	if err != nil {
+		return m, err
+	}
+	return m, b.record(domain.AuditMachineRestore, ipmi, nil, service.AuditMachine(m))
+}
+
+// PurgeMachine 彻底删除回收站中的机器 (修订记录保留)
+func (b *Backend) PurgeMachine(ipmi string) error {
+	if err := b.require(domain.PermEditMachines); err != nil {
+		return err
+	}
+	rv, err := b.revisioner()
+	if err != nil {
+		return err
+	}
+	old, err := b.trashed(rv, ipmi)
+	if err != nil {
+		return err
+	}
+	if err := rv.Purge(ipmi); err != nil {
+		return err
+	}
+	return b.record(domain.AuditMachinePurge, ipmi, service.AuditMachine(old), nil)
+}
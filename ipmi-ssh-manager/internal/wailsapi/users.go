@@ -0,0 +1,90 @@
+package wailsapi
+
+import (
+	"errors"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
+)
+
+var errNoUserService = errors.New("user management is not available")
+
+// userService 用户管理需 manage_users 权限
+func (b *Backend) userService() (*service.UserService, error) {
+	if err := b.require(domain.PermManageUsers); err != nil {
+		return nil, err
+	}
+	if b.users == nil {
+		return nil, errNoUserService
+	}
+	return b.users, nil
+}
+
+// ListUsers 列出全部用户
+func (b *Backend) ListUsers() ([]domain.User, error) {
+	us, err := b.userService()
+	if err != nil {
+		return nil, err
+	}
+	return us.List()
+}
+
+// CreateUser 新建用户 (role: viewer|operator|admin；groups 非空时限制可操作的机器分组)，
+// 返回访问令牌，仅此一次可见
+func (b *Backend) CreateUser(name string, role string, groups []string) (string, error) {
+	us, err := b.userService()
+	if err != nil {
+		return "", err
+	}
+	u, token, err := us.Create(domain.User{Name: name, Role: domain.Role(role), Groups: groups})
+	if err != nil {
+		return "", err
+	}
+	return token, b.record(domain.AuditUserCreate, u.Name, nil, u)
+}
+
+// UpdateUser 修改角色 / 分组范围 / 禁用状态 (按 name 定位)
+func (b *Backend) UpdateUser(u domain.User) error {
+	us, err := b.userService()
+	if err != nil {
+		return err
+	}
+	old, err := us.Get(u.Name)
+	if err != nil {
+		return err
+	}
+	if err := us.Update(u); err != nil {
+		return err
+	}
+	now, _ := us.Get(u.Name)
+	return b.record(domain.AuditUserUpdate, u.Name, old, now)
+}
+
+// DeleteUser 删除用户
+func (b *Backend) DeleteUser(name string) error {
+	us, err := b.userService()
+	if err != nil {
+		return err
+	}
+	old, err := us.Get(name)
+	if err != nil {
+		return err
+	}
+	if err := us.Delete(name); err != nil {
+		return err
+	}
+	return b.record(domain.AuditUserDelete, name, old, nil)
+}
+
+// ResetUserToken 重新生成访问令牌并返回，旧令牌立即失效
+func (b *Backend) ResetUserToken(name string) (string, error) {
+	us, err := b.userService()
+	if err != nil {
+		return "", err
+	}
+	token, err := us.ResetToken(name)
+	if err != nil {
+		return "", err
+	}
+	return token, b.record(domain.AuditUserToken, name, nil, service.RedactedSecret("token"))
+}
@@ -3,7 +3,15 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/remoteapi"
@@ -12,11 +20,15 @@ import (
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
 	"github.com/wailsapp/wails/v2/pkg/options/assetserver"
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/httpapi"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/logging"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/metrics"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/repository"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/service"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/ssh"
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/tui"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/wailsapi"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/pkg/config"
 	"github.com/QingMing-Bot/ipmi-ssh-manager/webui"
@@ -24,46 +36,187 @@ import (
 
 func main() {
 	cfg := config.Load()
+	logFile, err := logging.OpenRotating(cfg.LogPath(), int64(cfg.LogMaxSizeMB)<<20, cfg.LogMaxFiles)
+	if err != nil {
+		log.Fatalf("open log file: %v", err)
+	}
+	defer logFile.Close()
+	var console io.Writer = os.Stderr
+	if cfg.Mode == "tui" { // 终端界面占用屏幕，只写文件
+		console = nil
+	}
+	logger := logging.New(logging.Options{Level: logging.ParseLevel(cfg.LogLevel), File: logFile, Console: console})
+	slog.SetDefault(logger) // 标准库 log 的输出同样进入日志文件
+	fatal := func(msg string, err error) {
+		logger.Error(msg, logging.Err(err))
+		if console == nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", msg, err)
+		}
+		_ = logFile.Close()
+		os.Exit(1)
+	}
+	repository.SetLogger(logger.With("component", "repository"), time.Duration(cfg.SlowQueryMs)*time.Millisecond)
+
 	db, err := sql.Open("sqlite", cfg.DBPath())
 	if err != nil {
-		log.Fatal(err)
+		fatal("open database", err)
 	}
 	// 根据配置决定本地还是远程仓库
 	var (
 		mRepo     repository.MachineRepoIface
 		hRepo     repository.HistoryRepoIface
+		users     *service.UserService // 仅本地数据库支持用户管理与审计
+		auditor   *service.Auditor
+		approvals repository.ApprovalRepoIface // 为空时需审批的命令一律拒绝
+		backups   *service.BackupService       // 仅本地数据库支持备份 / 恢复 (共享库使用数据库自身的备份工具)
 		useRemote = cfg.RemoteAPIBase != ""
 	)
 	if useRemote {
 		remoteClient, err := remoteapi.New(cfg.RemoteAPIBase, func() string { return cfg.RemoteAPIToken })
 		if err != nil {
-			log.Fatalf("remote client init failed: %v", err)
+			fatal("remote client init failed", err)
 		}
 		mRepo = remoteapi.NewRemoteMachineRepo(remoteClient)
 		hRepo = remoteapi.NewRemoteHistoryRepo(remoteClient)
 	} else {
 		localM := repository.NewMachineRepo(db)
-		_ = localM.EnsureSchema()
 		localH := repository.NewHistoryRepo(db)
-		_ = localH.EnsureSchema()
+		localU := repository.NewUserRepo(db)
+		localA := repository.NewAuditRepo(db)
+		localP := repository.NewApprovalRepo(db)
+		// 版本化迁移 (见 repository.Migrate)；全文索引依赖驱动，单独创建
+		if _, err := repository.Migrate(db); err != nil {
+			fatal("migrate database schema", err)
+		}
+		if err := localH.EnsureSchema(); err != nil {
+			fatal("init history index", err)
+		}
 		mRepo = localM
 		hRepo = localH
+		if cfg.DatabaseDSN != "" {
+			// 共享库：机器与历史保存在 PostgreSQL / MySQL，启动时执行该方言的迁移
+			sharedDB, dialect, err := repository.OpenDSN(cfg.DatabaseDSN)
+			if err != nil {
+				fatal("open shared database", err)
+			}
+			defer sharedDB.Close()
+			sharedM := repository.NewMachineRepoDialect(sharedDB, dialect)
+			if err := sharedM.EnsureSchema(); err != nil {
+				fatal("migrate shared database schema", err)
+			}
+			mRepo = sharedM
+			hRepo = repository.NewHistoryRepoDialect(sharedDB, dialect)
+			logger.Info("machines and history stored in shared database", "dialect", dialect)
+		}
+		users = service.NewUserService(localU)
+		auditor = service.NewAuditor(localA)
+		approvals = localP
+		backups = service.NewBackupService(db, cfg.BackupDir, cfg.BackupKeep)
+		backups.SetLogger(logger.With("component", "backup"))
+		backups.SetAfterRestore(localH.EnsureSchema) // 备份中缺少全文索引时重建
+		backups.Start(time.Duration(cfg.BackupIntervalHours) * time.Hour)
 	}
 	hWriter := service.NewHistoryWriter(hRepo, cfg.HistoryFlushInterval, cfg.HistoryBatchSize)
+	hWriter.SetLogger(logger.With("component", "history"))
+	hWriter.SetBlockTimeout(time.Duration(cfg.HistoryBlockMs) * time.Millisecond)
+	if n, err := hWriter.SetJournal(cfg.HistoryJournalPath()); err != nil {
+		logger.Warn("history journal unavailable, records may be lost when the queue is full", logging.Err(err))
+	} else if n > 0 {
+		logger.Info("history journal replayed on startup", "records", n)
+	}
 	if cfg.HistoryRetentionDays > 0 || cfg.HistoryMaxRows > 0 {
 		go func() {
 			ticker := time.NewTicker(time.Hour)
 			defer ticker.Stop()
 			for range ticker.C {
-				_ = hRepo.Cleanup(cfg.HistoryRetentionDays, cfg.HistoryMaxRows)
+				if err := hRepo.Cleanup(cfg.HistoryRetentionDays, cfg.HistoryMaxRows); err != nil {
+					logger.Warn("history cleanup failed", logging.Err(err))
+				}
 			}
 		}()
 	}
 	executor := ssh.NewExecutor(cfg.MaxParallel)
+	executor.SetLogger(logger.With("component", "ssh"))
 	execSvc := service.NewExecService(mRepo, hWriter, executor, cfg.MaxParallel)
+	execSvc.SetLogger(logger.With("component", "exec"))
+	execSvc.SetOutputLimit(cfg.MaxOutputKB << 10)
 	backend := wailsapi.NewBackend(db, mRepo, hRepo, execSvc)
-	// 设置全局 key provider，允许执行时回退使用 (机器未配置单独 key 时)
-	execSvc.SetGlobalKeyProvider(func() string { return backend.GetGlobalSSHKey() })
+	backend.SetLogger(logger.With("component", "backend"), cfg.LogPath())
+	if users != nil {
+		backend.SetUserService(users)
+		backend.SetAuditor(auditor)
+		backend.SetBackupService(backups)
+	}
+	policyCfg, err := service.LoadPolicyConfig(cfg.PolicyFile)
+	if err != nil {
+		fatal("load command policy", err)
+	}
+	policy, err := service.NewPolicy(policyCfg, approvals)
+	if err != nil {
+		fatal("load command policy", err)
+	}
+	policy.OnDecision = func(task domain.ExecTask, d domain.PolicyDecision) {
+		if err := auditor.RecordPolicy(task, d); err != nil {
+			logger.Warn("audit policy decision failed", logging.KeyJobID, task.JobID, logging.Err(err))
+		}
+	}
+	backend.SetPolicy(policy)
+	if cfg.MetricsAddr != "" {
+		// Prometheus 指标：独立端口，建议只监听本机或内网地址
+		reg := metrics.NewRegistry()
+		metrics.Register(reg, metrics.Sources{Exec: execSvc, History: hWriter, SSH: executor, SQLite: !useRemote})
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", reg.Handler())
+		msrv := &http.Server{Addr: cfg.MetricsAddr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			logger.Info("metrics listening", "addr", cfg.MetricsAddr, "path", "/metrics")
+			if err := msrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("metrics server stopped", logging.Err(err))
+			}
+		}()
+	}
+
+	switch cfg.Mode {
+	case "tui":
+		// 终端界面：无需 Wails / 图形环境，适合 SSH 登录管理机使用
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+		defer stop()
+		err := tui.Run(ctx, tui.Options{Machines: mRepo, History: hRepo, Exec: execSvc, Parallel: cfg.MaxParallel, SetGlobalKey: backend.SetGlobalSSHKey, User: backend.CurrentUser().Name, Role: backend.CurrentUser().Role, Audit: auditor})
+		hWriter.Close()
+		if err != nil && !errors.Is(err, context.Canceled) {
+			fatal("tui", err)
+		}
+		return
+	case "server":
+		// 无窗口 HTTP 服务：浏览器访问同一前端，事件经 WebSocket / SSE 推送
+		hub := httpapi.NewHub()
+		backend.SetEventBus(hub)
+		srv := httpapi.NewServer(backend, hub, webui.Assets)
+		if cfg.Auth {
+			if users == nil {
+				fatal("server auth", errors.New("needs the local database (set IPMI_AUTH=off to run without users)"))
+			}
+			// 首次启动且没有任何用户时创建管理员，令牌只打印这一次
+			token, err := users.Bootstrap("admin")
+			if err != nil {
+				fatal("bootstrap admin user", err)
+			}
+			if token != "" {
+				// 令牌只输出到控制台，不写入日志文件
+				fmt.Fprintf(os.Stderr, "created user admin, access token: %s\n", token)
+				logger.Info("created user admin")
+			}
+			srv.SetAuth(&httpapi.Auth{Authenticate: users.Authenticate, Bind: func(u domain.User) any { return backend.ForUser(u) }})
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		logger.Info("server listening", "addr", cfg.Addr, "auth", cfg.Auth, "log_file", cfg.LogPath())
+		if err := srv.ListenAndServe(ctx, cfg.Addr); err != nil {
+			logger.Error("server stopped", logging.Err(err))
+		}
+		hWriter.Close()
+		return
+	}
 
 	app := &options.App{
 		Title:       "IPMI SSH Manager",
@@ -75,11 +228,11 @@ func main() {
 		Bind:        []interface{}{backend},
 		OnStartup: func(ctx context.Context) {
 			backend.SetCtx(ctx)
-			runtime.LogInfo(ctx, "Wails backend context initialized")
+			logger.Info("wails backend context initialized", "log_file", cfg.LogPath())
 		},
 	}
 	if err := wails.Run(app); err != nil {
-		log.Fatal(err)
+		fatal("wails", err)
 	}
 	hWriter.Close()
 }
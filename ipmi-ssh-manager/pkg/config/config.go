@@ -18,8 +18,23 @@ type Config struct {
 	HistoryMaxRows       int
 	HistoryFlushInterval int
 	HistoryBatchSize     int
+	HistoryBlockMs       int    // 历史写入队列满时最长等待 (毫秒)，超时后写入落盘日志
+	MaxOutputKB          int    // 每台机器 stdout / stderr 各自保留上限 (KiB)，超出只保留开头与结尾；<=0 不限
 	RemoteAPIBase        string // 远程 API 基址 (非空则启用 remote 模式)
 	RemoteAPIToken       string // 静态 Token(示例)；真实应通过登录流程获取
+	Mode                 string // 运行模式: desktop (Wails 窗口) | server (HTTP 服务) | tui (终端界面)
+	Addr                 string // server 模式监听地址
+	Auth                 bool   // server 模式是否要求访问令牌 (关闭时所有请求以本机管理员身份执行)
+	PolicyFile           string // 命令策略文件 (JSON)，不存在时使用内置默认策略
+	MetricsAddr          string // Prometheus 指标监听地址 (如 127.0.0.1:9102)，为空不启用
+	LogLevel             string // 日志级别 debug|info|warn|error
+	LogMaxSizeMB         int    // 单个日志文件大小上限 (MB)，超出后轮转
+	LogMaxFiles          int    // 保留的旧日志文件数
+	SlowQueryMs          int    // 数据库调用超过该耗时 (毫秒) 记录慢查询日志，<=0 不记录
+	BackupDir            string // 数据库备份目录
+	BackupIntervalHours  int    // 定时备份间隔 (小时)，<=0 不定时备份
+	BackupKeep           int    // 保留的备份数，<=0 不删除旧备份
+	DatabaseDSN          string // 共享数据库 DSN (postgres://… 或 mysql://…)，非空时机器与历史保存在该库；用户、审计与审批仍在本地 SQLite
 }
 
 var (
@@ -30,10 +45,16 @@ var (
 // Load 读取全局配置（只初始化一次）。
 // 环境变量：
 //
-//	IPMI_MODE          (tui|server) 默认 tui
+//	IPMI_MODE          (desktop|server|tui) 默认 desktop
 //	IPMI_ADDR          监听地址 (默认 :8080)
+//	IPMI_AUTH          server 模式令牌认证 (on|off) 默认 on
 //	IPMI_DATA_DIR      数据目录 (默认 data)
+//	IPMI_POLICY_FILE   命令策略文件 (默认 <数据目录>/policy.json)
 //	IPMI_MAX_PARALLEL  并发数 (整数, 默认 0 不限)
+//	IPMI_METRICS_ADDR  指标监听地址 (默认空，不启用)
+//	IPMI_LOG_LEVEL     日志级别 (默认 info)
+//	IPMI_BACKUP_DIR    数据库备份目录 (默认 <数据目录>/backups)
+//	IPMI_DB_DSN        共享数据库 DSN (默认空，使用本地 SQLite)
 func Load() *Config {
 	once.Do(func() {
 		c := &Config{
@@ -43,9 +64,24 @@ func Load() *Config {
 			HistoryMaxRows:       envInt("IPMI_HISTORY_MAX_ROWS", 10000),
 			HistoryFlushInterval: envInt("IPMI_HISTORY_FLUSH_INTERVAL", 2),
 			HistoryBatchSize:     envInt("IPMI_HISTORY_BATCH_SIZE", 20),
+			HistoryBlockMs:       envInt("IPMI_HISTORY_BLOCK_MS", 2000),
+			MaxOutputKB:          envInt("IPMI_MAX_OUTPUT_KB", 1024),
 			RemoteAPIBase:        envOr("IPMI_REMOTE_API_BASE", ""),
 			RemoteAPIToken:       envOr("IPMI_REMOTE_API_TOKEN", ""),
+			Mode:                 envOr("IPMI_MODE", "desktop"),
+			Addr:                 envOr("IPMI_ADDR", ":8080"),
+			Auth:                 envOr("IPMI_AUTH", "on") != "off",
+			MetricsAddr:          envOr("IPMI_METRICS_ADDR", ""),
+			LogLevel:             envOr("IPMI_LOG_LEVEL", "info"),
+			LogMaxSizeMB:         envInt("IPMI_LOG_MAX_SIZE_MB", 10),
+			LogMaxFiles:          envInt("IPMI_LOG_MAX_FILES", 5),
+			SlowQueryMs:          envInt("IPMI_LOG_SLOW_QUERY_MS", 200),
+			BackupIntervalHours:  envInt("IPMI_BACKUP_INTERVAL_HOURS", 24),
+			BackupKeep:           envInt("IPMI_BACKUP_KEEP", 7),
+			DatabaseDSN:          envOr("IPMI_DB_DSN", ""),
 		}
+		c.PolicyFile = envOr("IPMI_POLICY_FILE", filepath.Join(c.DataDir, "policy.json"))
+		c.BackupDir = envOr("IPMI_BACKUP_DIR", filepath.Join(c.DataDir, "backups"))
 		_ = os.MkdirAll(c.DataDir, 0755)
 		global = c
 	})
@@ -55,6 +91,12 @@ func Load() *Config {
 // DBPath 返回 sqlite 文件路径。
 func (c *Config) DBPath() string { return filepath.Join(c.DataDir, "machines.db") }
 
+// LogPath 返回 JSON 日志文件路径 (轮转后的旧文件为 .1 .2 ...)。
+func (c *Config) LogPath() string { return filepath.Join(c.DataDir, "logs", "ipmi-ssh-manager.log") }
+
+// HistoryJournalPath 返回历史写入落盘日志路径 (写库失败或队列满时暂存，启动时重放)。
+func (c *Config) HistoryJournalPath() string { return filepath.Join(c.DataDir, "history.journal") }
+
 // Helpers
 func envOr(k, def string) string {
 	if v := os.Getenv(k); v != "" {
@@ -4,6 +4,8 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"maps"
+	"slices"
 	"strings"
 
 	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
@@ -24,7 +26,7 @@ func ParseMachinesJSON(data []byte) ([]domain.Machine, error) {
 	return out, nil
 }
 
-// ParseMachinesCSV 解析 CSV (含 header) -> machines
+// ParseMachinesCSV 解析 CSV (含 header) -> machines；第 6 列 attrs 为 k=v;k2=v2，为空时保留已有属性
 func ParseMachinesCSV(data []byte) ([]domain.Machine, error) {
 	r := csv.NewReader(strings.NewReader(string(data)))
 	rows, err := r.ReadAll()
@@ -61,6 +63,9 @@ func ParseMachinesCSV(data []byte) ([]domain.Machine, error) {
 		if len(cols) > 4 {
 			m.Remark = strings.TrimSpace(cols[4])
 		}
+		if len(cols) > 5 {
+			m.Attrs = ParseAttrs(cols[5])
+		}
 		out = append(out, m)
 	}
 	return out, nil
@@ -69,16 +74,41 @@ func ParseMachinesCSV(data []byte) ([]domain.Machine, error) {
 // RenderMachinesCSV 输出 CSV 字符串 (含 header)
 func RenderMachinesCSV(ms []domain.Machine) string {
 	var b strings.Builder
-	b.WriteString("ipmi_ip,ssh_ip,ssh_user,ssh_key,remark\n")
+	b.WriteString("ipmi_ip,ssh_ip,ssh_user,ssh_key,remark,attrs\n")
 	for _, m := range ms {
 		b.WriteString(strings.Join([]string{
-			escapeCSV(m.IPMIIP), escapeCSV(m.SSHIP), escapeCSV(m.SSHUser), escapeCSV(m.SSHKey), escapeCSV(m.Remark),
+			escapeCSV(m.IPMIIP), escapeCSV(m.SSHIP), escapeCSV(m.SSHUser), escapeCSV(m.SSHKey), escapeCSV(m.Remark), escapeCSV(FormatAttrs(m.Attrs)),
 		}, ","))
 		b.WriteString("\n")
 	}
 	return b.String()
 }
 
+// ParseAttrs 解析 k=v;k2=v2 形式的自定义属性 (忽略空键与不含 = 的项)；结果为空时返回 nil
+func ParseAttrs(s string) map[string]string {
+	var attrs map[string]string
+	for _, kv := range strings.Split(s, ";") {
+		k, v, ok := strings.Cut(kv, "=")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			continue
+		}
+		if attrs == nil {
+			attrs = map[string]string{}
+		}
+		attrs[k] = strings.TrimSpace(v)
+	}
+	return attrs
+}
+
+// FormatAttrs 按键排序输出 k=v;k2=v2
+func FormatAttrs(attrs map[string]string) string {
+	parts := make([]string, 0, len(attrs))
+	for _, k := range slices.Sorted(maps.Keys(attrs)) {
+		parts = append(parts, k+"="+attrs[k])
+	}
+	return strings.Join(parts, ";")
+}
+
 func escapeCSV(s string) string {
 	if strings.ContainsAny(s, ",\n\"") {
 		return "\"" + strings.ReplaceAll(s, "\"", "\"\"") + "\""
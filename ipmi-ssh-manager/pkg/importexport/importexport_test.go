@@ -0,0 +1,22 @@
+package importexport
+
+import (
+	"testing"
+)
+
+func TestMachinesCSV_Attrs(t *testing.T) {
+	ms, err := ParseMachinesCSV([]byte("ipmi_ip,ssh_ip,ssh_user,ssh_key,remark,attrs\n10.0.0.1,,root,,web,hostname=node-a; rack = A12 ;bad\n10.0.0.2,,root,,,\n"))
+	if err != nil || len(ms) != 2 {
+		t.Fatalf("parse %+v %v", ms, err)
+	}
+	if len(ms[0].Attrs) != 2 || ms[0].Attrs["hostname"] != "node-a" || ms[0].Attrs["rack"] != "A12" {
+		t.Fatalf("attrs %+v", ms[0].Attrs)
+	}
+	if ms[1].Attrs != nil { // 空列保留已有属性
+		t.Fatalf("empty attrs column should be nil, got %+v", ms[1].Attrs)
+	}
+	back, err := ParseMachinesCSV([]byte(RenderMachinesCSV(ms)))
+	if err != nil || FormatAttrs(back[0].Attrs) != "hostname=node-a;rack=A12" {
+		t.Fatalf("round trip %+v %v", back, err)
+	}
+}
@@ -0,0 +1,233 @@
+package importexport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QingMing-Bot/ipmi-ssh-manager/internal/domain"
+)
+
+// ReportRow 报告中一台机器的一次执行
+type ReportRow struct {
+	JobID       string    `json:"job_id,omitempty"`
+	MachineID   int64     `json:"machine_id"`
+	IPMIIP      string    `json:"ipmi_ip"`
+	Command     string    `json:"command"`
+	ExitCode    int       `json:"exit_code"`
+	Passed      bool      `json:"passed"`
+	Error       string    `json:"error,omitempty"`
+	AssertMsg   string    `json:"assert_msg,omitempty"`
+	StartedAt   time.Time `json:"started_at,omitzero"`
+	DurationMs  int64     `json:"duration_ms"`
+	User        string    `json:"user,omitempty"`
+	Truncated   bool      `json:"truncated,omitempty"`
+	OutputBytes int64     `json:"output_bytes,omitempty"`
+	Stdout      string    `json:"stdout"`
+	Stderr      string    `json:"stderr"`
+}
+
+// Report 一个任务的结果或一组历史记录
+type Report struct {
+	Title       string      `json:"title"`
+	GeneratedAt time.Time   `json:"generated_at"`
+	Redacted    bool        `json:"redacted"` // 命令与输出中的密钥已替换为占位符
+	Rows        []ReportRow `json:"rows"`
+}
+
+// ReportFormats 支持的导出格式
+var ReportFormats = []string{"csv", "jsonl", "html", "xlsx"}
+
+// RowsFromHistory 从历史记录构建报告行
+func RowsFromHistory(hs []domain.ExecHistory) []ReportRow {
+	out := make([]ReportRow, 0, len(hs))
+	for _, h := range hs {
+		out = append(out, ReportRow{JobID: h.JobID, MachineID: h.MachineID, IPMIIP: h.IPMIIP, Command: h.Command, ExitCode: h.ExitCode, Passed: h.Passed, Error: h.ErrorText, AssertMsg: h.AssertMsg, StartedAt: h.StartedAt, DurationMs: h.DurationMs, User: h.User, Truncated: h.Truncated, OutputBytes: h.OutputBytes, Stdout: h.Stdout, Stderr: h.Stderr})
+	}
+	return out
+}
+
+// RowsFromResults 从实时结果构建报告行 (无开始时间与耗时)
+func RowsFromResults(rs []domain.ExecResult) []ReportRow {
+	out := make([]ReportRow, 0, len(rs))
+	for _, r := range rs {
+		row := ReportRow{JobID: r.JobID, MachineID: r.MachineID, IPMIIP: r.IPMIIP, Command: r.Command, ExitCode: r.ExitCode, Passed: r.Passed, AssertMsg: r.AssertMsg, User: r.User, Truncated: r.Truncated, OutputBytes: r.OutputBytes, Stdout: r.Stdout, Stderr: r.Stderr}
+		if r.Err != nil {
+			row.Error = r.Err.Error()
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+// Redact 对命令、输出与错误信息脱敏 (见 RedactSecrets)，返回新的报告
+func (r Report) Redact(extra ...string) Report {
+	rows := make([]ReportRow, len(r.Rows))
+	for i, row := range r.Rows {
+		row.Command = RedactSecrets(row.Command, extra...)
+		row.Stdout = RedactSecrets(row.Stdout, extra...)
+		row.Stderr = RedactSecrets(row.Stderr, extra...)
+		row.Error = RedactSecrets(row.Error, extra...)
+		row.AssertMsg = RedactSecrets(row.AssertMsg, extra...)
+		rows[i] = row
+	}
+	r.Rows, r.Redacted = rows, true
+	return r
+}
+
+// ReportSummary 报告汇总
+type ReportSummary struct {
+	Hosts, Passed, Failed, Truncated int
+	MaxMs                            int64
+}
+
+// Summary 统计通过 / 失败机器数
+func (r Report) Summary() ReportSummary {
+	var s ReportSummary
+	for _, row := range r.Rows {
+		s.Hosts++
+		if row.Passed {
+			s.Passed++
+		} else {
+			s.Failed++
+		}
+		if row.Truncated {
+			s.Truncated++
+		}
+		s.MaxMs = max(s.MaxMs, row.DurationMs)
+	}
+	return s
+}
+
+// reportColumns CSV / XLSX 的列，顺序与 ReportRow.cells 一致
+var reportColumns = []string{"job_id", "machine_id", "ipmi_ip", "command", "exit_code", "passed", "error", "assert_msg", "started_at", "duration_ms", "user", "truncated", "output_bytes", "stdout", "stderr"}
+
+func (row ReportRow) cells() []string {
+	started := ""
+	if !row.StartedAt.IsZero() {
+		started = row.StartedAt.Format(time.RFC3339)
+	}
+	cells := []string{row.JobID, strconv.FormatInt(row.MachineID, 10), row.IPMIIP, row.Command, strconv.Itoa(row.ExitCode), strconv.FormatBool(row.Passed), row.Error, row.AssertMsg, started, strconv.FormatInt(row.DurationMs, 10), row.User, strconv.FormatBool(row.Truncated), strconv.FormatInt(row.OutputBytes, 10), row.Stdout, row.Stderr}
+	for i, c := range cells {
+		if !numericColumns[i] {
+			cells[i] = spreadsheetSafe(c)
+		}
+	}
+	return cells
+}
+
+// spreadsheetSafe 以 = + - @ (或制表符、回车) 开头的文本前加 '，避免表格软件把命令与输出当作公式执行
+func spreadsheetSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// RenderReport 按格式输出报告：csv | jsonl (每行一台机器) | html (自包含、可折叠输出) | xlsx
+func RenderReport(r Report, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "csv":
+		w := csv.NewWriter(&buf)
+		_ = w.Write(reportColumns)
+		for _, row := range r.Rows {
+			_ = w.Write(row.cells())
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, err
+		}
+	case "", "jsonl":
+		enc := json.NewEncoder(&buf)
+		for _, row := range r.Rows {
+			if err := enc.Encode(row); err != nil {
+				return nil, err
+			}
+		}
+	case "html":
+		if err := reportHTML.Execute(&buf, struct {
+			Report
+			Summary ReportSummary
+		}{r, r.Summary()}); err != nil {
+			return nil, err
+		}
+	case "xlsx":
+		if err := writeXLSX(&buf, r); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported report format %q (csv|jsonl|html|xlsx)", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// ReportMIME 各格式的 Content-Type
+func ReportMIME(format string) string {
+	switch format {
+	case "csv":
+		return "text/csv; charset=utf-8"
+	case "html":
+		return "text/html; charset=utf-8"
+	case "xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "application/x-ndjson"
+}
+
+var reportHTML = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ts": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body{font-family:-apple-system,"Segoe UI","Microsoft YaHei",sans-serif;margin:24px;color:#1f2933;background:#fff}
+h1{font-size:1.3rem;margin:0 0 4px}
+.meta{color:#6b7785;font-size:.85rem;margin-bottom:16px}
+.cards{display:flex;gap:12px;margin-bottom:18px}
+.cards div{border:1px solid #d9dee4;border-radius:6px;padding:8px 14px;min-width:90px}
+.cards b{display:block;font-size:1.2rem}
+table{border-collapse:collapse;width:100%;font-size:.85rem;margin-bottom:20px}
+th,td{border:1px solid #d9dee4;padding:4px 8px;text-align:left;vertical-align:top}
+th{background:#f3f5f7}
+.ok{color:#1a7f37}.fail{color:#c62828}
+details{border:1px solid #d9dee4;border-radius:6px;margin-bottom:8px}
+summary{cursor:pointer;padding:6px 10px;background:#f8f9fa}
+pre{margin:0;padding:8px 10px;white-space:pre-wrap;word-break:break-all;font-family:Consolas,monospace;font-size:.8rem}
+pre.err{color:#c62828;border-top:1px dashed #d9dee4}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="meta">生成时间 {{ts .GeneratedAt}}{{if .Redacted}} · 已脱敏{{end}}</div>
+<div class="cards">
+<div>机器<b>{{.Summary.Hosts}}</b></div>
+<div class="ok">通过<b>{{.Summary.Passed}}</b></div>
+<div class="fail">失败<b>{{.Summary.Failed}}</b></div>
+<div>输出截断<b>{{.Summary.Truncated}}</b></div>
+<div>最长耗时<b>{{.Summary.MaxMs}} ms</b></div>
+</div>
+<table>
+<tr><th>IPMI</th><th>命令</th><th>结果</th><th>退出码</th><th>耗时 (ms)</th><th>开始时间</th><th>错误 / 断言</th></tr>
+{{range $i, $r := .Rows}}<tr><td><a href="#h{{$i}}">{{$r.IPMIIP}}</a></td><td>{{$r.Command}}</td><td class="{{if $r.Passed}}ok">通过{{else}}fail">失败{{end}}</td><td>{{$r.ExitCode}}</td><td>{{$r.DurationMs}}</td><td>{{ts $r.StartedAt}}</td><td>{{$r.Error}}{{if and $r.Error $r.AssertMsg}}; {{end}}{{$r.AssertMsg}}</td></tr>
+{{end}}</table>
+{{range $i, $r := .Rows}}<details id="h{{$i}}"{{if not $r.Passed}} open{{end}}>
+<summary><span class="{{if $r.Passed}}ok{{else}}fail{{end}}">{{if $r.Passed}}✔{{else}}✘{{end}}</span> {{$r.IPMIIP}} — {{$r.Command}}{{if $r.Truncated}} (输出已截断，原始 {{$r.OutputBytes}} 字节){{end}}</summary>
+<pre>{{if $r.Stdout}}{{$r.Stdout}}{{else}}(无输出){{end}}</pre>{{if $r.Stderr}}
+<pre class="err">{{$r.Stderr}}</pre>{{end}}
+</details>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,137 @@
+package importexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// xlsxCellLimit Excel 单元格最多 32767 个字符，超出部分截断
+const xlsxCellLimit = 32767
+
+// numericColumns 在 XLSX 中以数字写入的列 (reportColumns 下标)
+var numericColumns = map[int]bool{1: true, 4: true, 9: true, 12: true}
+
+// writeXLSX 生成最小的 Office Open XML 工作簿：Summary 与 Results 两个工作表，字符串使用内联格式
+func writeXLSX(w io.Writer, r Report) error {
+	s := r.Summary()
+	summary := [][]string{
+		{"title", r.Title},
+		{"generated_at", r.GeneratedAt.Format("2006-01-02 15:04:05")},
+		{"redacted", strconv.FormatBool(r.Redacted)},
+		{"hosts", strconv.Itoa(s.Hosts)},
+		{"passed", strconv.Itoa(s.Passed)},
+		{"failed", strconv.Itoa(s.Failed)},
+		{"truncated", strconv.Itoa(s.Truncated)},
+		{"max_duration_ms", strconv.FormatInt(s.MaxMs, 10)},
+	}
+	results := [][]string{reportColumns}
+	for _, row := range r.Rows {
+		results = append(results, row.cells())
+	}
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		body []byte
+	}{
+		{"[Content_Types].xml", []byte(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet2.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`)},
+		{"_rels/.rels", []byte(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`)},
+		{"xl/workbook.xml", []byte(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Summary" sheetId="1" r:id="rId1"/><sheet name="Results" sheetId="2" r:id="rId2"/></sheets></workbook>`)},
+		{"xl/_rels/workbook.xml.rels", []byte(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>` +
+			`<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+			`</Relationships>`)},
+		// 样式 0 为默认，1 为加粗 (表头)
+		{"xl/styles.xml", []byte(xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+			`</styleSheet>`)},
+		{"xl/worksheets/sheet1.xml", sheetXML(summary, false, func(int) bool { return false })},
+		{"xl/worksheets/sheet2.xml", sheetXML(results, true, func(col int) bool { return numericColumns[col] })},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.body); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// sheetXML 渲染工作表；header 为 true 时首行加粗并冻结
+func sheetXML(rows [][]string, header bool, numeric func(col int) bool) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if header {
+		b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	}
+	b.WriteString(`<sheetData>`)
+	for i, row := range rows {
+		fmtRow := strconv.Itoa(i + 1)
+		b.WriteString(`<row r="` + fmtRow + `">`)
+		for j, v := range row {
+			ref := columnName(j) + fmtRow
+			style := ""
+			if header && i == 0 {
+				style = ` s="1"`
+			}
+			if !(header && i == 0) && numeric(j) {
+				if _, err := strconv.ParseFloat(v, 64); err == nil {
+					b.WriteString(`<c r="` + ref + `"` + style + `><v>` + v + `</v></c>`)
+					continue
+				}
+			}
+			b.WriteString(`<c r="` + ref + `" t="inlineStr"` + style + `><is><t xml:space="preserve">`)
+			_ = xml.EscapeText(&b, []byte(cellText(v)))
+			b.WriteString(`</t></is></c>`)
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.Bytes()
+}
+
+// cellText 截断到单元格上限 (按字符，不拆开 UTF-8)
+func cellText(s string) string {
+	if utf8.RuneCountInString(s) <= xlsxCellLimit {
+		return s
+	}
+	n := 0
+	for i := range s {
+		if n == xlsxCellLimit {
+			return s[:i]
+		}
+		n++
+	}
+	return s
+}
+
+// columnName 0 -> A, 25 -> Z, 26 -> AA
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
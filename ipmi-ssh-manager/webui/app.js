@@ -4,7 +4,8 @@ const AppState = {
   machines: [],
   currentJob: null,
   jobOff: null,
-  histTimer: null
+  histTimer: null,
+  editing: null // 表单当前编辑的机器 {ipmi_ip, version}，保存时携带 version 做冲突检测
 };
 
 function $(sel, root=document){ return root.querySelector(sel); }
@@ -25,6 +26,21 @@ async function invoke(name, ...args){
   throw new Error('Binding not ready: '+name);
 }
 
+// policyGate 下发前检查命令策略：需确认时提示，需审批时输入已批准的审批单；返回附加到 JobRequest 的字段，取消时返回 null
+async function policyGate(cmd, ids){
+  let d;
+  try { d=await invoke('CheckPolicy',{command:cmd,machine_ids:ids,render:$('#ctrl_render').checked}); } catch(e){ return {}; } // 未启用策略等，交由执行时判定
+  const why=(d.reasons||[]).join('\n');
+  if(d.verdict==='deny'){ alert('命令被策略拒绝:\n'+why); return null; }
+  if(d.verdict==='confirm') return confirm('危险操作 ('+d.targets+' 台):\n'+why+'\n\n确认执行?') ? {confirm:d.confirm_token} : null;
+  if(d.verdict==='approve'){
+    const id=prompt('需要另一名用户审批:\n'+why+'\n\n输入已批准的审批单 ID (留空则提交审批申请):','');
+    if(id===null) return null;
+    return id.trim() ? {approval_id:id.trim()} : {};
+  }
+  return {};
+}
+
 /* 路由 (三个页面): assets / control / history */
 let currentPage = 'control';
 function switchPage(id){
@@ -75,21 +91,96 @@ function fillMachineForm(m){
   $('#f_ssh').value = m.ssh_ip||'';
   $('#f_zbx').value = m.zbx_id||'';
   $('#f_remark').value = m.remark||'';
+  AppState.editing = { ipmi_ip:m.ipmi_ip, version:m.version||0 };
 }
+// machineFormFields 表单可编辑的字段，冲突时按这些字段对比
+const machineFormFields = [['ssh_ip','SSH'],['zbx_id','ZBX'],['remark','备注']];
 async function saveMachine(){
-  const m = { id:0, ipmi_ip:$('#f_ipmi').value.trim(), ssh_ip:$('#f_ssh').value.trim(), ssh_user:'root', zbx_id:$('#f_zbx').value.trim(), remark:$('#f_remark').value.trim() };
-  if(!m.ipmi_ip){ alert('IPMI 必填'); return }
-  // 区分创建或更新: 先检查本地缓存是否存在
-  const existed = Array.isArray(AppState.machines) && AppState.machines.some(x=>x.ipmi_ip===m.ipmi_ip);
-  try { console.debug('Saving machine', m); await invoke('UpsertMachine', m); await loadMachines(); setStatus('保存成功'); showToast((existed?'更新':'创建')+'成功','success'); }
-  catch(e){ showToast('创建失败: '+e,'error'); setStatus('保存失败'); }
+  const ipmi = $('#f_ipmi').value.trim();
+  if(!ipmi){ alert('IPMI 必填'); return }
+  // 区分创建或更新: 先检查本地缓存是否存在；表单之外的字段 (用户、密钥、属性、标签、分组) 沿用缓存值，避免保存时被清空
+  const prev = Array.isArray(AppState.machines) ? AppState.machines.find(x=>x.ipmi_ip===ipmi) : null;
+  const existed = !!prev;
+  const m = { id:0, ipmi_ip:ipmi, ssh_ip:$('#f_ssh').value.trim(), ssh_user:(prev&&prev.ssh_user)||'root', zbx_id:$('#f_zbx').value.trim(), remark:$('#f_remark').value.trim(),
+    attrs:prev?prev.attrs||{}:{}, labels:prev?prev.labels||{}:{}, groups:prev?prev.groups||[]:[] };
+  if(AppState.editing && AppState.editing.ipmi_ip===m.ipmi_ip) m.version = AppState.editing.version;
+  try {
+    console.debug('Saving machine', m); await invoke('UpsertMachine', m); await loadMachines();
+    const cur = AppState.machines.find(x=>x.ipmi_ip===m.ipmi_ip); AppState.editing = cur ? { ipmi_ip:cur.ipmi_ip, version:cur.version||0 } : null;
+    setStatus('保存成功'); showToast((existed?'更新':'创建')+'成功','success');
+  }
+  catch(e){
+    if(String(e).includes('modified by someone else')){ await showMachineConflict(m); return; }
+    showToast('创建失败: '+e,'error'); setStatus('保存失败');
+  }
+}
+// showMachineConflict 保存冲突：保留表单输入，列出服务端当前值中与表单不同的字段；仅更新记录的版本，再次保存即有意覆盖
+async function showMachineConflict(m){
+  await loadMachines();
+  const cur = AppState.machines.find(x=>x.ipmi_ip===m.ipmi_ip);
+  if(!cur){ AppState.editing = null; showToast('该机器已被他人删除，再次保存将重新创建','error',6000); setStatus('保存冲突: 机器已删除'); return; }
+  AppState.editing = { ipmi_ip:cur.ipmi_ip, version:cur.version||0 };
+  const diffs = machineFormFields.filter(([k])=>(cur[k]||'')!==(m[k]||'')).map(([k,label])=>label+'='+(cur[k]||'(空)'));
+  const detail = diffs.length ? '服务端当前值: '+diffs.join('，') : '表单字段与服务端一致 (其他字段已变化)';
+  showToast('该机器已被他人修改 (版本 '+cur.version+')，表单内容已保留。'+detail+'；确认后再次保存将覆盖','error',8000);
+  setStatus('保存冲突: 服务端版本 '+cur.version+(diffs.length?' · '+diffs.join(' · '):''));
 }
 async function deleteMachine(){
   const ip = $('#f_ipmi').value.trim(); if(!ip){ alert('无 IPMI'); return }
-  if(!confirm('删除 '+ip+'?')) return;
-  try { await invoke('DeleteMachine', ip); await loadMachines(); setStatus('删除完成'); showToast('删除成功','success'); }
+  if(!confirm('删除 '+ip+'? (移入回收站，可恢复)')) return;
+  try { await invoke('DeleteMachine', ip); await loadMachines(); setStatus('删除完成'); showToast('已移入回收站','success'); }
   catch(e){ showToast('删除失败: '+e,'error'); setStatus('删除失败'); }
 }
+/* Machine revisions & trash (MachineRevisions / DiffMachineRevisions / ListTrash / RestoreMachine / PurgeMachine) */
+function showAssetLog(nodes){ const pre=document.createElement('pre'); pre.className='log'; nodes.forEach(n=>pre.appendChild(n)); $('#asset_history').innerHTML=''; $('#asset_history').appendChild(pre); return pre; }
+async function loadMachineHistory(){
+  const ip=$('#f_ipmi').value.trim(); if(!ip){ alert('无 IPMI'); return }
+  let list;
+  try { list=await invoke('MachineRevisions', ip); } catch(e){ $('#asset_history').innerHTML='<pre class="log">读取失败 '+e+'</pre>'; return; }
+  let from=null;
+  const rows=(list||[]).map(r=>{
+    const row=document.createElement('div'); row.style.cursor='pointer'; row.title='依次点击两个修订查看差异';
+    row.textContent='#'+r.revision+'  '+r.action.padEnd(8)+'  '+(r.actor||'-').padEnd(10)+'  '+new Date(r.created_at).toLocaleString()+'  ssh '+(r.snapshot.ssh_ip||'')+'  '+(r.snapshot.groups||[]).join(',');
+    row.addEventListener('click', async ()=>{
+      if(!from){ from=r; row.style.color='var(--accent)'; return; }
+      const a=from; from=null;
+      try { showMachineDiff(await invoke('DiffMachineRevisions', a.id, r.id)); } catch(e){ alert('对比失败: '+e); }
+    });
+    return row;
+  });
+  if(!rows.length) rows.push(document.createTextNode(ip+' 暂无变更记录'));
+  showAssetLog(rows);
+}
+function showMachineDiff(d){
+  const lines=[d.from.ipmi_ip+'  #'+d.from.revision+' ('+d.from.action+') → #'+d.to.revision+' ('+d.to.action+')'];
+  (d.changes||[]).forEach(c=>lines.push('  '+c.field+': '+JSON.stringify(c.old)+' → '+JSON.stringify(c.new)));
+  if(!(d.changes||[]).length) lines.push('  无差异');
+  showAssetLog([document.createTextNode(lines.join('\n'))]);
+}
+async function loadTrash(){
+  let list;
+  try { list=await invoke('ListTrash'); } catch(e){ $('#asset_history').innerHTML='<pre class="log">读取失败 '+e+'</pre>'; return; }
+  const rows=(list||[]).map(m=>{
+    const row=document.createElement('div');
+    const restore=document.createElement('button'); restore.className='mini-btn'; restore.textContent='恢复';
+    restore.addEventListener('click', ()=>restoreMachine(m.ipmi_ip));
+    const purge=document.createElement('button'); purge.className='mini-btn'; purge.textContent='彻底删除';
+    purge.addEventListener('click', ()=>purgeMachine(m.ipmi_ip));
+    row.append(restore, ' ', purge, '  '+m.ipmi_ip+'  ssh '+(m.ssh_ip||'')+'  删除于 '+new Date(m.deleted_at).toLocaleString()+'  '+(m.remark||''));
+    return row;
+  });
+  if(!rows.length) rows.push(document.createTextNode('回收站为空'));
+  showAssetLog(rows);
+}
+async function restoreMachine(ip){
+  try { await invoke('RestoreMachine', ip); showToast('已恢复 '+ip,'success'); await loadMachines(); loadTrash(); }
+  catch(e){ alert('恢复失败: '+e); }
+}
+async function purgeMachine(ip){
+  if(!confirm('彻底删除 '+ip+'? 删除后无法恢复 (变更历史保留)')) return;
+  try { await invoke('PurgeMachine', ip); showToast('已彻底删除 '+ip,'success'); loadTrash(); }
+  catch(e){ alert('删除失败: '+e); }
+}
 function getSelectedIDs(){ return $all('#machineTable tbody input[type=checkbox]:checked').map(c=>Number(c.dataset.id)); }
 function selectAll(chk){ $all('#machineTable tbody input[type=checkbox]').forEach(c=>c.checked = chk.checked); }
 function invertSelect(){ $all('#machineTable tbody input[type=checkbox]').forEach(c=>c.checked = !c.checked); }
@@ -196,7 +287,8 @@ async function ctrlExecute(streamJob){
     const errStr = err? (' ERR '+fmtErr(err)) : '';
     const code = (data.exit_code!=null?data.exit_code:data.ExitCode!=null?data.ExitCode:undefined);
     const usedGlobal = data.used_global_key || data.UsedGlobalKey ? ' [G]' : '';
-    return ip+': '+out+(code!==undefined && out? (' (code '+code+')'):'')+errStr+usedGlobal;
+    const trunc = data.truncated ? ' [输出已截断, 原始 '+fmtBytes(data.output_bytes)+']' : '';
+    return ip+': '+out+(code!==undefined && out? (' (code '+code+')'):'')+errStr+usedGlobal+trunc;
   }
   const outBox=$('#ctrl_log'); outBox.textContent='执行中...';
   if(streamJob==='stream'){ // 临时流
@@ -281,7 +373,8 @@ async function ctrlExecute(streamJob){
   outBox.textContent=''; const pre=document.createElement('pre'); pre.className='log'; outBox.appendChild(pre); function append(l){ pre.textContent+=l+'\n'; pre.scrollTop=pre.scrollHeight; liveAppend(l); }
     const off=runtime.EventsOn('exec_result', data=>{ if(data.job_id && data.job_id!==AppState.currentJob) return; if(!data.job_id && AppState.currentJob) return; const p=data.progress!==undefined?(' ['+Math.round(data.progress*100)+'%]'):''; const line=fmtLine(data)+p; append(line); });
     const offDone=runtime.EventsOn('exec_job_done', data=>{ if(data.job_id===AppState.currentJob){ finishCtrlJob(); setStatus('任务完成'); offDone(); }});
-  try { const jobID=await invoke('StartJob','',cmd,ids,timeout,parallel,authMode,password,false); AppState.currentJob=jobID; AppState.jobOff=()=>{off();offDone();}; $('#ctrl_jobid').textContent=jobID; setStatus('任务运行:'+jobID); toggleCtrlJobButtons(true); lockPasswordField(true); }
+  const gate=await policyGate(cmd, ids); if(!gate){ off(); offDone(); setStatus('已取消'); return; }
+  try { const jobID=await invoke('StartJobRequest',Object.assign({job_id:'',command:cmd,machine_ids:ids,timeout_sec:timeout,parallel,auth_mode:authMode,password,stream:false,max_output_kb:parseInt($('#ctrl_max_output').value)||0,render:$('#ctrl_render').checked},gate)); AppState.currentJob=jobID; AppState.jobOff=()=>{off();offDone();}; $('#ctrl_jobid').textContent=jobID; setStatus('任务运行:'+jobID); toggleCtrlJobButtons(true); lockPasswordField(true); }
     catch(e){ off(); offDone(); append('启动失败:'+e); setStatus('任务失败'); }
     return;
   }
@@ -299,12 +392,132 @@ async function loadHistory(){
   const limit = parseInt($('#hist_limit').value)||20;
   const ipf = $('#hist_ipmi').value.trim();
   const cmdf = $('#hist_cmd').value.trim();
+  const search = historySearchQuery(limit);
   let hs;
-  try { hs = (ipf||cmdf) ? await invoke('RecentHistoryFiltered', limit, ipf, cmdf) : await invoke('RecentHistory', limit); }
+  try { hs = search ? await invoke('SearchHistory', search) : (ipf||cmdf) ? await invoke('RecentHistoryFiltered', limit, ipf, cmdf) : await invoke('RecentHistory', limit); }
   catch(e){ $('#history_list').innerHTML = '<pre class="log">加载失败 '+e+'</pre>'; setStatus('历史加载失败'); return; }
   if(!Array.isArray(hs)) hs=[];
-  $('#history_list').innerHTML = '<pre class="log">'+hs.map(h=>h.ipmi_ip+': '+h.command+' => '+(h.exit_code||h.exitCode)).join('\n')+'</pre>';
+  const pre=document.createElement('pre'); pre.className='log';
+  hs.forEach(h=>{
+    const row=document.createElement('div'); row.style.cursor='pointer'; row.title='点击查看输出';
+    row.textContent=h.ipmi_ip+': '+h.command+' => '+(h.exit_code||h.exitCode)+(h.truncated?' [输出已截断, 原始 '+fmtBytes(h.output_bytes)+']':'');
+    row.addEventListener('click', ()=>toggleHistOutput(row, h));
+    if(h.snippet){ // 服务端已转义，仅含 <mark> 标签
+      const sn=document.createElement('div'); sn.className='hist-snippet'; sn.innerHTML=h.snippet; row.appendChild(sn);
+    }
+    pre.appendChild(row);
+  });
+  $('#history_list').innerHTML=''; $('#history_list').appendChild(pre);
   setStatus('历史:'+hs.length);
+  loadHistoryStats();
+}
+/* 全文检索条件；均未填写时返回 null (使用普通列表) */
+function historySearchQuery(limit){
+  const q={query:$('#hist_q').value.trim(), job_id:$('#hist_job').value.trim(), selector:$('#hist_selector').value.trim(), limit};
+  const exit=$('#hist_exit').value.trim(), since=$('#hist_since').value, until=$('#hist_until').value;
+  if(exit!=='') q.exit_code=parseInt(exit);
+  if(since) q.since=new Date(since).toISOString();
+  if(until) q.until=new Date(until).toISOString();
+  return (q.query||q.job_id||q.selector||exit!==''||since||until) ? q : null;
+}
+/* 导出报告：仅填写任务 ID 时导出该任务结果，否则按当前检索条件导出历史；data 为 base64 */
+async function exportHistoryReport(){
+  const format=$('#hist_report_fmt').value, limit=parseInt($('#hist_limit').value)||20;
+  const history=historySearchQuery(limit)||{limit};
+  const job=Object.keys(history).filter(k=>k!=='limit').join()==='job_id' ? history.job_id : '';
+  try {
+    const f=await invoke('ExportReport',{format,job_id:job,history:job?{}:history,no_redact:$('#hist_report_raw').checked});
+    const bin=atob(f.data||''), buf=new Uint8Array(bin.length);
+    for(let i=0;i<bin.length;i++) buf[i]=bin.charCodeAt(i);
+    const a=document.createElement('a'); a.href=URL.createObjectURL(new Blob([buf],{type:f.mime})); a.download=f.name; a.click(); URL.revokeObjectURL(a.href);
+    setStatus('报告已导出: '+f.name);
+  } catch(e){ alert('导出失败: '+e); }
+}
+/* 展开 / 收起单条历史的输出；列表只含预览 (preview) 时按 ID 加载完整输出 */
+async function toggleHistOutput(row, h){
+  const next=row.nextSibling;
+  if(next && next.classList && next.classList.contains('hist-output')){ next.remove(); return; }
+  const box=document.createElement('div'); box.className='hist-output'; box.style.cssText='margin:2px 0 8px 14px;color:var(--text-dim);white-space:pre-wrap';
+  box.textContent='加载中...'; row.after(box);
+  let o=h;
+  if(h.preview){ try { o=await invoke('HistoryOutput', h.id); } catch(e){ box.textContent='加载失败 '+e; return; } }
+  box.textContent=(o.stdout||'')+(o.stderr?'\n--- stderr ---\n'+o.stderr:'')||'(无输出)';
+}
+function fmtBytes(n){ n=n||0; return n>=1<<20 ? (n/(1<<20)).toFixed(1)+' MB' : n>=1024 ? (n/1024).toFixed(1)+' KB' : n+' B'; }
+/* History writer stats */
+async function loadHistoryStats(){
+  let s;
+  try { s = await invoke('HistoryWriterStats'); } catch(e){ $('#hist_stats').textContent=''; return; }
+  const lost=(s.failed||0)+(s.dropped||0);
+  $('#hist_stats').textContent='写入队列 '+s.queue_depth+'/'+s.queue_capacity+' · 已写入 '+s.written+' · 重试 '+s.retries+' · 落盘 '+s.spilled+' (待重放 '+s.journal_pending+(s.journal_enabled?'':'，未启用落盘')+') · 丢失 '+lost;
+  $('#hist_stats').style.color = lost>0 ? 'var(--danger)' : '';
+}
+/* System logs (TailLogs) */
+async function loadLogs(){
+  const q={lines:parseInt($('#log_lines').value)||200, level:$('#log_level').value, job_id:$('#log_job').value.trim(), contains:$('#log_contains').value.trim()};
+  let es;
+  try { es = await invoke('TailLogs', q); }
+  catch(e){ $('#log_list').innerHTML = '<pre class="log">读取失败 '+e+'</pre>'; return; }
+  if(!Array.isArray(es)) es=[];
+  const skip=new Set(['time','level','msg','component']);
+  const line=e=>[e.time||'', (e.level||'').padEnd(5), e.component?'['+e.component+']':'', e.msg||''].concat(Object.keys(e).filter(k=>!skip.has(k)).map(k=>k+'='+(typeof e[k]==='object'?JSON.stringify(e[k]):e[k]))).filter(Boolean).join(' ');
+  const pre=document.createElement('pre'); pre.className='log'; pre.textContent=es.map(line).join('\n')||'无匹配日志';
+  $('#log_list').innerHTML=''; $('#log_list').appendChild(pre); pre.scrollTop=pre.scrollHeight;
+  setStatus('日志:'+es.length);
+}
+/* Database maintenance (CheckIntegrity / CreateBackup / ListBackups / RestoreBackup) */
+async function checkIntegrity(){
+  const box=$('#db_integrity'); box.textContent='检查中...'; box.style.color='';
+  try {
+    const r=await invoke('CheckIntegrity');
+    box.textContent=(r.ok?'完整性正常':'发现问题: '+(r.problems||[]).join('; '))+' · 迁移版本 '+r.schema_version+(r.pending?' (待应用 '+r.pending+')':'')+' · 耗时 '+r.duration_ms+' ms';
+    box.style.color=r.ok?'':'var(--danger)';
+  } catch(e){ box.textContent='检查失败 '+e; box.style.color='var(--danger)'; }
+}
+async function createBackup(){
+  try { const b=await invoke('CreateBackup'); showToast('已备份 '+b.name+' ('+fmtBytes(b.size)+')','success'); loadBackups(); }
+  catch(e){ alert('备份失败: '+e); }
+}
+async function loadBackups(){
+  let list;
+  try { list=await invoke('ListBackups'); } catch(e){ $('#db_backup_list').innerHTML='<pre class="log">读取失败 '+e+'</pre>'; return; }
+  const pre=document.createElement('pre'); pre.className='log';
+  (list||[]).forEach(b=>{
+    const row=document.createElement('div'); row.style.cursor='pointer'; row.title='点击从该备份恢复';
+    row.textContent=b.name+'  '+fmtBytes(b.size)+'  '+new Date(b.created_at).toLocaleString();
+    row.addEventListener('click', ()=>restoreBackup(b.name));
+    pre.appendChild(row);
+  });
+  if(!pre.childNodes.length) pre.textContent='暂无备份';
+  $('#db_backup_list').innerHTML=''; $('#db_backup_list').appendChild(pre);
+}
+async function restoreBackup(name){
+  if(!confirm('用备份 '+name+' 覆盖当前数据库?\n当前数据库会先自动备份，之后的机器变更与历史将丢失。')) return;
+  try { const pre=await invoke('RestoreBackup', name); showToast('已恢复，原数据库保存为 '+pre.name,'success'); loadBackups(); loadHistory(); }
+  catch(e){ alert('恢复失败: '+e); }
+}
+/* History analytics (HistoryAnalytics + HistoryTrend) */
+async function loadStats(){
+  const hours=parseInt($('#stats_window').value)||168;
+  const q={since:new Date(Date.now()-hours*3600e3).toISOString(), command:$('#stats_cmd').value.trim(), selector:$('#stats_selector').value.trim(), top:10};
+  let a, tr;
+  try { [a, tr] = await Promise.all([invoke('HistoryAnalytics', q), invoke('HistoryTrend', q)]); }
+  catch(e){ $('#stats_view').innerHTML = '<pre class="log">统计失败 '+e+'</pre>'; return; }
+  const pct=r=>(r*100).toFixed(1)+'%';
+  const host=h=>'  '+h.ipmi_ip.padEnd(16)+' 执行 '+String(h.runs).padStart(5)+'  成功率 '+pct(h.success_rate).padStart(6)+'  p50 '+h.p50_ms+'ms  p95 '+h.p95_ms+'ms  超时 '+h.timeouts+(h.flaky?'  抖动 '+pct(h.flip_rate):'');
+  const spark=vals=>vals.map(v=> v==null ? ' ' : '▁▂▃▄▅▆▇█'[Math.min(7, Math.floor(v*8))]).join('');
+  const o=a.overall, lines=['执行 '+o.runs+' · 失败 '+o.failed+' · 超时 '+o.timeouts+' · 成功率 '+pct(o.success_rate)+' · p50 '+o.p50_ms+'ms · p95 '+o.p95_ms+'ms'];
+  const all=(tr.series||[])[0];
+  if(all) lines.push('成功率趋势 (每'+(tr.bucket==='hour'?'小时':'天')+') |'+spark(all.success_rate)+'|');
+  const section=(title, list, fmt)=>{ if(list&&list.length){ lines.push('', title); list.forEach(x=>lines.push(fmt(x))); } };
+  section('成功率最低', (a.hosts||[]).slice(0,10), host);
+  section('最慢 (p95)', a.slowest, host);
+  section('超时最多', a.most_timeouts, host);
+  section('抖动机器 (成败交替)', a.flaky, host);
+  section('命令', a.commands, c=>'  '+c.command+'  执行 '+c.runs+' / '+c.hosts+' 台  失败 '+c.failed+'  成功率 '+pct(c.success_rate)+'  p95 '+c.p95_ms+'ms');
+  const pre=document.createElement('pre'); pre.className='log'; pre.textContent=lines.join('\n');
+  $('#stats_view').innerHTML=''; $('#stats_view').appendChild(pre);
+  setStatus('统计: '+o.runs+' 次执行');
 }
 function toggleHistAuto(){ if($('#hist_auto').checked){ AppState.histTimer=setInterval(loadHistory,5000); } else { clearInterval(AppState.histTimer); } }
 
@@ -318,10 +531,14 @@ function init(){
   // 资产管理按钮事件
   const bs=$('#btn_save'); if(bs) bs.addEventListener('click', saveMachine);
   const bd=$('#btn_delete'); if(bd) bd.addEventListener('click', deleteMachine);
+  $('#btn_machine_history').addEventListener('click', loadMachineHistory); $('#btn_trash').addEventListener('click', loadTrash);
   const ms=$('#machineSearch');
   if(ms){ ms.addEventListener('keyup', e=>{ if(e.key==='Enter'){ filterMachines(); } }); }
   const msb=$('#btn_machine_search'); if(msb){ msb.addEventListener('click', filterMachines); }
   $('#hist_refresh').addEventListener('click', loadHistory); $('#hist_auto').addEventListener('change', toggleHistAuto);
+  $('#stats_refresh').addEventListener('click', loadStats); $('#hist_report').addEventListener('click', exportHistoryReport);
+  $('#log_refresh').addEventListener('click', loadLogs);
+  $('#db_check').addEventListener('click', checkIntegrity); $('#db_backup').addEventListener('click', createBackup); $('#db_backups').addEventListener('click', loadBackups);
   // 控制页事件
   $('#btn_lookup').addEventListener('click', ctrlLookup);
   $('#btn_goto_assets').addEventListener('click', ()=>{ switchPage('assets'); });
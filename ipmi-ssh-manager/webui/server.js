@@ -0,0 +1,78 @@
+// 浏览器 (IPMI_MODE=server) 适配层：不在 Wails 窗口内时，
+// 以 HTTP 调用模拟 window.go.wailsapi.Backend，以 WebSocket (失败回退 SSE) 模拟 runtime.EventsOn。
+// 服务端启用认证时，访问令牌保存在 localStorage，收到 401 时提示输入。
+(function(){
+  if(window.go && window.go.wailsapi) return; // Wails 环境，使用原生绑定
+
+  const tokenKey = 'ipmi_token';
+  let token = localStorage.getItem(tokenKey) || '';
+  function askToken(){
+    const t = window.prompt('Access token');
+    if(t===null) return false;
+    token = t.trim(); localStorage.setItem(tokenKey, token);
+    return true;
+  }
+  function withToken(url){ return token ? url+(url.includes('?')?'&':'?')+'token='+encodeURIComponent(token) : url; }
+
+  async function call(name, args, retried){
+    const headers = {'Content-Type': 'application/json'};
+    if(token) headers['Authorization'] = 'Bearer '+token;
+    const res = await fetch('api/call/'+encodeURIComponent(name), {method: 'POST', headers, body: JSON.stringify(args)});
+    const body = await res.json().catch(()=>null);
+    if(res.status===401 && !retried && askToken()) return call(name, args, true);
+    if(!res.ok) throw new Error((body && body.error) || ('HTTP '+res.status));
+    startEvents();
+    return body;
+  }
+  const Backend = new Proxy({}, { get: (_, name)=> typeof name==='string' ? (...args)=>call(name, args) : undefined });
+  window.go = { wailsapi: { Backend } };
+
+  // 事件: name -> [{cb, left}]，left<0 表示不限次数
+  const listeners = {};
+  function dispatch(name, data){
+    const ls = listeners[name]; if(!ls) return;
+    for(const l of ls.slice()){
+      try{ l.cb(data); }catch(e){ console.error(e); }
+      if(l.left>0 && --l.left===0) off(name, l);
+    }
+  }
+  function off(name, l){
+    const ls = listeners[name]; if(!ls) return;
+    const i = ls.indexOf(l); if(i>=0) ls.splice(i,1);
+  }
+  function EventsOnMultiple(name, cb, max){
+    const l = {cb, left: max};
+    (listeners[name] = listeners[name] || []).push(l);
+    return ()=>off(name, l);
+  }
+
+  let retry = 1000;
+  function connectWS(){
+    const url = (location.protocol==='https:'?'wss://':'ws://')+location.host+location.pathname.replace(/[^/]*$/,'')+'api/events';
+    let opened = false;
+    const ws = new WebSocket(withToken(url));
+    ws.onopen = ()=>{ opened = true; retry = 1000; };
+    ws.onmessage = ev=>{ try{ const m = JSON.parse(ev.data); dispatch(m.name, m.data); }catch(e){ console.error(e); } };
+    ws.onclose = ()=>{
+      if(!opened && window.EventSource){ connectSSE(); return; } // 从未连通 (如代理不支持 WebSocket)
+      setTimeout(connectWS, retry); retry = Math.min(retry*2, 15000);
+    };
+  }
+  const sseEvents = ['exec_result','exec_chunk','exec_job_done'];
+  function connectSSE(){
+    const es = new EventSource(withToken('api/events/sse')); // EventSource 自带断线重连
+    sseEvents.forEach(name=>es.addEventListener(name, ev=>{ try{ dispatch(name, JSON.parse(ev.data)); }catch(e){ console.error(e); } }));
+  }
+  // 首次调用成功 (令牌已确认) 后再建立事件连接
+  let eventsStarted = false;
+  function startEvents(){ if(!eventsStarted){ eventsStarted = true; connectWS(); } }
+
+  window.runtime = Object.assign(window.runtime || {}, {
+    EventsOnMultiple,
+    EventsOn: (name, cb)=>EventsOnMultiple(name, cb, -1),
+    EventsOnce: (name, cb)=>EventsOnMultiple(name, cb, 1),
+    EventsOff: (...names)=>names.forEach(n=>delete listeners[n]),
+    EventsEmit: (name, ...data)=>dispatch(name, data[0]),
+    LogInfo: console.info.bind(console), LogWarning: console.warn.bind(console), LogError: console.error.bind(console)
+  });
+})();